@@ -0,0 +1,116 @@
+/*
+ * auditcmd.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/billziss-gh/golib/keyring"
+	"github.com/winfsp/hubfs/util"
+)
+
+// The records of the audit log are hashed with a key that is stored in the system
+// keyring (and not in the log).
+const (
+	auditKeyName = "auditkey"
+	auditKeySize = 32
+)
+
+// auditHeadInterval is the interval at which the head of the audit log is exported.
+const auditHeadInterval = 1 * time.Minute
+
+// runAuditCommand runs an audit log command: "audit verify file" verifies that the
+// records of an audit log (-audit-log) have not been modified, removed or reordered.
+// It reports the hash of the last record (the head), which must match the last head
+// exported to the log and event sinks; otherwise records were removed from the end.
+// It exits with status 1 if the log fails verification.
+func runAuditCommand(args []string) int {
+	fset := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	if nil != fset.Parse(args) || 2 != fset.NArg() || "verify" != fset.Arg(0) || "" == fset.Arg(1) {
+		flag.Usage()
+		return exitUsage
+	}
+	path := fset.Arg(1)
+
+	key, err := keyring.Get(MyProductName, auditKeyName)
+	if nil != err {
+		warn("audit error: cannot get audit key from system keyring: %v", err)
+		return exitFailure
+	}
+
+	file, err := os.Open(path)
+	if nil != err {
+		warn("audit error: %v", err)
+		return exitFailure
+	}
+	defer file.Close()
+
+	n, head, err := util.VerifyAuditLog(file, []byte(key))
+	if nil != err {
+		warn("audit error: %s: %v", path, err)
+		return exitFailure
+	}
+	if jsonOutput {
+		printJSON(struct {
+			Path    string `json:"path"`
+			Records int    `json:"records"`
+			Head    string `json:"head"`
+		}{path, n, head})
+	} else {
+		fmt.Printf("%s: %d records verified\n", path, n)
+		fmt.Printf("head: %s\n", head)
+	}
+	return 0
+}
+
+// watchAuditHead exports the head of an audit log to the log and event sinks when
+// it changes (at most every auditHeadInterval) and when the returned function is
+// called. The exported heads are the reference for "audit verify".
+func watchAuditHead(auditlog *util.AuditLog, path string) (stop func()) {
+	var lock sync.Mutex
+	last := ""
+	export := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if head := auditlog.Head(); last != head {
+			last = head
+			util.Logf(util.LogInfo, "audit log %s: head %s", path, head)
+			reportEvent(eventInformation, eventAuditHead, "audit log %s: head %s", path, head)
+		}
+	}
+	export()
+
+	doneC := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(auditHeadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				export()
+			case <-doneC:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(doneC)
+		export()
+	}
+}
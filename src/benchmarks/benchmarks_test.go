@@ -0,0 +1,171 @@
+/*
+ * benchmarks_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package benchmarks
+
+import (
+	"bytes"
+	"flag"
+	pathutil "path"
+	"testing"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/hubfs"
+)
+
+// Run with:
+//
+//	go test -bench . ./benchmarks/ [-latency 10ms]
+//
+// The latency is the delay of every "remote" fetch of a tree or file. It defaults to 0,
+// so that the benchmarks measure the overhead of the file system rather than the sleep.
+var latency = flag.Duration("latency", 0, "latency of remote fetches")
+
+var repoConfig = MockConfig{
+	Owners:   1,
+	Repos:    1,
+	Depth:    2,
+	Dirs:     4,
+	Files:    16,
+	FileSize: 4096,
+}
+
+var smallFilesConfig = MockConfig{
+	Owners:   1,
+	Repos:    1,
+	Depth:    1,
+	Dirs:     8,
+	Files:    128,
+	FileSize: 64,
+}
+
+const repoRoot = "/owner0/repo0/main"
+
+func newFileSystem(config MockConfig) fuse.FileSystemInterface {
+	config.Latency = *latency
+	return hubfs.New(hubfs.Config{Client: NewMockClient(config)})
+}
+
+func readdir(b *testing.B, fs fuse.FileSystemInterface, path string,
+	fn func(name string, stat *fuse.Stat_t)) {
+
+	errc, fh := fs.Opendir(path)
+	if 0 != errc {
+		b.Fatal(path, errc)
+	}
+	defer fs.Releasedir(path, fh)
+	errc = fs.Readdir(path, func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		if "." != name && ".." != name {
+			fn(name, stat)
+		}
+		return true
+	}, 0, fh)
+	if 0 != errc {
+		b.Fatal(path, errc)
+	}
+}
+
+func walk(b *testing.B, fs fuse.FileSystemInterface, path string,
+	fn func(path string, stat *fuse.Stat_t)) {
+
+	readdir(b, fs, path, func(name string, stat *fuse.Stat_t) {
+		p := pathutil.Join(path, name)
+		if fuse.S_IFDIR == stat.Mode&fuse.S_IFMT {
+			walk(b, fs, p, fn)
+		} else {
+			fn(p, stat)
+		}
+	})
+}
+
+func readFile(b *testing.B, fs fuse.FileSystemInterface, path string, buff []byte,
+	fn func(content []byte)) int64 {
+
+	errc, fh := fs.Open(path, fuse.O_RDONLY)
+	if 0 != errc {
+		b.Fatal(path, errc)
+	}
+	defer fs.Release(path, fh)
+	total := int64(0)
+	for {
+		n := fs.Read(path, buff, total, fh)
+		if 0 > n {
+			b.Fatal(path, n)
+		}
+		if 0 == n {
+			return total
+		}
+		fn(buff[:n])
+		total += int64(n)
+	}
+}
+
+// BenchmarkColdMount measures the time from mount to the first listing of a ref.
+func BenchmarkColdMount(b *testing.B) {
+	for i := 0; b.N > i; i++ {
+		fs := newFileSystem(repoConfig)
+		for _, path := range []string{"/", "/owner0", "/owner0/repo0", repoRoot} {
+			readdir(b, fs, path, func(name string, stat *fuse.Stat_t) {})
+		}
+	}
+}
+
+// BenchmarkWarmReaddir measures listing every directory of a ref whose trees are
+// already cached.
+func BenchmarkWarmReaddir(b *testing.B) {
+	fs := newFileSystem(repoConfig)
+	walk(b, fs, repoRoot, func(path string, stat *fuse.Stat_t) {})
+	b.ResetTimer()
+	for i := 0; b.N > i; i++ {
+		walk(b, fs, repoRoot, func(path string, stat *fuse.Stat_t) {})
+	}
+}
+
+// BenchmarkGrep measures the throughput of searching every file of a ref (as with
+// grep -r) on a newly mounted file system.
+func BenchmarkGrep(b *testing.B) {
+	pattern := []byte("nonexistent")
+	buff := make([]byte, 128*1024)
+	for i := 0; b.N > i; i++ {
+		fs := newFileSystem(repoConfig)
+		total := int64(0)
+		walk(b, fs, repoRoot, func(path string, stat *fuse.Stat_t) {
+			total += readFile(b, fs, path, buff, func(content []byte) {
+				if bytes.Contains(content, pattern) {
+					b.Fatal(path)
+				}
+			})
+		})
+		b.SetBytes(total)
+	}
+}
+
+// BenchmarkSmallFiles measures opening and reading many small files whose contents
+// are already cached.
+func BenchmarkSmallFiles(b *testing.B) {
+	fs := newFileSystem(smallFilesConfig)
+	paths := []string{}
+	walk(b, fs, repoRoot, func(path string, stat *fuse.Stat_t) {
+		paths = append(paths, path)
+	})
+	buff := make([]byte, 4096)
+	for _, path := range paths {
+		readFile(b, fs, path, buff, func(content []byte) {})
+	}
+	b.ResetTimer()
+	for i := 0; b.N > i; i++ {
+		for _, path := range paths {
+			readFile(b, fs, path, buff, func(content []byte) {})
+		}
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * mock.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+// Package benchmarks contains reproducible benchmarks of the file system and a mock
+// provider that serves synthetic repositories to them.
+package benchmarks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+// MockConfig describes the synthetic content served by a MockClient. Every owner has
+// Repos repositories, every repository has a single "main" ref and every directory
+// has Dirs subdirectories (up to Depth levels) and Files files of FileSize bytes.
+type MockConfig struct {
+	Owners   int
+	Repos    int
+	Depth    int
+	Dirs     int
+	Files    int
+	FileSize int
+	Latency  time.Duration // delay of the first fetch of every tree and file
+}
+
+// MockClient is a prov.Client that serves synthetic content. The first fetch of a tree
+// or file waits for the configured latency (as a remote fetch would); subsequent fetches
+// are served from memory (as from a warm cache).
+type MockClient struct {
+	config  MockConfig
+	time    time.Time
+	lock    sync.Mutex
+	trees   map[string][]prov.TreeEntry
+	blobs   map[string][]byte
+	fetches int
+}
+
+type mockOwner struct {
+	name string
+}
+
+type mockRepository struct {
+	client *MockClient
+	name   string
+}
+
+type mockRef struct {
+	name string
+	time time.Time
+}
+
+type mockTreeEntry struct {
+	name string
+	mode uint32
+	size int64
+	hash string
+}
+
+type mockReader struct {
+	*bytes.Reader
+}
+
+var errNotSupported = errors.New("not supported")
+
+// NewMockClient creates a MockClient with the specified configuration.
+func NewMockClient(config MockConfig) *MockClient {
+	return &MockClient{
+		config: config,
+		time:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		trees:  make(map[string][]prov.TreeEntry),
+		blobs:  make(map[string][]byte),
+	}
+}
+
+// Fetches returns the number of trees and files that have been fetched "remotely".
+func (c *MockClient) Fetches() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.fetches
+}
+
+func (c *MockClient) fetch() {
+	c.fetches++
+	if 0 < c.config.Latency {
+		c.lock.Unlock()
+		time.Sleep(c.config.Latency)
+		c.lock.Lock()
+	}
+}
+
+func mockIndex(name string, prefix string, count int) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	i, err := strconv.Atoi(name[len(prefix):])
+	return nil == err && 0 <= i && count > i && name[len(prefix):] == strconv.Itoa(i)
+}
+
+func (c *MockClient) SetConfig(config []string) ([]string, error) {
+	return config, nil
+}
+
+func (c *MockClient) GetDirectory() string {
+	return ""
+}
+
+func (c *MockClient) GetOwners() ([]prov.Owner, error) {
+	res := make([]prov.Owner, c.config.Owners)
+	for i := range res {
+		res[i] = &mockOwner{name: "owner" + strconv.Itoa(i)}
+	}
+	return res, nil
+}
+
+func (c *MockClient) OpenOwner(name string) (prov.Owner, error) {
+	if !mockIndex(name, "owner", c.config.Owners) {
+		return nil, prov.ErrNotFound
+	}
+	return &mockOwner{name: name}, nil
+}
+
+func (c *MockClient) CloseOwner(owner prov.Owner) {
+}
+
+func (c *MockClient) GetRepositories(owner prov.Owner) ([]prov.Repository, error) {
+	res := make([]prov.Repository, c.config.Repos)
+	for i := range res {
+		res[i] = &mockRepository{client: c, name: owner.Name() + "/repo" + strconv.Itoa(i)}
+	}
+	return res, nil
+}
+
+func (c *MockClient) OpenRepository(owner prov.Owner, name string) (prov.Repository, error) {
+	if !mockIndex(name, "repo", c.config.Repos) {
+		return nil, prov.ErrNotFound
+	}
+	return &mockRepository{client: c, name: owner.Name() + "/" + name}, nil
+}
+
+func (c *MockClient) CloseRepository(repository prov.Repository) {
+}
+
+func (c *MockClient) SearchCode(query string) ([]prov.SearchResult, error) {
+	return nil, errNotSupported
+}
+
+func (c *MockClient) InvalidateRepository(owner string, name string) {
+}
+
+func (c *MockClient) InvalidatePath(path string) {
+}
+
+func (c *MockClient) ImportObjects(path string, owner string, name string) (int, error) {
+	return 0, errNotSupported
+}
+
+func (c *MockClient) ExportCache(w io.Writer) error {
+	return errNotSupported
+}
+
+func (c *MockClient) ImportCache(r io.Reader) (int, error) {
+	return 0, errNotSupported
+}
+
+func (c *MockClient) CollectGarbage() (int, int64, error) {
+	return 0, 0, errNotSupported
+}
+
+func (c *MockClient) VerifyCache() (int, int, error) {
+	return 0, 0, errNotSupported
+}
+
+func (c *MockClient) Reconfigure(config []string) {
+}
+
+func (c *MockClient) GetStatus() prov.ClientStatus {
+	return prov.ClientStatus{Ident: "mock"}
+}
+
+func (c *MockClient) GetCacheStats() []prov.CacheStats {
+	return nil
+}
+
+func (c *MockClient) StartExpiration() {
+}
+
+func (c *MockClient) StopExpiration() {
+}
+
+func (o *mockOwner) Name() string {
+	return o.name
+}
+
+func (r *mockRepository) Close() error {
+	return nil
+}
+
+func (r *mockRepository) GetDirectory() string {
+	return ""
+}
+
+func (r *mockRepository) SetDirectory(path string) error {
+	return errNotSupported
+}
+
+func (r *mockRepository) RemoveDirectory() error {
+	return errNotSupported
+}
+
+func (r *mockRepository) Name() string {
+	return r.name[strings.IndexByte(r.name, '/')+1:]
+}
+
+func (r *mockRepository) GetRefs() ([]prov.Ref, error) {
+	return []prov.Ref{&mockRef{name: "main", time: r.client.time}}, nil
+}
+
+func (r *mockRepository) GetRef(name string) (prov.Ref, error) {
+	if "main" != name {
+		return nil, prov.ErrNotFound
+	}
+	return &mockRef{name: "main", time: r.client.time}, nil
+}
+
+func (r *mockRepository) GetTempRef(name string) (prov.Ref, error) {
+	return nil, prov.ErrNotFound
+}
+
+func (r *mockRepository) GetTree(ref prov.Ref, entry prov.TreeEntry) ([]prov.TreeEntry, error) {
+	path := r.name
+	if nil != entry {
+		if 0040000 != entry.Mode() {
+			return nil, prov.ErrNotFound
+		}
+		path = entry.Hash()
+	}
+
+	c := r.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tree, ok := c.trees[path]
+	if ok {
+		return tree, nil
+	}
+
+	c.fetch()
+	level := strings.Count(path, "/") - 1
+	tree = make([]prov.TreeEntry, 0, c.config.Dirs+c.config.Files)
+	if c.config.Depth > level {
+		for i := 0; c.config.Dirs > i; i++ {
+			n := "dir" + strconv.Itoa(i)
+			tree = append(tree, &mockTreeEntry{name: n, mode: 0040000, hash: path + "/" + n})
+		}
+	}
+	for i := 0; c.config.Files > i; i++ {
+		n := "file" + strconv.Itoa(i) + ".txt"
+		tree = append(tree, &mockTreeEntry{
+			name: n, mode: 0100644, size: int64(c.config.FileSize), hash: path + "/" + n})
+	}
+	c.trees[path] = tree
+	return tree, nil
+}
+
+func (r *mockRepository) GetTreeEntry(ref prov.Ref, entry prov.TreeEntry, name string) (
+	prov.TreeEntry, error) {
+
+	tree, err := r.GetTree(ref, entry)
+	if nil != err {
+		return nil, err
+	}
+	for _, e := range tree {
+		if name == e.Name() {
+			return e, nil
+		}
+	}
+	return nil, prov.ErrNotFound
+}
+
+func (r *mockRepository) GetBlobReader(entry prov.TreeEntry) (io.ReaderAt, error) {
+	path := entry.Hash()
+
+	c := r.client
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, ok := c.blobs[path]
+	if !ok {
+		c.fetch()
+		content = make([]byte, entry.Size())
+		line := []byte(path + "\n")
+		for i := 0; len(content) > i; i += len(line) {
+			copy(content[i:], line)
+		}
+		c.blobs[path] = content
+	}
+	return mockReader{bytes.NewReader(content)}, nil
+}
+
+func (r *mockRepository) GetModule(ref prov.Ref, path string, rootrel bool) (string, error) {
+	return "", prov.ErrNotFound
+}
+
+func (r *mockRef) Name() string {
+	return r.name
+}
+
+func (r *mockRef) Kind() prov.RefKind {
+	return prov.RefBranch
+}
+
+func (r *mockRef) TreeTime() time.Time {
+	return r.time
+}
+
+func (e *mockTreeEntry) Name() string {
+	return e.name
+}
+
+func (e *mockTreeEntry) Mode() uint32 {
+	return e.mode
+}
+
+func (e *mockTreeEntry) Size() int64 {
+	return e.size
+}
+
+func (e *mockTreeEntry) Target() string {
+	return ""
+}
+
+func (e *mockTreeEntry) Hash() string {
+	return e.hash
+}
+
+func (mockReader) Close() error {
+	return nil
+}
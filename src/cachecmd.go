@@ -0,0 +1,194 @@
+/*
+ * cachecmd.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/winfsp/hubfs/git"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// parseCacheCommand parses the arguments of the cache command:
+// "cache export file" exports a snapshot of the cache to an archive file;
+// "cache import file" imports a snapshot archive file;
+// "cache import [-repo [remote/]owner/repo] clonepath" imports the objects of a
+// local clone (the repo defaults to the origin remote of the clone);
+// "cache gc [-age duration] [-size size]" prunes the cache (the age and size are
+// returned as additional config);
+// "cache verify" verifies the cached objects and removes corrupt ones.
+func parseCacheCommand(args []string) (
+	cmd string, cachepath string, repo string, config []string, err error) {
+	if 0 == len(args) {
+		return "", "", "", nil, errors.New("missing cache command")
+	}
+
+	cmd = args[0]
+	switch cmd {
+	case "import":
+		fset := flag.NewFlagSet("cache import", flag.ContinueOnError)
+		fset.StringVar(&repo, "repo", repo,
+			"repository to import into (default: origin remote of clone)")
+		err = fset.Parse(args[1:])
+		if nil != err {
+			return
+		}
+		if 1 != fset.NArg() || "" == fset.Arg(0) {
+			return "", "", "", nil, errors.New("missing clone path")
+		}
+		cachepath = fset.Arg(0)
+		if info, e := os.Stat(cachepath); nil == e && info.Mode().IsRegular() {
+			return "import-snapshot", cachepath, "", nil, nil
+		}
+		if "" == repo {
+			var u string
+			u, err = git.GetLocalRemote(cachepath, "origin")
+			if nil != err {
+				return
+			}
+			repo = remoteRepoName(u)
+			if "" == repo {
+				return "", "", "", nil, errors.New("cannot determine repository of clone; use -repo")
+			}
+		}
+	case "export":
+		if 2 != len(args) || "" == args[1] {
+			return "", "", "", nil, errors.New("missing snapshot file")
+		}
+		cachepath = args[1]
+	case "gc":
+		age, size := "", ""
+		fset := flag.NewFlagSet("cache gc", flag.ContinueOnError)
+		fset.StringVar(&age, "age", age,
+			"remove repositories and objects unused for longer than `duration`")
+		fset.StringVar(&size, "size", size,
+			"remove least recently used repositories and objects until the cache is within `size`")
+		err = fset.Parse(args[1:])
+		if nil != err {
+			return
+		}
+		if 0 != fset.NArg() {
+			return "", "", "", nil, errors.New("unexpected arguments: " + strings.Join(fset.Args(), " "))
+		}
+		if "" != age {
+			config = append(config, "config.retain.age="+age)
+		}
+		if "" != size {
+			config = append(config, "config.retain.size="+size)
+		}
+	case "verify":
+		if 1 != len(args) {
+			return "", "", "", nil, errors.New("unexpected arguments: " + strings.Join(args[1:], " "))
+		}
+	default:
+		return "", "", "", nil, errors.New("unknown cache command: " + cmd)
+	}
+
+	return
+}
+
+// remoteRepoName converts a git remote URL (https://host/owner/repo.git,
+// ssh://user@host/owner/repo.git or user@host:owner/repo.git) to host/owner/repo.
+func remoteRepoName(u string) string {
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git")
+	if i := strings.Index(u, "://"); -1 != i {
+		u = u[i+3:]
+	} else if i := strings.IndexByte(u, ':'); -1 != i && !strings.Contains(u[:i], "/") {
+		u = u[:i] + "/" + u[i+1:]
+	} else {
+		return ""
+	}
+	if i := strings.IndexByte(u, '/'); -1 != i {
+		host := u[:i]
+		if j := strings.LastIndexByte(host, '@'); -1 != j {
+			host = host[j+1:]
+		}
+		if j := strings.LastIndexByte(host, ':'); -1 != j {
+			host = host[:j]
+		}
+		u = host + u[i:]
+	}
+	if 3 > len(strings.Split(u, "/")) {
+		return ""
+	}
+	return u
+}
+
+func runCacheCommand(client prov.Client, cmd string, cachepath string, repo string) int {
+	switch cmd {
+	case "import":
+		lst := strings.SplitN(strings.Trim(repo, "/"), "/", 2)
+		if 2 != len(lst) {
+			warn("invalid repository: %s", repo)
+			return exitUsage
+		}
+		lst[1] = strings.ReplaceAll(lst[1], "/", string(prov.AltPathSeparator))
+		n, err := client.ImportObjects(cachepath, lst[0], lst[1])
+		if nil != err {
+			warn("cache import error: %v", err)
+			return exitFailure
+		}
+		fmt.Printf("%s/%s: %d objects imported\n", lst[0], lst[1], n)
+	case "import-snapshot":
+		file, err := os.Open(cachepath)
+		if nil != err {
+			warn("cache import error: %v", err)
+			return exitFailure
+		}
+		n, err := client.ImportCache(file)
+		file.Close()
+		if nil != err {
+			warn("cache import error: %v", err)
+			return exitFailure
+		}
+		fmt.Printf("%s: %d files imported\n", cachepath, n)
+	case "gc":
+		n, size, err := client.CollectGarbage()
+		if nil != err {
+			warn("cache gc error: %v", err)
+			return exitFailure
+		}
+		fmt.Printf("%d repositories and objects removed (%d bytes)\n", n, size)
+	case "verify":
+		n, corrupt, err := client.VerifyCache()
+		if nil != err {
+			warn("cache verify error: %v", err)
+			return exitFailure
+		}
+		fmt.Printf("%d objects verified, %d corrupt objects removed\n", n, corrupt)
+		if 0 != corrupt {
+			return exitFailure
+		}
+	case "export":
+		file, err := os.Create(cachepath)
+		if nil != err {
+			warn("cache export error: %v", err)
+			return exitFailure
+		}
+		err = client.ExportCache(file)
+		if e := file.Close(); nil == err {
+			err = e
+		}
+		if nil != err {
+			os.Remove(cachepath)
+			warn("cache export error: %v", err)
+			return exitFailure
+		}
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+/*
+ * completion.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+// completionCommands are the commands of HUBFS (as offered by shell completion).
+var completionCommands = []string{
+	"prefetch", "cache", "daemon", "ctl", "status", "refresh", "unmount", "systemd", "launchagent",
+	"doctor", "audit", "version", "completion",
+}
+
+// completionAuthMethods are the auth methods offered by shell completion.
+var completionAuthMethods = []string{
+	"force", "full", "required", "optional", "none", "git",
+}
+
+const completionBash = `_%[1]s() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    case "$prev" in
+    -auth)
+        COMPREPLY=($(compgen -W "%[3]s" -- "$cur")); return;;
+    -log-level)
+        COMPREPLY=($(compgen -W "debug info warn error" -- "$cur")); return;;
+    -log-format)
+        COMPREPLY=($(compgen -W "text json" -- "$cur")); return;;
+    -config|-o|-authkey|-filter|-pin|-webhook|-pprof|-mount|-socket|-dir|-log-file)
+        COMPREPLY=($(compgen -f -- "$cur")); return;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%[2]s" -- "$cur")); return
+    fi
+    local words="%[4]s $(%[1]s __complete remotes 2>/dev/null) $(%[1]s __complete repos 2>/dev/null)"
+    COMPREPLY=($(compgen -W "$words" -- "$cur") $(compgen -d -- "$cur"))
+}
+complete -F _%[1]s %[1]s
+`
+
+const completionZsh = `autoload -U +X bashcompinit && bashcompinit
+`
+
+const completionFish = `complete -c %[1]s -f
+complete -c %[1]s -n "__fish_use_subcommand" -a "%[2]s"
+complete -c %[1]s -a "(%[1]s __complete remotes 2>/dev/null; %[1]s __complete repos 2>/dev/null)"
+complete -c %[1]s -a "(__fish_complete_directories)"
+`
+
+const completionPowershell = `Register-ArgumentCompleter -Native -CommandName %[1]s -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @(%[2]s) + @(%[3]s) + @(& %[1]s __complete remotes) + @(& %[1]s __complete repos)
+    $words | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`
+
+// completionScript returns the completion script of a shell (bash, zsh, fish or
+// powershell). Options and commands are part of the script; remotes and cached
+// owner/repo names are completed dynamically with the __complete command.
+func completionScript(shell string) (string, bool) {
+	flags := []string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+	})
+	quote := func(l []string) string {
+		return "'" + strings.Join(l, "','") + "'"
+	}
+
+	switch shell {
+	case "bash":
+		return fmt.Sprintf(completionBash, progname, strings.Join(flags, " "),
+			strings.Join(completionAuthMethods, " "), strings.Join(completionCommands, " ")), true
+	case "zsh":
+		s, _ := completionScript("bash")
+		return completionZsh + s, true
+	case "fish":
+		s := fmt.Sprintf(completionFish, progname, strings.Join(completionCommands, " "))
+		flag.VisitAll(func(f *flag.Flag) {
+			_, usage := flag.UnquoteUsage(f)
+			d := strings.SplitN(usage, "\n", 2)[0]
+			s += fmt.Sprintf("complete -c %s -o %s -d %q", progname, f.Name, d)
+			if "auth" == f.Name {
+				s += fmt.Sprintf(" -x -a %q", strings.Join(completionAuthMethods, " "))
+			}
+			s += "\n"
+		})
+		return s, true
+	case "powershell":
+		return fmt.Sprintf(completionPowershell, progname, quote(flags), quote(completionCommands)), true
+	}
+	return "", false
+}
+
+// runCompletionCommand prints the completion script of a shell:
+// "completion bash|zsh|fish|powershell".
+func runCompletionCommand(args []string) int {
+	if 1 != len(args) {
+		flag.Usage()
+		return exitUsage
+	}
+	s, ok := completionScript(args[0])
+	if !ok {
+		warn("unknown shell: %s", args[0])
+		return exitUsage
+	}
+	fmt.Print(s)
+	return 0
+}
+
+// runCompleteCommand prints the candidates of dynamic completion:
+// "__complete remotes" prints the known providers;
+// "__complete repos" prints the owner/repo names in the persistent caches that
+// are configured with config.dir (on the command line or in the configuration file).
+func runCompleteCommand(args []string, config []string, mntopt []string) int {
+	if 1 != len(args) {
+		return exitUsage
+	}
+	switch args[0] {
+	case "remotes":
+		for _, n := range prov.GetProviderClassNames() {
+			if !strings.HasSuffix(n, ":") {
+				fmt.Println(n)
+			}
+		}
+	case "repos":
+		for _, m := range append(append([]string{}, config...), mntopt...) {
+			for _, s := range strings.Split(m, ",") {
+				v := strings.TrimPrefix(s, "config.dir=")
+				if s == v || ":" == v {
+					continue
+				}
+				names, _ := prov.ListCacheRepositories(v)
+				for _, n := range names {
+					fmt.Println(n)
+				}
+			}
+		}
+	default:
+		return exitUsage
+	}
+	return 0
+}
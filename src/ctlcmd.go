@@ -0,0 +1,294 @@
+/*
+ * ctlcmd.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errDaemonNotRunning = errors.New("daemon not running")
+
+// newControlClient returns an HTTP client that connects to the control socket of
+// the daemon.
+func newControlClient(sockpath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sockpath)
+			},
+		},
+		Timeout: 5 * time.Minute,
+	}
+}
+
+// controlRequest sends a request to the daemon. A GET request decodes the JSON
+// reply into result; a POST request sends the form values.
+func controlRequest(client *http.Client, method string, op string, form url.Values,
+	result interface{}) error {
+	var rsp *http.Response
+	var err error
+	if "GET" == method {
+		rsp, err = client.Get("http://" + strings.ToLower(MyProductName) + "/" + op)
+	} else {
+		rsp, err = client.PostForm("http://"+strings.ToLower(MyProductName)+"/"+op, form)
+	}
+	if nil != err {
+		var operr *net.OpError
+		if errors.As(err, &operr) && "dial" == operr.Op {
+			return errDaemonNotRunning
+		}
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if http.StatusOK != rsp.StatusCode && http.StatusNoContent != rsp.StatusCode {
+		msg, _ := ioutil.ReadAll(rsp.Body)
+		if s := strings.TrimSpace(string(msg)); "" != s {
+			return errors.New(s)
+		}
+		return errors.New(rsp.Status)
+	}
+	if nil != result {
+		return json.NewDecoder(rsp.Body).Decode(result)
+	}
+	return nil
+}
+
+// runCtlCommand runs a ctl command against the daemon:
+// "ctl mounts" lists the active mounts;
+// "ctl stats" reports daemon statistics;
+// "ctl mount [remote=]mountpoint" mounts the file system of a remote;
+// "ctl unmount mountpoint" unmounts a file system;
+// "ctl refresh [remote/]owner/repo" discards the cached refs of a repository;
+// "ctl dump" dumps the internal state of the daemon to its log (like SIGUSR1);
+// "ctl reload" re-reads the configuration file and applies its runtime settings;
+// "ctl stop" unmounts all file systems and stops the daemon.
+func runCtlCommand(args []string, defremote string) int {
+	sockpath := defaultSocketPath()
+	fset := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.StringVar(&sockpath, "socket", sockpath, "daemon control socket `path`")
+	if nil != fset.Parse(args) || 0 == fset.NArg() {
+		flag.Usage()
+		return exitUsage
+	}
+
+	cmd, args := fset.Arg(0), fset.Args()[1:]
+	nargs := map[string]int{
+		"mounts": 0, "stats": 0, "dump": 0, "stop": 0, "reload": 0,
+		"mount": 1, "unmount": 1, "refresh": 1}
+	if n, ok := nargs[cmd]; !ok || n != len(args) || (1 == n && "" == args[0]) {
+		flag.Usage()
+		return exitUsage
+	}
+
+	client := newControlClient(sockpath)
+	var err error
+	switch cmd {
+	case "mounts":
+		var mounts []mountInfo
+		err = controlRequest(client, "GET", "mounts", nil, &mounts)
+		if nil == err && jsonOutput {
+			printJSON(mounts)
+			break
+		}
+		for _, m := range mounts {
+			fmt.Printf("%s %s (since %s)\n",
+				m.Remote, m.Mountpoint, m.Time.Format(time.RFC3339))
+		}
+	case "stats":
+		var stats controlStats
+		err = controlRequest(client, "GET", "stats", nil, &stats)
+		if nil == err && jsonOutput {
+			printJSON(stats)
+		} else if nil == err {
+			fmt.Printf("pid: %d\nuptime: %s\nmounts: %d\nremotes: %s\nheap: %d\ngoroutines: %d\n",
+				stats.Pid, stats.Uptime, stats.Mounts, strings.Join(stats.Remotes, ","),
+				stats.HeapSize, stats.Goroutines)
+			for _, c := range stats.Cache {
+				if 0 == c.Hits+c.Stale+c.Misses {
+					continue
+				}
+				fmt.Printf("cache %s/%s/%s: %.1f%% hits (%d hits, %d stale, %d misses)\n",
+					c.Remote, c.Owner, c.Repository, 100*c.HitRatio, c.Hits, c.Stale, c.Misses)
+			}
+			for _, u := range usageTotals(stats.Cache) {
+				fmt.Printf("usage %s: %d requests, %d bytes downloaded\n", u.name, u.requests, u.bytes)
+			}
+		}
+	case "mount":
+		remote, mntpnt := splitMountSpec(args[0], defremote)
+		err = controlRequest(client, "POST", "mount",
+			url.Values{"remote": {remote}, "mountpoint": {absMountpoint(mntpnt)}}, nil)
+	case "unmount":
+		err = controlRequest(client, "POST", "unmount",
+			url.Values{"mountpoint": {absMountpoint(args[0])}}, nil)
+	case "refresh":
+		remote, repo := splitPrefetchPath(args[0], defremote)
+		err = controlRequest(client, "POST", "refresh",
+			url.Values{"remote": {remote}, "repo": {repo}}, nil)
+	case "reload":
+		err = controlRequest(client, "POST", "reload", nil, nil)
+	case "dump":
+		err = controlRequest(client, "POST", "dump", nil, nil)
+	case "stop":
+		err = controlRequest(client, "POST", "stop", nil, nil)
+	}
+	if nil != err {
+		warn("ctl error: %v", err)
+		return controlExitCode(err)
+	}
+	return 0
+}
+
+// runStatusCommand reports the status of the daemon: its mounts and for each
+// remote the provider identity, rate limits, cache sizes and recent errors.
+func runStatusCommand(args []string) int {
+	sockpath := defaultSocketPath()
+	fset := flag.NewFlagSet("status", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.StringVar(&sockpath, "socket", sockpath, "daemon control socket `path`")
+	if nil != fset.Parse(args) || 0 != fset.NArg() {
+		flag.Usage()
+		return exitUsage
+	}
+
+	var status controlStatus
+	err := controlRequest(newControlClient(sockpath), "GET", "status", nil, &status)
+	if nil != err {
+		warn("status error: %v", err)
+		return controlExitCode(err)
+	}
+	if jsonOutput {
+		printJSON(status)
+		return 0
+	}
+
+	fmt.Printf("daemon: pid %d, uptime %s, heap %d bytes, %d goroutines\n",
+		status.Pid, status.Uptime, status.HeapSize, status.Goroutines)
+	fmt.Printf("mounts: %d\n", len(status.MountList))
+	for _, m := range status.MountList {
+		fmt.Printf("  %s %s (since %s)\n",
+			m.Remote, m.Mountpoint, m.Time.Format(time.RFC3339))
+	}
+	for _, name := range status.Remotes {
+		c, ok := status.Clients[name]
+		if !ok {
+			continue
+		}
+		login := c.Login
+		if "" == login {
+			login = "(anonymous)"
+		}
+		fmt.Printf("%s:\n", name)
+		fmt.Printf("  login: %s\n", login)
+		if c.Offline {
+			fmt.Printf("  offline: yes\n")
+		} else if !c.Reachable {
+			fmt.Printf("  reachable: no\n")
+		}
+		for _, l := range c.RateLimits {
+			fmt.Printf("  rate limit %s: %d of %d remaining (resets %s)\n",
+				l.Resource, l.Remaining, l.Limit, l.Reset.Format(time.RFC3339))
+		}
+		if nil != c.Backoff {
+			fmt.Printf("  secondary rate limit: backing off until %s\n", c.Backoff.Format(time.RFC3339))
+		}
+		fmt.Printf("  cache: %d bytes in memory, %d bytes on disk\n", c.CacheMemory, c.CacheDisk)
+		fmt.Printf("  errors: %d\n", len(c.Errors))
+		for _, e := range c.Errors {
+			fmt.Printf("    %s %s %s: %s\n", e.Time.Format(time.RFC3339), e.Op, e.Path, e.Message)
+		}
+	}
+	return 0
+}
+
+// runRefreshCommand discards the cached information of a path under a mountpoint
+// of the daemon and everything below it, so that the next access fetches it from
+// the provider: "refresh [-socket path] path".
+func runRefreshCommand(args []string) int {
+	sockpath := defaultSocketPath()
+	fset := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.StringVar(&sockpath, "socket", sockpath, "daemon control socket `path`")
+	if nil != fset.Parse(args) || 1 != fset.NArg() || "" == fset.Arg(0) {
+		flag.Usage()
+		return exitUsage
+	}
+	p, err := filepath.Abs(fset.Arg(0))
+	if nil != err {
+		warn("refresh error: %v", err)
+		return exitFailure
+	}
+
+	err = controlRequest(newControlClient(sockpath), "POST", "refresh", url.Values{"path": {p}}, nil)
+	if nil != err {
+		warn("refresh error: %s: %v", p, err)
+		return controlExitCode(err)
+	}
+	return 0
+}
+
+// runUnmountCommand unmounts a file system. A file system of the daemon is unmounted
+// by the daemon, which writes its pending changes and removes its temporary cache
+// directory (if it was the last mount of its remote); any other HUBFS file system is
+// unmounted with the unmount tool of the OS (fusermount, umount).
+func runUnmountCommand(args []string) int {
+	sockpath := defaultSocketPath()
+	fset := flag.NewFlagSet("unmount", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.StringVar(&sockpath, "socket", sockpath, "daemon control socket `path`")
+	if nil != fset.Parse(args) || 1 != fset.NArg() || "" == fset.Arg(0) {
+		flag.Usage()
+		return exitUsage
+	}
+	mntpnt := absMountpoint(fset.Arg(0))
+
+	client := newControlClient(sockpath)
+	var mounts []mountInfo
+	err := controlRequest(client, "GET", "mounts", nil, &mounts)
+	if nil != err && errDaemonNotRunning != err {
+		warn("unmount error: %v", err)
+		return exitFailure
+	}
+	daemon := false
+	for _, m := range mounts {
+		if mntpnt == m.Mountpoint {
+			daemon = true
+			break
+		}
+	}
+
+	if daemon {
+		err = controlRequest(client, "POST", "unmount", url.Values{"mountpoint": {mntpnt}}, nil)
+	} else {
+		err = systemUnmount(mntpnt, false)
+	}
+	if nil != err {
+		warn("unmount error: %s: %v", mntpnt, err)
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,377 @@
+/*
+ * daemon.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+// defaultSocketPath returns the path of the control socket of the daemon
+// (e.g. ~/.cache/hubfs/control.sock on Linux).
+func defaultSocketPath() string {
+	dir, err := os.UserCacheDir()
+	if nil != err {
+		return ""
+	}
+	return filepath.Join(dir, strings.ToLower(MyProductName), "control.sock")
+}
+
+// listenControl listens on the control socket. A stale socket left behind by a
+// daemon that did not exit cleanly is removed; a live one is an error.
+func listenControl(sockpath string) (net.Listener, error) {
+	if "" == sockpath {
+		return nil, errors.New("no control socket path")
+	}
+	err := os.MkdirAll(filepath.Dir(sockpath), 0700)
+	if nil != err {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", sockpath); nil == err {
+		conn.Close()
+		return nil, fmt.Errorf("daemon already running: %s", sockpath)
+	}
+	os.Remove(sockpath)
+	listener, err := net.Listen("unix", sockpath)
+	if nil != err {
+		return nil, err
+	}
+	os.Chmod(sockpath, 0600)
+	return listener, nil
+}
+
+// startDaemon runs the daemon in the background by running the current command
+// line again with the -foreground option. It returns once the daemon listens on
+// its control socket.
+func startDaemon(sockpath string) error {
+	exe, err := os.Executable()
+	if nil != err {
+		return err
+	}
+	cmd := exec.Command(exe, append(os.Args[1:], "-foreground")...)
+	cmd.SysProcAttr = daemonSysProcAttr()
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	if nil != err {
+		return err
+	}
+
+	doneC := make(chan error, 1)
+	go func() {
+		doneC <- cmd.Wait()
+	}()
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); {
+		select {
+		case err = <-doneC:
+			if nil == err {
+				err = errors.New("daemon exited")
+			}
+			return err
+		case <-time.After(100 * time.Millisecond):
+		}
+		if conn, err := net.Dial("unix", sockpath); nil == err {
+			conn.Close()
+			return nil
+		}
+	}
+	return errors.New("daemon did not start")
+}
+
+// runDaemon serves the control API on the control socket and mounts the initial
+// mounts. It returns after a stop command or signal, once all file systems have
+// been unmounted.
+func runDaemon(manager *mountManager, remote string, mounts []string, sockpath string,
+	reload func() error) int {
+	listener, err := listenControl(sockpath)
+	if nil != err {
+		warn("daemon error: %v", err)
+		return exitFailure
+	}
+	handler := newControlHandler(manager, remote, reload)
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	for _, m := range mounts {
+		r, p := splitMountSpec(m, remote)
+		err = manager.mount(r, p)
+		if nil != err {
+			warn("%v", err)
+		}
+	}
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigC)
+	select {
+	case <-handler.stopC:
+	case <-sigC:
+	}
+
+	manager.unmountAll()
+	manager.wait()
+	return 0
+}
+
+// controlStats are the statistics reported by the daemon.
+type controlStats struct {
+	Pid        int      `json:"pid"`
+	Uptime     string   `json:"uptime"`
+	Mounts     int      `json:"mounts"`
+	Remotes    []string `json:"remotes"`
+	HeapSize   uint64   `json:"heapsize"`
+	Goroutines int      `json:"goroutines"`
+
+	Cache []repoCacheStats `json:"cache"`
+}
+
+// repoCacheStats are the cache statistics (and provider usage) of a repository of
+// a remote.
+type repoCacheStats struct {
+	Remote string `json:"remote"`
+	prov.CacheStats
+}
+
+// repoUsage is the use of the provider of a remote by a repository, by an owner
+// (e.g. to list its repositories) or in total.
+type repoUsage struct {
+	name     string
+	requests uint64
+	bytes    uint64
+}
+
+// usageTotals returns the use of the provider by every repository and owner that
+// made requests (remote/owner/repo or remote/owner), followed by the total of every
+// remote (remote total).
+func usageTotals(stats []repoCacheStats) []repoUsage {
+	res := []repoUsage{}
+	totals := []repoUsage{}
+	for _, c := range stats {
+		if 0 == len(totals) || c.Remote+" total" != totals[len(totals)-1].name {
+			totals = append(totals, repoUsage{name: c.Remote + " total"})
+		}
+		if 0 == c.Requests && 0 == c.Bytes {
+			continue
+		}
+		name := c.Remote + "/" + c.Owner
+		if "" != c.Repository {
+			name += "/" + c.Repository
+		}
+		res = append(res, repoUsage{name, c.Requests, c.Bytes})
+		t := &totals[len(totals)-1]
+		t.requests += c.Requests
+		t.bytes += c.Bytes
+	}
+	return append(res, totals...)
+}
+
+// controlStatus is the status reported by the daemon.
+type controlStatus struct {
+	controlStats
+	MountList []mountInfo                  `json:"mountlist"`
+	Clients   map[string]prov.ClientStatus `json:"clients"`
+}
+
+type controlHandler struct {
+	http.ServeMux
+	manager *mountManager
+	remote  string
+	reloadf func() error
+	start   time.Time
+	stopC   chan struct{}
+	stop    sync.Once
+}
+
+// newControlHandler returns the handler of the control API of the daemon:
+//
+// - GET /mounts: list the active mounts
+// - GET /stats: report process statistics
+// - GET /status: report process statistics, mounts and status of clients
+// - GET /metrics: report metrics in the Prometheus text exposition format
+// - GET /health: report the health of the mounts and providers
+// - POST /mount (remote, mountpoint): mount the file system of a remote
+// - POST /unmount (mountpoint): unmount a file system
+// - POST /refresh (remote, repo): discard the cached refs of an owner/repo
+// - POST /refresh (path): discard the cached information of a path under a mountpoint
+// - POST /reload: re-read the configuration file and apply its runtime settings
+// - POST /stop: unmount all file systems and stop the daemon
+//
+// The remote defaults to the default remote.
+func newControlHandler(manager *mountManager, remote string, reload func() error) *controlHandler {
+	h := &controlHandler{
+		manager: manager,
+		remote:  remote,
+		reloadf: reload,
+		start:   time.Now(),
+		stopC:   make(chan struct{}),
+	}
+	h.HandleFunc("/mounts", h.get(h.mounts))
+	h.HandleFunc("/stats", h.get(h.stats))
+	h.HandleFunc("/status", h.get(h.status))
+	h.Handle("/metrics", newMetricsHandler(manager))
+	h.Handle("/health", newHealthHandler(manager))
+	h.HandleFunc("/mount", h.post(h.mount))
+	h.HandleFunc("/unmount", h.post(h.unmount))
+	h.HandleFunc("/refresh", h.post(h.refresh))
+	h.HandleFunc("/reload", h.post(h.reload))
+	h.HandleFunc("/dump", h.post(h.dump))
+	h.HandleFunc("/stop", h.post(h.stopDaemon))
+	return h
+}
+
+func (h *controlHandler) get(fn func(req *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if "GET" != req.Method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := fn(req)
+		h.reply(w, result, err)
+	}
+}
+
+func (h *controlHandler) post(fn func(req *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if "POST" != req.Method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := fn(req)
+		h.reply(w, result, err)
+	}
+}
+
+func (h *controlHandler) reply(w http.ResponseWriter, result interface{}, err error) {
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if nil == result {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *controlHandler) formRemote(req *http.Request) string {
+	if remote := req.FormValue("remote"); "" != remote {
+		return remote
+	}
+	return h.remote
+}
+
+func (h *controlHandler) mounts(req *http.Request) (interface{}, error) {
+	return h.manager.list(), nil
+}
+
+func (h *controlHandler) status(req *http.Request) (interface{}, error) {
+	stats, _ := h.stats(req)
+	return controlStatus{
+		controlStats: stats.(controlStats),
+		MountList:    h.manager.list(),
+		Clients:      h.manager.clientStatus(),
+	}, nil
+}
+
+func (h *controlHandler) stats(req *http.Request) (interface{}, error) {
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+
+	stats := controlStats{
+		Pid:        os.Getpid(),
+		Uptime:     time.Since(h.start).Round(time.Second).String(),
+		Mounts:     len(h.manager.list()),
+		Remotes:    h.manager.remotes(),
+		HeapSize:   memstats.HeapAlloc,
+		Goroutines: runtime.NumGoroutine(),
+		Cache:      h.manager.cacheStats(),
+	}
+	return stats, nil
+}
+
+func (h *controlHandler) mount(req *http.Request) (interface{}, error) {
+	mntpnt := req.FormValue("mountpoint")
+	if "" == mntpnt {
+		return nil, errors.New("missing mountpoint")
+	}
+	return nil, h.manager.mount(h.formRemote(req), mntpnt)
+}
+
+func (h *controlHandler) unmount(req *http.Request) (interface{}, error) {
+	mntpnt := req.FormValue("mountpoint")
+	if "" == mntpnt {
+		return nil, errors.New("missing mountpoint")
+	}
+	return nil, h.manager.unmount(mntpnt)
+}
+
+func (h *controlHandler) refresh(req *http.Request) (interface{}, error) {
+	if p := req.FormValue("path"); "" != p {
+		client, rel, err := h.manager.lookupPath(p)
+		if nil != err {
+			return nil, err
+		}
+		client.InvalidatePath(rel)
+		return nil, nil
+	}
+
+	uri, err := parseRemote(h.formRemote(req))
+	if nil != err {
+		return nil, err
+	}
+	client, ok := h.manager.lookupClient(uri)
+	if !ok {
+		return nil, fmt.Errorf("no mounts of remote: %s", prov.GetProviderInstanceName(uri))
+	}
+	repo := strings.TrimPrefix(path.Join(uri.Path, req.FormValue("repo")), "/")
+	i := strings.IndexByte(repo, '/')
+	if 0 >= i || len(repo)-1 == i || -1 != strings.IndexByte(repo[i+1:], '/') {
+		return nil, fmt.Errorf("invalid repository: %s", repo)
+	}
+	client.InvalidateRepository(repo[:i], repo[i+1:])
+	return nil, nil
+}
+
+func (h *controlHandler) reload(req *http.Request) (interface{}, error) {
+	return nil, h.reloadf()
+}
+
+func (h *controlHandler) dump(req *http.Request) (interface{}, error) {
+	dumpState(h.manager)
+	return nil, nil
+}
+
+func (h *controlHandler) stopDaemon(req *http.Request) (interface{}, error) {
+	h.stop.Do(func() {
+		close(h.stopC)
+	})
+	return nil, nil
+}
@@ -0,0 +1,29 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * daemon_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"syscall"
+)
+
+// daemonSysProcAttr detaches the daemon from the session (and terminal) of
+// the command that started it.
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setsid: true,
+	}
+}
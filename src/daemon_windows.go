@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+/*
+ * daemon_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"syscall"
+)
+
+const (
+	_CREATE_NEW_PROCESS_GROUP = 0x00000200
+	_DETACHED_PROCESS         = 0x00000008
+)
+
+// daemonSysProcAttr detaches the daemon from the console of the command that
+// started it.
+func daemonSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: _CREATE_NEW_PROCESS_GROUP | _DETACHED_PROCESS,
+	}
+}
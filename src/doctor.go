@@ -0,0 +1,289 @@
+/*
+ * doctor.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/billziss-gh/golib/appdata"
+	"github.com/billziss-gh/golib/keyring"
+	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// doctorTimeout is the timeout of the network checks.
+const doctorTimeout = 15 * time.Second
+
+// doctor reports the results of the checks of the doctor command.
+type doctor struct {
+	failed int
+	checks []doctorCheck
+}
+
+// doctorCheck is the result of a check as printed with -output json.
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Ok     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+func (d *doctor) report(name string, detail string, fix string, err error) bool {
+	if jsonOutput {
+		c := doctorCheck{Name: name, Ok: nil == err, Detail: detail}
+		if nil != err {
+			d.failed++
+			c.Error, c.Fix = err.Error(), fix
+		}
+		d.checks = append(d.checks, c)
+		return nil == err
+	}
+	if nil != err {
+		d.failed++
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+		if "" != fix {
+			fmt.Printf("      fix: %s\n", fix)
+		}
+		return false
+	}
+	if "" != detail {
+		fmt.Printf("ok    %s: %s\n", name, detail)
+	} else {
+		fmt.Printf("ok    %s\n", name)
+	}
+	return true
+}
+
+// finish prints the results with -output json and returns the exit code: code if
+// any of the checks failed and 0 otherwise.
+func (d *doctor) finish(code int) int {
+	if jsonOutput {
+		printJSON(struct {
+			Checks []doctorCheck `json:"checks"`
+			Failed int           `json:"failed"`
+		}{d.checks, d.failed})
+	}
+	if 0 < d.failed {
+		return code
+	}
+	return 0
+}
+
+// pingProvider sends a request to the host of a remote and reports the response
+// status and latency.
+func pingProvider(uri *url.URL) (string, error) {
+	apiuri := "https://" + uri.Host
+	req, err := http.NewRequest("GET", apiuri, nil)
+	if nil != err {
+		return "", err
+	}
+	client := &http.Client{
+		Transport: httputil.DefaultTransport,
+		Timeout:   doctorTimeout,
+	}
+	start := time.Now()
+	rsp, err := client.Do(req)
+	if nil != err {
+		return "", err
+	}
+	rsp.Body.Close()
+	return fmt.Sprintf("%s (%s, %v)", apiuri, rsp.Status, time.Since(start).Round(time.Millisecond)), nil
+}
+
+// doctorCacheDirs returns the persistent cache directories that are configured with
+// config.dir and config.objdir. The temporary cache directory (config.dir=:) is
+// reported as the application cache directory that contains it.
+func doctorCacheDirs(config []string, mntopt []string) []string {
+	dirs := []string{}
+	for _, m := range append(append([]string{}, config...), mntopt...) {
+		for _, s := range strings.Split(m, ",") {
+			v := strings.TrimPrefix(s, "config.dir=")
+			if s == v {
+				v = strings.TrimPrefix(s, "config.objdir=")
+			}
+			if s == v {
+				continue
+			}
+			if ":" == v {
+				d, err := appdata.CacheDir()
+				if nil != err {
+					continue
+				}
+				v = filepath.Join(d, progname)
+			}
+			dup := false
+			for _, d := range dirs {
+				dup = dup || d == v
+			}
+			if !dup {
+				dirs = append(dirs, v)
+			}
+		}
+	}
+	return dirs
+}
+
+// runDoctorCommand checks the environment of HUBFS and prints a fix for every
+// problem found: "doctor [remote]". It checks FUSE (or WinFsp), the proxy settings,
+// that the provider API is reachable, keyring access and the validity of the auth
+// token (according to the -auth method), and the cache directories.
+func runDoctorCommand(args []string, defremote string, authmeth string, authkey string,
+	config []string, mntopt []string) int {
+	if 1 < len(args) {
+		flag.Usage()
+		return exitUsage
+	}
+	remote := defremote
+	if 1 == len(args) {
+		remote = args[0]
+	}
+
+	d := &doctor{}
+
+	detail, fix, err := checkFuse()
+	d.report("fuse", detail, fix, err)
+
+	uri, err := parseRemote(remote)
+	var provider prov.Provider
+	if nil == err {
+		provider = prov.NewProviderInstance(uri)
+		if nil == provider {
+			err = fmt.Errorf("%w: %s", errUnknownProvider, prov.GetProviderInstanceName(uri))
+		}
+	}
+	if !d.report("remote", remote, "specify a remote from the list in the usage (-h)", err) {
+		return d.finish(exitUsage)
+	}
+	if "" == authkey {
+		authkey = prov.GetProviderInstanceName(uri)
+	}
+
+	req, _ := http.NewRequest("GET", "https://"+uri.Host, nil)
+	proxy, err := httputil.DefaultTransport.Proxy(req)
+	detail = "none"
+	if nil != proxy {
+		detail = proxy.Scheme + "://" + proxy.Host
+	}
+	d.report("proxy", detail, "correct the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables", err)
+
+	detail, err = pingProvider(uri)
+	d.report("network", detail,
+		"check the network connection, firewall and proxy settings; use -offline to work from the cache",
+		err)
+
+	if "" == authmeth {
+		authmeth = "full"
+	}
+	token := ""
+	switch {
+	case "none" == authmeth:
+		d.report("keyring", "not used (-auth none)", "", nil)
+	case "git" == authmeth:
+		d.report("keyring", "not used (-auth git)", "", nil)
+		client, e := gitauthNewClientWithUri(provider, uri)
+		if nil == e {
+			d.report("token", "valid (git credential)", "", nil)
+			if s := client.GetStatus(); "" != s.Login {
+				d.report("login", s.Login, "", nil)
+			}
+		} else {
+			d.report("token", "", "store a token with git credential approve or use another -auth method", e)
+		}
+	case strings.HasPrefix(authmeth, "token="):
+		d.report("keyring", "not used (-auth token=T)", "", nil)
+		token = strings.TrimPrefix(authmeth, "token=")
+	default:
+		t, e := keyring.Get(MyProductName, authkey)
+		if nil == e && "" != t {
+			d.report("keyring", "token "+authkey+" found", "", nil)
+			token = t
+			break
+		}
+		probe := MyProductName + ".doctor"
+		e = keyring.Set(MyProductName, probe, probe)
+		if nil == e {
+			_, e = keyring.Get(MyProductName, probe)
+			keyring.Delete(MyProductName, probe)
+		}
+		if d.report("keyring", "accessible", "unlock the system keyring (on Linux a Secret Service "+
+			"keyring such as gnome-keyring must be running) or use -auth git or -auth token=T", e) {
+			fix := fmt.Sprintf("run %s -authonly %s to authenticate", progname, remote)
+			if "optional" == authmeth {
+				d.report("token", "none (anonymous access)", "", nil)
+			} else {
+				d.report("token", "", fix, errors.New("no token "+authkey+" in keyring"))
+			}
+		}
+	}
+	if "" != token {
+		client, e := provider.NewClient(token)
+		if d.report("token", "valid", fmt.Sprintf("create a new token or run %s -auth force -authonly %s",
+			progname, remote), e) {
+			if s := client.GetStatus(); "" != s.Login {
+				d.report("login", s.Login, "", nil)
+			}
+		}
+	}
+
+	for _, dir := range doctorCacheDirs(config, mntopt) {
+		d.report("cache", dir, "ensure that the directory is writable by "+
+			"the current user or specify another directory with -o config.dir=DIR",
+			prov.CheckCacheDirectory(dir))
+	}
+
+	if 0 < d.failed && !jsonOutput {
+		fmt.Printf("\n%d problem(s) found\n", d.failed)
+	}
+	return d.finish(exitFailure)
+}
+
+// runCheck reports whether a mount would succeed without mounting (-check). The
+// configuration and credentials are checked by the caller when it creates and
+// configures the client; runCheck pings the provider (unless offline) and checks
+// the mountpoints.
+func runCheck(client prov.Client, uri *url.URL, authmeth string, offline bool, mntpnts []string) int {
+	d := &doctor{}
+
+	d.report("config", "", "", nil)
+
+	detail := "anonymous"
+	if s := client.GetStatus(); "" != s.Login {
+		detail = s.Login
+	}
+	d.report("auth", fmt.Sprintf("%s (-auth %s)", detail, strings.SplitN(authmeth, "=", 2)[0]), "", nil)
+
+	code := exitFailure
+	if !offline {
+		detail, err := pingProvider(uri)
+		if !d.report("network", detail, "", err) {
+			code = exitUnreachable
+		}
+	}
+
+	for _, m := range mntpnts {
+		if !d.report("mountpoint", m, "", checkMountpoint(m)) && exitFailure == code {
+			code = exitMount
+		}
+	}
+
+	return d.finish(code)
+}
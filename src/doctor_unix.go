@@ -0,0 +1,91 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * doctor_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// checkFuse checks that FUSE is installed: the FUSE library and device (and the
+// fusermount tool) on Linux, macFUSE on macOS.
+func checkFuse() (detail string, fix string, err error) {
+	if "darwin" == runtime.GOOS {
+		for _, p := range []string{macfusePath, "/Library/Filesystems/osxfuse.fs"} {
+			if _, e := os.Stat(p); nil == e {
+				return p, "", nil
+			}
+		}
+		return "", "install macFUSE (https://osxfuse.github.io) and allow its system extension",
+			errors.New("macFUSE is not installed")
+	}
+
+	found := []string{}
+	libs, _ := filepath.Glob("/usr/lib*/libfuse.so.2")
+	more, _ := filepath.Glob("/usr/lib*/*/libfuse.so.2")
+	libs = append(libs, more...)
+	more, _ = filepath.Glob("/lib*/*/libfuse.so.2")
+	libs = append(libs, more...)
+	if 0 == len(libs) {
+		return "", "install the FUSE 2 library (e.g. apt install libfuse2, dnf install fuse-libs)",
+			errors.New("libfuse.so.2 not found")
+	}
+	found = append(found, libs[0])
+	if _, e := os.Stat("/dev/fuse"); nil != e {
+		return "", "load the fuse kernel module (modprobe fuse); in a container pass --device /dev/fuse",
+			e
+	}
+	found = append(found, "/dev/fuse")
+	tool := ""
+	for _, n := range []string{"fusermount3", "fusermount"} {
+		if p, e := exec.LookPath(n); nil == e {
+			tool = p
+			break
+		}
+	}
+	if "" == tool {
+		return "", "install the FUSE tools (e.g. apt install fuse3, dnf install fuse3)",
+			errors.New("fusermount not found")
+	}
+	found = append(found, tool)
+	return strings.Join(found, ", "), "", nil
+}
+
+// checkMountpoint checks that a directory can be used as a mountpoint: it must be
+// a writable directory that is not already a mountpoint.
+func checkMountpoint(mntpnt string) error {
+	info, err := os.Stat(mntpnt)
+	if nil != err {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", mntpnt)
+	}
+	if isMountpoint(mntpnt) {
+		return fmt.Errorf("%s is already a mountpoint", mntpnt)
+	}
+	if err = syscall.Access(mntpnt, 2 /*W_OK*/); nil != err {
+		return fmt.Errorf("%s is not writable: %v", mntpnt, err)
+	}
+	return nil
+}
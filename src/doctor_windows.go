@@ -0,0 +1,56 @@
+//go:build windows
+// +build windows
+
+/*
+ * doctor_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkFuse checks that WinFsp is installed.
+func checkFuse() (detail string, fix string, err error) {
+	for _, e := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+		d := os.Getenv(e)
+		if "" == d {
+			continue
+		}
+		p := filepath.Join(d, "WinFsp", "bin", "winfsp-x64.dll")
+		if _, e := os.Stat(p); nil == e {
+			return p, "", nil
+		}
+	}
+	return "", "install WinFsp (https://winfsp.dev/rel/)",
+		errors.New("WinFsp is not installed")
+}
+
+// checkMountpoint checks that a drive or directory can be used as a mountpoint:
+// it must not exist (WinFsp creates it) and a directory must have a parent.
+func checkMountpoint(mntpnt string) error {
+	if "*" == mntpnt {
+		return nil
+	}
+	if _, err := os.Stat(mntpnt); nil == err {
+		return fmt.Errorf("%s already exists", mntpnt)
+	}
+	if 2 == len(mntpnt) && ':' == mntpnt[1] {
+		return nil
+	}
+	_, err := os.Stat(filepath.Dir(mntpnt))
+	return err
+}
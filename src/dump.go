@@ -0,0 +1,83 @@
+/*
+ * dump.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+// dumpState logs the internal state of the file systems of a mount manager and of
+// the clients of their remotes, so that hangs can be diagnosed without a debugger:
+// the open handles and operations in progress of every mount and the cache items
+// and requests in flight of every remote. The state is logged at the warn level.
+func dumpState(manager *mountManager) {
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+	util.Logf(util.LogWarn, "state dump: pid %d, goroutines %d, heap %d",
+		os.Getpid(), runtime.NumGoroutine(), memstats.HeapAlloc)
+	for _, m := range manager.list() {
+		handles, inprogress := 0, 0
+		if nil != m.drainfs {
+			handles, inprogress = m.drainfs.Handles(), m.drainfs.InProgress()
+		}
+		util.Logf(util.LogWarn, "state dump: mount %s %s: %d open handles, %d operations in progress",
+			m.Remote, m.Mountpoint, handles, inprogress)
+	}
+	status := manager.clientStatus()
+	now := time.Now()
+	for _, remote := range manager.remotes() {
+		s, ok := status[remote]
+		if !ok {
+			continue
+		}
+		util.Logf(util.LogWarn,
+			"state dump: remote %s: %d cache items (memory %d, disk %d), %d requests in flight",
+			remote, s.CacheItems, s.CacheMemory, s.CacheDisk, len(s.InFlight))
+		for _, r := range s.InFlight {
+			util.Logf(util.LogWarn, "state dump: remote %s: %s %s in flight for %v",
+				remote, r.Op, r.Path, now.Sub(r.Start).Round(time.Millisecond))
+		}
+	}
+}
+
+// watchDump dumps the internal state of a mount manager to the log on the dump
+// signals (SIGUSR1; none on Windows, where the ctl dump command is used instead)
+// until the returned function is called.
+func watchDump(manager *mountManager) (stop func()) {
+	if 0 == len(dumpSignals) {
+		return func() {}
+	}
+	sigC := make(chan os.Signal, 1)
+	doneC := make(chan struct{})
+	signal.Notify(sigC, dumpSignals...)
+	go func() {
+		for {
+			select {
+			case <-sigC:
+				dumpState(manager)
+			case <-doneC:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigC)
+		close(doneC)
+	}
+}
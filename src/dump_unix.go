@@ -0,0 +1,25 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * dump_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals are the signals that dump the internal state to the log.
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
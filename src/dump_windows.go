@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+/*
+ * dump_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"os"
+)
+
+// dumpSignals are the signals that dump the internal state to the log. Windows has
+// no such signal; the state is dumped with the ctl dump command instead.
+var dumpSignals []os.Signal
@@ -0,0 +1,89 @@
+/*
+ * events.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+// eventType is the severity of a reported event.
+type eventType int
+
+const (
+	eventInformation eventType = iota
+	eventWarning
+	eventError
+)
+
+// Event IDs of the reported events. (The Windows Event Log message file of HUBFS is
+// EventCreate.exe, which supports IDs 1 to 1000.)
+const (
+	eventMount        = 1 // a file system was mounted
+	eventUnmount      = 2 // a file system was unmounted
+	eventMountFailure = 3 // a file system could not be mounted
+	eventAuthFailure  = 4 // the provider rejected the credentials
+	eventAuditHead    = 5 // the head of the audit log changed
+)
+
+// eventSink receives the significant events of the process (e.g. mounts and
+// failures) for the monitoring systems of the OS.
+type eventSink interface {
+	report(etype eventType, id uint32, msg string) error
+	close()
+}
+
+// eventSinks are the sinks that receive events (-eventlog).
+var eventSinks []eventSink
+
+// openEventSinks opens the event sinks of a list of the form sink1,sink2,... where
+// each sink is eventlog (the Windows Event Log) or etw (Event Tracing for Windows).
+func openEventSinks(spec string) error {
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if "" == name {
+			continue
+		}
+		sink, err := newEventSink(name)
+		if nil != err {
+			closeEventSinks()
+			return err
+		}
+		eventSinks = append(eventSinks, sink)
+	}
+	return nil
+}
+
+// closeEventSinks closes the event sinks.
+func closeEventSinks() {
+	for _, sink := range eventSinks {
+		sink.close()
+	}
+	eventSinks = nil
+}
+
+// reportEvent reports an event to the event sinks.
+func reportEvent(etype eventType, id uint32, format string, a ...interface{}) {
+	if 0 == len(eventSinks) {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	for _, sink := range eventSinks {
+		if err := sink.report(etype, id, msg); nil != err {
+			util.Logf(util.LogWarn, "event error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * events_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+// newEventSink creates an event sink. Event sinks are only available on Windows;
+// elsewhere the events are in the log.
+func newEventSink(name string) (eventSink, error) {
+	return nil, fmt.Errorf("event sink %s is only available on Windows", name)
+}
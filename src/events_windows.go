@@ -0,0 +1,165 @@
+//go:build windows
+// +build windows
+
+/*
+ * events_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSourceW  = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW          = advapi32.NewProc("ReportEventW")
+	procEventRegister         = advapi32.NewProc("EventRegister")
+	procEventUnregister       = advapi32.NewProc("EventUnregister")
+	procEventWriteString      = advapi32.NewProc("EventWriteString")
+)
+
+// etwProviderId is the ETW provider GUID of HUBFS:
+// {A0E8AD86-BC59-4665-992A-6077FA0C768D}.
+var etwProviderId = syscall.GUID{
+	Data1: 0xa0e8ad86,
+	Data2: 0xbc59,
+	Data3: 0x4665,
+	Data4: [8]byte{0x99, 0x2a, 0x60, 0x77, 0xfa, 0x0c, 0x76, 0x8d},
+}
+
+// newEventSink creates an event sink: eventlog reports events to the Application
+// log of the Windows Event Log (source HUBFS) and etw writes them as strings to the
+// ETW provider of HUBFS.
+func newEventSink(name string) (eventSink, error) {
+	switch name {
+	case "eventlog":
+		return newEventLogSink()
+	case "etw":
+		return newEtwSink()
+	default:
+		return nil, fmt.Errorf("invalid event sink: %s (expected eventlog or etw)", name)
+	}
+}
+
+type eventLogSink struct {
+	handle uintptr
+}
+
+func newEventLogSink() (eventSink, error) {
+	source, err := syscall.UTF16PtrFromString(MyProductName)
+	if nil != err {
+		return nil, err
+	}
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(source)))
+	if 0 == handle {
+		return nil, fmt.Errorf("event log error: %v", err)
+	}
+	return &eventLogSink{handle: handle}, nil
+}
+
+func (sink *eventLogSink) report(etype eventType, id uint32, msg string) error {
+	const (
+		EVENTLOG_ERROR_TYPE       = 0x0001
+		EVENTLOG_WARNING_TYPE     = 0x0002
+		EVENTLOG_INFORMATION_TYPE = 0x0004
+	)
+	wtype := EVENTLOG_INFORMATION_TYPE
+	switch etype {
+	case eventWarning:
+		wtype = EVENTLOG_WARNING_TYPE
+	case eventError:
+		wtype = EVENTLOG_ERROR_TYPE
+	}
+	str, err := syscall.UTF16PtrFromString(msg)
+	if nil != err {
+		return err
+	}
+	strs := []*uint16{str}
+	ok, _, err := procReportEventW.Call(
+		sink.handle,
+		uintptr(wtype),
+		0,
+		uintptr(id),
+		0,
+		uintptr(len(strs)),
+		0,
+		uintptr(unsafe.Pointer(&strs[0])),
+		0)
+	if 0 == ok {
+		return fmt.Errorf("event log error: %v", err)
+	}
+	return nil
+}
+
+func (sink *eventLogSink) close() {
+	procDeregisterEventSource.Call(sink.handle)
+}
+
+// etwSink writes events to ETW. (The REGHANDLE of ETW is 64-bit and is passed in a
+// single register; only 64-bit builds are supported.)
+type etwSink struct {
+	handle uint64
+}
+
+func newEtwSink() (eventSink, error) {
+	if 8 != unsafe.Sizeof(uintptr(0)) {
+		return nil, fmt.Errorf("event sink etw requires a 64-bit build")
+	}
+	sink := &etwSink{}
+	r, _, _ := procEventRegister.Call(
+		uintptr(unsafe.Pointer(&etwProviderId)),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&sink.handle)))
+	if 0 != r {
+		return nil, fmt.Errorf("etw error: %v", syscall.Errno(r))
+	}
+	return sink, nil
+}
+
+func (sink *etwSink) report(etype eventType, id uint32, msg string) error {
+	const (
+		TRACE_LEVEL_ERROR       = 2
+		TRACE_LEVEL_WARNING     = 3
+		TRACE_LEVEL_INFORMATION = 4
+	)
+	level := TRACE_LEVEL_INFORMATION
+	switch etype {
+	case eventWarning:
+		level = TRACE_LEVEL_WARNING
+	case eventError:
+		level = TRACE_LEVEL_ERROR
+	}
+	str, err := syscall.UTF16PtrFromString(msg)
+	if nil != err {
+		return err
+	}
+	// every event ID has its own keyword bit, so that consumers can filter on it
+	r, _, _ := procEventWriteString.Call(
+		uintptr(sink.handle),
+		uintptr(level),
+		uintptr(uint64(1)<<(id-1)),
+		uintptr(unsafe.Pointer(str)))
+	if 0 != r {
+		return fmt.Errorf("etw error: %v", syscall.Errno(r))
+	}
+	return nil
+}
+
+func (sink *etwSink) close() {
+	procEventUnregister.Call(uintptr(sink.handle))
+}
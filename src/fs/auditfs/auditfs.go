@@ -0,0 +1,292 @@
+/*
+ * auditfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package auditfs
+
+import (
+	"os/user"
+	pathutil "path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/util"
+)
+
+// Config configures the audit of a file system. Prefixes are the prefixes (e.g.
+// /owner) of the remotes of the file system by namespace name; the file system of a
+// remote that is not a namespace has the single namespace name "".
+type Config struct {
+	Log      *util.AuditLog
+	Remote   string
+	Prefixes map[string]string
+}
+
+type filesystem struct {
+	fuse.FileSystemInterface
+	Config
+	lock    sync.Mutex
+	writes  map[uint64]*write
+	userMap map[uint32]string
+}
+
+// write accumulates the writes to an open file, which are recorded when the file
+// is released.
+type write struct {
+	user string
+	path string
+	size int64
+	errc int
+}
+
+// New records the modifications made through a file system (e.g. creating, writing,
+// renaming or removing files) in an audit log: who made them, on which paths, of
+// which repository and ref and with what result. Writes to a file are recorded once
+// when the file is released.
+func New(fs fuse.FileSystemInterface, config Config) fuse.FileSystemInterface {
+	return &filesystem{
+		FileSystemInterface: fs,
+		Config:              config,
+		writes:              make(map[uint64]*write),
+		userMap:             make(map[uint32]string),
+	}
+}
+
+// user returns the user that performs the current operation.
+func (fs *filesystem) user() string {
+	uid, _, _ := fuse.Getcontext()
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	name, ok := fs.userMap[uid]
+	if !ok {
+		name = strconv.FormatUint(uint64(uid), 10)
+		if u, err := user.LookupId(name); nil == err {
+			name = u.Username + " (" + name + ")"
+		}
+		fs.userMap[uid] = name
+	}
+	return name
+}
+
+// locate returns the owner/repo and ref of a path.
+func (fs *filesystem) locate(path string) (repo string, ref string) {
+	comp := strings.Split(path, "/")[1:]
+	prefix, ok := fs.Prefixes[""]
+	if !ok {
+		if 0 == len(comp) {
+			return
+		}
+		prefix, ok = fs.Prefixes[comp[0]]
+		if !ok {
+			return
+		}
+		comp = comp[1:]
+	}
+	comp = append(strings.Split(prefix, "/")[1:], comp...)
+	if "" == comp[0] {
+		comp = comp[1:]
+	}
+	if 3 <= len(comp) {
+		repo, ref = comp[0]+"/"+comp[1], comp[2]
+	}
+	return
+}
+
+func result(errc int) string {
+	if 0 <= errc {
+		return "ok"
+	}
+	return "error: " + fuse.Error(-errc).Error()
+}
+
+func (fs *filesystem) record(user string, op string, path string, target string, size int64,
+	errc int) {
+	repo, ref := fs.locate(path)
+	err := fs.Log.Record(util.AuditRecord{
+		User:   user,
+		Remote: fs.Remote,
+		Op:     op,
+		Path:   path,
+		Target: target,
+		Repo:   repo,
+		Ref:    ref,
+		Size:   size,
+		Result: result(errc),
+	})
+	if nil != err {
+		util.Logf(util.LogError, "audit log error: %v", err)
+	}
+}
+
+// audit records an operation on a path once it completes.
+func (fs *filesystem) audit(op string, path string, target string) func(errc *int) {
+	user := fs.user()
+	return func(errc *int) {
+		fs.record(user, op, path, target, 0, *errc)
+	}
+}
+
+func (fs *filesystem) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	defer fs.audit("mknod", path, "")(&errc)
+	return fs.FileSystemInterface.Mknod(path, mode, dev)
+}
+
+func (fs *filesystem) Mkdir(path string, mode uint32) (errc int) {
+	defer fs.audit("mkdir", path, "")(&errc)
+	return fs.FileSystemInterface.Mkdir(path, mode)
+}
+
+func (fs *filesystem) Unlink(path string) (errc int) {
+	defer fs.audit("unlink", path, "")(&errc)
+	return fs.FileSystemInterface.Unlink(path)
+}
+
+func (fs *filesystem) Rmdir(path string) (errc int) {
+	defer fs.audit("rmdir", path, "")(&errc)
+	return fs.FileSystemInterface.Rmdir(path)
+}
+
+func (fs *filesystem) Link(oldpath string, newpath string) (errc int) {
+	defer fs.audit("link", newpath, oldpath)(&errc)
+	return fs.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (fs *filesystem) Symlink(target string, newpath string) (errc int) {
+	defer fs.audit("symlink", newpath, target)(&errc)
+	return fs.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (fs *filesystem) Rename(oldpath string, newpath string) (errc int) {
+	defer fs.audit("rename", oldpath, newpath)(&errc)
+	return fs.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (fs *filesystem) Chmod(path string, mode uint32) (errc int) {
+	defer fs.audit("chmod", path, "0"+strconv.FormatUint(uint64(mode&07777), 8))(&errc)
+	return fs.FileSystemInterface.Chmod(path, mode)
+}
+
+func (fs *filesystem) Chown(path string, uid uint32, gid uint32) (errc int) {
+	defer fs.audit("chown", path,
+		strconv.FormatInt(int64(int32(uid)), 10)+":"+strconv.FormatInt(int64(int32(gid)), 10))(&errc)
+	return fs.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (fs *filesystem) Truncate(path string, size int64, fh uint64) (errc int) {
+	defer fs.audit("truncate", path, strconv.FormatInt(size, 10))(&errc)
+	return fs.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (fs *filesystem) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	defer fs.audit("create", path, "")(&errc)
+	errc, fh = fs.FileSystemInterface.Create(path, flags, mode)
+	if 0 == errc {
+		fs.track(fh, path)
+	}
+	return
+}
+
+func (fs *filesystem) Open(path string, flags int) (errc int, fh uint64) {
+	if 0 != flags&fuse.O_TRUNC && 0 != flags&(fuse.O_WRONLY|fuse.O_RDWR) {
+		defer fs.audit("truncate", path, "0")(&errc)
+	}
+	errc, fh = fs.FileSystemInterface.Open(path, flags)
+	if 0 == errc && 0 != flags&(fuse.O_WRONLY|fuse.O_RDWR) {
+		fs.track(fh, path)
+	}
+	return
+}
+
+// track tracks the writes to a file opened for writing.
+func (fs *filesystem) track(fh uint64, path string) {
+	user := fs.user()
+	fs.lock.Lock()
+	fs.writes[fh] = &write{user: user, path: path}
+	fs.lock.Unlock()
+}
+
+func (fs *filesystem) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	n = fs.FileSystemInterface.Write(path, buff, ofst, fh)
+	fs.lock.Lock()
+	if w, ok := fs.writes[fh]; ok {
+		if 0 <= n {
+			w.size += int64(n)
+		} else if 0 == w.errc {
+			w.errc = n
+		}
+	}
+	fs.lock.Unlock()
+	return
+}
+
+func (fs *filesystem) Release(path string, fh uint64) (errc int) {
+	errc = fs.FileSystemInterface.Release(path, fh)
+	fs.lock.Lock()
+	w, ok := fs.writes[fh]
+	delete(fs.writes, fh)
+	fs.lock.Unlock()
+	if ok && (0 != w.size || 0 != w.errc) {
+		fs.record(w.user, "write", pathutil.Clean(w.path), "", w.size, w.errc)
+	}
+	return
+}
+
+func (fs *filesystem) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	defer fs.audit("setxattr", path, name)(&errc)
+	return fs.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+func (fs *filesystem) Removexattr(path string, name string) (errc int) {
+	defer fs.audit("removexattr", path, name)(&errc)
+	return fs.FileSystemInterface.Removexattr(path, name)
+}
+
+func (fs *filesystem) Getpath(path string, fh uint64) (errc int, normpath string) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemGetpath)
+	if !ok {
+		return -fuse.ENOSYS, ""
+	}
+	return intf.Getpath(path, fh)
+}
+
+func (fs *filesystem) Chflags(path string, flags uint32) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemChflags)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	defer fs.audit("chflags", path, strconv.FormatUint(uint64(flags), 16))(&errc)
+	return intf.Chflags(path, flags)
+}
+
+func (fs *filesystem) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetcrtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setcrtime(path, tmsp)
+}
+
+func (fs *filesystem) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetchgtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setchgtime(path, tmsp)
+}
+
+var _ fuse.FileSystemInterface = (*filesystem)(nil)
+var _ fuse.FileSystemGetpath = (*filesystem)(nil)
+var _ fuse.FileSystemChflags = (*filesystem)(nil)
+var _ fuse.FileSystemSetcrtime = (*filesystem)(nil)
+var _ fuse.FileSystemSetchgtime = (*filesystem)(nil)
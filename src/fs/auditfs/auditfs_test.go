@@ -0,0 +1,123 @@
+/*
+ * auditfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package auditfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/memfs"
+	"github.com/winfsp/hubfs/util"
+)
+
+func TestAudit(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	tmpdir, err := ioutil.TempDir("", "hubfs-auditfs-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	logpath := filepath.Join(tmpdir, "audit.log")
+
+	log, err := util.OpenAuditLog(logpath, []byte("key"))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	basefs := memfs.New()
+	basefs.Mkdir("/repo", 0755)
+	basefs.Mkdir("/repo/main", 0755)
+
+	fs := New(basefs, Config{Log: log, Remote: "github.com/owner", Prefixes: map[string]string{"": "/owner"}})
+	fs.Mkdir("/repo/main/dir", 0755)
+	fs.Mknod("/repo/main/dir/file", fuse.S_IFREG|0644, 0)
+	errc, fh := fs.Open("/repo/main/dir/file", fuse.O_WRONLY|fuse.O_TRUNC)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Write("/repo/main/dir/file", []byte("hello"), 0, fh)
+	fs.Write("/repo/main/dir/file", []byte(" world"), 5, fh)
+	fs.Release("/repo/main/dir/file", fh)
+	errc, fh = fs.Open("/repo/main/dir/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	fs.Release("/repo/main/dir/file", fh)
+	fs.Rename("/repo/main/dir/file", "/repo/main/file")
+	fs.Rmdir("/repo/main/nonexistent")
+	log.Close()
+
+	file, err := os.Open(logpath)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	expected := []util.AuditRecord{
+		{Op: "mkdir", Path: "/repo/main/dir", Result: "ok"},
+		{Op: "mknod", Path: "/repo/main/dir/file", Result: "ok"},
+		{Op: "truncate", Path: "/repo/main/dir/file", Target: "0", Result: "ok"},
+		{Op: "write", Path: "/repo/main/dir/file", Size: 11, Result: "ok"},
+		{Op: "rename", Path: "/repo/main/dir/file", Target: "/repo/main/file", Result: "ok"},
+		{Op: "rmdir", Path: "/repo/main/nonexistent"},
+	}
+	scanner := bufio.NewScanner(file)
+	i := 0
+	for ; scanner.Scan(); i++ {
+		var r util.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); nil != err {
+			t.Fatal(err)
+		}
+		if len(expected) <= i {
+			continue
+		}
+		e := expected[i]
+		if e.Op != r.Op || e.Path != r.Path || e.Target != r.Target || e.Size != r.Size ||
+			"github.com/owner" != r.Remote || "owner/repo" != r.Repo || "main" != r.Ref ||
+			("" != e.Result && e.Result != r.Result) || ("" == e.Result && "ok" == r.Result) {
+			t.Error(i, r)
+		}
+	}
+	if len(expected) != i {
+		t.Error(i)
+	}
+
+	file.Seek(0, 0)
+	if n, _, err := util.VerifyAuditLog(file, []byte("key")); nil != err || len(expected) != n {
+		t.Error(n, err)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	fs := New(nil, Config{Prefixes: map[string]string{"gh": "", "gl": "/group"}}).(*filesystem)
+	for _, c := range []struct {
+		path, repo, ref string
+	}{
+		{"/gh/owner/repo/main/file", "owner/repo", "main"},
+		{"/gh/owner/repo", "", ""},
+		{"/gl/repo/main", "group/repo", "main"},
+		{"/other/owner/repo/main", "", ""},
+		{"/", "", ""},
+	} {
+		repo, ref := fs.locate(c.path)
+		if c.repo != repo || c.ref != ref {
+			t.Error(c.path, repo, ref)
+		}
+	}
+}
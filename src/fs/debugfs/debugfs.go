@@ -0,0 +1,334 @@
+/*
+ * debugfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package debugfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/util"
+)
+
+// Filter selects the file system operations that are logged: operations of the
+// listed kinds (all kinds if none are listed) on paths under one of the listed
+// paths (all paths if none are listed).
+type Filter struct {
+	ops   map[string]bool
+	paths []string
+}
+
+var opnames = map[string]bool{}
+
+func init() {
+	for _, n := range []string{
+		"statfs", "mknod", "mkdir", "unlink", "rmdir", "link", "symlink", "readlink",
+		"rename", "chmod", "chown", "utimens", "access", "create", "open", "getattr",
+		"truncate", "read", "write", "flush", "release", "fsync", "opendir", "readdir",
+		"releasedir", "fsyncdir", "setxattr", "getxattr", "removexattr", "listxattr",
+		"getpath", "chflags", "setcrtime", "setchgtime",
+	} {
+		opnames[n] = true
+	}
+}
+
+// ParseFilter parses a filter of the form item1,item2,... where each item is an
+// operation name (e.g. open, read) or a path (e.g. /owner/repo). The item "all"
+// selects all operations.
+func ParseFilter(spec string) (*Filter, error) {
+	f := &Filter{ops: map[string]bool{}}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		switch {
+		case "" == s || "all" == s:
+		case strings.HasPrefix(s, "/"):
+			if "/" != s {
+				f.paths = append(f.paths, strings.TrimRight(s, "/"))
+			}
+		default:
+			s = strings.ToLower(s)
+			if !opnames[s] {
+				ops := make([]string, 0, len(opnames))
+				for n := range opnames {
+					ops = append(ops, n)
+				}
+				sort.Strings(ops)
+				return nil, fmt.Errorf("unknown FUSE operation: %s (expected one of %s)",
+					s, strings.Join(ops, ","))
+			}
+			f.ops[s] = true
+		}
+	}
+	return f, nil
+}
+
+// Match determines if an operation on a path is selected by the filter.
+func (f *Filter) Match(op string, path string) bool {
+	if 0 != len(f.ops) && !f.ops[op] {
+		return false
+	}
+	if 0 == len(f.paths) {
+		return true
+	}
+	for _, p := range f.paths {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type filesystem struct {
+	fuse.FileSystemInterface
+	filter *Filter
+}
+
+// New logs the operations on a file system that are selected by the filter (if not
+// nil) and the operations that are slow (see util.SetSlowThreshold). Each operation
+// is logged (at the info level, or the warn level if slow) when it completes,
+// together with its latency and error (if any).
+func New(fs fuse.FileSystemInterface, filter *Filter) fuse.FileSystemInterface {
+	return &filesystem{
+		FileSystemInterface: fs,
+		filter:              filter,
+	}
+}
+
+func nop(*int) {}
+
+// trace starts logging an operation on one or more paths; the returned function
+// logs its result.
+func (fs *filesystem) trace(op string, paths ...string) func(errc *int) {
+	match := false
+	if nil != fs.filter {
+		for _, p := range paths {
+			if fs.filter.Match(op, p) {
+				match = true
+				break
+			}
+		}
+	}
+	if !match && 0 == util.GetSlowThreshold() {
+		return nop
+	}
+	start := time.Now()
+	return func(errc *int) {
+		latency := time.Since(start)
+		if !match && !util.IsSlow(latency) {
+			return
+		}
+		var err error
+		if 0 > *errc {
+			err = fuse.Error(-*errc)
+		}
+		util.LogOp(util.LogInfo, op, strings.Join(paths, " -> "), "fuse", latency, err)
+	}
+}
+
+func (fs *filesystem) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	defer fs.trace("statfs", path)(&errc)
+	return fs.FileSystemInterface.Statfs(path, stat)
+}
+
+func (fs *filesystem) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	defer fs.trace("mknod", path)(&errc)
+	return fs.FileSystemInterface.Mknod(path, mode, dev)
+}
+
+func (fs *filesystem) Mkdir(path string, mode uint32) (errc int) {
+	defer fs.trace("mkdir", path)(&errc)
+	return fs.FileSystemInterface.Mkdir(path, mode)
+}
+
+func (fs *filesystem) Unlink(path string) (errc int) {
+	defer fs.trace("unlink", path)(&errc)
+	return fs.FileSystemInterface.Unlink(path)
+}
+
+func (fs *filesystem) Rmdir(path string) (errc int) {
+	defer fs.trace("rmdir", path)(&errc)
+	return fs.FileSystemInterface.Rmdir(path)
+}
+
+func (fs *filesystem) Link(oldpath string, newpath string) (errc int) {
+	defer fs.trace("link", oldpath, newpath)(&errc)
+	return fs.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (fs *filesystem) Symlink(target string, newpath string) (errc int) {
+	defer fs.trace("symlink", newpath)(&errc)
+	return fs.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (fs *filesystem) Readlink(path string) (errc int, target string) {
+	defer fs.trace("readlink", path)(&errc)
+	return fs.FileSystemInterface.Readlink(path)
+}
+
+func (fs *filesystem) Rename(oldpath string, newpath string) (errc int) {
+	defer fs.trace("rename", oldpath, newpath)(&errc)
+	return fs.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (fs *filesystem) Chmod(path string, mode uint32) (errc int) {
+	defer fs.trace("chmod", path)(&errc)
+	return fs.FileSystemInterface.Chmod(path, mode)
+}
+
+func (fs *filesystem) Chown(path string, uid uint32, gid uint32) (errc int) {
+	defer fs.trace("chown", path)(&errc)
+	return fs.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (fs *filesystem) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	defer fs.trace("utimens", path)(&errc)
+	return fs.FileSystemInterface.Utimens(path, tmsp)
+}
+
+func (fs *filesystem) Access(path string, mask uint32) (errc int) {
+	defer fs.trace("access", path)(&errc)
+	return fs.FileSystemInterface.Access(path, mask)
+}
+
+func (fs *filesystem) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	defer fs.trace("create", path)(&errc)
+	return fs.FileSystemInterface.Create(path, flags, mode)
+}
+
+func (fs *filesystem) Open(path string, flags int) (errc int, fh uint64) {
+	defer fs.trace("open", path)(&errc)
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
+func (fs *filesystem) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
+	defer fs.trace("getattr", path)(&errc)
+	return fs.FileSystemInterface.Getattr(path, stat, fh)
+}
+
+func (fs *filesystem) Truncate(path string, size int64, fh uint64) (errc int) {
+	defer fs.trace("truncate", path)(&errc)
+	return fs.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (fs *filesystem) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	defer fs.trace("read", path)(&n)
+	return fs.FileSystemInterface.Read(path, buff, ofst, fh)
+}
+
+func (fs *filesystem) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	defer fs.trace("write", path)(&n)
+	return fs.FileSystemInterface.Write(path, buff, ofst, fh)
+}
+
+func (fs *filesystem) Flush(path string, fh uint64) (errc int) {
+	defer fs.trace("flush", path)(&errc)
+	return fs.FileSystemInterface.Flush(path, fh)
+}
+
+func (fs *filesystem) Release(path string, fh uint64) (errc int) {
+	defer fs.trace("release", path)(&errc)
+	return fs.FileSystemInterface.Release(path, fh)
+}
+
+func (fs *filesystem) Fsync(path string, datasync bool, fh uint64) (errc int) {
+	defer fs.trace("fsync", path)(&errc)
+	return fs.FileSystemInterface.Fsync(path, datasync, fh)
+}
+
+func (fs *filesystem) Opendir(path string) (errc int, fh uint64) {
+	defer fs.trace("opendir", path)(&errc)
+	return fs.FileSystemInterface.Opendir(path)
+}
+
+func (fs *filesystem) Readdir(path string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+	defer fs.trace("readdir", path)(&errc)
+	return fs.FileSystemInterface.Readdir(path, fill, ofst, fh)
+}
+
+func (fs *filesystem) Releasedir(path string, fh uint64) (errc int) {
+	defer fs.trace("releasedir", path)(&errc)
+	return fs.FileSystemInterface.Releasedir(path, fh)
+}
+
+func (fs *filesystem) Fsyncdir(path string, datasync bool, fh uint64) (errc int) {
+	defer fs.trace("fsyncdir", path)(&errc)
+	return fs.FileSystemInterface.Fsyncdir(path, datasync, fh)
+}
+
+func (fs *filesystem) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	defer fs.trace("setxattr", path)(&errc)
+	return fs.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+func (fs *filesystem) Getxattr(path string, name string) (errc int, xatr []byte) {
+	defer fs.trace("getxattr", path)(&errc)
+	return fs.FileSystemInterface.Getxattr(path, name)
+}
+
+func (fs *filesystem) Removexattr(path string, name string) (errc int) {
+	defer fs.trace("removexattr", path)(&errc)
+	return fs.FileSystemInterface.Removexattr(path, name)
+}
+
+func (fs *filesystem) Listxattr(path string, fill func(name string) bool) (errc int) {
+	defer fs.trace("listxattr", path)(&errc)
+	return fs.FileSystemInterface.Listxattr(path, fill)
+}
+
+func (fs *filesystem) Getpath(path string, fh uint64) (errc int, normpath string) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemGetpath)
+	if !ok {
+		return -fuse.ENOSYS, ""
+	}
+	defer fs.trace("getpath", path)(&errc)
+	return intf.Getpath(path, fh)
+}
+
+func (fs *filesystem) Chflags(path string, flags uint32) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemChflags)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	defer fs.trace("chflags", path)(&errc)
+	return intf.Chflags(path, flags)
+}
+
+func (fs *filesystem) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetcrtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	defer fs.trace("setcrtime", path)(&errc)
+	return intf.Setcrtime(path, tmsp)
+}
+
+func (fs *filesystem) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetchgtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	defer fs.trace("setchgtime", path)(&errc)
+	return intf.Setchgtime(path, tmsp)
+}
+
+var _ fuse.FileSystemInterface = (*filesystem)(nil)
+var _ fuse.FileSystemGetpath = (*filesystem)(nil)
+var _ fuse.FileSystemChflags = (*filesystem)(nil)
+var _ fuse.FileSystemSetcrtime = (*filesystem)(nil)
+var _ fuse.FileSystemSetchgtime = (*filesystem)(nil)
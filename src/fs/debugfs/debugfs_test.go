@@ -0,0 +1,119 @@
+/*
+ * debugfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package debugfs
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/memfs"
+	"github.com/winfsp/hubfs/util"
+)
+
+func TestParseFilter(t *testing.T) {
+	if _, err := ParseFilter("open,bogus"); nil == err {
+		t.Error()
+	}
+
+	f, err := ParseFilter("open,Read,/owner/repo/")
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		op, path string
+		match    bool
+	}{
+		{"open", "/owner/repo", true},
+		{"read", "/owner/repo/file", true},
+		{"open", "/owner/repository", false},
+		{"getattr", "/owner/repo/file", false},
+		{"open", "/owner", false},
+	} {
+		if c.match != f.Match(c.op, c.path) {
+			t.Error(c.op, c.path)
+		}
+	}
+
+	f, err = ParseFilter("all")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !f.Match("getattr", "/anything") {
+		t.Error()
+	}
+}
+
+func TestLog(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	buf := &bytes.Buffer{}
+	util.SetLogOutput(buf)
+	util.SetLogLevel(util.LogInfo)
+	defer util.SetLogOutput(os.Stderr)
+	defer util.SetLogLevel(util.LogWarn)
+
+	basefs := memfs.New()
+	basefs.Mkdir("/dir", 0755)
+	basefs.Mknod("/dir/file", fuse.S_IFREG|0644, 0)
+	basefs.Mknod("/file", fuse.S_IFREG|0644, 0)
+
+	f, _ := ParseFilter("open,/dir")
+	fs := New(basefs, f)
+	for _, p := range []string{"/dir/file", "/file", "/dir/nonexistent"} {
+		stat := fuse.Stat_t{}
+		fs.Getattr(p, &stat, ^uint64(0))
+		if errc, fh := fs.Open(p, fuse.O_RDONLY); 0 == errc {
+			fs.Release(p, fh)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if 2 != len(lines) ||
+		!strings.Contains(lines[0], "open /dir/file [fuse") ||
+		!strings.Contains(lines[1], "open /dir/nonexistent [fuse") {
+		t.Error(lines)
+	}
+}
+
+func TestSlow(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	buf := &bytes.Buffer{}
+	util.SetLogOutput(buf)
+	defer util.SetLogOutput(os.Stderr)
+	defer util.SetSlowThreshold(0)
+
+	basefs := memfs.New()
+	basefs.Mknod("/file", fuse.S_IFREG|0644, 0)
+
+	fs := New(basefs, nil)
+	stat := fuse.Stat_t{}
+
+	util.SetSlowThreshold(time.Hour)
+	fs.Getattr("/file", &stat, ^uint64(0))
+	if 0 != buf.Len() {
+		t.Error(buf.String())
+	}
+
+	util.SetSlowThreshold(time.Nanosecond)
+	fs.Getattr("/file", &stat, ^uint64(0))
+	if !strings.Contains(buf.String(), " WARN  getattr /file [fuse ") ||
+		!strings.Contains(buf.String(), " slow]") {
+		t.Error(buf.String())
+	}
+}
@@ -0,0 +1,447 @@
+/*
+ * drainfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package drainfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+// FileSystem tracks the operations in progress on another file system, so that
+// it can be drained before it is unmounted: once draining, new operations fail
+// with EIO, while operations that close or flush open files are still passed
+// through, so that pending changes are written. It also tracks the open handles
+// and the time of the last operation, so that idle file systems can be unmounted.
+type FileSystem struct {
+	fuse.FileSystemInterface
+	timeout time.Duration
+	lock    sync.Mutex
+	count   int
+	handles int
+	last    time.Time
+	closed  bool
+	idleC   chan struct{}
+}
+
+// New creates a FileSystem. The file system is drained (for up to timeout) before
+// it is destroyed.
+func New(fs fuse.FileSystemInterface, timeout time.Duration) *FileSystem {
+	return &FileSystem{
+		FileSystemInterface: fs,
+		timeout:             timeout,
+		last:                time.Now(),
+		idleC:               make(chan struct{}),
+	}
+}
+
+func (fs *FileSystem) enter(always bool) bool {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.closed && !always {
+		return false
+	}
+	fs.count++
+	return true
+}
+
+func (fs *FileSystem) leave() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.count--
+	fs.last = time.Now()
+	fs.signalIdle()
+}
+
+// addHandle counts the handles opened (delta > 0) or released (delta < 0) by a
+// successful operation.
+func (fs *FileSystem) addHandle(errc int, delta int) {
+	if 0 != errc {
+		return
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.handles += delta
+}
+
+// Idle returns the time since the last operation completed, or 0 if there are
+// operations in progress or open handles.
+func (fs *FileSystem) Idle() time.Duration {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if 0 != fs.count || 0 < fs.handles {
+		return 0
+	}
+	return time.Since(fs.last)
+}
+
+// InProgress returns the number of operations in progress.
+func (fs *FileSystem) InProgress() int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.count
+}
+
+// Handles returns the number of open handles.
+func (fs *FileSystem) Handles() int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.handles
+}
+
+// signalIdle signals that the file system is drained. Must be called with the
+// lock held.
+func (fs *FileSystem) signalIdle() {
+	if !fs.closed || 0 != fs.count {
+		return
+	}
+	select {
+	case <-fs.idleC:
+	default:
+		close(fs.idleC)
+	}
+}
+
+// Drain stops accepting new operations and waits until the operations in progress
+// complete or the timeout elapses. It returns the number of operations that are
+// still in progress.
+func (fs *FileSystem) Drain(timeout time.Duration) int {
+	fs.lock.Lock()
+	fs.closed = true
+	fs.signalIdle()
+	idleC := fs.idleC
+	fs.lock.Unlock()
+
+	select {
+	case <-idleC:
+	case <-time.After(timeout):
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.count
+}
+
+// Resume accepts new operations again (e.g. after a failed unmount).
+func (fs *FileSystem) Resume() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.closed {
+		fs.closed = false
+		fs.idleC = make(chan struct{})
+	}
+}
+
+func (fs *FileSystem) Destroy() {
+	fs.Drain(fs.timeout)
+	fs.FileSystemInterface.Destroy()
+}
+
+func (fs *FileSystem) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Statfs(path, stat)
+}
+
+func (fs *FileSystem) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Mknod(path, mode, dev)
+}
+
+func (fs *FileSystem) Mkdir(path string, mode uint32) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Mkdir(path, mode)
+}
+
+func (fs *FileSystem) Unlink(path string) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Unlink(path)
+}
+
+func (fs *FileSystem) Rmdir(path string) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Rmdir(path)
+}
+
+func (fs *FileSystem) Link(oldpath string, newpath string) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Link(oldpath, newpath)
+}
+
+func (fs *FileSystem) Symlink(target string, newpath string) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Symlink(target, newpath)
+}
+
+func (fs *FileSystem) Readlink(path string) (errc int, target string) {
+	if !fs.enter(false) {
+		return -fuse.EIO, ""
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Readlink(path)
+}
+
+func (fs *FileSystem) Rename(oldpath string, newpath string) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Rename(oldpath, newpath)
+}
+
+func (fs *FileSystem) Chmod(path string, mode uint32) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Chmod(path, mode)
+}
+
+func (fs *FileSystem) Chown(path string, uid uint32, gid uint32) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Chown(path, uid, gid)
+}
+
+func (fs *FileSystem) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Utimens(path, tmsp)
+}
+
+func (fs *FileSystem) Access(path string, mask uint32) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Access(path, mask)
+}
+
+func (fs *FileSystem) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	if !fs.enter(false) {
+		return -fuse.EIO, ^uint64(0)
+	}
+	defer fs.leave()
+	errc, fh = fs.FileSystemInterface.Create(path, flags, mode)
+	fs.addHandle(errc, +1)
+	return
+}
+
+func (fs *FileSystem) Open(path string, flags int) (errc int, fh uint64) {
+	if !fs.enter(false) {
+		return -fuse.EIO, ^uint64(0)
+	}
+	defer fs.leave()
+	errc, fh = fs.FileSystemInterface.Open(path, flags)
+	fs.addHandle(errc, +1)
+	return
+}
+
+func (fs *FileSystem) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Getattr(path, stat, fh)
+}
+
+func (fs *FileSystem) Truncate(path string, size int64, fh uint64) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Truncate(path, size, fh)
+}
+
+func (fs *FileSystem) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Read(path, buff, ofst, fh)
+}
+
+func (fs *FileSystem) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Write(path, buff, ofst, fh)
+}
+
+func (fs *FileSystem) Flush(path string, fh uint64) (errc int) {
+	fs.enter(true)
+	defer fs.leave()
+	return fs.FileSystemInterface.Flush(path, fh)
+}
+
+func (fs *FileSystem) Release(path string, fh uint64) (errc int) {
+	fs.enter(true)
+	defer fs.leave()
+	errc = fs.FileSystemInterface.Release(path, fh)
+	fs.addHandle(errc, -1)
+	return
+}
+
+func (fs *FileSystem) Fsync(path string, datasync bool, fh uint64) (errc int) {
+	fs.enter(true)
+	defer fs.leave()
+	return fs.FileSystemInterface.Fsync(path, datasync, fh)
+}
+
+func (fs *FileSystem) Opendir(path string) (errc int, fh uint64) {
+	if !fs.enter(false) {
+		return -fuse.EIO, ^uint64(0)
+	}
+	defer fs.leave()
+	errc, fh = fs.FileSystemInterface.Opendir(path)
+	fs.addHandle(errc, +1)
+	return
+}
+
+func (fs *FileSystem) Readdir(path string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Readdir(path, fill, ofst, fh)
+}
+
+func (fs *FileSystem) Releasedir(path string, fh uint64) (errc int) {
+	fs.enter(true)
+	defer fs.leave()
+	errc = fs.FileSystemInterface.Releasedir(path, fh)
+	fs.addHandle(errc, -1)
+	return
+}
+
+func (fs *FileSystem) Fsyncdir(path string, datasync bool, fh uint64) (errc int) {
+	fs.enter(true)
+	defer fs.leave()
+	return fs.FileSystemInterface.Fsyncdir(path, datasync, fh)
+}
+
+func (fs *FileSystem) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Setxattr(path, name, value, flags)
+}
+
+func (fs *FileSystem) Getxattr(path string, name string) (errc int, xatr []byte) {
+	if !fs.enter(false) {
+		return -fuse.EIO, nil
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Getxattr(path, name)
+}
+
+func (fs *FileSystem) Removexattr(path string, name string) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Removexattr(path, name)
+}
+
+func (fs *FileSystem) Listxattr(path string, fill func(name string) bool) (errc int) {
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return fs.FileSystemInterface.Listxattr(path, fill)
+}
+
+func (fs *FileSystem) Getpath(path string, fh uint64) (errc int, normpath string) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemGetpath)
+	if !ok {
+		return -fuse.ENOSYS, ""
+	}
+	if !fs.enter(false) {
+		return -fuse.EIO, ""
+	}
+	defer fs.leave()
+	return intf.Getpath(path, fh)
+}
+
+func (fs *FileSystem) Chflags(path string, flags uint32) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemChflags)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return intf.Chflags(path, flags)
+}
+
+func (fs *FileSystem) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetcrtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return intf.Setcrtime(path, tmsp)
+}
+
+func (fs *FileSystem) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetchgtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	if !fs.enter(false) {
+		return -fuse.EIO
+	}
+	defer fs.leave()
+	return intf.Setchgtime(path, tmsp)
+}
+
+var _ fuse.FileSystemInterface = (*FileSystem)(nil)
+var _ fuse.FileSystemGetpath = (*FileSystem)(nil)
+var _ fuse.FileSystemChflags = (*FileSystem)(nil)
+var _ fuse.FileSystemSetcrtime = (*FileSystem)(nil)
+var _ fuse.FileSystemSetchgtime = (*FileSystem)(nil)
@@ -0,0 +1,117 @@
+/*
+ * drainfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package drainfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/memfs"
+)
+
+type testfs struct {
+	fuse.FileSystemInterface
+	readC chan struct{}
+}
+
+func (fs *testfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	<-fs.readC
+	return fs.FileSystemInterface.Read(path, buff, ofst, fh)
+}
+
+func TestDrain(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	basefs := &testfs{FileSystemInterface: memfs.New(), readC: make(chan struct{})}
+	basefs.Mknod("/file", fuse.S_IFREG|0644, 0)
+
+	fs := New(basefs, time.Second)
+	errc, fh := fs.Open("/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+
+	doneC := make(chan int)
+	go func() {
+		doneC <- fs.Read("/file", make([]byte, 16), 0, fh)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if n := fs.InProgress(); 1 != n {
+		t.Error(n)
+	}
+	if n := fs.Drain(100 * time.Millisecond); 1 != n {
+		t.Error(n)
+	}
+	stat := fuse.Stat_t{}
+	if errc = fs.Getattr("/file", &stat, ^uint64(0)); -fuse.EIO != errc {
+		t.Error(errc)
+	}
+
+	close(basefs.readC)
+	if n := <-doneC; 0 != n {
+		t.Error(n)
+	}
+	if n := fs.Drain(100 * time.Millisecond); 0 != n {
+		t.Error(n)
+	}
+
+	// open files can still be closed
+	if errc = fs.Release("/file", fh); 0 != errc {
+		t.Error(errc)
+	}
+
+	fs.Resume()
+	if errc = fs.Getattr("/file", &stat, ^uint64(0)); 0 != errc {
+		t.Error(errc)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	basefs := memfs.New()
+	basefs.Mknod("/file", fuse.S_IFREG|0644, 0)
+
+	fs := New(basefs, time.Second)
+	errc, fh := fs.Open("/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if d := fs.Idle(); 0 != d {
+		t.Error("open file: idle", d)
+	}
+	if n := fs.Handles(); 1 != n {
+		t.Error("open file: handles", n)
+	}
+
+	if errc = fs.Release("/file", fh); 0 != errc {
+		t.Error(errc)
+	}
+	if n := fs.Handles(); 0 != n {
+		t.Error("released file: handles", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if d := fs.Idle(); 50*time.Millisecond > d {
+		t.Error("released file: idle", d)
+	}
+
+	stat := fuse.Stat_t{}
+	fs.Getattr("/file", &stat, ^uint64(0))
+	if d := fs.Idle(); 50*time.Millisecond <= d {
+		t.Error("after getattr: idle", d)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * hubdir.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/prov"
+)
+
+// The .hubfs directory presents the state of the file system as read-only virtual
+// files, whose contents are generated when they are opened. It is available at the
+// root of every mount (or of every namespace of a namespace mount), but it is not
+// listed.
+const hubdirName = ".hubfs"
+
+var hubfiles = map[string]func(c prov.Client) string{
+	"ratelimit": ratelimitText,
+	"usage":     usageText,
+}
+
+// ratelimitText reports the remaining request budget of the rate limited resources
+// of the provider and the backoff of a secondary rate limit (if any).
+func ratelimitText(c prov.Client) string {
+	s := c.GetStatus()
+	b := strings.Builder{}
+	for _, l := range s.RateLimits {
+		fmt.Fprintf(&b, "%s: %d of %d remaining (resets %s)\n",
+			l.Resource, l.Remaining, l.Limit, l.Reset.Format(time.RFC3339))
+	}
+	if nil != s.Backoff {
+		fmt.Fprintf(&b, "secondary: backing off until %s\n", s.Backoff.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// usageText reports the requests to the provider and the bytes downloaded from it
+// by every repository (and owner) and in total.
+func usageText(c prov.Client) string {
+	b := strings.Builder{}
+	var requests, bytes uint64
+	for _, s := range c.GetCacheStats() {
+		if 0 == s.Requests && 0 == s.Bytes {
+			continue
+		}
+		name := s.Owner
+		if "" != s.Repository {
+			name += "/" + s.Repository
+		}
+		fmt.Fprintf(&b, "%s: %d requests, %d bytes downloaded\n", name, s.Requests, s.Bytes)
+		requests += s.Requests
+		bytes += s.Bytes
+	}
+	fmt.Fprintf(&b, "total: %d requests, %d bytes downloaded\n", requests, bytes)
+	return b.String()
+}
+
+func (fs *hubfs) openhub(lst []string) (errc int, res *obstack) {
+	obs := &obstack{hubdir: true}
+	switch len(lst) {
+	case 1:
+	case 2:
+		gen, ok := hubfiles[lst[1]]
+		if !ok {
+			errc = -fuse.ENOENT
+			return
+		}
+		obs.hubfile = lst[1]
+		obs.reader = strings.NewReader(gen(fs.client))
+	default:
+		errc = -fuse.ENOENT
+		return
+	}
+	res = obs
+	return
+}
+
+func hubdirNames() []string {
+	names := make([]string, 0, len(hubfiles))
+	for n := range hubfiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -14,6 +14,7 @@
 package hubfs
 
 import (
+	"fmt"
 	"io"
 	pathutil "path"
 	"path/filepath"
@@ -26,15 +27,18 @@ import (
 	"github.com/winfsp/cgofuse/fuse"
 	"github.com/winfsp/hubfs/fs/port"
 	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
 )
 
 type hubfs struct {
 	fuse.FileSystemBase
-	client  prov.Client
-	prefix  string
-	lock    sync.RWMutex
-	fh      uint64
-	openmap map[uint64]*obstack
+	client   prov.Client
+	prefix   string
+	init     func()
+	lock     sync.RWMutex
+	fh       uint64
+	openmap  map[uint64]*obstack
+	searches map[string]*searchResults
 }
 
 type obstack struct {
@@ -43,30 +47,73 @@ type obstack struct {
 	ref        prov.Ref
 	entry      prov.TreeEntry
 	reader     io.ReaderAt
+	search     bool
+	query      string
+	results    []prov.SearchResult
+	result     prov.SearchResult
+	hubdir     bool
+	hubfile    string
+	dirents    []dirent
+}
+
+// searchResults are the results of a search query that are kept while a directory of
+// the query is open, so that lookups of the result names do not repeat the search.
+type searchResults struct {
+	results []prov.SearchResult
+	refs    int
+}
+
+// dirent is a directory entry. Directory listings are captured when a directory is
+// first read, so that large directories can be read in chunks at stable offsets.
+type dirent struct {
+	name   string
+	entry  prov.TreeEntry // tree entries
+	target string         // search results
 }
 
 type Config struct {
-	Client  prov.Client
-	Prefix  string
-	Caseins bool
-	Overlay bool
+	Client   prov.Client
+	Prefix   string
+	Caseins  bool
+	Overlay  bool
+	ReadOnly bool   // fail all modifications with EROFS (implies no Overlay)
+	Init     func() // called when the file system has been mounted
 }
 
 func new(c Config) fuse.FileSystemInterface {
 	return &hubfs{
-		client:  c.Client,
-		prefix:  c.Prefix,
-		openmap: make(map[uint64]*obstack),
+		client:   c.Client,
+		prefix:   c.Prefix,
+		init:     c.Init,
+		openmap:  make(map[uint64]*obstack),
+		searches: make(map[string]*searchResults),
+	}
+}
+
+func (fs *hubfs) Init() {
+	if nil != fs.init {
+		fs.init()
 	}
 }
 
-func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []string) {
+func (fs *hubfs) openex(sp *util.Span, path string, norm bool) (errc int, res *obstack, lst []string) {
 	if strings.HasSuffix(path, "/.") {
 		errc = -fuse.ENOENT
 		return
 	}
 
+	if comp := split(path); 0 < len(comp) && hubdirName == comp[0] {
+		errc, res = fs.openhub(comp)
+		lst = append(split(fs.prefix), comp...)
+		return
+	}
+
 	lst = split(pathutil.Join(fs.prefix, path))
+	if "" == fs.prefix && 0 < len(lst) && searchName == lst[0] {
+		errc, res = fs.opensearch(sp, lst)
+		return
+	}
+
 	obs := &obstack{}
 	var err error
 	for i, c := range lst {
@@ -79,26 +126,35 @@ func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []s
 			if -1 != strings.IndexFunc(c, func(r rune) bool { return '.' == r }) || "HEAD" == c {
 				obs.owner, err = nil, prov.ErrNotFound
 			} else {
+				s := startLookup(sp, "OpenOwner", "owner", c)
 				obs.owner, err = fs.client.OpenOwner(c)
+				endLookup(s, err)
 				if norm && nil == err {
 					lst[i] = obs.owner.Name()
 				}
 			}
 		case 1:
+			s := startLookup(sp, "OpenRepository", "owner", obs.owner.Name(), "repo", c)
 			obs.repository, err = fs.client.OpenRepository(obs.owner, c)
+			endLookup(s, err)
 			if norm && nil == err {
 				lst[i] = obs.repository.Name()
 			}
 		case 2:
+			s := startLookup(sp, "GetRef", "repo", obs.repository.Name(), "ref", c)
 			obs.ref, err = obs.repository.GetRef(c)
 			if prov.ErrNotFound == err {
 				obs.ref, err = obs.repository.GetTempRef(c)
 			}
+			endLookup(s, err)
 			if norm && nil == err {
 				lst[i] = obs.ref.Name()
 			}
 		default:
+			s := startLookup(sp, "GetTreeEntry", "repo", obs.repository.Name(), "ref", obs.ref.Name(),
+				"name", c)
 			obs.entry, err = obs.repository.GetTreeEntry(obs.ref, obs.entry, c)
+			endLookup(s, err)
 			if norm && nil == err {
 				lst[i] = obs.entry.Name()
 			}
@@ -113,8 +169,77 @@ func (fs *hubfs) openex(path string, norm bool) (errc int, res *obstack, lst []s
 	return
 }
 
-func (fs *hubfs) open(path string) (errc int, res *obstack) {
-	errc, res, _ = fs.openex(path, false)
+func (fs *hubfs) opensearch(sp *util.Span, lst []string) (errc int, res *obstack) {
+	obs := &obstack{search: true}
+	switch len(lst) {
+	case 1:
+	case 2:
+		obs.query = lst[1]
+	case 3:
+		obs.query = lst[1]
+		results, err := fs.search(sp, obs.query)
+		if nil != err {
+			errc = fuseErrc(err)
+			return
+		}
+		for _, r := range results {
+			if lst[2] == searchResultName(r) {
+				obs.result = r
+				break
+			}
+		}
+		if nil == obs.result {
+			errc = -fuse.ENOENT
+			return
+		}
+	default:
+		errc = -fuse.ENOENT
+		return
+	}
+	res = obs
+	return
+}
+
+// search returns the results of a search query. The results of a query whose
+// directory is open are reused.
+func (fs *hubfs) search(sp *util.Span, query string) ([]prov.SearchResult, error) {
+	fs.lock.RLock()
+	r, ok := fs.searches[query]
+	fs.lock.RUnlock()
+	if ok {
+		return r.results, nil
+	}
+	s := startLookup(sp, "SearchCode", "query", query)
+	results, err := fs.client.SearchCode(query)
+	endLookup(s, err)
+	return results, err
+}
+
+// holdSearch keeps the results of a search query until releaseSearch is called.
+func (fs *hubfs) holdSearch(query string, results []prov.SearchResult) {
+	fs.lock.Lock()
+	r, ok := fs.searches[query]
+	if !ok {
+		r = &searchResults{results: results}
+		fs.searches[query] = r
+	}
+	r.refs++
+	fs.lock.Unlock()
+}
+
+func (fs *hubfs) releaseSearch(query string) {
+	fs.lock.Lock()
+	if r, ok := fs.searches[query]; ok {
+		r.refs--
+		if 0 == r.refs {
+			delete(fs.searches, query)
+		}
+	}
+	fs.lock.Unlock()
+}
+
+func (fs *hubfs) open(sp *util.Span, path string) (errc int, res *obstack) {
+	errc, res, _ = fs.openex(sp, path, false)
 	return
 }
 
@@ -130,7 +255,20 @@ func (fs *hubfs) release(obs *obstack) {
 func (fs *hubfs) getattr(obs *obstack, entry prov.TreeEntry, path string, stat *fuse.Stat_t) (
 	target string) {
 
-	if nil != entry {
+	if obs.search {
+		if nil != obs.result {
+			target = searchResultTarget(obs.result)
+			fuseStat(stat, fuse.S_IFLNK, int64(len(target)), time.Now())
+		} else {
+			fuseStat(stat, fuse.S_IFDIR, 0, time.Now())
+		}
+	} else if obs.hubdir {
+		if "" != obs.hubfile {
+			fuseStat(stat, fuse.S_IFREG, obs.reader.(*strings.Reader).Size(), time.Now())
+		} else {
+			fuseStat(stat, fuse.S_IFDIR, 0, time.Now())
+		}
+	} else if nil != entry {
 		mode := entry.Mode()
 		fuseStat(stat, mode, entry.Size(), obs.ref.TreeTime())
 		switch mode & fuse.S_IFMT {
@@ -165,9 +303,10 @@ func (fs *hubfs) getattr(obs *obstack, entry prov.TreeEntry, path string, stat *
 }
 
 func (fs *hubfs) Getpath(path string, fh uint64) (errc int, normpath string) {
-	defer trace(path, fh)(&errc, &normpath)
+	sp, end := trace(path, fh)
+	defer end(&errc, &normpath)
 
-	errc0, obs, pathlst := fs.openex(path, true)
+	errc0, obs, pathlst := fs.openex(sp, path, true)
 	if 0 == errc0 {
 		fs.release(obs)
 	}
@@ -182,9 +321,10 @@ func (fs *hubfs) Getpath(path string, fh uint64) (errc int, normpath string) {
 }
 
 func (fs *hubfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
-	defer trace(path, fh)(&errc, stat)
+	sp, end := trace(path, fh)
+	defer end(&errc, stat)
 
-	errc, obs := fs.open(path)
+	errc, obs := fs.open(sp, path)
 	if 0 != errc {
 		return
 	}
@@ -197,9 +337,10 @@ func (fs *hubfs) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
 }
 
 func (fs *hubfs) Readlink(path string) (errc int, target string) {
-	defer trace(path)(&errc, &target)
+	sp, end := trace(path)
+	defer end(&errc, &target)
 
-	errc, obs := fs.open(path)
+	errc, obs := fs.open(sp, path)
 	if 0 != errc {
 		return
 	}
@@ -216,13 +357,27 @@ func (fs *hubfs) Readlink(path string) (errc int, target string) {
 }
 
 func (fs *hubfs) Opendir(path string) (errc int, fh uint64) {
-	defer trace(path)(&errc, &fh)
+	sp, end := trace(path)
+	defer end(&errc, &fh)
 
-	errc, obs := fs.open(path)
+	errc, obs := fs.open(sp, path)
 	if 0 != errc {
 		return
 	}
 
+	// the search of a query directory runs when it is opened, so that its errors
+	// are reported (rather than an empty directory)
+	if obs.search && "" != obs.query && nil == obs.result {
+		results, err := fs.search(sp, obs.query)
+		if nil != err {
+			fs.release(obs)
+			errc = fuseErrc(err)
+			return
+		}
+		fs.holdSearch(obs.query, results)
+		obs.results = results
+	}
+
 	fs.lock.Lock()
 	fh = fs.fh
 	fs.openmap[fh] = obs
@@ -236,66 +391,120 @@ func (fs *hubfs) Readdir(path string,
 	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
 	ofst int64,
 	fh uint64) (errc int) {
-	defer trace(path, ofst, fh)(&errc)
+	sp, end := trace(path, ofst, fh)
+	defer end(&errc)
 
 	fs.lock.RLock()
 	obs, ok := fs.openmap[fh]
+	var dirents []dirent
+	if ok {
+		dirents = obs.dirents
+	}
 	fs.lock.RUnlock()
 	if !ok {
 		errc = -fuse.ENOENT
 		return
 	}
 
-	stat := fuse.Stat_t{}
+	if 0 == ofst || nil == dirents {
+		dirents = fs.listdir(sp, obs)
+		fs.lock.Lock()
+		obs.dirents = dirents
+		fs.lock.Unlock()
+	}
+
+	dirstat := fuse.Stat_t{}
 	if nil != obs.entry {
-		fuseStat(&stat, fuse.S_IFDIR, 0, obs.ref.TreeTime())
+		fuseStat(&dirstat, fuse.S_IFDIR, 0, obs.ref.TreeTime())
 	} else {
-		fuseStat(&stat, fuse.S_IFDIR, 0, time.Now())
+		fuseStat(&dirstat, fuse.S_IFDIR, 0, time.Now())
 	}
-	fill(".", &stat, 0)
-	fill("..", &stat, 0)
 
-	if nil != obs.ref {
-		if lst, err := obs.repository.GetTree(obs.ref, obs.entry); nil == err {
+	// offsets 1 and 2 follow the dot dirs; offset i+3 follows dirents[i]
+	stat := fuse.Stat_t{}
+	for i := ofst; int64(len(dirents))+2 > i; i++ {
+		name := ""
+		switch i {
+		case 0:
+			name, stat = ".", dirstat
+		case 1:
+			name, stat = "..", dirstat
+		default:
+			d := &dirents[i-2]
+			name = d.name
+			if nil != d.entry {
+				fs.getattr(obs, d.entry, pathutil.Join(path, name), &stat)
+			} else if "" != d.target {
+				fuseStat(&stat, fuse.S_IFLNK, int64(len(d.target)), time.Now())
+			} else if obs.hubdir {
+				fuseStat(&stat, fuse.S_IFREG, int64(len(hubfiles[name](fs.client))), time.Now())
+			} else {
+				stat = dirstat
+			}
+		}
+		if !fill(name, &stat, i+1) {
+			break
+		}
+	}
+
+	return
+}
+
+// listdir captures the listing of a directory.
+func (fs *hubfs) listdir(sp *util.Span, obs *obstack) (dirents []dirent) {
+	dirents = []dirent{}
+	if obs.hubdir {
+		for _, name := range hubdirNames() {
+			dirents = append(dirents, dirent{name: name})
+		}
+	} else if obs.search {
+		for _, elm := range obs.results {
+			dirents = append(dirents,
+				dirent{name: searchResultName(elm), target: searchResultTarget(elm)})
+		}
+	} else if nil != obs.ref {
+		s := startLookup(sp, "GetTree", "repo", obs.repository.Name(), "ref", obs.ref.Name())
+		lst, err := obs.repository.GetTree(obs.ref, obs.entry)
+		endLookup(s, err)
+		if nil == err {
 			for _, elm := range lst {
-				n := elm.Name()
-				fs.getattr(obs, elm, pathutil.Join(path, n), &stat)
-				if !fill(n, &stat, 0) {
-					break
-				}
+				dirents = append(dirents, dirent{name: elm.Name(), entry: elm})
 			}
 		}
 	} else if nil != obs.repository {
-		if lst, err := obs.repository.GetRefs(); nil == err {
+		s := startLookup(sp, "GetRefs", "repo", obs.repository.Name())
+		lst, err := obs.repository.GetRefs()
+		endLookup(s, err)
+		if nil == err {
 			for _, elm := range lst {
-				if !fill(elm.Name(), &stat, 0) {
-					break
-				}
+				dirents = append(dirents, dirent{name: elm.Name()})
 			}
 		}
 	} else if nil != obs.owner {
-		if lst, err := fs.client.GetRepositories(obs.owner); nil == err {
+		s := startLookup(sp, "GetRepositories", "owner", obs.owner.Name())
+		lst, err := fs.client.GetRepositories(obs.owner)
+		endLookup(s, err)
+		if nil == err {
 			for _, elm := range lst {
-				if !fill(elm.Name(), &stat, 0) {
-					break
-				}
+				dirents = append(dirents, dirent{name: elm.Name()})
 			}
 		}
 	} else {
-		if lst, err := fs.client.GetOwners(); nil == err {
+		s := startLookup(sp, "GetOwners")
+		lst, err := fs.client.GetOwners()
+		endLookup(s, err)
+		if nil == err {
 			for _, elm := range lst {
-				if !fill(elm.Name(), &stat, 0) {
-					break
-				}
+				dirents = append(dirents, dirent{name: elm.Name()})
 			}
 		}
 	}
-
 	return
 }
 
 func (fs *hubfs) Releasedir(path string, fh uint64) (errc int) {
-	defer trace(path, fh)(&errc)
+	_, end := trace(path, fh)
+	defer end(&errc)
 
 	fs.lock.Lock()
 	obs, ok := fs.openmap[fh]
@@ -308,15 +517,19 @@ func (fs *hubfs) Releasedir(path string, fh uint64) (errc int) {
 		return
 	}
 
+	if obs.search && "" != obs.query && nil == obs.result {
+		fs.releaseSearch(obs.query)
+	}
 	fs.release(obs)
 
 	return
 }
 
 func (fs *hubfs) Open(path string, flags int) (errc int, fh uint64) {
-	defer trace(path, flags)(&errc, &fh)
+	sp, end := trace(path, flags)
+	defer end(&errc, &fh)
 
-	errc, obs := fs.open(path)
+	errc, obs := fs.open(sp, path)
 	if 0 != errc {
 		return
 	}
@@ -331,7 +544,8 @@ func (fs *hubfs) Open(path string, flags int) (errc int, fh uint64) {
 }
 
 func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
-	defer trace(path, ofst, fh)(&n)
+	sp, end := trace(path, ofst, fh)
+	defer end(&n)
 
 	var reader io.ReaderAt
 
@@ -347,7 +561,10 @@ func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 	}
 
 	if nil == reader {
-		reader, _ = obs.repository.GetBlobReader(obs.entry)
+		s := startLookup(sp, "GetBlobReader", "repo", obs.repository.Name(), "hash", obs.entry.Hash())
+		var err error
+		reader, err = obs.repository.GetBlobReader(obs.entry)
+		endLookup(s, err)
 		if nil == reader {
 			n = -fuse.EIO
 			return
@@ -377,7 +594,8 @@ func (fs *hubfs) Read(path string, buff []byte, ofst int64, fh uint64) (n int) {
 }
 
 func (fs *hubfs) Release(path string, fh uint64) (errc int) {
-	defer trace(path, fh)(&errc)
+	_, end := trace(path, fh)
+	defer end(&errc)
 
 	fs.lock.Lock()
 	obs, ok := fs.openmap[fh]
@@ -432,6 +650,30 @@ func fuseStat(stat *fuse.Stat_t, mode uint32, size int64, time time.Time) {
 	}
 }
 
+// The .search directory presents code search results. A directory such as
+// /.search/QUERY contains one symlink per result that points to the matching
+// file within the owner/repository/commit hierarchy.
+const searchName = ".search"
+
+// searchResultName returns the name of a search result: its owner, repository and
+// path components joined with AltPathSeparator. Percent signs and AltPathSeparator
+// characters within the components are escaped (e.g. c++ becomes c%2B%2B), so that
+// different results never have the same name.
+func searchResultName(r prov.SearchResult) string {
+	sep := string(prov.AltPathSeparator)
+	return searchNameEscaper.Replace(r.Owner()) + sep +
+		searchNameEscaper.Replace(r.Repository()) + sep +
+		strings.ReplaceAll(searchNameEscaper.Replace(r.Path()), "/", sep)
+}
+
+var searchNameEscaper = strings.NewReplacer(
+	"%", "%25",
+	string(prov.AltPathSeparator), fmt.Sprintf("%%%02X", prov.AltPathSeparator))
+
+func searchResultTarget(r prov.SearchResult) string {
+	return "../../" + r.Owner() + "/" + r.Repository() + "/" + r.Ref() + "/" + r.Path()
+}
+
 func split(path string) []string {
 	comp := strings.Split(path, "/")[1:]
 	if 1 == len(comp) && "" == comp[0] {
@@ -453,8 +695,55 @@ func repoPath(path string) string {
 	return ""
 }
 
-func trace(vals ...interface{}) func(vals ...interface{}) {
-	return libtrace.Trace(1, "", vals...)
+// trace traces a file system operation and starts its span (named after the
+// operation, e.g. fuse.Getattr). The span is the parent of the spans of the cache
+// lookups that the operation performs; it is nil if tracing is not enabled.
+func trace(vals ...interface{}) (*util.Span, func(vals ...interface{})) {
+	t := libtrace.Trace(1, "", vals...)
+	if !util.TracingEnabled() {
+		return nil, t
+	}
+
+	name := "fuse"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		if f := runtime.FuncForPC(pc); nil != f {
+			name = "fuse." + f.Name()[strings.LastIndex(f.Name(), ".")+1:]
+		}
+	}
+	attrs := []string{}
+	if 0 < len(vals) {
+		if path, ok := vals[0].(string); ok {
+			attrs = append(attrs, "path", path)
+		}
+	}
+	sp := util.StartSpan(nil, name, attrs...)
+	return sp, func(vals ...interface{}) {
+		t(vals...)
+		var err error
+		if 0 < len(vals) {
+			if errc, ok := vals[0].(*int); ok && 0 > *errc {
+				err = fuse.Error(-*errc)
+			}
+		}
+		sp.End(err)
+	}
+}
+
+// startLookup starts the span of a cache lookup (e.g. cache.GetTree) as a child of
+// the span of a file system operation.
+func startLookup(parent *util.Span, name string, attrs ...string) *util.Span {
+	if nil == parent {
+		return nil
+	}
+	return util.StartSpan(parent, "cache."+name, attrs...)
+}
+
+// endLookup ends the span of a cache lookup; nonexistent names are not errors.
+func endLookup(s *util.Span, err error) {
+	if prov.ErrNotFound == err {
+		err = nil
+	}
+	s.End(err)
 }
 
 func tracef(form string, vals ...interface{}) {
@@ -16,7 +16,11 @@ package hubfs
 import (
 	"reflect"
 	"testing"
+	"time"
 	"unsafe"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/prov"
 )
 
 // See https://stackoverflow.com/q/42664837/568557
@@ -65,3 +69,185 @@ func TestNewOverlay(t *testing.T) {
 		}
 	}
 }
+
+type testSearchResult struct{}
+
+func (testSearchResult) Owner() string      { return "owner" }
+func (testSearchResult) Repository() string { return "repo" }
+func (testSearchResult) Ref() string        { return "0123456789abcdef0123456789abcdef01234567" }
+func (testSearchResult) Path() string       { return "dir/file.go" }
+
+func TestSearchResult(t *testing.T) {
+	r := testSearchResult{}
+	if "owner+repo+dir+file.go" != searchResultName(r) {
+		t.Error()
+	}
+	if "../../owner/repo/0123456789abcdef0123456789abcdef01234567/dir/file.go" !=
+		searchResultTarget(r) {
+		t.Error()
+	}
+
+	fs := newOverlay(Config{})
+	split := testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+	for _, q := range []string{"/.search", "/.search/q", "/.search/q/owner+repo+file"} {
+		r := split.Call([]reflect.Value{reflect.ValueOf(q)})
+		if "" != r[0].String() || q != r[1].String() {
+			t.Error()
+		}
+	}
+}
+
+type testPathResult struct {
+	testSearchResult
+	path string
+}
+
+func (r testPathResult) Path() string { return r.path }
+
+type testSearchClient struct {
+	prov.Client
+	results []prov.SearchResult
+	err     error
+	count   int
+}
+
+func (c *testSearchClient) SearchCode(query string) ([]prov.SearchResult, error) {
+	c.count++
+	return c.results, c.err
+}
+
+func TestSearchResultNames(t *testing.T) {
+	// without escaping these would both be named owner+repo+c+++x
+	a := searchResultName(testPathResult{path: "c++/x"})
+	b := searchResultName(testPathResult{path: "c/++x"})
+	if "owner+repo+c%2B%2B+x" != a || "owner+repo+c+%2B%2Bx" != b {
+		t.Error(a, b)
+	}
+	if "owner+repo+100%25+x" != searchResultName(testPathResult{path: "100%/x"}) {
+		t.Error()
+	}
+}
+
+func TestSearchDir(t *testing.T) {
+	c := &testSearchClient{results: []prov.SearchResult{testSearchResult{}}}
+	fs := new(Config{Client: c})
+
+	errc, fh := fs.Opendir("/.search/q")
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	names := []string{}
+	fs.Readdir("/.search/q", func(name string, stat *fuse.Stat_t, ofst int64) bool {
+		names = append(names, name)
+		return true
+	}, 0, fh)
+	if !reflect.DeepEqual([]string{".", "..", "owner+repo+dir+file.go"}, names) {
+		t.Error(names)
+	}
+
+	// lookups of result names reuse the results while the directory is open
+	stat := fuse.Stat_t{}
+	for i := 0; 3 > i; i++ {
+		if errc := fs.Getattr("/.search/q/owner+repo+dir+file.go", &stat, ^uint64(0)); 0 != errc {
+			t.Error(errc)
+		}
+	}
+	if 1 != c.count {
+		t.Error(c.count)
+	}
+	fs.Releasedir("/.search/q", fh)
+	fs.Getattr("/.search/q/owner+repo+dir+file.go", &stat, ^uint64(0))
+	if 2 != c.count {
+		t.Error(c.count)
+	}
+
+	// search errors are reported rather than listed as an empty directory
+	c.err = prov.ErrNotFound
+	if errc, _ := fs.Opendir("/.search/other"); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+}
+
+type testStatusClient struct {
+	prov.Client
+	status prov.ClientStatus
+	stats  []prov.CacheStats
+}
+
+func (c *testStatusClient) GetStatus() prov.ClientStatus {
+	return c.status
+}
+
+func (c *testStatusClient) GetCacheStats() []prov.CacheStats {
+	return c.stats
+}
+
+func TestHubdir(t *testing.T) {
+	reset := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	backoff := reset.Add(time.Minute)
+	c := &testStatusClient{status: prov.ClientStatus{
+		RateLimits: []prov.RateLimitStatus{{Resource: "core", Limit: 5000, Remaining: 4990, Reset: reset}},
+		Backoff:    &backoff,
+	}}
+	expect := "core: 4990 of 5000 remaining (resets 2022-01-02T03:04:05Z)\n" +
+		"secondary: backing off until 2022-01-02T03:05:05Z\n"
+
+	for _, p := range []string{"", "/owner"} {
+		fs := new(Config{Client: c, Prefix: p})
+
+		stat := fuse.Stat_t{}
+		if errc := fs.Getattr("/.hubfs", &stat, ^uint64(0)); 0 != errc ||
+			fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+			t.Error(errc)
+		}
+		if errc := fs.Getattr("/.hubfs/nonexistent", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+			t.Error(errc)
+		}
+		if errc := fs.Getattr("/.hubfs/ratelimit", &stat, ^uint64(0)); 0 != errc ||
+			fuse.S_IFREG != stat.Mode&fuse.S_IFMT || int64(len(expect)) != stat.Size {
+			t.Error(errc)
+		}
+
+		errc, fh := fs.Open("/.hubfs/ratelimit", fuse.O_RDONLY)
+		if 0 != errc {
+			t.Fatal(errc)
+		}
+		buff := make([]byte, 1024)
+		n := fs.Read("/.hubfs/ratelimit", buff, 0, fh)
+		if expect != string(buff[:n]) {
+			t.Error(string(buff[:n]))
+		}
+		fs.Release("/.hubfs/ratelimit", fh)
+	}
+
+	c.stats = []prov.CacheStats{
+		{Owner: "owner", Requests: 2},
+		{Owner: "owner", Repository: "idle"},
+		{Owner: "owner", Repository: "repo", Requests: 3, Bytes: 1000},
+	}
+	expect = "owner: 2 requests, 0 bytes downloaded\n" +
+		"owner/repo: 3 requests, 1000 bytes downloaded\n" +
+		"total: 5 requests, 1000 bytes downloaded\n"
+	fs := new(Config{Client: c})
+	errc, fh := fs.Open("/.hubfs/usage", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buff := make([]byte, 1024)
+	n := fs.Read("/.hubfs/usage", buff, 0, fh)
+	if expect != string(buff[:n]) {
+		t.Error(string(buff[:n]))
+	}
+	fs.Release("/.hubfs/usage", fh)
+
+	for _, p := range []string{"", "/1", "/1/2", "/1/2/3"} {
+		fs := newOverlay(Config{Prefix: p})
+		split := testGetUnexportedField(reflect.ValueOf(fs).Elem().FieldByName("split"))
+		for _, q := range []string{"/.hubfs", "/.hubfs/ratelimit"} {
+			r := split.Call([]reflect.Value{reflect.ValueOf(q)})
+			if "" != r[0].String() || q != r[1].String() {
+				t.Error(p, q)
+			}
+		}
+	}
+}
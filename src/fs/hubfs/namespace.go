@@ -0,0 +1,213 @@
+/*
+ * namespace.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs
+
+import (
+	"strings"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/overlayfs"
+)
+
+// NamespaceConfig configures a file system that presents the file systems of
+// several remotes under named top-level directories (e.g. /gh and /gl).
+type NamespaceConfig struct {
+	Names   []string
+	Configs []Config // file system of each name
+	Caseins bool
+	Init    func() // called when the file system has been mounted
+}
+
+// nsroot is the root directory of a namespace. It owns the file systems of the
+// names, which are shared by the shards of the overlayfs.
+type nsroot struct {
+	fuse.FileSystemBase
+	names   []string
+	fsmap   map[string]fuse.FileSystemInterface
+	caseins bool
+	init    func()
+	time    time.Time
+}
+
+// nsentry is the shard of a name. Its file system is initialized and destroyed
+// with the namespace rather than the shard.
+type nsentry struct {
+	fuse.FileSystemInterface
+}
+
+func NewNamespace(c NamespaceConfig) fuse.FileSystemInterface {
+	root := &nsroot{
+		names:   c.Names,
+		fsmap:   make(map[string]fuse.FileSystemInterface, len(c.Names)),
+		caseins: c.Caseins,
+		init:    c.Init,
+		time:    time.Now(),
+	}
+	for i, name := range c.Names {
+		config := c.Configs[i]
+		config.Caseins = c.Caseins
+		config.Init = nil
+		root.fsmap[root.key(name)] = New(config)
+	}
+
+	split := func(path string) (string, string) {
+		if "/" == path {
+			return "", path
+		}
+		if i := strings.IndexByte(path[1:], '/'); -1 != i {
+			return path[:i+1], path[i+1:]
+		}
+		return path, "/"
+	}
+
+	newfs := func(prefix string) fuse.FileSystemInterface {
+		if fs, ok := root.fsmap[root.key(prefix[1:])]; ok {
+			return &nsentry{fs}
+		}
+		return nil
+	}
+
+	return overlayfs.New(overlayfs.Config{
+		Topfs:   root,
+		Split:   split,
+		Newfs:   newfs,
+		Caseins: c.Caseins,
+	})
+}
+
+func (fs *nsroot) key(name string) string {
+	if fs.caseins {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+func (fs *nsroot) Init() {
+	for _, name := range fs.names {
+		fs.fsmap[fs.key(name)].Init()
+	}
+	if nil != fs.init {
+		fs.init()
+	}
+}
+
+func (fs *nsroot) Destroy() {
+	for _, name := range fs.names {
+		fs.fsmap[fs.key(name)].Destroy()
+	}
+}
+
+func (fs *nsroot) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	if 0 == len(fs.names) {
+		return -fuse.ENOSYS
+	}
+	return fs.fsmap[fs.key(fs.names[0])].Statfs("/", stat)
+}
+
+func (fs *nsroot) Getpath(path string, fh uint64) (errc int, normpath string) {
+	_, end := trace(path, fh)
+	defer end(&errc, &normpath)
+
+	if "/" == path {
+		return 0, path
+	}
+	for _, name := range fs.names {
+		if fs.key(name) == fs.key(path[1:]) {
+			return 0, "/" + name
+		}
+	}
+	return -fuse.ENOENT, ""
+}
+
+func (fs *nsroot) Getattr(path string, stat *fuse.Stat_t, fh uint64) (errc int) {
+	_, end := trace(path, fh)
+	defer end(&errc, stat)
+
+	if "/" != path {
+		return -fuse.ENOENT
+	}
+	fuseStat(stat, fuse.S_IFDIR, 0, fs.time)
+	return 0
+}
+
+func (fs *nsroot) Opendir(path string) (errc int, fh uint64) {
+	_, end := trace(path)
+	defer end(&errc, &fh)
+
+	if "/" != path {
+		return -fuse.ENOENT, ^uint64(0)
+	}
+	return 0, 0
+}
+
+func (fs *nsroot) Readdir(path string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+	_, end := trace(path, ofst, fh)
+	defer end(&errc)
+
+	stat := fuse.Stat_t{}
+	fuseStat(&stat, fuse.S_IFDIR, 0, fs.time)
+	names := append([]string{".", ".."}, fs.names...)
+	for i := ofst; int64(len(names)) > i; i++ {
+		if !fill(names[i], &stat, i+1) {
+			break
+		}
+	}
+	return 0
+}
+
+func (fs *nsroot) Releasedir(path string, fh uint64) (errc int) {
+	return 0
+}
+
+func (fs *nsentry) Init() {
+}
+
+func (fs *nsentry) Destroy() {
+}
+
+func (fs *nsentry) Getpath(path string, fh uint64) (errc int, normpath string) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemGetpath)
+	if !ok {
+		return -fuse.ENOSYS, ""
+	}
+	return intf.Getpath(path, fh)
+}
+
+func (fs *nsentry) Chflags(path string, flags uint32) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemChflags)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Chflags(path, flags)
+}
+
+func (fs *nsentry) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetcrtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setcrtime(path, tmsp)
+}
+
+func (fs *nsentry) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetchgtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setchgtime(path, tmsp)
+}
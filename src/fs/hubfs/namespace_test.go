@@ -0,0 +1,80 @@
+/*
+ * namespace_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/benchmarks"
+	"github.com/winfsp/hubfs/fs/hubfs"
+)
+
+func TestNamespace(t *testing.T) {
+	fs := hubfs.NewNamespace(hubfs.NamespaceConfig{
+		Names: []string{"gh", "gl"},
+		Configs: []hubfs.Config{
+			{Client: benchmarks.NewMockClient(benchmarks.MockConfig{Owners: 1, Repos: 1, Files: 1})},
+			{Client: benchmarks.NewMockClient(benchmarks.MockConfig{Owners: 2, Repos: 1, Files: 1})},
+		},
+		Caseins: true,
+	})
+	fs.Init()
+	defer fs.Destroy()
+
+	readdir := func(path string) []string {
+		errc, fh := fs.Opendir(path)
+		if 0 != errc {
+			t.Fatal(path, errc)
+		}
+		defer fs.Releasedir(path, fh)
+		names := []string{}
+		fs.Readdir(path, func(name string, stat *fuse.Stat_t, ofst int64) bool {
+			names = append(names, name)
+			return true
+		}, 0, fh)
+		sort.Strings(names)
+		return names
+	}
+
+	if names := readdir("/"); 4 != len(names) || "gh" != names[2] || "gl" != names[3] {
+		t.Error(names)
+	}
+	if names := readdir("/gl"); 4 != len(names) || "owner0" != names[2] || "owner1" != names[3] {
+		t.Error(names)
+	}
+	if names := readdir("/GH/owner0/repo0/main"); 3 != len(names) {
+		t.Error(names)
+	}
+
+	stat := fuse.Stat_t{}
+	if errc := fs.Getattr("/gh/owner0", &stat, ^uint64(0)); 0 != errc || fuse.S_IFDIR != stat.Mode&fuse.S_IFMT {
+		t.Error(errc, stat.Mode)
+	}
+	if errc := fs.Getattr("/gh/owner1", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Getattr("/bb", &stat, ^uint64(0)); -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+	if errc := fs.Mkdir("/bb", 0755); 0 == errc {
+		t.Error(errc)
+	}
+
+	getpath := fs.(fuse.FileSystemGetpath)
+	if errc, normpath := getpath.Getpath("/GL/owner1", ^uint64(0)); 0 != errc || "/gl/owner1" != normpath {
+		t.Error(errc, normpath)
+	}
+}
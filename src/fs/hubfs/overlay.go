@@ -24,6 +24,7 @@ import (
 	"github.com/winfsp/hubfs/fs/overlayfs"
 	"github.com/winfsp/hubfs/fs/port"
 	"github.com/winfsp/hubfs/fs/ptfs"
+	"github.com/winfsp/hubfs/fs/rofs"
 	"github.com/winfsp/hubfs/fs/unionfs"
 )
 
@@ -45,7 +46,9 @@ func New(c Config) fuse.FileSystemInterface {
 		}
 	}
 
-	if c.Overlay {
+	if c.ReadOnly {
+		return rofs.New(new(c))
+	} else if c.Overlay {
 		return newOverlay(c)
 	} else {
 		return new(c)
@@ -61,9 +64,16 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 		Client:  c.Client,
 		Prefix:  c.Prefix,
 		Caseins: c.Caseins,
+		Init:    c.Init,
 	}).(*hubfs)
 
 	split := func(path string) (string, string) {
+		if "" == scope && (path == "/"+searchName || strings.HasPrefix(path, "/"+searchName+"/")) {
+			return "", path
+		}
+		if path == "/"+hubdirName || strings.HasPrefix(path, "/"+hubdirName+"/") {
+			return "", path
+		}
 		slashes := scopeSlashes
 		for i := 0; len(path) > i; i++ {
 			if '/' == path[i] {
@@ -90,7 +100,7 @@ func newOverlay(c Config) fuse.FileSystemInterface {
 			}
 		}()
 
-		errc, obs := topfs.open(prefix)
+		errc, obs := topfs.open(nil, prefix)
 		if 0 != errc {
 			return nil
 		}
@@ -0,0 +1,71 @@
+/*
+ * readdir_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package hubfs_test
+
+import (
+	"testing"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/benchmarks"
+	"github.com/winfsp/hubfs/fs/hubfs"
+)
+
+func TestReaddirOffsets(t *testing.T) {
+	fs := hubfs.New(hubfs.Config{Client: benchmarks.NewMockClient(benchmarks.MockConfig{
+		Owners: 1,
+		Repos:  1,
+		Files:  1000,
+	})})
+
+	path := "/owner0/repo0/main"
+	errc, fh := fs.Opendir(path)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	defer fs.Releasedir(path, fh)
+
+	// read the directory in chunks of 64 entries, as the kernel does when the
+	// listing does not fit in its buffer
+	names := map[string]bool{}
+	ofst := int64(0)
+	for {
+		count := 0
+		next := ofst
+		errc = fs.Readdir(path, func(name string, stat *fuse.Stat_t, o int64) bool {
+			if 64 == count {
+				return false
+			}
+			if names[name] {
+				t.Error(name)
+			}
+			names[name] = true
+			if o <= next {
+				t.Error(o)
+			}
+			next = o
+			count++
+			return true
+		}, ofst, fh)
+		if 0 != errc {
+			t.Fatal(errc)
+		}
+		if 0 == count {
+			break
+		}
+		ofst = next
+	}
+	if 1002 != len(names) || !names["."] || !names[".."] || !names["file999.txt"] {
+		t.Error(len(names))
+	}
+}
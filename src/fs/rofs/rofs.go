@@ -0,0 +1,141 @@
+/*
+ * rofs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package rofs
+
+import (
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+const (
+	accessWrite  = 2 // W_OK
+	statfsRdonly = 1 // ST_RDONLY (MNT_RDONLY on macOS)
+)
+
+type filesystem struct {
+	fuse.FileSystemInterface
+}
+
+// New makes a file system read only. Operations that would modify it fail with EROFS
+// and are never passed to it.
+func New(fs fuse.FileSystemInterface) fuse.FileSystemInterface {
+	return &filesystem{FileSystemInterface: fs}
+}
+
+func (fs *filesystem) Statfs(path string, stat *fuse.Statfs_t) (errc int) {
+	errc = fs.FileSystemInterface.Statfs(path, stat)
+	if 0 == errc {
+		stat.Flag |= statfsRdonly
+	}
+	return
+}
+
+func (fs *filesystem) Mknod(path string, mode uint32, dev uint64) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Mkdir(path string, mode uint32) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Unlink(path string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Rmdir(path string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Link(oldpath string, newpath string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Symlink(target string, newpath string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Rename(oldpath string, newpath string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Chmod(path string, mode uint32) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Chown(path string, uid uint32, gid uint32) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Utimens(path string, tmsp []fuse.Timespec) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Access(path string, mask uint32) (errc int) {
+	if 0 != mask&accessWrite {
+		return -fuse.EROFS
+	}
+	return fs.FileSystemInterface.Access(path, mask)
+}
+
+func (fs *filesystem) Create(path string, flags int, mode uint32) (errc int, fh uint64) {
+	return -fuse.EROFS, ^uint64(0)
+}
+
+func (fs *filesystem) Open(path string, flags int) (errc int, fh uint64) {
+	if fuse.O_RDONLY != flags&fuse.O_ACCMODE || 0 != flags&(fuse.O_CREAT|fuse.O_TRUNC|fuse.O_APPEND) {
+		return -fuse.EROFS, ^uint64(0)
+	}
+	return fs.FileSystemInterface.Open(path, flags)
+}
+
+func (fs *filesystem) Truncate(path string, size int64, fh uint64) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Write(path string, buff []byte, ofst int64, fh uint64) (n int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Setxattr(path string, name string, value []byte, flags int) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Removexattr(path string, name string) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Getpath(path string, fh uint64) (errc int, normpath string) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemGetpath)
+	if !ok {
+		return -fuse.ENOSYS, ""
+	}
+	return intf.Getpath(path, fh)
+}
+
+func (fs *filesystem) Chflags(path string, flags uint32) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	return -fuse.EROFS
+}
+
+func (fs *filesystem) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	return -fuse.EROFS
+}
+
+var _ fuse.FileSystemInterface = (*filesystem)(nil)
+var _ fuse.FileSystemGetpath = (*filesystem)(nil)
+var _ fuse.FileSystemChflags = (*filesystem)(nil)
+var _ fuse.FileSystemSetcrtime = (*filesystem)(nil)
+var _ fuse.FileSystemSetchgtime = (*filesystem)(nil)
@@ -0,0 +1,85 @@
+/*
+ * rofs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package rofs
+
+import (
+	"testing"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/memfs"
+)
+
+func TestReadOnly(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	basefs := memfs.New()
+	basefs.Mknod("/file", fuse.S_IFREG|0644, 0)
+	errc, fh := basefs.Open("/file", fuse.O_RDWR)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	basefs.Write("/file", []byte("hello"), 0, fh)
+	basefs.Release("/file", fh)
+	basefs.Mkdir("/dir", 0755)
+
+	fs := New(basefs)
+	for name, errc := range map[string]int{
+		"Mknod":   fs.Mknod("/node", fuse.S_IFREG|0644, 0),
+		"Mkdir":   fs.Mkdir("/dir2", 0755),
+		"Unlink":  fs.Unlink("/file"),
+		"Rmdir":   fs.Rmdir("/dir"),
+		"Link":    fs.Link("/file", "/link"),
+		"Symlink": fs.Symlink("/file", "/link"),
+		"Rename":  fs.Rename("/file", "/file2"),
+		"Chmod":   fs.Chmod("/file", 0600),
+		"Chown":   fs.Chown("/file", 0, 0),
+		"Utimens": fs.Utimens("/file", []fuse.Timespec{{}, {}}),
+		"Access":  fs.Access("/file", 2),
+		"Trunc":   fs.Truncate("/file", 0, ^uint64(0)),
+		"Write":   fs.Write("/file", []byte("x"), 0, fh),
+		"Setxatr": fs.Setxattr("/file", "user.x", []byte("x"), 0),
+		"Rmxattr": fs.Removexattr("/file", "user.x"),
+	} {
+		if -fuse.EROFS != errc {
+			t.Error(name, errc)
+		}
+	}
+	if errc, _ := fs.Create("/file2", fuse.O_RDWR, 0644); -fuse.EROFS != errc {
+		t.Error("Create", errc)
+	}
+	for _, flags := range []int{fuse.O_WRONLY, fuse.O_RDWR, fuse.O_RDONLY | fuse.O_TRUNC} {
+		if errc, _ := fs.Open("/file", flags); -fuse.EROFS != errc {
+			t.Error("Open", flags, errc)
+		}
+	}
+
+	errc, fh = fs.Open("/file", fuse.O_RDONLY)
+	if 0 != errc {
+		t.Fatal(errc)
+	}
+	buff := make([]byte, 16)
+	if n := fs.Read("/file", buff, 0, fh); "hello" != string(buff[:n]) {
+		t.Error(n, string(buff))
+	}
+	fs.Release("/file", fh)
+
+	stat := fuse.Stat_t{}
+	if errc = fs.Getattr("/file", &stat, ^uint64(0)); 0 != errc || 5 != stat.Size {
+		t.Error(errc, stat.Size)
+	}
+	errc = fs.Getattr("/file2", &stat, ^uint64(0))
+	if -fuse.ENOENT != errc {
+		t.Error(errc)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * sortfs.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package sortfs
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/winfsp/cgofuse/fuse"
+)
+
+type entry struct {
+	name string
+	stat *fuse.Stat_t
+}
+
+// dirKey identifies an open directory. The file handle alone does not suffice,
+// because file systems that combine other file systems (e.g. overlayfs) may pass
+// through the same handle value for directories of different file systems.
+type dirKey struct {
+	path string
+	fh   uint64
+}
+
+type filesystem struct {
+	fuse.FileSystemInterface
+	less func(a, b string) bool
+	lock sync.Mutex
+	dirs map[dirKey][]entry
+}
+
+// New makes the directory listings of a file system deterministic: the entries of a
+// directory are returned in the order determined by less (after . and ..). The
+// listing of a directory is captured when it is first read, so that it can be read
+// in chunks at stable offsets.
+func New(fs fuse.FileSystemInterface, less func(a, b string) bool) fuse.FileSystemInterface {
+	return &filesystem{
+		FileSystemInterface: fs,
+		less:                less,
+		dirs:                make(map[dirKey][]entry),
+	}
+}
+
+// ByteLess orders names by their bytes (i.e. by Unicode code point for UTF-8),
+// regardless of locale.
+func ByteLess(a, b string) bool {
+	return a < b
+}
+
+// NaturalLess orders names by their bytes, except that runs of digits are ordered
+// by their numeric value (e.g. file2 before file10). Runs of equal value are ordered
+// by their number of leading zeros (e.g. file1 before file01).
+func NaturalLess(a, b string) bool {
+	for 0 < len(a) && 0 < len(b) {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			i, j := digits(a), digits(b)
+			x, y := trimZeros(a[:i]), trimZeros(b[:j])
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			if i != j {
+				return i < j
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func digits(s string) int {
+	i := 0
+	for len(s) > i && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+func trimZeros(s string) string {
+	for 1 < len(s) && '0' == s[0] {
+		s = s[1:]
+	}
+	return s
+}
+
+func dotRank(name string) int {
+	switch name {
+	case ".":
+		return 0
+	case "..":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (fs *filesystem) Readdir(path string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+
+	key := dirKey{path, fh}
+	fs.lock.Lock()
+	entries, ok := fs.dirs[key]
+	fs.lock.Unlock()
+
+	if 0 == ofst || !ok {
+		entries = []entry{}
+		errc = fs.FileSystemInterface.Readdir(path,
+			func(name string, stat *fuse.Stat_t, ofst int64) bool {
+				e := entry{name: name}
+				if nil != stat {
+					s := *stat
+					e.stat = &s
+				}
+				entries = append(entries, e)
+				return true
+			}, 0, fh)
+		if 0 != errc {
+			return
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			ri, rj := dotRank(entries[i].name), dotRank(entries[j].name)
+			if ri != rj {
+				return ri < rj
+			}
+			return fs.less(entries[i].name, entries[j].name)
+		})
+
+		fs.lock.Lock()
+		fs.dirs[key] = entries
+		fs.lock.Unlock()
+	}
+
+	for i := ofst; int64(len(entries)) > i; i++ {
+		if !fill(entries[i].name, entries[i].stat, i+1) {
+			break
+		}
+	}
+	return 0
+}
+
+func (fs *filesystem) Releasedir(path string, fh uint64) (errc int) {
+	fs.lock.Lock()
+	delete(fs.dirs, dirKey{path, fh})
+	fs.lock.Unlock()
+	return fs.FileSystemInterface.Releasedir(path, fh)
+}
+
+func (fs *filesystem) Getpath(path string, fh uint64) (errc int, normpath string) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemGetpath)
+	if !ok {
+		return -fuse.ENOSYS, ""
+	}
+	return intf.Getpath(path, fh)
+}
+
+func (fs *filesystem) Chflags(path string, flags uint32) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemChflags)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Chflags(path, flags)
+}
+
+func (fs *filesystem) Setcrtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetcrtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setcrtime(path, tmsp)
+}
+
+func (fs *filesystem) Setchgtime(path string, tmsp fuse.Timespec) (errc int) {
+	intf, ok := fs.FileSystemInterface.(fuse.FileSystemSetchgtime)
+	if !ok {
+		return -fuse.ENOSYS
+	}
+	return intf.Setchgtime(path, tmsp)
+}
+
+var _ fuse.FileSystemInterface = (*filesystem)(nil)
+var _ fuse.FileSystemGetpath = (*filesystem)(nil)
+var _ fuse.FileSystemChflags = (*filesystem)(nil)
+var _ fuse.FileSystemSetcrtime = (*filesystem)(nil)
+var _ fuse.FileSystemSetchgtime = (*filesystem)(nil)
@@ -0,0 +1,153 @@
+/*
+ * sortfs_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package sortfs
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/memfs"
+)
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"file10", "file2", "File1", "file01", "file1", "file", "a10b2", "a10b10", "a9"}
+	sort.Slice(names, func(i, j int) bool {
+		return NaturalLess(names[i], names[j])
+	})
+	expect := []string{"File1", "a9", "a10b2", "a10b10", "file", "file1", "file01", "file2", "file10"}
+	if !reflect.DeepEqual(expect, names) {
+		t.Error(names)
+	}
+}
+
+func TestReaddir(t *testing.T) {
+	fuse.OptParse([]string{}, "")
+
+	basefs := memfs.New()
+	names := []string{"b", "a", "C", "-x", "file10", "file2"}
+	for _, n := range names {
+		basefs.Mknod("/"+n, fuse.S_IFREG|0644, 0)
+	}
+
+	for _, c := range []struct {
+		less   func(a, b string) bool
+		expect []string
+	}{
+		{ByteLess, []string{".", "..", "-x", "C", "a", "b", "file10", "file2"}},
+		{NaturalLess, []string{".", "..", "-x", "C", "a", "b", "file2", "file10"}},
+	} {
+		fs := New(basefs, c.less)
+		errc, fh := fs.Opendir("/")
+		if 0 != errc {
+			t.Fatal(errc)
+		}
+
+		// read the directory in chunks of 3 entries
+		lst := []string{}
+		for ofst := int64(0); ; {
+			n := 0
+			fs.Readdir("/", func(name string, stat *fuse.Stat_t, o int64) bool {
+				if 3 == n {
+					return false
+				}
+				lst = append(lst, name)
+				ofst = o
+				n++
+				return true
+			}, ofst, fh)
+			if 0 == n {
+				break
+			}
+		}
+		fs.Releasedir("/", fh)
+
+		if !reflect.DeepEqual(c.expect, lst) {
+			t.Error(lst)
+		}
+	}
+}
+
+// layerfs lists directories of the same handle value, as the layers of an overlay
+// do, since every layer allocates handles independently.
+type layerfs struct {
+	fuse.FileSystemBase
+	dirs map[string][]string
+}
+
+func (fs *layerfs) Opendir(path string) (errc int, fh uint64) {
+	if _, ok := fs.dirs[path]; !ok {
+		return -fuse.ENOENT, ^uint64(0)
+	}
+	return 0, 0
+}
+
+func (fs *layerfs) Readdir(path string,
+	fill func(name string, stat *fuse.Stat_t, ofst int64) bool,
+	ofst int64,
+	fh uint64) (errc int) {
+	for _, n := range fs.dirs[path] {
+		if !fill(n, nil, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+func TestReaddirSameHandle(t *testing.T) {
+	fs := New(&layerfs{dirs: map[string][]string{
+		"/layer1/ref": {"b1", "a1", "c1", "d1"},
+		"/layer2/ref": {"b2", "a2", "c2", "d2"},
+	}}, ByteLess)
+
+	errc1, fh1 := fs.Opendir("/layer1/ref")
+	errc2, fh2 := fs.Opendir("/layer2/ref")
+	if 0 != errc1 || 0 != errc2 || fh1 != fh2 {
+		t.Fatal(errc1, errc2, fh1, fh2)
+	}
+
+	// interleave reading the two directories in chunks of 3 entries
+	paths := []string{"/layer1/ref", "/layer2/ref"}
+	lsts := [][]string{{}, {}}
+	ofsts := []int64{0, 0}
+	for done := 0; len(paths) > done; {
+		done = 0
+		for i, path := range paths {
+			n := 0
+			fs.Readdir(path, func(name string, stat *fuse.Stat_t, o int64) bool {
+				if 3 == n {
+					return false
+				}
+				lsts[i] = append(lsts[i], name)
+				ofsts[i] = o
+				n++
+				return true
+			}, ofsts[i], fh1)
+			if 0 == n {
+				done++
+			}
+		}
+	}
+	fs.Releasedir("/layer1/ref", fh1)
+	fs.Releasedir("/layer2/ref", fh2)
+
+	if !reflect.DeepEqual([]string{"a1", "b1", "c1", "d1"}, lsts[0]) ||
+		!reflect.DeepEqual([]string{"a2", "b2", "c2", "d2"}, lsts[1]) {
+		t.Error(lsts)
+	}
+	if 0 != len(fs.(*filesystem).dirs) {
+		t.Error(len(fs.(*filesystem).dirs))
+	}
+}
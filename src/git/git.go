@@ -16,9 +16,12 @@ package git
 import (
 	"context"
 	"io"
+	"io/ioutil"
+	"sync"
 	"time"
 
 	libtrace "github.com/billziss-gh/golib/trace"
+	gogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -243,19 +246,83 @@ func (repository *Repository) fetchObjects(wants []string,
 
 func (repository *Repository) FetchObjects(wants []string,
 	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
+	return repository.FetchObjectsConcurrently(wants, 1, fn)
+}
+
+const (
+	fetchBatchMin = 32
+	fetchBatchMax = 256
+)
+
+// FetchObjectsConcurrently fetches objects in batches, up to concurrency batches at
+// a time, so that the latency of the individual requests overlaps. The function fn
+// is never called concurrently.
+func (repository *Repository) FetchObjectsConcurrently(wants []string, concurrency int,
+	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
+
+	size := fetchBatchMax
+	if 1 < concurrency {
+		size = (len(wants) + concurrency - 1) / concurrency
+		if fetchBatchMin > size {
+			size = fetchBatchMin
+		} else if fetchBatchMax < size {
+			size = fetchBatchMax
+		}
+	}
+
+	if 1 >= concurrency || size >= len(wants) {
+		for i, j := 0, 0; len(wants) > i; i = j {
+			j = i + size
+			if len(wants) < j {
+				j = len(wants)
+			}
+			err = repository.fetchObjects(wants[i:j], fn)
+			if nil != err {
+				return err
+			}
+		}
+		return nil
+	}
 
+	var lock sync.Mutex
+	sfn := func(hash string, ot ObjectType, content []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		return fn(hash, ot, content)
+	}
+
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
 	for i, j := 0, 0; len(wants) > i; i = j {
-		j = i + 256
+		j = i + size
 		if len(wants) < j {
 			j = len(wants)
 		}
-		err = repository.fetchObjects(wants[i:j], fn)
-		if nil != err {
-			return err
+		sem <- struct{}{}
+		lock.Lock()
+		failed := nil != err
+		lock.Unlock()
+		if failed {
+			<-sem
+			break
 		}
+		wg.Add(1)
+		go func(wants []string) {
+			defer wg.Done()
+			e := repository.fetchObjects(wants, sfn)
+			if nil != e {
+				lock.Lock()
+				if nil == err {
+					err = e
+				}
+				lock.Unlock()
+			}
+			<-sem
+		}(wants[i:j])
 	}
+	wg.Wait()
 
-	return nil
+	return err
 }
 
 func DecodeTag(content []byte) (res *Tag, err error) {
@@ -327,6 +394,55 @@ func DecodeTree(content []byte) (res []*TreeEntry, err error) {
 	return
 }
 
+// ReadLocalObjects reads all objects of the local repository at path (a working
+// tree or a bare repository), including packed objects.
+func ReadLocalObjects(path string,
+	fn func(hash string, ot ObjectType, content []byte) error) (err error) {
+	defer trace(path)(&err)
+
+	repo, err := gogit.PlainOpenWithOptions(path, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if nil != err {
+		return err
+	}
+
+	iter, err := repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if nil != err {
+		return err
+	}
+	defer iter.Close()
+
+	return iter.ForEach(func(obj plumbing.EncodedObject) error {
+		reader, err := obj.Reader()
+		if nil != err {
+			return err
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if nil != err {
+			return err
+		}
+		return fn(obj.Hash().String(), ObjectType(obj.Type()), content)
+	})
+}
+
+// GetLocalRemote gets the URL of a remote of the local repository at path.
+func GetLocalRemote(path string, name string) (res string, err error) {
+	repo, err := gogit.PlainOpenWithOptions(path, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if nil != err {
+		return "", err
+	}
+
+	remote, err := repo.Remote(name)
+	if nil != err {
+		return "", err
+	}
+
+	if urls := remote.Config().URLs; 0 < len(urls) {
+		res = urls[0]
+	}
+	return res, nil
+}
+
 func trace(vals ...interface{}) func(vals ...interface{}) {
 	return libtrace.Trace(1, "", vals...)
 }
@@ -0,0 +1,151 @@
+/*
+ * health.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// healthTimeout is the time that a mountpoint has to respond to a health check.
+const healthTimeout = 5 * time.Second
+
+// healthReport is the health of the file systems of a mount manager and of the
+// providers of their remotes.
+type healthReport struct {
+	Healthy   bool             `json:"healthy"`
+	Mounts    []mountHealth    `json:"mounts"`
+	Providers []providerHealth `json:"providers"`
+}
+
+// mountHealth is the health of a mounted file system. A file system is alive if its
+// mountpoint responds. InProgress is the number of file system operations in
+// progress. (Changes are written to the local overlay as they are made, so there
+// is no queue of pending writes.)
+type mountHealth struct {
+	Remote     string `json:"remote"`
+	Mountpoint string `json:"mountpoint"`
+	Alive      bool   `json:"alive"`
+	InProgress int    `json:"inprogress"`
+	Error      string `json:"error,omitempty"`
+}
+
+// providerHealth is the reachability of the provider of a remote as determined by
+// the most recent request to it.
+type providerHealth struct {
+	Remote    string `json:"remote"`
+	Reachable bool   `json:"reachable"`
+	Offline   bool   `json:"offline"`
+}
+
+var errNotResponding = errors.New("mountpoint not responding")
+
+// statProbe is a stat of a mountpoint that may still be in progress.
+type statProbe struct {
+	start time.Time
+	done  chan struct{}
+	err   error
+}
+
+// statProbes are the stats in progress by mountpoint. A mountpoint that does not
+// respond has at most one stat in progress, however often it is checked.
+var statProbes = struct {
+	sync.Mutex
+	m map[string]*statProbe
+}{m: map[string]*statProbe{}}
+
+// statMountpoint checks that a mountpoint responds within the timeout. (The stat of
+// a mountpoint that does not respond completes when it responds or is unmounted.)
+// If a stat of the mountpoint is already in progress, its result is awaited rather
+// than starting another one; the mountpoint is not responding once that stat has
+// been in progress for longer than the timeout.
+func statMountpoint(mntpnt string, timeout time.Duration) error {
+	statProbes.Lock()
+	p, ok := statProbes.m[mntpnt]
+	if !ok {
+		p = &statProbe{start: time.Now(), done: make(chan struct{})}
+		statProbes.m[mntpnt] = p
+		go func() {
+			_, p.err = os.Stat(mntpnt)
+			statProbes.Lock()
+			delete(statProbes.m, mntpnt)
+			statProbes.Unlock()
+			close(p.done)
+		}()
+	}
+	statProbes.Unlock()
+	timer := time.NewTimer(time.Until(p.start.Add(timeout)))
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return p.err
+	case <-timer.C:
+		return errNotResponding
+	}
+}
+
+// checkHealth checks the health of the file systems of a mount manager. The report
+// is healthy if all file systems are alive and, if strict, all providers (other than
+// those of offline remotes) are reachable.
+func checkHealth(manager *mountManager, strict bool) healthReport {
+	report := healthReport{Healthy: true, Mounts: []mountHealth{}, Providers: []providerHealth{}}
+	for _, m := range manager.list() {
+		h := mountHealth{Remote: m.Remote, Mountpoint: m.Mountpoint, Alive: true}
+		if err := statMountpoint(m.Mountpoint, healthTimeout); nil != err {
+			h.Alive, h.Error = false, err.Error()
+			report.Healthy = false
+		}
+		if nil != m.drainfs {
+			h.InProgress = m.drainfs.InProgress()
+		}
+		report.Mounts = append(report.Mounts, h)
+	}
+	status := manager.clientStatus()
+	for _, remote := range manager.remotes() {
+		s, ok := status[remote]
+		if !ok {
+			continue
+		}
+		report.Providers = append(report.Providers,
+			providerHealth{Remote: remote, Reachable: s.Reachable, Offline: s.Offline})
+		if strict && !s.Offline && !s.Reachable {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// newHealthHandler returns a handler that serves the health of the file systems of
+// a mount manager at /health as JSON, with status 200 if healthy and 503 otherwise.
+// With ?strict=1 unreachable providers are also unhealthy.
+func newHealthHandler(manager *mountManager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		if "GET" != req.Method && "HEAD" != req.Method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		report := checkHealth(manager, "" != req.FormValue("strict") && "0" != req.FormValue("strict"))
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+	return mux
+}
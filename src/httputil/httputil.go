@@ -15,10 +15,10 @@ package httputil
 
 import (
 	"crypto/tls"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
-
-	"github.com/billziss-gh/golib/retry"
 )
 
 var (
@@ -29,11 +29,18 @@ var (
 	DefaultTransport  *http.Transport
 )
 
+// DefaultMaxIdleConnsPerHost is the number of idle connections kept per host. The
+// net/http default (2) is too low for concurrent fetches, which then have to open
+// new connections (and perform new TLS handshakes) on every request.
+const DefaultMaxIdleConnsPerHost = 16
+
 func init() {
 	DefaultTransport = http.DefaultTransport.(*http.Transport).Clone()
 	if nil == DefaultTransport.TLSClientConfig {
 		DefaultTransport.TLSClientConfig = &tls.Config{}
 	}
+	DefaultTransport.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	DefaultTransport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
 	DefaultClient = &http.Client{
 		Transport: &transport{
 			RoundTripper: DefaultTransport,
@@ -41,32 +48,123 @@ func init() {
 	}
 }
 
+// EnableHTTP2 enables or disables HTTP/2 on the DefaultTransport. HTTP/2 is enabled
+// by default. Disabling it takes effect for new connections; enabling it again only
+// works if the DefaultTransport has not been used since it was disabled.
+func EnableHTTP2(enable bool) {
+	DefaultTransport.ForceAttemptHTTP2 = enable
+	if enable {
+		DefaultTransport.TLSNextProto = nil
+	} else {
+		DefaultTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		// do not negotiate h2 (the transport may have been set up for it already)
+		protos := []string{}
+		for _, p := range DefaultTransport.TLSClientConfig.NextProtos {
+			if "h2" != p {
+				protos = append(protos, p)
+			}
+		}
+		DefaultTransport.TLSClientConfig.NextProtos = protos
+	}
+}
+
+// SetTLSSessionCacheSize sets the number of TLS sessions that the DefaultTransport
+// keeps for resumption (0 disables session resumption). It takes effect for new
+// connections.
+func SetTLSSessionCacheSize(size int) {
+	if 0 < size {
+		DefaultTransport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	} else {
+		DefaultTransport.TLSClientConfig.ClientSessionCache = nil
+	}
+}
+
 type transport struct {
 	http.RoundTripper
 }
 
+// RoundTrip retries requests that fail with transient errors (connection errors,
+// HTTP 429 and 5xx, secondary rate limits) with exponential backoff and jitter.
+// A Retry-After response header takes precedence over the backoff, unless it asks
+// for a wait longer than DefaultMaxSleep. Requests with a body are retried only if
+// the body can be replayed (i.e. if the request has a GetBody function).
 func (t *transport) RoundTrip(req *http.Request) (rsp *http.Response, err error) {
-	retry.Retry(
-		retry.Count(DefaultRetryCount),
-		retry.Backoff(DefaultSleep, DefaultMaxSleep),
-		func(i int) bool {
-
-			rsp, err = t.RoundTripper.RoundTrip(req)
-
-			// retry on connection errors without body
+	replayable := nil == req.Body || http.NoBody == req.Body || nil != req.GetBody
+	sleep := DefaultSleep
+	for i := 0; ; i++ {
+		r := req
+		if 0 < i && nil != req.GetBody {
+			r = req.Clone(req.Context())
+			r.Body, err = req.GetBody()
 			if nil != err {
-				return nil == req.Body
+				return nil, err
 			}
+		}
+
+		rsp, err = t.RoundTripper.RoundTrip(r)
 
-			// retry on HTTP 429, 503, 509
-			switch rsp.StatusCode {
-			case 429, 503, 509:
-				rsp.Body.Close()
-				return true
+		delay, retry := retryDelay(rsp, err)
+		if !retry || !replayable || DefaultRetryCount <= i+1 || nil != req.Context().Err() {
+			return
+		}
+		if 0 == delay {
+			delay = sleep
+			if DefaultMaxSleep < delay {
+				delay = DefaultMaxSleep
 			}
+			sleep = time.Duration((1.5 + rand.Float64()) * float64(sleep))
+		} else if DefaultMaxSleep < delay {
+			return
+		}
 
-			return false
-		})
+		if nil != rsp {
+			rsp.Body.Close()
+		}
 
-	return
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryDelay determines if a request should be retried and how long to wait before
+// retrying it (0 if the response does not say).
+func retryDelay(rsp *http.Response, err error) (time.Duration, bool) {
+	// retry on connection errors
+	if nil != err {
+		return 0, true
+	}
+
+	// retry on HTTP 429, 5xx and secondary rate limits (HTTP 403 with Retry-After)
+	switch rsp.StatusCode {
+	case 429, 500, 502, 503, 504, 509:
+	case 403:
+		if "" == rsp.Header.Get("Retry-After") {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	return RetryAfter(rsp.Header), true
+}
+
+// RetryAfter returns the wait requested by the Retry-After header (in seconds or as
+// an HTTP date) or 0 if there is no such header.
+func RetryAfter(header http.Header) time.Duration {
+	if s := header.Get("Retry-After"); "" != s {
+		if n, e := strconv.Atoi(s); nil == e && 0 <= n {
+			return time.Duration(n) * time.Second
+		}
+		if t, e := http.ParseTime(s); nil == e {
+			if d := time.Until(t); 0 < d {
+				return d
+			}
+		}
+	}
+	return 0
 }
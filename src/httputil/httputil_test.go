@@ -0,0 +1,127 @@
+/*
+ * httputil_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package httputil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	savedSleep := DefaultSleep
+	DefaultSleep = time.Millisecond
+	defer func() { DefaultSleep = savedSleep }()
+
+	count := 0
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch r.URL.Path {
+		case "/transient":
+			if 3 > count {
+				w.WriteHeader(502)
+				return
+			}
+		case "/secondary":
+			if 2 > count {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(403)
+				return
+			}
+		case "/forbidden":
+			w.WriteHeader(403)
+			return
+		case "/unavailable":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	count, bodies = 0, nil
+	rsp, err := DefaultClient.Post(server.URL+"/transient", "text/plain",
+		bytes.NewReader([]byte("body")))
+	if nil != err || 200 != rsp.StatusCode || 3 != count {
+		t.Error(rsp, err, count)
+	} else {
+		content, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if "ok" != string(content) {
+			t.Error(string(content))
+		}
+		for _, b := range bodies {
+			if "body" != b {
+				t.Error(bodies)
+			}
+		}
+	}
+
+	count = 0
+	rsp, err = DefaultClient.Get(server.URL + "/secondary")
+	if nil != err || 200 != rsp.StatusCode || 2 != count {
+		t.Error(rsp, err, count)
+	} else {
+		rsp.Body.Close()
+	}
+
+	count = 0
+	rsp, err = DefaultClient.Get(server.URL + "/forbidden")
+	if nil != err || 403 != rsp.StatusCode || 1 != count {
+		t.Error(rsp, err, count)
+	} else {
+		rsp.Body.Close()
+	}
+
+	// Retry-After longer than DefaultMaxSleep is not waited for
+	count = 0
+	rsp, err = DefaultClient.Get(server.URL + "/unavailable")
+	if nil != err || 503 != rsp.StatusCode || 1 != count {
+		t.Error(rsp, err, count)
+	} else {
+		rsp.Body.Close()
+	}
+}
+
+func TestTransportConfig(t *testing.T) {
+	if DefaultMaxIdleConnsPerHost != DefaultTransport.MaxIdleConnsPerHost ||
+		nil == DefaultTransport.TLSClientConfig.ClientSessionCache {
+		t.Error()
+	}
+
+	SetTLSSessionCacheSize(0)
+	if nil != DefaultTransport.TLSClientConfig.ClientSessionCache {
+		t.Error()
+	}
+	SetTLSSessionCacheSize(16)
+	if nil == DefaultTransport.TLSClientConfig.ClientSessionCache {
+		t.Error()
+	}
+
+	EnableHTTP2(false)
+	if DefaultTransport.ForceAttemptHTTP2 || nil == DefaultTransport.TLSNextProto {
+		t.Error()
+	}
+	EnableHTTP2(true)
+	if !DefaultTransport.ForceAttemptHTTP2 || nil != DefaultTransport.TLSNextProto {
+		t.Error()
+	}
+}
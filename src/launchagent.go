@@ -0,0 +1,145 @@
+/*
+ * launchagent.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// macfusePath is the path of the macFUSE file system bundle. The agent does not
+// start the file system until macFUSE is installed.
+const macfusePath = "/Library/Filesystems/macfuse.fs"
+
+// launchAgentLabel returns the launchd label of the agent of a mountpoint.
+func launchAgentLabel(mntpnt string) string {
+	n := strings.Map(func(c rune) rune {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			return c
+		}
+		return '-'
+	}, strings.Trim(mntpnt, "/"))
+	return strings.ToLower(MyProductName) + "." + n
+}
+
+// launchAgentPlist returns the property list of a LaunchAgent that runs a command
+// line at login. launchd starts the command only when macFUSE is present and runs it
+// again if it exits abnormally (e.g. crashes); a stale mount left behind is unmounted
+// before the command runs.
+func launchAgentPlist(label string, mntpnt string, args []string, logpath string) string {
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	progargs := append([]string{
+		"/bin/sh", "-c", `/sbin/umount "$1" 2>/dev/null; shift; exec "$@"`, progname, mntpnt},
+		args...)
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" ` +
+		`"http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>%s</string>\n", esc(label))
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range progargs {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", esc(a))
+	}
+	b.WriteString("\t</array>\n")
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n")
+	b.WriteString("\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n")
+	fmt.Fprintf(&b, "\t\t<key>PathState</key>\n\t\t<dict>\n\t\t\t<key>%s</key>\n\t\t\t<true/>\n\t\t</dict>\n",
+		esc(macfusePath))
+	b.WriteString("\t</dict>\n")
+	b.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>10</integer>\n")
+	fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", esc(logpath))
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// runLaunchAgentCommand installs (or uninstalls) a LaunchAgent that mounts a remote
+// at login: "launchagent [-uninstall] [remote] mountpoint". The agent runs HUBFS
+// with the specified options (those that precede the launchagent command).
+func runLaunchAgentCommand(args []string, defremote string, options []string) int {
+	uninstall := false
+	fset := flag.NewFlagSet("launchagent", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.BoolVar(&uninstall, "uninstall", uninstall, "uninstall the agent")
+	if nil != fset.Parse(args) || 1 > fset.NArg() || 2 < fset.NArg() {
+		flag.Usage()
+		return exitUsage
+	}
+	remote, mntpnt := defremote, fset.Arg(0)
+	if 2 == fset.NArg() {
+		remote, mntpnt = fset.Arg(0), fset.Arg(1)
+	}
+	mntpnt = absMountpoint(mntpnt)
+
+	home, err := os.UserHomeDir()
+	if nil != err {
+		warn("launchagent error: %v", err)
+		return exitFailure
+	}
+	label := launchAgentLabel(mntpnt)
+	path := filepath.Join(home, "Library", "LaunchAgents", label+".plist")
+	launchctl := func(args ...string) {
+		if "darwin" != runtime.GOOS {
+			return
+		}
+		if out, err := exec.Command("launchctl", args...).CombinedOutput(); nil != err {
+			warn("launchctl %s: %s", args[0], strings.TrimSpace(string(out)))
+		}
+	}
+
+	if uninstall {
+		launchctl("unload", "-w", path)
+		err = os.Remove(path)
+		if nil != err {
+			warn("launchagent error: %v", err)
+			return exitFailure
+		}
+		fmt.Printf("%s removed\n", path)
+		return 0
+	}
+
+	exe, err := os.Executable()
+	if nil != err {
+		warn("launchagent error: %v", err)
+		return exitFailure
+	}
+	cmdline := append(append([]string{exe}, options...), remote, mntpnt)
+
+	logpath := filepath.Join(home, "Library", "Logs", label+".log")
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if nil == err {
+		err = ioutil.WriteFile(path, []byte(launchAgentPlist(label, mntpnt, cmdline, logpath)), 0644)
+	}
+	if nil != err {
+		warn("launchagent error: %v", err)
+		return exitFailure
+	}
+	launchctl("load", "-w", path)
+	fmt.Printf("%s installed\n", path)
+	return 0
+}
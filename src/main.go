@@ -14,22 +14,30 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/billziss-gh/golib/keyring"
 	libtrace "github.com/billziss-gh/golib/trace"
-	"github.com/winfsp/cgofuse/fuse"
-	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/fs/debugfs"
 	"github.com/winfsp/hubfs/fs/port"
+	"github.com/winfsp/hubfs/httputil"
 	"github.com/winfsp/hubfs/prov"
 	"github.com/winfsp/hubfs/util"
 )
@@ -45,7 +53,25 @@ var (
 
 var progname = strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
 
+const (
+	// logFileSize is the size at which the log file is rotated.
+	logFileSize = 10 * 1024 * 1024
+
+	// logFileCount is the number of rotated log files that are kept.
+	logFileCount = 5
+)
+
+// logfile is the log file (-log-file); messages are also logged to it.
+var logfile *util.RotatingFile
+
 func warn(format string, a ...interface{}) {
+	if nil != logfile {
+		util.Logf(util.LogError, format, a...)
+	}
+	if jsonOutput {
+		printJSONError(fmt.Sprintf(format, a...))
+		return
+	}
 	format = "%s: " + format + "\n"
 	a = append([]interface{}{progname}, a...)
 	fmt.Fprintf(os.Stderr, format, a...)
@@ -97,35 +123,426 @@ func gitauthNewClientWithUri(provider prov.Provider, uri *url.URL) (
 	return
 }
 
-func mount(client prov.Client, overlay bool, prefix string, mntpnt string, config []string) bool {
-	mntopt := []string{}
-	for _, s := range config {
-		mntopt = append(mntopt, "-o"+s)
+// kernelCacheOptions maps the kernel cache options to the FUSE mount options of
+// the OS. The options are:
+//
+// - config.kernel.attrttl=D: time that file attributes are cached
+// - config.kernel.entryttl=D: time that names (and directory listings) are cached
+// - config.kernel.negttl=D: time that nonexistent names are cached
+// - config.kernel.directio=1: bypass the kernel page cache when reading files
+// - config.kernel.keepcache=1: keep the kernel page cache of files across opens
+//
+// WinFsp does not cache nonexistent names and does not have a direct I/O option.
+var kernelCacheOptions = map[string]string{
+	"attrttl":   "attr_timeout",
+	"entryttl":  "entry_timeout",
+	"negttl":    "negative_timeout",
+	"directio":  "direct_io",
+	"keepcache": "kernel_cache",
+}
+
+var kernelCacheOptionsWindows = map[string]string{
+	"attrttl":   "FileInfoTimeout",
+	"entryttl":  "DirInfoTimeout",
+	"keepcache": "KeepFileCache",
+}
+
+// kernelCacheOption translates a kernel cache option to a FUSE mount option. It
+// returns the empty string for options that are disabled or not supported.
+func kernelCacheOption(s string) (opt string, ok bool) {
+	i := strings.IndexByte(s, '=')
+	if -1 == i || !strings.HasPrefix(s, "config.kernel.") {
+		return "", false
+	}
+	k, v := s[len("config.kernel."):i], s[i+1:]
+	if _, ok = kernelCacheOptions[k]; !ok {
+		return "", false
 	}
 
-	caseins := false
-	if "windows" == runtime.GOOS || "darwin" == runtime.GOOS {
-		caseins = true
+	windows := "windows" == runtime.GOOS
+	n := kernelCacheOptions[k]
+	if windows {
+		n = kernelCacheOptionsWindows[k]
+	}
+	if "" == n {
+		return "", true
 	}
 
-	if caseins {
-		client.SetConfig([]string{"config._caseins=1"})
-	} else {
-		client.SetConfig([]string{"config._caseins=0"})
+	switch k {
+	case "directio", "keepcache":
+		if "1" == v {
+			opt = n
+		}
+	default:
+		if d, e := time.ParseDuration(v); nil == e && 0 <= d {
+			if windows {
+				opt = fmt.Sprintf("%s=%d", n, d.Milliseconds())
+			} else {
+				opt = fmt.Sprintf("%s=%g", n, d.Seconds())
+			}
+		}
+	}
+	return opt, true
+}
+
+// mountOption translates a portable mount option to a FUSE mount option. It
+// returns the empty string for options that are disabled, not supported by the OS
+// or handled by the mount manager. The options are:
+//
+// - config.mount.label=NAME: volume label (macOS and Windows)
+// - config.mount.allowother=1: allow access by other users (Linux and macOS)
+// - config.mount.drives=LETTERS: drive letters to try in order when the mountpoint
+// is * (Windows)
+// - config.mount.sort=byte|natural: list directories in byte order or in natural
+// order (numbers by value) regardless of OS and locale
+//
+// All other FUSE and WinFsp options (e.g. default_permissions, FileInfoTimeout=N)
+// are passed to the OS as specified.
+func mountOption(s string) (opt string, ok bool) {
+	i := strings.IndexByte(s, '=')
+	if -1 == i || !strings.HasPrefix(s, "config.mount.") {
+		return "", false
+	}
+	k, v := s[len("config.mount."):i], s[i+1:]
+	switch k {
+	case "label":
+		if "linux" != runtime.GOOS && "" != v {
+			opt = "volname=" + v
+		}
+	case "allowother":
+		if "windows" != runtime.GOOS && "1" == v {
+			opt = "allow_other"
+		}
+	case "drives", "sort":
+	default:
+		return "", false
+	}
+	return opt, true
+}
+
+// httpOption applies an HTTP option to the transport and retry policy that all
+// clients share. It reports whether the option is an HTTP option. The options are:
+//
+// - config.retry.count=N: number of attempts of a request that fails transiently
+// - config.retry.sleep=D: initial wait between attempts
+// - config.retry.maxsleep=D: maximum wait between attempts
+// - config.http.maxidle=N: maximum number of idle connections
+// - config.http.maxidleperhost=N: maximum number of idle connections per host
+// - config.http.maxperhost=N: maximum number of connections per host
+// - config.http.http2=0|1: use HTTP/2
+// - config.http.tlscache=N: number of TLS sessions kept for resumption
+//
+// HTTP options are process-wide; they must be applied before any client is created.
+func httpOption(s string) bool {
+	i := strings.IndexByte(s, '=')
+	if -1 == i || !isHttpOption(s) {
+		return false
+	}
+	k, v := s[len("config."):i], s[i+1:]
+	switch k {
+	case "retry.count":
+		if n, e := strconv.Atoi(v); nil == e && 0 < n {
+			httputil.DefaultRetryCount = n
+		}
+	case "retry.sleep":
+		if d, e := time.ParseDuration(v); nil == e && 0 < d {
+			httputil.DefaultSleep = d
+		}
+	case "retry.maxsleep":
+		if d, e := time.ParseDuration(v); nil == e && 0 < d {
+			httputil.DefaultMaxSleep = d
+		}
+	case "http.maxidle":
+		if n, e := strconv.Atoi(v); nil == e && 0 <= n {
+			httputil.DefaultTransport.MaxIdleConns = n
+		}
+	case "http.maxidleperhost":
+		if n, e := strconv.Atoi(v); nil == e && 0 <= n {
+			httputil.DefaultTransport.MaxIdleConnsPerHost = n
+		}
+	case "http.maxperhost":
+		if n, e := strconv.Atoi(v); nil == e && 0 <= n {
+			httputil.DefaultTransport.MaxConnsPerHost = n
+		}
+	case "http.http2":
+		httputil.EnableHTTP2("0" != v)
+	case "http.tlscache":
+		if n, e := strconv.Atoi(v); nil == e && 0 <= n {
+			httputil.SetTLSSessionCacheSize(n)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func isHttpOption(s string) bool {
+	return strings.HasPrefix(s, "config.http.") || strings.HasPrefix(s, "config.retry.")
+}
+
+// applyHttpOptions applies the HTTP options in a list of (possibly comma-separated)
+// options.
+func applyHttpOptions(opts []string) {
+	for _, m := range opts {
+		for _, s := range strings.Split(m, ",") {
+			httpOption(s)
+		}
+	}
+}
+
+// isLoopbackListener determines if a listener only accepts connections from the
+// local host.
+func isLoopbackListener(listener net.Listener) bool {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
+
+// splitMountSpec splits an additional mount ([remote=]mountpoint) into a remote and
+// a mountpoint. The remote is the default remote if not specified.
+func splitMountSpec(s string, defremote string) (remote string, mntpnt string) {
+	if i := strings.IndexByte(s, '='); -1 != i {
+		return s[:i], s[i+1:]
+	}
+	return defremote, s
+}
+
+// isNamespaceArgs determines if the remote arguments are the remote=name pairs of
+// a namespace mount (e.g. github.com=gh gitlab.com=gl).
+func isNamespaceArgs(args []string) bool {
+	for _, a := range args {
+		if -1 == strings.IndexByte(a, '=') {
+			return false
+		}
+	}
+	return 0 < len(args)
+}
+
+// parseRemote parses a remote (e.g. github.com/owner) into a URI.
+func parseRemote(remote string) (*url.URL, error) {
+	uri, err := url.Parse(remote)
+	if nil != uri && "" == uri.Scheme {
+		uri, err = url.Parse("https://" + remote)
+	}
+	if nil != err {
+		return nil, fmt.Errorf("invalid remote: %s", remote)
+	}
+	return uri, nil
+}
+
+// newClient creates a client for the provider of a remote using an auth method.
+func newClient(uri *url.URL, authmeth string, authkey string) (client prov.Client, err error) {
+	provider := prov.NewProviderInstance(uri)
+	if nil == provider {
+		return nil, fmt.Errorf("%w: %s", errUnknownProvider, prov.GetProviderInstanceName(uri))
+	}
+
+	if "" == authkey {
+		authkey = prov.GetProviderInstanceName(uri)
+	}
+
+	switch authmeth {
+	case "force":
+		client, err = oauthNewClientWithKey(provider, authkey)
+	case "full":
+		client, err = newClientWithKey(provider, authkey)
+		if nil != err {
+			client, err = oauthNewClientWithKey(provider, authkey)
+		}
+	case "required":
+		client, err = newClientWithKey(provider, authkey)
+	case "optional":
+		client, err = newClientWithKey(provider, authkey)
+		if nil != err {
+			client, err = provider.NewClient("")
+		}
+	case "none":
+		client, err = provider.NewClient("")
+	case "git":
+		client, err = gitauthNewClientWithUri(provider, uri)
+	default:
+		if strings.HasPrefix(authmeth, "token=") {
+			client, err = provider.NewClient(strings.TrimPrefix(authmeth, "token="))
+		}
+	}
+	if nil != err && exitAuth == clientExitCode(err) {
+		reportEvent(eventError, eventAuthFailure, "auth error: %s: %v",
+			prov.GetProviderInstanceName(uri), err)
+	}
+	return
+}
+
+// restrictRules returns the filter rules that restrict the file system to the owners
+// and owner/repo names of the -owner and -repo options.
+func restrictRules(owners []string, repos []string) ([]string, error) {
+	rules := []string{}
+	for _, f := range owners {
+		for _, s := range strings.Split(f, ",") {
+			if "" == s || strings.ContainsRune(s, '/') ||
+				strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+				return nil, fmt.Errorf("invalid owner: %s", s)
+			}
+			rules = append(rules, s)
+		}
+	}
+	for _, f := range repos {
+		for _, s := range strings.Split(f, ",") {
+			i := strings.IndexByte(s, '/')
+			if 0 >= i || len(s)-1 == i || strings.ContainsRune(s[i+1:], '/') ||
+				strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+				return nil, fmt.Errorf("invalid repo: %s", s)
+			}
+			rules = append(rules, s)
+		}
+	}
+	return rules, nil
+}
+
+// filterConfig returns the filter settings of the restrict rules (-owner, -repo) and
+// the filter rules (-filter); either may be comma-separated lists. The restrict rules
+// come first, so that filter rules can exclude names that the restrict rules include
+// (e.g. -owner acme -filter -acme/secret).
+func filterConfig(restrict []string, filter []string) []string {
+	config := []string{}
+	for _, rules := range [][]string{restrict, filter} {
+		for _, f := range rules {
+			for _, s := range strings.Split(f, ",") {
+				config = append(config, "config._filter="+s)
+			}
+		}
+	}
+	return config
+}
+
+// getCacheKey gets the key used to encrypt the persistent cache from the system
+// keyring. A new key is created if there is none.
+func getCacheKey(name string) (string, error) {
+	return getKeyringKey(name, prov.CacheKeySize)
+}
+
+// getKeyringKey gets a (hex encoded) key from the system keyring. A new random key
+// of the specified size is created if there is none.
+func getKeyringKey(name string, size int) (string, error) {
+	key, err := keyring.Get(MyProductName, name)
+	if nil == err {
+		return key, nil
+	}
+	buf := make([]byte, size)
+	_, err = rand.Read(buf)
+	if nil != err {
+		return "", err
+	}
+	key = hex.EncodeToString(buf)
+	err = keyring.Set(MyProductName, name, key)
+	if nil != err {
+		return "", err
+	}
+	return key, nil
+}
+
+// splitPrefetchPath splits a prefetch path into a remote and an owner/repo/... path.
+// The remote is the default remote unless the first path component is a host name.
+func splitPrefetchPath(s string, defremote string) (remote string, target string) {
+	s = strings.TrimPrefix(s, "https://")
+	i := strings.IndexByte(s, '/')
+	if -1 != i && strings.ContainsRune(s[:i], '.') {
+		return s[:i], s[i+1:]
+	}
+	return defremote, s
+}
+
+func hasPersistentCache(mntopt []string) bool {
+	for _, m := range mntopt {
+		for _, s := range strings.Split(m, ",") {
+			if (strings.HasPrefix(s, "config.dir=") && "config.dir=:" != s) ||
+				strings.HasPrefix(s, "config.objdir=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// defaultConfigFile returns the path of the default configuration file
+// (e.g. ~/.config/hubfs/config.yaml on Linux).
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if nil != err {
+		return ""
+	}
+	return filepath.Join(dir, strings.ToLower(MyProductName), "config.yaml")
+}
+
+// selectProfile returns the settings of a configuration file that apply to a
+// profile: the settings of the profile (profiles.NAME.KEY) and the top-level
+// settings that the profile does not specify. The settings of other profiles are
+// dropped.
+func selectProfile(items []util.Confitem, profile string) ([]util.Confitem, error) {
+	prefix := "profiles." + profile + "."
+	prof := []util.Confitem{}
+	keys := map[string]bool{}
+	if "" != profile {
+		for _, item := range items {
+			if strings.HasPrefix(item.Key, prefix) {
+				item.Key = item.Key[len(prefix):]
+				prof = append(prof, item)
+				keys[item.Key] = true
+			}
+		}
+		if 0 == len(prof) {
+			return nil, fmt.Errorf("unknown profile %s", profile)
+		}
+	}
+
+	res := []util.Confitem{}
+	for _, item := range items {
+		if !strings.HasPrefix(item.Key, "profiles.") && !keys[item.Key] {
+			res = append(res, item)
+		}
+	}
+	return append(res, prof...), nil
+}
+
+// applyConfigFile applies the settings of a configuration file and profile. A
+// setting is the name of a command line option (e.g. auth, filter, o) or remote (the
+// default remote) or config.NAME (equivalent to -o config.NAME=VALUE). Options
+// specified on the command line take precedence over the configuration file.
+func applyConfigFile(path string, profile string, required bool, remote *string, config *[]string) error {
+	items, err := util.ReadConfigFile(path)
+	if nil != err {
+		if !required && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	items, err = selectProfile(items, profile)
+	if nil != err {
+		return fmt.Errorf("%s: %v", path, err)
 	}
-	client.StartExpiration()
-	defer client.StopExpiration()
 
-	fs := hubfs.New(hubfs.Config{
-		Client:  client,
-		Prefix:  prefix,
-		Caseins: caseins,
-		Overlay: overlay,
+	cmdline := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
 	})
-	host := fuse.NewFileSystemHost(fs)
-	host.SetCapCaseInsensitive(caseins)
-	host.SetCapReaddirPlus(true)
-	return host.Mount(mntpnt, mntopt)
+
+	for _, item := range items {
+		switch {
+		case "remote" == item.Key:
+			*remote = item.Value
+		case strings.HasPrefix(item.Key, "config."):
+			*config = append(*config, item.Key+"="+item.Value)
+		case "config" == item.Key || "profile" == item.Key || nil == flag.Lookup(item.Key):
+			return fmt.Errorf("%s: unknown setting %s", path, item.Key)
+		case cmdline[item.Key]:
+			// the command line takes precedence
+		default:
+			err = flag.Set(item.Key, item.Value)
+			if nil != err {
+				return fmt.Errorf("%s: %s: %v", path, item.Key, err)
+			}
+		}
+	}
+
+	return nil
 }
 
 func run() int {
@@ -140,20 +557,74 @@ func run() int {
 	}
 
 	debug := false
+	debugfuse := ""
 	printver := false
 	authmeth := "full"
 	authkey := ""
 	authonly := false
+	check := false
 	readonly := false
 	fullrefs := false
+	offline := false
+	maxdisk := ""
+	encrypt := false
+	webhook := ""
+	pprofaddr := ""
+	metricsaddr := ""
+	healthaddr := ""
+	healthpublic := false
+	auditpath := ""
+	eventlog := ""
+	otlp := ""
 	filter := util.Optlist{}
+	owners := util.Optlist{}
+	repos := util.Optlist{}
+	pin := util.Optlist{}
+	mounts := util.Optlist{}
 	mntopt := util.Optlist{}
 	remote := "github.com"
 	mntpnt := ""
 	config := []string{"config.dir=:"}
+	confpath := ""
+	profile := ""
+	loglevel := "warn"
+	logformat := "text"
+	logslow := time.Duration(0)
+	output := "text"
+	logpath := ""
+	watchdog := false
+	shutdownTimeout := 10 * time.Second
+	idleTimeout := time.Duration(0)
+	daemon := false
+	foreground := false
+	sockpath := defaultSocketPath()
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [options] [remote] mountpoint\n\n", progname)
+		fmt.Fprintf(os.Stderr, "usage: %s [options] [remote] mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] remote=name... mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] prefetch [-depth N] [-jobs N] [remote/]owner/repo[/ref[/path]]\n",
+			progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] cache import [-repo [remote/]owner/repo] clonepath\n",
+			progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] cache export|import snapshotfile\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] cache gc [-age duration] [-size size]\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] cache verify\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] daemon [-socket path] [-foreground]\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] ctl [-socket path] mounts|stats|dump|reload|stop\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] ctl [-socket path] mount [remote=]mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] ctl [-socket path] unmount mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] ctl [-socket path] refresh [remote/]owner/repo\n",
+			progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] status [-socket path]\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] refresh [-socket path] path\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] unmount [-socket path] mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] systemd [-automount] [-idle duration] [-dir dir] [remote] mountpoint\n",
+			progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] launchagent [-uninstall] [remote] mountpoint\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] doctor [remote]\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s [options] audit verify file\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s version [-check]\n", progname)
+		fmt.Fprintf(os.Stderr, "       %s completion bash|zsh|fish|powershell\n\n", progname)
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nremotes:\n")
 		for _, n := range prov.GetProviderClassNames() {
@@ -161,7 +632,12 @@ func run() int {
 		}
 	}
 
-	flag.BoolVar(&debug, "d", debug, "debug output")
+	flag.BoolVar(&debug, "d", debug, "debug output (implies -log-level debug)")
+	flag.StringVar(&debugfuse, "debug-fuse", debugfuse,
+		"log the FUSE operations selected by `filter` (implies -log-level info)\n"+
+			"- list form: item1,item2,...\n"+
+			"- item is operation (e.g. open, read) or path (e.g. /owner/repo)\n"+
+			"- operations of any kind (if none listed) under any path (if none listed)")
 	flag.BoolVar(&printver, "version", printver, "print version information")
 	flag.StringVar(&authmeth, "auth", "",
 		"`method` is from list below; auth tokens are stored in system keyring\n"+
@@ -174,47 +650,295 @@ func run() int {
 			"- token=T   use specified auth token T; do not use system keyring")
 	flag.StringVar(&authkey, "authkey", authkey, "`name` of key that stores auth token in system keyring")
 	flag.BoolVar(&authonly, "authonly", authonly, "perform auth only; do not mount")
-	flag.BoolVar(&readonly, "readonly", readonly, "read only file system")
+	flag.BoolVar(&check, "check", check,
+		"check configuration, credentials, provider and mountpoint; do not mount")
+	flag.BoolVar(&readonly, "readonly", readonly,
+		"read only file system; all modifications fail with EROFS")
+	flag.BoolVar(&readonly, "read-only", readonly, "same as -readonly")
 	flag.BoolVar(&fullrefs, "fullrefs", fullrefs, "full format refs (refs+heads+master instead of master)")
+	flag.StringVar(&maxdisk, "max-disk", maxdisk,
+		"limit the disk usage of the cache to `size` (e.g. 2G); evict least recently used content")
+	flag.BoolVar(&offline, "offline", offline,
+		"serve from persistent cache only; do not contact remote (requires -o config.dir=DIR)")
+	flag.BoolVar(&encrypt, "encrypt", encrypt,
+		"encrypt persistent cache; the cache key is stored in system keyring")
+	flag.StringVar(&webhook, "webhook", webhook,
+		"listen on `addr` for push webhooks that invalidate cached repositories\n"+
+			"(webhook secret is read from environment variable HUBFS_WEBHOOK_SECRET;\n"+
+			"it is required unless addr is a loopback address)")
+	flag.StringVar(&pprofaddr, "pprof", pprofaddr,
+		"listen on loopback `addr` for profiling requests\n"+
+			"(e.g. go tool pprof http://addr/debug/pprof/heap)")
+	flag.StringVar(&metricsaddr, "metrics", metricsaddr,
+		"listen on loopback `addr` for metrics requests (e.g. http://addr/metrics; Prometheus format)")
+	flag.StringVar(&healthaddr, "health", healthaddr,
+		"listen on loopback `addr` for health checks (e.g. http://addr/health; 503 if unhealthy)")
+	flag.BoolVar(&healthpublic, "health-public", healthpublic,
+		"allow -health to listen on an address that is not loopback")
+	flag.StringVar(&auditpath, "audit-log", auditpath,
+		"record modifications made through the file system in audit log `file`\n"+
+			"(verify with: audit verify file)")
+	flag.StringVar(&eventlog, "eventlog", eventlog,
+		"report mounts, unmounts and mount and auth failures to Windows event `sinks`\n"+
+			"- list form: eventlog,etw")
+	flag.StringVar(&otlp, "otlp", otlp,
+		"export traces to OpenTelemetry collector at `url` (e.g. http://localhost:4318)\n"+
+			"(service name is read from environment variable OTEL_SERVICE_NAME)")
 	flag.Var(&filter, "filter",
 		"list of `rules` that determine repo availability\n"+
 			"- list form: rule1,rule2,...\n"+
 			"- rule form: [+-]owner or [+-]owner/repo\n"+
 			"- rule is include (+) or exclude (-) (default: include)\n"+
 			"- rule owner/repo can use wildcards for pattern matching")
+	flag.Var(&owners, "owner",
+		"restrict the file system to `owner` (repeatable; list form: owner1,owner2,...)\n"+
+			"(equivalent to -filter owner)")
+	flag.Var(&repos, "repo",
+		"restrict the file system to `owner/repo` (repeatable; list form: owner/repo1,...)\n"+
+			"(equivalent to -filter owner/repo)")
+	flag.Var(&pin, "pin",
+		"list of `repos` that are never evicted from the cache\n"+
+			"- list form: owner/repo[/ref],...")
 	flag.Var(&mntopt, "o", "FUSE mount `options`\n(default: "+strings.Join(default_mntopt, ",")+")")
+	flag.Var(&mounts, "mount",
+		"additional `[remote=]mountpoint` to mount in the same process\n"+
+			"(mounts of the same remote share its cache and rate limit)")
+	flag.StringVar(&loglevel, "log-level", loglevel, "log `level` (debug, info, warn, error)")
+	flag.StringVar(&logformat, "log-format", logformat,
+		"log `format` (text, json); json records carry op, path, provider, latency and error")
+	flag.DurationVar(&logslow, "log-slow", logslow,
+		"log file system operations and provider requests that take `duration` or longer\n"+
+			"(at the warn level; default: never)")
+	flag.StringVar(&output, "output", output,
+		"output `format` of commands and errors (text, json)")
+	flag.StringVar(&logpath, "log-file", logpath,
+		"write log to `file` instead of stderr (rotated at 10MB)")
+	flag.BoolVar(&watchdog, "watchdog", watchdog,
+		"mount again if the file system crashes or its FUSE connection fails")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout,
+		"on unmount or termination wait up to `duration` for operations in progress")
+	flag.DurationVar(&idleTimeout, "idle-timeout", idleTimeout,
+		"unmount after no files have been open or accessed for `duration` (default: never)")
+	flag.StringVar(&confpath, "config", confpath,
+		"read options from configuration `file` (default: "+defaultConfigFile()+")")
+	flag.StringVar(&profile, "profile", profile,
+		"use the settings of `profile` in the configuration file")
 
 	util.InvokeEvent("main.Flagvar", nil)
 
+	if strings.HasPrefix(progname, "mount.") || isMountHelperArgs(os.Args[1:]) {
+		/* invoked as mount helper (e.g. mount.hubfs, mount.fuse.hubfs or by mount.fuse) */
+		return runMountHelper(os.Args[1:])
+	}
+
 	flag.Parse()
 
+	switch output {
+	case "text":
+	case "json":
+		jsonOutput = true
+	default:
+		warn("invalid output format: %s", output)
+		return exitUsage
+	}
+
+	if 0 > idleTimeout {
+		warn("invalid idle timeout: %v", idleTimeout)
+		return exitUsage
+	}
+
+	if 0 > logslow {
+		warn("invalid slow operation threshold: %v", logslow)
+		return exitUsage
+	}
+
 	if printver {
-		name := MyProductName
-		if "" != MyProductTag {
-			name += " " + MyProductTag
-		}
-		fmt.Printf("%s %s (%s) - %s\nCopyright %s\n\n",
-			name, MyProductVersion, MyVersion, MyDescription, MyCopyright)
-		util.InvokeEvent("main.Printver", nil)
-		fmt.Printf("Providers:\n")
-		for _, n := range prov.GetProviderClassNames() {
-			fmt.Printf("  %s\n", n)
-		}
+		printVersion()
 		return 0
 	}
 
-	switch flag.NArg() {
-	case 1:
-		mntpnt = flag.Arg(0)
-	case 2:
-		remote = flag.Arg(0)
-		mntpnt = flag.Arg(1)
-	default:
-		if !authonly {
+	cmdline := commandLineFlags()
+	required := "" != confpath || "" != profile
+	if !required {
+		confpath = defaultConfigFile()
+	}
+	if "" != confpath {
+		err := applyConfigFile(confpath, profile, required, &remote, &config)
+		if nil != err {
+			warn("config file error: %v", err)
+			return exitUsage
+		}
+	}
+
+	level, err := util.ParseLogLevel(loglevel)
+	if nil != err {
+		warn("%v", err)
+		return exitUsage
+	}
+	if debug {
+		level = util.LogDebug
+	}
+	var debugfilter *debugfs.Filter
+	if "" != debugfuse {
+		debugfilter, err = debugfs.ParseFilter(debugfuse)
+		if nil != err {
+			warn("%v", err)
+			return exitUsage
+		}
+		if util.LogInfo < level {
+			level = util.LogInfo
+		}
+	}
+	util.SetLogLevel(level)
+	util.SetSlowThreshold(logslow)
+	err = util.SetLogFormat(logformat)
+	if nil != err {
+		warn("%v", err)
+		return exitUsage
+	}
+	if "" != logpath {
+		logfile, err = util.OpenRotatingFile(logpath, logFileSize, logFileCount)
+		if nil != err {
+			warn("log file error: %v", err)
+			return exitFailure
+		}
+		defer logfile.Close()
+		util.SetLogOutput(logfile)
+	}
+	if "" != eventlog {
+		err = openEventSinks(eventlog)
+		if nil != err {
+			warn("%v", err)
+			return exitUsage
+		}
+		defer closeEventSinks()
+	}
+	libtrace.Logger = log.New(util.LogWriter(util.LogDebug), "", 0)
+	if "" != otlp {
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if "" == service {
+			service = "hubfs"
+		}
+		err = util.StartTracing(otlp, service)
+		if nil != err {
+			warn("%v", err)
+			return exitUsage
+		}
+		defer util.StopTracing()
+	}
+
+	/* HTTP options configure the process-wide transport; apply them once, up front */
+	applyHttpOptions(config)
+	applyHttpOptions(mntopt)
+
+	prefetchpath := ""
+	prefetchdepth := -1
+	prefetchjobs := prov.DefaultWalkers
+	cachecmd := ""
+	cachepath := ""
+	cacherepo := ""
+	cacheconfig := []string(nil)
+	if 0 < flag.NArg() && "prefetch" == flag.Arg(0) {
+		fset := flag.NewFlagSet("prefetch", flag.ContinueOnError)
+		fset.Usage = flag.Usage
+		fset.IntVar(&prefetchdepth, "depth", prefetchdepth,
+			"number of directory levels to prefetch (default: unlimited)")
+		fset.IntVar(&prefetchjobs, "jobs", prefetchjobs,
+			"number of directories and files to prefetch concurrently")
+		if nil != fset.Parse(flag.Args()[1:]) || 1 != fset.NArg() || "" == fset.Arg(0) {
 			flag.Usage()
-			return 2
+			return exitUsage
+		}
+		remote, prefetchpath = splitPrefetchPath(fset.Arg(0), remote)
+		if !hasPersistentCache(config) && !hasPersistentCache(mntopt) {
+			warn("prefetch requires a persistent cache (-o config.dir=DIR or -o config.objdir=DIR)")
+			return exitUsage
+		}
+	} else if 0 < flag.NArg() && "cache" == flag.Arg(0) {
+		var e error
+		cachecmd, cachepath, cacherepo, cacheconfig, e = parseCacheCommand(flag.Args()[1:])
+		if nil != e {
+			warn("%v", e)
+			flag.Usage()
+			return exitUsage
+		}
+		remote, cacherepo = splitPrefetchPath(cacherepo, remote)
+		if !hasPersistentCache(config) && !hasPersistentCache(mntopt) {
+			warn("cache commands require a persistent cache (-o config.dir=DIR or -o config.objdir=DIR)")
+			return exitUsage
+		}
+	} else if 0 < flag.NArg() && "daemon" == flag.Arg(0) {
+		fset := flag.NewFlagSet("daemon", flag.ContinueOnError)
+		fset.Usage = flag.Usage
+		fset.StringVar(&sockpath, "socket", sockpath, "daemon control socket `path`")
+		fset.BoolVar(&foreground, "foreground", foreground, "do not run in the background")
+		if nil != fset.Parse(flag.Args()[1:]) || 0 != fset.NArg() || authonly {
+			flag.Usage()
+			return exitUsage
+		}
+		if !foreground {
+			err := startDaemon(sockpath)
+			if nil != err {
+				warn("daemon error: %v", err)
+				return exitFailure
+			}
+			return 0
+		}
+		daemon = true
+	} else if 0 < flag.NArg() && "ctl" == flag.Arg(0) {
+		return runCtlCommand(flag.Args()[1:], remote)
+	} else if 0 < flag.NArg() && "status" == flag.Arg(0) {
+		return runStatusCommand(flag.Args()[1:])
+	} else if 0 < flag.NArg() && "refresh" == flag.Arg(0) {
+		return runRefreshCommand(flag.Args()[1:])
+	} else if 0 < flag.NArg() && "unmount" == flag.Arg(0) {
+		return runUnmountCommand(flag.Args()[1:])
+	} else if 0 < flag.NArg() && "systemd" == flag.Arg(0) {
+		return runSystemdCommand(flag.Args()[1:], remote, mntopt)
+	} else if 0 < flag.NArg() && "doctor" == flag.Arg(0) {
+		return runDoctorCommand(flag.Args()[1:], remote, authmeth, authkey, config, mntopt)
+	} else if 0 < flag.NArg() && "audit" == flag.Arg(0) {
+		return runAuditCommand(flag.Args()[1:])
+	} else if 0 < flag.NArg() && "version" == flag.Arg(0) {
+		return runVersionCommand(flag.Args()[1:])
+	} else if 0 < flag.NArg() && "completion" == flag.Arg(0) {
+		return runCompletionCommand(flag.Args()[1:])
+	} else if 0 < flag.NArg() && "__complete" == flag.Arg(0) {
+		return runCompleteCommand(flag.Args()[1:], config, mntopt)
+	} else if 0 < flag.NArg() && "launchagent" == flag.Arg(0) {
+		return runLaunchAgentCommand(flag.Args()[1:], remote, os.Args[1:len(os.Args)-flag.NArg()])
+	} else {
+		switch flag.NArg() {
+		case 1:
+			mntpnt = flag.Arg(0)
+		case 2:
+			remote = flag.Arg(0)
+			mntpnt = flag.Arg(1)
+		case 0:
+			if 0 < len(mounts) {
+				remote, mntpnt = splitMountSpec(mounts[0], remote)
+				mounts = mounts[1:]
+				break
+			}
+			fallthrough
+		default:
+			if 2 < flag.NArg() && isNamespaceArgs(flag.Args()[:flag.NArg()-1]) {
+				remote = strings.Join(flag.Args()[:flag.NArg()-1], ",")
+				mntpnt = flag.Arg(flag.NArg() - 1)
+				break
+			}
+			if !authonly {
+				flag.Usage()
+				return exitUsage
+			}
 		}
 	}
+	restrict, err := restrictRules(owners, repos)
+	if nil != err {
+		warn("%v", err)
+		return exitUsage
+	}
+
 	switch authmeth {
 	case "":
 		authmeth = "full"
@@ -222,77 +946,68 @@ func run() int {
 	case "none":
 		if authonly {
 			flag.Usage()
-			return 2
+			return exitUsage
 		}
 	default:
 		if strings.HasPrefix(authmeth, "token=") {
 			break
 		}
 		flag.Usage()
-		return 2
+		return exitUsage
 	}
 
-	if debug {
+	if util.LogDebug == level {
 		libtrace.Verbose = true
 		libtrace.Pattern = "*,github.com/winfsp/hubfs/*,github.com/winfsp/hubfs/fs/*"
 	}
 
 	util.InvokeEvent("main.Flagrun", nil)
 
-	uri, err := url.Parse(remote)
-	if nil != uri && "" == uri.Scheme {
-		uri, err = url.Parse("https://" + remote)
-	}
-	if nil != err {
-		warn("invalid remote: %s", remote)
-		return 1
+	if watchdog && "" != mntpnt && !authonly && !daemon && !check {
+		return runWatchdog(watchdogArgs(os.Args[1:]), mntpnt)
 	}
 
-	provider := prov.NewProviderInstance(uri)
-	if nil == provider {
-		warn("unknown provider: %s", prov.GetProviderInstanceName(uri))
-		return 1
+	/* the first remote of a namespace is the primary remote */
+	primary := remote
+	if ns, err := parseNamespace(remote); nil != err {
+		warn("%v", err)
+		return exitUsage
+	} else if nil != ns {
+		primary = ns[0].remote
+	}
+	uri, err := parseRemote(primary)
+	if nil != err {
+		warn("%v", err)
+		return exitUsage
 	}
 
 	if "" == authkey {
 		authkey = prov.GetProviderInstanceName(uri)
 	}
 
-	var client prov.Client
-	switch authmeth {
-	case "force":
-		client, err = oauthNewClientWithKey(provider, authkey)
-	case "full":
-		client, err = newClientWithKey(provider, authkey)
-		if nil != err {
-			client, err = oauthNewClientWithKey(provider, authkey)
-		}
-	case "required":
-		client, err = newClientWithKey(provider, authkey)
-	case "optional":
-		client, err = newClientWithKey(provider, authkey)
-		if nil != err {
-			client, err = provider.NewClient("")
-		}
-	case "none":
-		client, err = provider.NewClient("")
-	case "git":
-		client, err = gitauthNewClientWithUri(provider, uri)
-	default:
-		if strings.HasPrefix(authmeth, "token=") {
-			client, err = provider.NewClient(strings.TrimPrefix(authmeth, "token="))
-		}
+	if (offline || "" != cachecmd) && !authonly {
+		/* auth is not possible (or necessary) when offline */
+		authmeth = "none"
+	} else if daemon && ("full" == authmeth || "force" == authmeth) {
+		/* the daemon cannot perform interactive auth */
+		authmeth = "optional"
+	} else if check && ("full" == authmeth || "force" == authmeth) {
+		/* a check must not perform interactive auth; the token must be present */
+		authmeth = "required"
 	}
+	client, err := newClient(uri, authmeth, authkey)
 	if nil != err {
 		warn("client error: %v", err)
-		return 1
+		return clientExitCode(err)
 	}
 
 	if !authonly {
 		if 0 == len(mntopt) {
 			mntopt = default_mntopt
 		}
-		fmt.Printf("%s -o %s %s %s\n", progname, strings.Join(mntopt, ","), remote, mntpnt)
+		if "" != mntpnt && !check && !jsonOutput {
+			fmt.Printf("%s -o %s %s %s\n", progname, strings.Join(mntopt, ","), remote, mntpnt)
+		}
 
 		if debug {
 			mntopt = append(mntopt, "debug")
@@ -318,22 +1033,180 @@ func run() int {
 			config = append(config, "config._fullrefs=1")
 		}
 
-		for _, f := range filter {
+		if offline {
+			config = append(config, "config._offline=1")
+		}
+
+		if "" != maxdisk {
+			config = append(config, "config.maxdisk="+maxdisk)
+		}
+
+		if encrypt {
+			key, err := getCacheKey(authkey + ".cachekey")
+			if nil != err {
+				warn("cache key error: %v", err)
+				return exitFailure
+			}
+			config = append(config, "config._cachekey="+key)
+		}
+
+		for _, f := range pin {
 			for _, s := range strings.Split(f, ",") {
-				config = append(config, "config._filter="+s)
+				config = append(config, "config._pin="+s)
 			}
 		}
 
+		config = append(config, filterConfig(restrict, filter)...)
+
+		config = append(config, cacheconfig...)
+
+		/* HTTP options have already been applied */
+		clientconfig := []string{}
+		for _, s := range config {
+			if !isHttpOption(s) {
+				clientconfig = append(clientconfig, s)
+			}
+		}
+		config = clientconfig
 		config, err = client.SetConfig(config)
 		if nil != err {
 			warn("config error: %v", err)
-			return 1
+			return exitUsage
+		}
+
+		if check {
+			mntpnts := []string{}
+			if "" != mntpnt {
+				mntpnts = append(mntpnts, absMountpoint(mntpnt))
+			}
+			for _, m := range mounts {
+				_, p := splitMountSpec(m, remote)
+				mntpnts = append(mntpnts, absMountpoint(p))
+			}
+			return runCheck(client, uri, authmeth, offline, mntpnts)
+		}
+
+		if "" != cachecmd {
+			return runCacheCommand(client, cachecmd, cachepath, path.Join(uri.Path, cacherepo))
+		}
+
+		if "" != prefetchpath {
+			err = prefetch(client, path.Join(uri.Path, prefetchpath), prefetchdepth, prefetchjobs)
+			if nil != err {
+				warn("prefetch error: %v", err)
+				return exitFailure
+			}
+			return 0
+		}
+
+		if "" != webhook {
+			secret := os.Getenv("HUBFS_WEBHOOK_SECRET")
+			listener, err := net.Listen("tcp", webhook)
+			if nil != err {
+				warn("webhook error: %v", err)
+				return exitFailure
+			}
+			defer listener.Close()
+			if "" == secret && !isLoopbackListener(listener) {
+				/* anyone who can reach the listener could discard the cache at will */
+				warn("webhook error: HUBFS_WEBHOOK_SECRET is required unless listening on loopback")
+				return exitUsage
+			}
+			go http.Serve(listener, prov.NewWebhookHandler(client, secret))
+		}
+
+		if "" != pprofaddr {
+			listener, err := net.Listen("tcp", pprofaddr)
+			if nil != err {
+				warn("pprof error: %v", err)
+				return exitFailure
+			}
+			defer listener.Close()
+			if !isLoopbackListener(listener) {
+				/* profiles expose process memory (tokens, cached content) to anyone */
+				warn("pprof error: profiling requires a loopback address")
+				return exitUsage
+			}
+			go http.Serve(listener, newPprofHandler())
 		}
 
 		port.Umask(0)
 
-		if !mount(client, !readonly, uri.Path, mntpnt, config) {
-			return 1
+		manager := newMountManager(authmeth, !daemon, clientconfig, config, readonly, shutdownTimeout,
+			idleTimeout)
+		manager.debug = debugfilter
+		if "" != auditpath {
+			key, err := getKeyringKey(auditKeyName, auditKeySize)
+			var auditlog *util.AuditLog
+			if nil == err {
+				auditlog, err = util.OpenAuditLog(auditpath, []byte(key))
+			}
+			if nil != err {
+				warn("audit log error: %v", err)
+				return exitFailure
+			}
+			defer auditlog.Close()
+			defer watchAuditHead(auditlog, auditpath)()
+			manager.audit = auditlog
+		}
+		manager.addClient(uri, authkey, client)
+		defer manager.close()
+
+		if "" != metricsaddr {
+			listener, err := net.Listen("tcp", metricsaddr)
+			if nil != err {
+				warn("metrics error: %v", err)
+				return exitFailure
+			}
+			defer listener.Close()
+			if !isLoopbackListener(listener) {
+				/* metrics name (possibly private) repositories and report rate limits */
+				warn("metrics error: metrics require a loopback address")
+				return exitUsage
+			}
+			go http.Serve(listener, newMetricsHandler(manager))
+		}
+
+		if "" != healthaddr {
+			listener, err := net.Listen("tcp", healthaddr)
+			if nil != err {
+				warn("health error: %v", err)
+				return exitFailure
+			}
+			defer listener.Close()
+			if !healthpublic && !isLoopbackListener(listener) {
+				/* the report lists mountpoints and provider reachability */
+				warn("health error: -health-public is required unless listening on loopback")
+				return exitUsage
+			}
+			go http.Serve(listener, newHealthHandler(manager))
+		}
+
+		reloader := newReloader(confpath, profile, cmdline, mntopt,
+			map[string][]string{"filter": filter, "owner": owners, "repo": repos}, manager)
+		defer reloader.watch()()
+		defer watchDump(manager)()
+
+		if daemon {
+			return runDaemon(manager, remote, mounts, sockpath, reloader.reload)
+		}
+
+		defer manager.watchShutdown()()
+
+		err = manager.mount(remote, mntpnt)
+		for _, m := range mounts {
+			if nil == err {
+				err = manager.mount(splitMountSpec(m, remote))
+			}
+		}
+		if nil != err {
+			warn("%v", err)
+			manager.unmountAll()
+			manager.wait()
+			return exitMount
+		}
+		if !manager.wait() {
+			return exitMount
 		}
 	}
 
@@ -342,5 +1215,8 @@ func run() int {
 
 func main() {
 	ec := run()
+	if jsonOutput && 0 != ec {
+		printJSONExit(ec)
+	}
 	os.Exit(ec)
 }
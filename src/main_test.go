@@ -0,0 +1,62 @@
+/*
+ * main_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilterConfig(t *testing.T) {
+	restrict, err := restrictRules([]string{"acme"}, []string{"other/repo"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	config := filterConfig(restrict, []string{"-acme/secret,-acme/private"})
+	expect := []string{
+		"config._filter=acme",
+		"config._filter=other/repo",
+		"config._filter=-acme/secret",
+		"config._filter=-acme/private",
+	}
+	if !reflect.DeepEqual(expect, config) {
+		t.Error(config)
+	}
+}
+
+func TestIsMountHelperArgs(t *testing.T) {
+	dir, err := filepath.Abs(os.TempDir())
+	if nil != err {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args   []string
+		expect bool
+	}{
+		{[]string{"github.com/acme", dir, "-o", "allow_other"}, true},
+		{[]string{"github.com/acme", "mnt", "-o", "allow_other"}, false},
+		{[]string{"github.com/acme", filepath.Join(dir, "nonexistent"), "-o", "ro"}, false},
+		{[]string{"prefetch", dir, "-o", "config.dir=x"}, false},
+		{[]string{"cache", dir, "-o", "config.dir=x"}, false},
+		{[]string{"-readonly", dir, "-o", "ro"}, false},
+		{[]string{"github.com/acme", dir}, false},
+	}
+	for _, test := range tests {
+		if test.expect != isMountHelperArgs(test.args) {
+			t.Error(test.args)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * metrics.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metric describes a metric in the Prometheus text exposition format.
+type metric struct {
+	name string
+	kind string
+	help string
+}
+
+var (
+	metricMounts = metric{"hubfs_mounts", "gauge",
+		"Number of mounted file systems."}
+	metricRateLimit = metric{"hubfs_ratelimit_limit", "gauge",
+		"Request budget of a rate limited resource of the provider."}
+	metricRateRemaining = metric{"hubfs_ratelimit_remaining", "gauge",
+		"Remaining request budget of a rate limited resource of the provider."}
+	metricRateReset = metric{"hubfs_ratelimit_reset_timestamp_seconds", "gauge",
+		"Time when the request budget of a rate limited resource resets."}
+	metricRateBackoff = metric{"hubfs_ratelimit_backoff_seconds", "gauge",
+		"Remaining backoff of a secondary rate limit of the provider."}
+	metricCacheLookups = metric{"hubfs_cache_lookups_total", "counter",
+		"Cache lookups of a repository by result (hit, stale, miss)."}
+	metricRequests = metric{"hubfs_provider_requests_total", "counter",
+		"Requests to the provider for a repository (or an owner if repository is empty)."}
+	metricDownload = metric{"hubfs_download_bytes_total", "counter",
+		"Bytes of the objects of a repository downloaded from the provider."}
+)
+
+func (m metric) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+}
+
+// sample writes a sample of the metric; labels are name, value pairs.
+func (m metric) sample(w io.Writer, value float64, labels ...string) {
+	fmt.Fprint(w, m.name)
+	for i := 0; len(labels) > i+1; i += 2 {
+		sep := ","
+		if 0 == i {
+			sep = "{"
+		}
+		fmt.Fprintf(w, "%s%s=%s", sep, labels[i], strconv.Quote(labels[i+1]))
+	}
+	if 0 < len(labels) {
+		fmt.Fprint(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeMetrics writes the metrics of the file systems of a mount manager and of the
+// clients of their remotes.
+func writeMetrics(w io.Writer, manager *mountManager) {
+	metricMounts.header(w)
+	metricMounts.sample(w, float64(len(manager.list())))
+
+	status := manager.clientStatus()
+	remotes := manager.remotes()
+	for _, m := range []metric{metricRateLimit, metricRateRemaining, metricRateReset} {
+		m.header(w)
+		for _, remote := range remotes {
+			for _, l := range status[remote].RateLimits {
+				value := 0.0
+				switch m {
+				case metricRateLimit:
+					value = float64(l.Limit)
+				case metricRateRemaining:
+					value = float64(l.Remaining)
+				case metricRateReset:
+					value = float64(l.Reset.Unix())
+				}
+				m.sample(w, value, "remote", remote, "resource", l.Resource)
+			}
+		}
+	}
+	metricRateBackoff.header(w)
+	for _, remote := range remotes {
+		if s, ok := status[remote]; ok {
+			value := 0.0
+			if nil != s.Backoff {
+				if d := time.Until(*s.Backoff); 0 < d {
+					value = d.Seconds()
+				}
+			}
+			metricRateBackoff.sample(w, value, "remote", remote)
+		}
+	}
+	cachestats := manager.cacheStats()
+	metricCacheLookups.header(w)
+	for _, c := range cachestats {
+		for _, r := range []struct {
+			result string
+			value  uint64
+		}{{"hit", c.Hits}, {"stale", c.Stale}, {"miss", c.Misses}} {
+			metricCacheLookups.sample(w, float64(r.value),
+				"remote", c.Remote, "owner", c.Owner, "repository", c.Repository, "result", r.result)
+		}
+	}
+	for _, m := range []metric{metricRequests, metricDownload} {
+		m.header(w)
+		for _, c := range cachestats {
+			value := c.Requests
+			if metricDownload == m {
+				value = c.Bytes
+			}
+			m.sample(w, float64(value), "remote", c.Remote, "owner", c.Owner, "repository", c.Repository)
+		}
+	}
+}
+
+// newMetricsHandler returns a handler that serves the metrics of a mount manager at
+// /metrics in the Prometheus text exposition format.
+func newMetricsHandler(manager *mountManager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		if "GET" != req.Method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, manager)
+	})
+	return mux
+}
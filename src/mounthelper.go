@@ -0,0 +1,172 @@
+/*
+ * mounthelper.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// mountHelperIgnoredOptions are fstab options that are meant for mount(8) or systemd
+// rather than the file system.
+var mountHelperIgnoredOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"users":    true,
+	"nouser":   true,
+	"owner":    true,
+	"group":    true,
+	"nofail":   true,
+	"_netdev":  true,
+	"rw":       true,
+}
+
+// mountHelperArgs translates the arguments of a mount helper (source mountpoint
+// [-sfnv] [-N namespace] [-o options] [-t type]) to HUBFS arguments. A source of
+// the form hubfs#remote (as used with the fuse file system type) is remote. Options that
+// name HUBFS command line options (e.g. auth=required, filter=owner, readonly) are
+// passed as such; ro is readonly; fstab and systemd options (e.g. noauto, _netdev,
+// x-systemd.automount) are ignored; any other options are FUSE mount options.
+func mountHelperArgs(args []string) (res []string, err error) {
+	pos := []string{}
+	opts := []string{}
+	for i := 0; len(args) > i; i++ {
+		switch a := args[i]; {
+		case "-o" == a || "-t" == a || "-N" == a:
+			if len(args) == i+1 {
+				return nil, fmt.Errorf("missing argument for %s", a)
+			}
+			i++
+			if "-o" == a {
+				opts = append(opts, strings.Split(args[i], ",")...)
+			}
+		case strings.HasPrefix(a, "-o"):
+			opts = append(opts, strings.Split(a[2:], ",")...)
+		case strings.HasPrefix(a, "-"):
+			// -s (sloppy), -f (fake), -n (no mtab), -v (verbose)
+		default:
+			pos = append(pos, a)
+		}
+	}
+	if 2 != len(pos) {
+		return nil, errors.New("usage: mount.hubfs remote mountpoint [-o options]")
+	}
+	if i := strings.IndexByte(pos[0], '#'); -1 != i {
+		pos[0] = pos[0][i+1:]
+	}
+
+	mntopt := []string{}
+	for _, o := range opts {
+		n := o
+		if i := strings.IndexByte(o, '='); -1 != i {
+			n = o[:i]
+		}
+		switch {
+		case "" == o || mountHelperIgnoredOptions[n] ||
+			strings.HasPrefix(n, "x-") || strings.HasPrefix(n, "comment"):
+		case "ro" == n:
+			res = append(res, "-readonly")
+		case "o" != n && nil != flag.Lookup(n):
+			res = append(res, "-"+o)
+		default:
+			mntopt = append(mntopt, o)
+		}
+	}
+	if 0 < len(mntopt) {
+		res = append(res, "-o", strings.Join(mntopt, ","))
+	}
+	res = append(res, pos...)
+	return res, nil
+}
+
+// isMountHelperArgs determines if the arguments are those of a mount helper as
+// passed by mount.fuse for an fstab entry of the form hubfs#remote mountpoint fuse
+// (i.e. remote mountpoint -o options). mount.fuse runs HUBFS under its own name and
+// does not identify itself, so the arguments must have exactly this form: the remote
+// is not a command (e.g. prefetch or cache) and the mountpoint is an existing
+// directory specified as an absolute path (as mount(8) passes it).
+func isMountHelperArgs(args []string) bool {
+	if 4 != len(args) || "-o" != args[2] ||
+		strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[1], "-") {
+		return false
+	}
+	if "__complete" == args[0] {
+		return false
+	}
+	for _, c := range completionCommands {
+		if c == args[0] {
+			return false
+		}
+	}
+	if !filepath.IsAbs(args[1]) {
+		return false
+	}
+	info, err := os.Stat(args[1])
+	return nil == err && info.IsDir()
+}
+
+// runMountHelper runs HUBFS as an external mount helper (mount.hubfs or
+// mount.fuse.hubfs) of mount(8) and systemd mount units. It mounts the file system in a process of its
+// own and returns once the mountpoint is mounted.
+func runMountHelper(args []string) int {
+	hargs, err := mountHelperArgs(args)
+	if nil != err {
+		warn("%v", err)
+		return exitUsage
+	}
+	mntpnt := absMountpoint(hargs[len(hargs)-1])
+
+	exe, err := os.Executable()
+	if nil == err {
+		cmd := exec.Command(exe, hargs...)
+		cmd.SysProcAttr = daemonSysProcAttr()
+		cmd.Stderr = os.Stderr
+		err = cmd.Start()
+		if nil == err {
+			doneC := make(chan error, 1)
+			go func() {
+				doneC <- cmd.Wait()
+			}()
+			err = errors.New("mount timed out")
+		loop:
+			for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); {
+				select {
+				case err = <-doneC:
+					if nil == err {
+						err = errors.New("file system exited")
+					}
+					break loop
+				case <-time.After(100 * time.Millisecond):
+				}
+				if isMountpoint(mntpnt) {
+					err = nil
+					break loop
+				}
+			}
+		}
+	}
+	if nil != err {
+		warn("mount error: %s: %v", mntpnt, err)
+		return exitFailure
+	}
+	return 0
+}
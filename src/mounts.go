@@ -0,0 +1,675 @@
+/*
+ * mounts.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/winfsp/cgofuse/fuse"
+	"github.com/winfsp/hubfs/fs/auditfs"
+	"github.com/winfsp/hubfs/fs/debugfs"
+	"github.com/winfsp/hubfs/fs/drainfs"
+	"github.com/winfsp/hubfs/fs/hubfs"
+	"github.com/winfsp/hubfs/fs/sortfs"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+// mountInfo describes an active mount.
+type mountInfo struct {
+	Remote     string    `json:"remote"`
+	Mountpoint string    `json:"mountpoint"`
+	Time       time.Time `json:"time"`
+	host       *fuse.FileSystemHost
+	drainfs    *drainfs.FileSystem
+	clients    []string
+	doneC      chan struct{}
+}
+
+// absMountpoint makes a mountpoint absolute, so that mounts are identified by
+// the same name regardless of the working directory (e.g. of a ctl command).
+// Windows drive mountpoints (X: or * for any drive) are left as is.
+func absMountpoint(mntpnt string) string {
+	if "windows" == runtime.GOOS &&
+		("*" == mntpnt || (2 == len(mntpnt) && ':' == mntpnt[1])) {
+		return mntpnt
+	}
+	if p, err := filepath.Abs(mntpnt); nil == err {
+		return p
+	}
+	return mntpnt
+}
+
+// freeDrive returns the first of the drive letters that is not in use (e.g. Z: for
+// "XYZ" if X: and Y: exist) or * if all of them are in use.
+func freeDrive(letters string) string {
+	for _, c := range strings.ToUpper(letters) {
+		if 'A' > c || 'Z' < c {
+			continue
+		}
+		drive := string(c) + ":"
+		if _, err := os.Stat(drive + `\`); nil != err && os.IsNotExist(err) {
+			return drive
+		}
+	}
+	return "*"
+}
+
+// nsRemote is a remote that is presented under a named top-level directory of a
+// namespace mount.
+type nsRemote struct {
+	remote string
+	name   string
+}
+
+// parseNamespace parses the remote of a namespace mount, which is a list of
+// remote=name pairs (e.g. github.com=gh,gitlab.com=gl). It returns nil if the
+// remote is not a namespace.
+func parseNamespace(remote string) ([]nsRemote, error) {
+	if -1 == strings.IndexByte(remote, '=') {
+		return nil, nil
+	}
+	ns := []nsRemote{}
+	for _, s := range strings.Split(remote, ",") {
+		i := strings.LastIndexByte(s, '=')
+		if -1 == i || 0 == i {
+			return nil, fmt.Errorf("invalid namespace remote: %s", s)
+		}
+		name := s[i+1:]
+		if "" == name || "." == name[:1] || strings.ContainsAny(name, "/\\") {
+			return nil, fmt.Errorf("invalid namespace name: %s", s)
+		}
+		for _, r := range ns {
+			if strings.EqualFold(r.name, name) {
+				return nil, fmt.Errorf("duplicate namespace name: %s", name)
+			}
+		}
+		ns = append(ns, nsRemote{remote: s[:i], name: name})
+	}
+	return ns, nil
+}
+
+// mountManager mounts and unmounts file systems in the same process. File systems
+// of the same remote share a client and therefore its cache (and rate limit budget).
+type mountManager struct {
+	authmeth string
+	fallback string
+	primary  string
+	authkey  string
+	config   []string
+	mntopt   []string
+	drives   string
+	sortless func(a, b string) bool
+	debug    *debugfs.Filter // logged FUSE operations (if not nil)
+	audit    *util.AuditLog  // audit log of modifications (if not nil)
+	readonly bool
+	timeout  time.Duration
+	idle     time.Duration
+	caseins  bool
+	lock     sync.Mutex
+	clients  map[string]prov.Client
+	mounts   map[string]*mountInfo
+	wg       sync.WaitGroup
+	failed   bool
+}
+
+// newMountManager creates a mount manager. The authmeth and clientconfig are used for
+// clients of additional remotes; config also contains the FUSE mount options. If not
+// interactive (e.g. in the daemon), clients never perform interactive auth. If
+// readonly, file systems have no overlay and fail all modifications with EROFS.
+// File systems are unmounted after the operations in progress complete, but no
+// later than the timeout. If idle is not 0, file systems that have had no open
+// files and no operations for that long are unmounted.
+func newMountManager(authmeth string, interactive bool,
+	clientconfig []string, config []string, readonly bool, timeout time.Duration,
+	idle time.Duration) *mountManager {
+	mntopt := []string{}
+	if readonly && "linux" == runtime.GOOS {
+		// also have the kernel reject modifications
+		mntopt = append(mntopt, "-oro")
+	}
+	drives := ""
+	var sortless func(a, b string) bool
+	for _, s := range config {
+		if strings.HasPrefix(s, "config.mount.drives=") {
+			drives = s[len("config.mount.drives="):]
+		}
+		switch s {
+		case "config.mount.sort=byte":
+			sortless = sortfs.ByteLess
+		case "config.mount.sort=natural":
+			sortless = sortfs.NaturalLess
+		}
+		if opt, ok := kernelCacheOption(s); ok {
+			s = opt
+		} else if opt, ok := mountOption(s); ok {
+			s = opt
+		}
+		if "" != s {
+			mntopt = append(mntopt, "-o"+s)
+		}
+	}
+
+	// an explicit token belongs to the primary remote
+	fallback := authmeth
+	if strings.HasPrefix(authmeth, "token=") {
+		fallback = "full"
+		if !interactive {
+			fallback = "optional"
+		}
+	}
+
+	return &mountManager{
+		authmeth: authmeth,
+		fallback: fallback,
+		config:   clientconfig,
+		mntopt:   mntopt,
+		drives:   drives,
+		sortless: sortless,
+		readonly: readonly,
+		timeout:  timeout,
+		idle:     idle,
+		caseins:  "windows" == runtime.GOOS || "darwin" == runtime.GOOS,
+		clients:  map[string]prov.Client{},
+		mounts:   map[string]*mountInfo{},
+	}
+}
+
+// addClient adds the already configured client of the primary remote. The authkey
+// is used if the client has to be created again (e.g. after its mounts are gone).
+func (m *mountManager) addClient(uri *url.URL, authkey string, client prov.Client) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.primary = prov.GetProviderInstanceName(uri)
+	m.authkey = authkey
+	m.addClientLocked(m.primary, client)
+}
+
+func (m *mountManager) addClientLocked(name string, client prov.Client) {
+	if m.caseins {
+		client.SetConfig([]string{"config._caseins=1"})
+	} else {
+		client.SetConfig([]string{"config._caseins=0"})
+	}
+	client.StartExpiration()
+	m.clients[name] = client
+}
+
+// getClient gets the client for a remote, creating it if necessary.
+func (m *mountManager) getClient(uri *url.URL) (prov.Client, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	name := prov.GetProviderInstanceName(uri)
+	if client, ok := m.clients[name]; ok {
+		return client, nil
+	}
+
+	authmeth, authkey := m.fallback, ""
+	if name == m.primary {
+		authmeth, authkey = m.authmeth, m.authkey
+	}
+	client, err := newClient(uri, authmeth, authkey)
+	if nil == err {
+		_, err = client.SetConfig(m.config)
+	}
+	if nil != err {
+		return nil, fmt.Errorf("client error: %s: %v", name, err)
+	}
+	m.addClientLocked(name, client)
+	return client, nil
+}
+
+// reconfigure applies the settings of a configuration that can be changed at runtime
+// to the clients (see prov.Client.Reconfigure). Clients that are created later are
+// configured with them as well.
+func (m *mountManager) reconfigure(config []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	clientconfig := []string{}
+	for _, s := range m.config {
+		if !prov.IsRuntimeConfig(s) {
+			clientconfig = append(clientconfig, s)
+		}
+	}
+	for _, s := range config {
+		if prov.IsRuntimeConfig(s) {
+			clientconfig = append(clientconfig, s)
+		}
+	}
+	m.config = clientconfig
+	for _, client := range m.clients {
+		client.Reconfigure(config)
+	}
+}
+
+// mount mounts the file system of a remote on a mountpoint and returns once the
+// file system has been mounted (or has failed to mount).
+func (m *mountManager) mount(remote string, mntpnt string) (err error) {
+	defer func() {
+		if nil != err {
+			reportEvent(eventError, eventMountFailure, "cannot mount %s on %s: %v", remote, mntpnt, err)
+		}
+	}()
+
+	mntpnt = absMountpoint(mntpnt)
+	if "*" == mntpnt && "" != m.drives {
+		mntpnt = freeDrive(m.drives)
+	}
+
+	m.lock.Lock()
+	_, ok := m.mounts[mntpnt]
+	m.lock.Unlock()
+	if ok {
+		return fmt.Errorf("already mounted: %s", mntpnt)
+	}
+
+	initC := make(chan struct{})
+	doneC := make(chan bool, 1)
+	fs, clients, err := m.newFileSystem(remote, func() { close(initC) })
+	if nil != err {
+		m.releaseClients(clients)
+		return err
+	}
+	if nil != m.sortless {
+		fs = sortfs.New(fs, m.sortless)
+	}
+	if nil != m.debug || 0 != util.GetSlowThreshold() {
+		fs = debugfs.New(fs, m.debug)
+	}
+	dfs := drainfs.New(fs, m.timeout)
+	host := fuse.NewFileSystemHost(dfs)
+	host.SetCapCaseInsensitive(m.caseins)
+	host.SetCapReaddirPlus(true)
+
+	m.lock.Lock()
+	if _, ok := m.mounts[mntpnt]; ok {
+		m.lock.Unlock()
+		m.releaseClients(clients)
+		return fmt.Errorf("already mounted: %s", mntpnt)
+	}
+	info := &mountInfo{
+		Remote:     remote,
+		Mountpoint: mntpnt,
+		Time:       time.Now(),
+		host:       host,
+		drainfs:    dfs,
+		clients:    clients,
+		doneC:      make(chan struct{}),
+	}
+	m.mounts[mntpnt] = info
+	m.lock.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ok := host.Mount(mntpnt, m.mntopt)
+		m.release(info, ok)
+		doneC <- ok
+	}()
+
+	select {
+	case <-initC:
+		util.Logf(util.LogInfo, "mounted %s on %s", remote, mntpnt)
+		reportEvent(eventInformation, eventMount, "mounted %s on %s", remote, mntpnt)
+		if 0 != m.idle {
+			go m.unmountIdle(info)
+		}
+		return nil
+	case <-doneC:
+		return fmt.Errorf("mount error: %s", mntpnt)
+	}
+}
+
+// newFileSystem creates the file system of a remote and returns the names of the
+// clients that it uses (on error the clients obtained so far). The file system of
+// a namespace remote presents the file system of each of its remotes under a
+// top-level directory.
+func (m *mountManager) newFileSystem(remote string, init func()) (
+	fs fuse.FileSystemInterface, clients []string, err error) {
+	ns, err := parseNamespace(remote)
+	if nil != err {
+		return nil, nil, err
+	}
+	if nil == ns {
+		ns = []nsRemote{{remote: remote}}
+	}
+
+	names := []string{}
+	configs := []hubfs.Config{}
+	prefixes := map[string]string{}
+	for _, r := range ns {
+		uri, err := parseRemote(r.remote)
+		if nil != err {
+			return nil, clients, err
+		}
+		client, err := m.getClient(uri)
+		if nil != err {
+			return nil, clients, err
+		}
+		names = append(names, r.name)
+		prefixes[r.name] = uri.Path
+		configs = append(configs, hubfs.Config{
+			Client:   client,
+			Prefix:   uri.Path,
+			Caseins:  m.caseins,
+			Overlay:  !m.readonly,
+			ReadOnly: m.readonly,
+			Init:     init,
+		})
+		clients = append(clients, prov.GetProviderInstanceName(uri))
+	}
+
+	if "" == names[0] {
+		fs = hubfs.New(configs[0])
+	} else {
+		fs = hubfs.NewNamespace(hubfs.NamespaceConfig{
+			Names:   names,
+			Configs: configs,
+			Caseins: m.caseins,
+			Init:    init,
+		})
+	}
+	if nil != m.audit && !m.readonly {
+		fs = auditfs.New(fs, auditfs.Config{Log: m.audit, Remote: remote, Prefixes: prefixes})
+	}
+	return
+}
+
+// release forgets a mount once its file system has been unmounted. The clients of
+// the last mount of a remote are closed, which saves their metadata and removes
+// their temporary cache directories.
+func (m *mountManager) release(info *mountInfo, ok bool) {
+	m.lock.Lock()
+	delete(m.mounts, info.Mountpoint)
+	if !ok {
+		m.failed = true
+	}
+	clients := m.removeClientsLocked(info.clients)
+	m.lock.Unlock()
+
+	for _, client := range clients {
+		client.StopExpiration()
+	}
+	if ok {
+		util.Logf(util.LogInfo, "unmounted %s from %s", info.Remote, info.Mountpoint)
+		reportEvent(eventInformation, eventUnmount, "unmounted %s from %s", info.Remote, info.Mountpoint)
+	}
+	close(info.doneC)
+}
+
+// releaseClients closes the named clients of a file system that was not mounted,
+// unless they are used by a mount.
+func (m *mountManager) releaseClients(names []string) {
+	m.lock.Lock()
+	clients := m.removeClientsLocked(names)
+	m.lock.Unlock()
+
+	for _, client := range clients {
+		client.StopExpiration()
+	}
+}
+
+// removeClientsLocked removes the named clients that are not used by any mount and
+// returns them, so that they can be closed outside the lock.
+func (m *mountManager) removeClientsLocked(names []string) (clients []prov.Client) {
+	for _, name := range names {
+		inuse := false
+		for _, other := range m.mounts {
+			for _, n := range other.clients {
+				inuse = inuse || n == name
+			}
+		}
+		if client, ok := m.clients[name]; ok && !inuse {
+			clients = append(clients, client)
+			delete(m.clients, name)
+		}
+	}
+	return
+}
+
+// unmount unmounts the file system on a mountpoint and waits until it has been
+// released. The file system stops accepting new operations and is unmounted once
+// the operations in progress complete (or the timeout elapses). Pending changes of
+// the file system (e.g. of its overlay) are written when it is destroyed.
+func (m *mountManager) unmount(mntpnt string) error {
+	mntpnt = absMountpoint(mntpnt)
+	m.lock.Lock()
+	info, ok := m.mounts[mntpnt]
+	m.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("not mounted: %s", mntpnt)
+	}
+	if n := info.drainfs.Drain(m.timeout); 0 != n {
+		util.Logf(util.LogWarn, "unmounting %s with %d operations in progress", mntpnt, n)
+	}
+	if !info.host.Unmount() {
+		info.drainfs.Resume()
+		return fmt.Errorf("unmount error: %s", mntpnt)
+	}
+	<-info.doneC
+	return nil
+}
+
+// unmountIdle unmounts a file system once it has been idle for the idle timeout.
+func (m *mountManager) unmountIdle(info *mountInfo) {
+	interval := m.idle / 4
+	if time.Minute < interval {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-info.doneC:
+			return
+		case <-ticker.C:
+			if info.drainfs.Idle() < m.idle {
+				continue
+			}
+			util.Logf(util.LogInfo, "unmounting %s after being idle for %v", info.Mountpoint, m.idle)
+			if err := m.unmount(info.Mountpoint); nil != err {
+				util.Logf(util.LogWarn, "%v", err)
+			}
+		}
+	}
+}
+
+// watchShutdown unmounts all file systems on an interrupt or termination signal
+// (on Windows also when the console is closed) until the returned function is
+// called.
+func (m *mountManager) watchShutdown() (stop func()) {
+	sigC := make(chan os.Signal, 1)
+	doneC := make(chan struct{})
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigC:
+			util.Logf(util.LogInfo, "shutting down on %v", sig)
+			m.unmountAll()
+		case <-doneC:
+		}
+	}()
+	return func() {
+		signal.Stop(sigC)
+		close(doneC)
+	}
+}
+
+// unmountAll unmounts all file systems.
+func (m *mountManager) unmountAll() {
+	for _, info := range m.list() {
+		m.unmount(info.Mountpoint)
+	}
+}
+
+// list returns the active mounts sorted by mountpoint.
+func (m *mountManager) list() []mountInfo {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mounts := make([]mountInfo, 0, len(m.mounts))
+	for _, info := range m.mounts {
+		mounts = append(mounts, *info)
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		return mounts[i].Mountpoint < mounts[j].Mountpoint
+	})
+	return mounts
+}
+
+// remotes returns the sorted names of the remotes that have clients.
+func (m *mountManager) remotes() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(m.clients))
+	for name := range m.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clientStatus returns the status of the clients by remote.
+func (m *mountManager) clientStatus() map[string]prov.ClientStatus {
+	m.lock.Lock()
+	clients := make(map[string]prov.Client, len(m.clients))
+	for name, client := range m.clients {
+		clients[name] = client
+	}
+	m.lock.Unlock()
+	status := make(map[string]prov.ClientStatus, len(clients))
+	for name, client := range clients {
+		status[name] = client.GetStatus()
+	}
+	return status
+}
+
+// cacheStats returns the cache statistics of the repositories of the clients sorted
+// by remote and owner/repo.
+func (m *mountManager) cacheStats() []repoCacheStats {
+	m.lock.Lock()
+	clients := make(map[string]prov.Client, len(m.clients))
+	names := make([]string, 0, len(m.clients))
+	for name, client := range m.clients {
+		clients[name] = client
+		names = append(names, name)
+	}
+	m.lock.Unlock()
+	sort.Strings(names)
+	res := []repoCacheStats{}
+	for _, name := range names {
+		for _, s := range clients[name].GetCacheStats() {
+			res = append(res, repoCacheStats{Remote: name, CacheStats: s})
+		}
+	}
+	return res
+}
+
+// lookupClient returns the client of a remote if there is one.
+func (m *mountManager) lookupClient(uri *url.URL) (prov.Client, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	client, ok := m.clients[prov.GetProviderInstanceName(uri)]
+	return client, ok
+}
+
+// lookupPath finds the mount that contains a path (an absolute path under its
+// mountpoint) and returns the client and the path within the provider (e.g.
+// /owner/repo/ref/dir). A path of a namespace mount must be within one of its
+// top-level directories.
+func (m *mountManager) lookupPath(p string) (prov.Client, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var info *mountInfo
+	rel := ""
+	for _, i := range m.mounts {
+		base := i.Mountpoint
+		if 2 == len(base) && ':' == base[1] {
+			base += string(filepath.Separator)
+		}
+		r, err := filepath.Rel(base, p)
+		if nil != err || ".." == r || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if nil == info || len(info.Mountpoint) < len(i.Mountpoint) {
+			info, rel = i, r
+		}
+	}
+	if nil == info {
+		return nil, "", fmt.Errorf("not under a mountpoint: %s", p)
+	}
+	if "." == rel {
+		rel = ""
+	}
+	rel = "/" + filepath.ToSlash(rel)
+
+	remote := info.Remote
+	if ns, _ := parseNamespace(remote); nil != ns {
+		comp := strings.SplitN(strings.TrimPrefix(rel, "/"), "/", 2)
+		remote = ""
+		for _, r := range ns {
+			if strings.EqualFold(r.name, comp[0]) {
+				remote = r.remote
+			}
+		}
+		if "" == remote {
+			return nil, "", fmt.Errorf("not under a namespace directory: %s", p)
+		}
+		rel = "/"
+		if 2 == len(comp) {
+			rel += comp[1]
+		}
+	}
+
+	uri, err := parseRemote(remote)
+	if nil != err {
+		return nil, "", err
+	}
+	client, ok := m.clients[prov.GetProviderInstanceName(uri)]
+	if !ok {
+		return nil, "", fmt.Errorf("no mounts of remote: %s", prov.GetProviderInstanceName(uri))
+	}
+	return client, path.Join("/", uri.Path, rel), nil
+}
+
+// wait waits until all file systems are unmounted. It returns false if any of
+// them failed to mount.
+func (m *mountManager) wait() bool {
+	m.wg.Wait()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return !m.failed
+}
+
+// close stops the cache expiration of all clients.
+func (m *mountManager) close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, client := range m.clients {
+		client.StopExpiration()
+	}
+}
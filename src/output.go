@@ -0,0 +1,94 @@
+/*
+ * output.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Exit codes. They are stable so that scripts can rely on them.
+const (
+	exitFailure     = 1 // any other failure
+	exitUsage       = 2 // invalid command line, remote or configuration
+	exitUpdate      = 3 // a newer release is available (version -check)
+	exitAuth        = 4 // authentication failed
+	exitMount       = 5 // the file system could not be mounted
+	exitUnreachable = 6 // the provider (or the daemon) could not be reached
+)
+
+var exitReasons = map[int]string{
+	exitFailure:     "failure",
+	exitUsage:       "usage",
+	exitUpdate:      "update",
+	exitAuth:        "auth",
+	exitMount:       "mount",
+	exitUnreachable: "unreachable",
+}
+
+// jsonOutput is set by -output json. Commands then print their results as JSON to
+// stdout and errors are printed as JSON objects (one per line) to stderr.
+var jsonOutput bool
+
+// errUnknownProvider is returned for remotes that do not name a known provider.
+var errUnknownProvider = errors.New("unknown provider")
+
+// printJSON prints a value as JSON to stdout.
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// printJSONError prints an error message as a JSON object to stderr.
+func printJSONError(msg string) {
+	b, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{msg})
+	fmt.Fprintf(os.Stderr, "%s\n", b)
+}
+
+// printJSONExit prints the exit code and its reason as a JSON object to stderr.
+func printJSONExit(code int) {
+	b, _ := json.Marshal(struct {
+		Exit   int    `json:"exit"`
+		Reason string `json:"reason"`
+	}{code, exitReasons[code]})
+	fmt.Fprintf(os.Stderr, "%s\n", b)
+}
+
+// clientExitCode returns the exit code for an error that occurred while creating a
+// client: the provider is unknown, unreachable or it rejected the credentials.
+func clientExitCode(err error) int {
+	var e net.Error
+	switch {
+	case errors.Is(err, errUnknownProvider):
+		return exitUsage
+	case errors.As(err, &e):
+		return exitUnreachable
+	default:
+		return exitAuth
+	}
+}
+
+// controlExitCode returns the exit code for an error of a control request.
+func controlExitCode(err error) int {
+	if errDaemonNotRunning == err {
+		return exitUnreachable
+	}
+	return exitFailure
+}
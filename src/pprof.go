@@ -0,0 +1,31 @@
+/*
+ * pprof.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// newPprofHandler returns a handler that serves the runtime profiling data of the
+// process at /debug/pprof/ (e.g. go tool pprof http://ADDR/debug/pprof/heap). The
+// command line is not served, because it may contain credentials.
+func newPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,115 @@
+/*
+ * prefetch.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/winfsp/hubfs/prov"
+)
+
+// prefetch walks owner/repo[/ref[/path]] and fetches its trees and blobs into the
+// cache. If ref is not specified all branches are prefetched. The depth limits the
+// number of directory levels below path that are walked (-1 for no limit); the jobs
+// limits the number of directories and files that are fetched concurrently.
+func prefetch(client prov.Client, path string, depth int, jobs int) (err error) {
+	lst := strings.Split(strings.Trim(path, "/"), "/")
+	if 2 > len(lst) || "" == lst[0] || "" == lst[1] {
+		return errors.New("invalid path: " + path)
+	}
+
+	client.StartExpiration()
+	defer client.StopExpiration()
+
+	owner, err := client.OpenOwner(lst[0])
+	if nil != err {
+		return
+	}
+	defer client.CloseOwner(owner)
+
+	repository, err := client.OpenRepository(owner, lst[1])
+	if nil != err {
+		return
+	}
+	defer client.CloseRepository(repository)
+
+	var refs []prov.Ref
+	if 2 < len(lst) {
+		var ref prov.Ref
+		ref, err = repository.GetRef(lst[2])
+		if nil != err {
+			ref, err = repository.GetTempRef(lst[2])
+		}
+		if nil != err {
+			return
+		}
+		refs = []prov.Ref{ref}
+	} else {
+		refs, err = repository.GetRefs()
+		if nil != err {
+			return
+		}
+	}
+
+	for _, ref := range refs {
+		var entry prov.TreeEntry
+		if 3 < len(lst) {
+			for _, n := range lst[3:] {
+				entry, err = repository.GetTreeEntry(ref, entry, n)
+				if nil != err {
+					return
+				}
+			}
+		}
+
+		count := int64(0)
+		if nil != entry && 0040000 != entry.Mode()&0170000 {
+			err = prefetchBlob(repository, entry, &count)
+		} else {
+			err = prov.WalkTree(repository, ref, entry, depth, jobs, func(e prov.TreeEntry) error {
+				switch e.Mode() & 0170000 {
+				case 0040000:
+					// directories are walked by WalkTree
+				case 0120000, 0160000:
+					// symlink targets are fetched with the tree; submodules are not followed
+				default:
+					return prefetchBlob(repository, e, &count)
+				}
+				return nil
+			})
+		}
+		if nil != err {
+			return
+		}
+		fmt.Printf("%s/%s/%s: %d files\n", owner.Name(), repository.Name(), ref.Name(), count)
+	}
+
+	return
+}
+
+func prefetchBlob(repository prov.Repository, entry prov.TreeEntry, count *int64) error {
+	reader, err := repository.GetBlobReader(entry)
+	if nil != err {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		closer.Close()
+	}
+	atomic.AddInt64(count, 1)
+	return nil
+}
@@ -14,6 +14,11 @@
 package prov
 
 import (
+	"math"
+	"math/rand"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,51 +26,135 @@ import (
 	libcache "github.com/billziss-gh/golib/cache"
 )
 
+// cacheImap is a case-insensitive cache map. Keys are stored in upper case; lookups
+// of ASCII keys fold the key into a stack buffer, so that they do not allocate.
 type cacheImap struct {
 	libcache.Map
+	list *libcache.MapItem
 }
 
+// cacheImapKeySize is the maximum length of keys that are folded without allocating.
+const cacheImapKeySize = 128
+
 func NewCacheImap(list *libcache.MapItem) *cacheImap {
-	m := &cacheImap{}
+	if nil == list {
+		list = &libcache.MapItem{}
+		list.Empty()
+	}
+	m := &cacheImap{list: list}
 	m.Map.InitMap(list)
 	return m
 }
 
+// foldKey stores the upper case form of an ASCII key in buf. It returns nil if the
+// key is not ASCII or does not fit in buf.
+func foldKey(buf []byte, key string) []byte {
+	if len(key) > len(buf) {
+		return nil
+	}
+	for i := 0; len(key) > i; i++ {
+		c := key[i]
+		if 0x80 <= c {
+			return nil
+		}
+		if 'a' <= c && 'z' >= c {
+			c -= 'a' - 'A'
+		}
+		buf[i] = c
+	}
+	return buf[:len(key)]
+}
+
+// foldString returns the upper case form of a key, folding ASCII keys with foldKey
+// so that keys are folded the same way wherever they are used.
+func foldString(key string) string {
+	var buf [cacheImapKeySize]byte
+	if k := foldKey(buf[:], key); nil != k {
+		return string(k)
+	}
+	return strings.ToUpper(key)
+}
+
 func (m *cacheImap) Items() map[string]*libcache.MapItem {
 	return m.Map.Items()
 }
 
 func (m *cacheImap) Get(key string) (*libcache.MapItem, bool) {
-	return m.Map.Get(strings.ToUpper(key))
+	var buf [cacheImapKeySize]byte
+	var item *libcache.MapItem
+	var ok bool
+	if k := foldKey(buf[:], key); nil != k {
+		item, ok = m.Map.Items()[string(k)]
+	} else {
+		item, ok = m.Map.Items()[strings.ToUpper(key)]
+	}
+	if !ok {
+		return nil, false
+	}
+	// touch the item as libcache.Map.Get does
+	if !item.IsEmpty() {
+		item.Remove()
+		item.InsertTail(m.list)
+	}
+	return item, true
 }
 
 func (m *cacheImap) Set(key string, newitem *libcache.MapItem, expirable bool) {
-	m.Map.Set(strings.ToUpper(key), newitem, expirable)
+	m.Map.Set(foldString(key), newitem, expirable)
 }
 
 func (m *cacheImap) Delete(key string) {
-	m.Map.Delete(strings.ToUpper(key))
+	m.Map.Delete(foldString(key))
 }
 
 type cache struct {
-	Value   interface{}
-	lock    sync.Locker
-	lrulist libcache.MapItem
-	ttl     time.Duration
-	stopC   chan bool
-	stopW   *sync.WaitGroup
+	Value    interface{}
+	lock     sync.Locker
+	lrulist  libcache.MapItem
+	ttl      time.Duration
+	tick     time.Duration
+	jitter   float64
+	maxmem   int64
+	maxdisk  int64
+	softmem  int64
+	hardmem  int64
+	lfu      bool
+	evicting bool
+	heap     int64
+	heapTime time.Time
+	stopC    chan bool
+	stopW    *sync.WaitGroup
 }
 
 type cacheItem struct {
 	libcache.MapItem
 	lastUsedTime time.Time
 	inUse        int64
+	hits         int64
+	ttl          time.Duration // overrides cache ttl if not 0
+	pinned       bool          // never expires or gets evicted
 }
 
 type expirable interface {
 	expire(c *cache, currentTime time.Time) bool
 }
 
+type invalidator interface {
+	invalidate()
+}
+
+type discarder interface {
+	discard()
+}
+
+type sizable interface {
+	cacheSize() (memsize int64, disksize int64)
+}
+
+type countable interface {
+	cacheHits() int64
+}
+
 func newCache(lock sync.Locker) *cache {
 	c := &cache{}
 	c.lock = lock
@@ -81,19 +170,55 @@ func (c *cache) newCacheImap() *cacheImap {
 	return NewCacheImap(&c.lrulist)
 }
 
+func (c *cache) itemTtl(citem *cacheItem) time.Duration {
+	if 0 != citem.ttl {
+		return citem.ttl
+	}
+	return c.ttl
+}
+
+// jitteredTtl returns the time to live of an item extended by a random amount of up
+// to the jitter fraction of it, so that items populated together do not all expire
+// (and get refetched) together.
+func (c *cache) jitteredTtl(citem *cacheItem) time.Duration {
+	ttl := c.itemTtl(citem)
+	if 0 < c.jitter && 0 < ttl {
+		if n := int64(float64(ttl) * c.jitter); 0 < n {
+			ttl += time.Duration(rand.Int63n(n + 1))
+		}
+	}
+	return ttl
+}
+
 func (c *cache) touchCacheItem(citem *cacheItem, delta int) {
-	citem.lastUsedTime = time.Now().Add(c.ttl)
+	citem.lastUsedTime = time.Now().Add(c.jitteredTtl(citem))
 	citem.inUse += int64(delta)
+	if 0 < delta {
+		citem.hits++
+	}
 }
 
+func (citem *cacheItem) cacheHits() int64 {
+	return citem.hits
+}
+
+// expireCacheItem expires an item whose time to live has elapsed. With the LFU policy
+// an item that has been used repeatedly is given another time to live instead and its
+// use count is halved, so that an item survives as many extra times to live as the
+// binary logarithm of its use count.
 func (c *cache) expireCacheItem(citem *cacheItem, currentTime time.Time, fn func()) bool {
-	if citem.lastUsedTime.After(currentTime) {
+	if !c.evicting && citem.lastUsedTime.After(currentTime) {
 		return false
 	}
-	citem.lastUsedTime = currentTime.Add(c.ttl)
+	citem.lastUsedTime = currentTime.Add(c.jitteredTtl(citem))
 	citem.Remove()
 	citem.InsertTail(&c.lrulist)
-	if 0 >= citem.inUse {
+	if c.lfu && !c.evicting && 1 < citem.hits {
+		citem.hits >>= 1
+		return true
+	}
+	if 0 >= citem.inUse && !citem.pinned {
+		citem.hits = 0
 		fn()
 	}
 	return true
@@ -104,7 +229,24 @@ func (c *cache) startExpiration(timeToLive time.Duration) {
 	c.stopC = make(chan bool, 1)
 	c.stopW = &sync.WaitGroup{}
 	c.stopW.Add(1)
-	go c._tick()
+	go c._tick(expirationTick(c.tick, timeToLive))
+}
+
+// expirationTick returns the interval at which the cache is scanned for expired
+// items. Unless configured, it is derived from the time to live, so that items
+// expire at most 10% later than their time to live (but no more often than once
+// a second and no less often than every 10 seconds).
+func expirationTick(tick time.Duration, timeToLive time.Duration) time.Duration {
+	if 0 < tick {
+		return tick
+	}
+	tick = timeToLive / 10
+	if time.Second > tick {
+		tick = time.Second
+	} else if 10*time.Second < tick {
+		tick = 10 * time.Second
+	}
+	return tick
 }
 
 func (c *cache) stopExpiration() {
@@ -116,21 +258,223 @@ func (c *cache) stopExpiration() {
 	c.stopW = nil
 }
 
-func (c *cache) _tick() {
+func (c *cache) _tick(tick time.Duration) {
 	defer c.stopW.Done()
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(tick)
 	for {
 		select {
 		case <-ticker.C:
 			currentTime := time.Now()
 			c.lock.Lock()
+			if c.lrulist.IsEmpty() {
+				c.lock.Unlock()
+				continue
+			}
 			c.lrulist.Expire(func(l, item *libcache.MapItem) bool {
 				return item.Value.(expirable).expire(c, currentTime)
 			})
+			pressure := c.evictCacheItems(currentTime)
 			c.lock.Unlock()
+			releaseMemory(pressure)
 		case <-c.stopC:
 			ticker.Stop()
 			return
 		}
 	}
 }
+
+func (c *cache) cacheSize() (memsize int64, disksize int64) {
+	c.lrulist.Iterate(func(l, item *libcache.MapItem) bool {
+		if s, ok := item.Value.(sizable); ok {
+			m, d := s.cacheSize()
+			memsize += m
+			disksize += d
+		}
+		return true
+	})
+	return
+}
+
+// cacheItems returns the number of items in the cache.
+func (c *cache) cacheItems() (n int) {
+	c.lrulist.Iterate(func(l, item *libcache.MapItem) bool {
+		n++
+		return true
+	})
+	return
+}
+
+// readHeapSize returns the number of bytes currently allocated on the heap.
+var readHeapSize = func() int64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapAlloc)
+}
+
+// heapReadInterval is the minimum interval between reads of the heap size, because
+// runtime.ReadMemStats stops the world.
+const heapReadInterval = 10 * time.Second
+
+// heapSize returns the heap size as read at most heapReadInterval ago.
+func (c *cache) heapSize(currentTime time.Time) int64 {
+	if c.heapTime.IsZero() || !currentTime.Before(c.heapTime.Add(heapReadInterval)) {
+		c.heap = readHeapSize()
+		c.heapTime = currentTime
+	}
+	return c.heap
+}
+
+// releaseMemory collects garbage after items have been evicted under memory pressure
+// (see evictCacheItems). It must be called without holding the cache lock.
+func releaseMemory(pressure int) {
+	switch pressure {
+	case 1:
+		runtime.GC()
+	case 2:
+		debug.FreeOSMemory()
+	}
+}
+
+// evictCacheItems evicts least recently used items (regardless of their time to live)
+// until the cache is within its memory and disk budgets. Items that are in use cannot
+// be evicted and are skipped.
+//
+// In addition to the memory budget (which is based on estimates of cached trees) the
+// process heap is monitored when soft or hard memory limits are set. When the heap
+// exceeds the soft limit, enough items are evicted to release the excess; when it
+// exceeds the hard limit, all items that are not in use are evicted. The returned
+// pressure (1 over the soft limit, 2 over the hard limit) is then passed to
+// releaseMemory to collect the evicted items and return the memory to the operating
+// system (under the hard limit).
+func (c *cache) evictCacheItems(currentTime time.Time) (pressure int) {
+	if 0 >= c.maxmem && 0 >= c.maxdisk && 0 >= c.softmem && 0 >= c.hardmem {
+		return 0
+	}
+
+	memsize, disksize := c.cacheSize()
+	maxmem := c.maxmem
+	if 0 >= maxmem {
+		maxmem = math.MaxInt64
+	}
+	if 0 < c.softmem || 0 < c.hardmem {
+		heap := c.heapSize(currentTime)
+		if 0 < c.hardmem && heap > c.hardmem {
+			maxmem = 0
+			pressure = 2
+		} else if 0 < c.softmem && heap > c.softmem {
+			if m := memsize - (heap - c.softmem); m < maxmem {
+				maxmem = m
+			}
+			pressure = 1
+		}
+	}
+	over := func() bool {
+		return memsize > maxmem || (0 < c.maxdisk && disksize > c.maxdisk)
+	}
+	if !over() {
+		return 0
+	}
+
+	evict := func(item *libcache.MapItem) bool {
+		s, ok := item.Value.(sizable)
+		if ok {
+			m, d := s.cacheSize()
+			memsize -= m
+			disksize -= d
+		}
+		item.Value.(expirable).expire(c, currentTime)
+		if ok {
+			m, d := s.cacheSize()
+			memsize += m
+			disksize += d
+		}
+		return over()
+	}
+
+	c.evicting = true
+	if c.lfu {
+		// evict least frequently used items first; least recently used among equals
+		items := []*libcache.MapItem{}
+		c.lrulist.Iterate(func(l, item *libcache.MapItem) bool {
+			items = append(items, item)
+			return true
+		})
+		hits := func(item *libcache.MapItem) int64 {
+			if h, ok := item.Value.(countable); ok {
+				return h.cacheHits()
+			}
+			return 0
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return hits(items[i]) < hits(items[j])
+		})
+		for _, item := range items {
+			if !evict(item) {
+				break
+			}
+		}
+	} else {
+		visited := make(map[*libcache.MapItem]bool)
+		c.lrulist.Expire(func(l, item *libcache.MapItem) bool {
+			if visited[item] {
+				return false
+			}
+			visited[item] = true
+			return evict(item)
+		})
+	}
+	c.evicting = false
+
+	if 0 != pressure {
+		// the heap shrinks once the evicted items are collected; read it again
+		c.heapTime = time.Time{}
+	}
+	return pressure
+}
+
+// negativeCache remembers names that were not found, so that repeated lookups of
+// nonexistent names (e.g. by shell completion or build tools) do not each contact
+// the provider. Names are case-insensitive. The negativeCache is not thread-safe.
+type negativeCache map[string]time.Time
+
+// negativeCacheMaxLen is the size above which expired entries are purged.
+const negativeCacheMaxLen = 1024
+
+func (m *negativeCache) get(name string, currentTime time.Time) bool {
+	var buf [cacheImapKeySize]byte
+	var t time.Time
+	var ok bool
+	k := foldKey(buf[:], name)
+	if nil != k {
+		t, ok = (*m)[string(k)]
+	} else {
+		t, ok = (*m)[strings.ToUpper(name)]
+	}
+	if !ok {
+		return false
+	}
+	if !currentTime.Before(t) {
+		delete(*m, foldString(name))
+		return false
+	}
+	return true
+}
+
+func (m *negativeCache) set(name string, expireTime time.Time) {
+	if nil == *m {
+		*m = make(negativeCache)
+	}
+	if negativeCacheMaxLen <= len(*m) {
+		currentTime := time.Now()
+		for k, t := range *m {
+			if !currentTime.Before(t) {
+				delete(*m, k)
+			}
+		}
+	}
+	(*m)[foldString(name)] = expireTime
+}
+
+func (m *negativeCache) remove(name string) {
+	delete(*m, foldString(name))
+}
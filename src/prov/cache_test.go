@@ -0,0 +1,307 @@
+/*
+ * cache_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	libcache "github.com/billziss-gh/golib/cache"
+)
+
+func TestNegativeCache(t *testing.T) {
+	var m negativeCache
+
+	now := time.Now()
+	if m.get("name", now) {
+		t.Error()
+	}
+
+	m.set("Name", now.Add(time.Second))
+	if !m.get("NAME", now) {
+		t.Error()
+	}
+	if m.get("NAME", now.Add(time.Second)) {
+		t.Error()
+	}
+	if 0 != len(m) {
+		t.Error()
+	}
+
+	m.set("Ωmega", now.Add(time.Second))
+	if !m.get("ωMEGA", now) {
+		t.Error()
+	}
+	m.remove("ΩMEGA")
+	if 0 != len(m) {
+		t.Error()
+	}
+
+	m.set("Name", now.Add(time.Second))
+	if n := testing.AllocsPerRun(100, func() { m.get("name", now) }); 0 != n {
+		t.Error(n)
+	}
+}
+
+func TestCacheImap(t *testing.T) {
+	c := newCache(&sync.Mutex{})
+	m := c.newCacheImap()
+
+	items := make([]*testCacheItem, 3)
+	for i := range items {
+		items[i] = &testCacheItem{}
+		items[i].Value = items[i]
+	}
+	m.Set("Owner", &items[0].MapItem, true)
+	m.Set("Ωmega", &items[1].MapItem, true)
+	m.Set("Other", &items[2].MapItem, false)
+
+	if item, ok := m.Get("OWNER"); !ok || &items[0].MapItem != item {
+		t.Error()
+	}
+	if item, ok := m.Get("ωMEGA"); !ok || &items[1].MapItem != item {
+		t.Error()
+	}
+	if item, ok := m.Get("other"); !ok || &items[2].MapItem != item {
+		t.Error()
+	}
+	if _, ok := m.Get("none"); ok {
+		t.Error()
+	}
+
+	// Get moves expirable items to the end of the LRU list
+	last := (*libcache.MapItem)(nil)
+	c.lrulist.Iterate(func(l, item *libcache.MapItem) bool {
+		last = item
+		return true
+	})
+	if &items[1].MapItem != last {
+		t.Error()
+	}
+	m.Get("owner")
+	c.lrulist.Iterate(func(l, item *libcache.MapItem) bool {
+		last = item
+		return true
+	})
+	if &items[0].MapItem != last {
+		t.Error()
+	}
+
+	if n := testing.AllocsPerRun(100, func() { m.Get("owner") }); 0 != n {
+		t.Error(n)
+	}
+
+	m.Delete("OWNER")
+	if _, ok := m.Get("owner"); ok {
+		t.Error()
+	}
+}
+
+type testCacheItem struct {
+	cacheItem
+	memsize int64
+}
+
+func (i *testCacheItem) expire(c *cache, currentTime time.Time) bool {
+	return c.expireCacheItem(&i.cacheItem, currentTime, func() {
+		i.memsize = 0
+	})
+}
+
+func (i *testCacheItem) cacheSize() (int64, int64) {
+	return i.memsize, 0
+}
+
+func TestCacheEviction(t *testing.T) {
+	c := newCache(&sync.Mutex{})
+	c.ttl = time.Hour
+	m := c.newCacheMap()
+
+	items := make([]*testCacheItem, 4)
+	for i := range items {
+		items[i] = &testCacheItem{memsize: 100}
+		items[i].Value = items[i]
+		m.Set(strconv.Itoa(i), &items[i].MapItem, true)
+		c.touchCacheItem(&items[i].cacheItem, 0)
+	}
+	items[0].inUse = 1
+
+	now := time.Now()
+	c.evictCacheItems(now)
+	if mem, _ := c.cacheSize(); 400 != mem {
+		t.Error(mem)
+	}
+
+	c.maxmem = 250
+	c.evictCacheItems(now)
+	if mem, _ := c.cacheSize(); 200 != mem {
+		t.Error(mem)
+	}
+	if 100 != items[0].memsize || 0 != items[1].memsize || 0 != items[2].memsize ||
+		100 != items[3].memsize {
+		t.Error()
+	}
+
+	c.maxmem = 50
+	c.evictCacheItems(now)
+	if mem, _ := c.cacheSize(); 100 != mem {
+		t.Error(mem)
+	}
+}
+
+func TestCacheEvictionLfu(t *testing.T) {
+	c := newCache(&sync.Mutex{})
+	c.ttl = time.Hour
+	c.lfu = true
+	m := c.newCacheMap()
+
+	items := make([]*testCacheItem, 4)
+	for i := range items {
+		items[i] = &testCacheItem{memsize: 100}
+		items[i].Value = items[i]
+		m.Set(strconv.Itoa(i), &items[i].MapItem, true)
+		c.touchCacheItem(&items[i].cacheItem, 0)
+	}
+	items[0].hits = 5
+	items[2].hits = 3
+	items[3].hits = 1
+
+	now := time.Now()
+	c.maxmem = 250
+	c.evictCacheItems(now)
+	if 100 != items[0].memsize || 0 != items[1].memsize || 100 != items[2].memsize ||
+		0 != items[3].memsize {
+		t.Error()
+	}
+
+	// expiration gives frequently used items another time to live
+	c.maxmem = 0
+	later := now.Add(2 * time.Hour)
+	c.lrulist.Expire(func(l, item *libcache.MapItem) bool {
+		return item.Value.(expirable).expire(c, later)
+	})
+	if 100 != items[0].memsize || 2 != items[0].hits ||
+		100 != items[2].memsize || 1 != items[2].hits {
+		t.Error()
+	}
+	later = later.Add(2 * time.Hour)
+	c.lrulist.Expire(func(l, item *libcache.MapItem) bool {
+		return item.Value.(expirable).expire(c, later)
+	})
+	if 100 != items[0].memsize || 1 != items[0].hits || 0 != items[2].memsize {
+		t.Error()
+	}
+}
+
+func TestCacheMemoryPressure(t *testing.T) {
+	heap := int64(0)
+	saved := readHeapSize
+	readHeapSize = func() int64 { return heap }
+	defer func() { readHeapSize = saved }()
+
+	c := newCache(&sync.Mutex{})
+	c.ttl = time.Hour
+	c.softmem = 1000
+	c.hardmem = 2000
+	m := c.newCacheMap()
+
+	items := make([]*testCacheItem, 4)
+	for i := range items {
+		items[i] = &testCacheItem{memsize: 100}
+		items[i].Value = items[i]
+		m.Set(strconv.Itoa(i), &items[i].MapItem, true)
+		c.touchCacheItem(&items[i].cacheItem, 0)
+	}
+	items[3].inUse = 1
+
+	now := time.Now()
+	heap = 900
+	c.evictCacheItems(now)
+	if mem, _ := c.cacheSize(); 400 != mem {
+		t.Error(mem)
+	}
+
+	// the heap size is not read again until heapReadInterval has elapsed
+	heap = 1150
+	if 0 != c.evictCacheItems(now) {
+		t.Error()
+	}
+	if mem, _ := c.cacheSize(); 400 != mem {
+		t.Error(mem)
+	}
+
+	now = now.Add(heapReadInterval)
+	if 1 != c.evictCacheItems(now) {
+		t.Error()
+	}
+	if mem, _ := c.cacheSize(); 200 != mem {
+		t.Error(mem)
+	}
+	if 0 != items[0].memsize || 0 != items[1].memsize {
+		t.Error()
+	}
+
+	heap = 2500
+	if 2 != c.evictCacheItems(now) {
+		t.Error()
+	}
+	if mem, _ := c.cacheSize(); 100 != mem {
+		t.Error(mem)
+	}
+	if 100 != items[3].memsize {
+		t.Error()
+	}
+}
+
+func TestExpirationTick(t *testing.T) {
+	if time.Second != expirationTick(0, 5*time.Second) {
+		t.Error()
+	}
+	if 3*time.Second != expirationTick(0, 30*time.Second) {
+		t.Error()
+	}
+	if 10*time.Second != expirationTick(0, time.Hour) {
+		t.Error()
+	}
+	if time.Minute != expirationTick(time.Minute, 30*time.Second) {
+		t.Error()
+	}
+}
+
+func TestJitteredTtl(t *testing.T) {
+	c := newCache(&sync.Mutex{})
+	c.ttl = time.Minute
+	citem := &cacheItem{}
+
+	if time.Minute != c.jitteredTtl(citem) {
+		t.Error()
+	}
+
+	c.jitter = 0.5
+	differ := false
+	for i := 0; 100 > i; i++ {
+		ttl := c.jitteredTtl(citem)
+		if time.Minute > ttl || 90*time.Second < ttl {
+			t.Error(ttl)
+		}
+		if time.Minute != ttl {
+			differ = true
+		}
+	}
+	if !differ {
+		t.Error()
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * cachestats.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheStats are the cache lookups (of refs, trees and files) of a repository and
+// its use of the provider as reported by GetCacheStats. Hits are served from the
+// cache, Stale lookups are served from the cache after the time to live of their
+// refs expired (and are revalidated) and Misses are fetched from the remote. The
+// HitRatio is the fraction of lookups that were hits. Requests is the number of
+// requests to the provider (or git remote) and Bytes the number of bytes of the
+// objects downloaded from it. Requests about an owner rather than one of its
+// repositories (e.g. to list its repositories) are counted with an empty
+// Repository.
+type CacheStats struct {
+	Owner      string  `json:"owner"`
+	Repository string  `json:"repository"`
+	Hits       uint64  `json:"hits"`
+	Stale      uint64  `json:"stale"`
+	Misses     uint64  `json:"misses"`
+	HitRatio   float64 `json:"hitratio"`
+	Requests   uint64  `json:"requests"`
+	Bytes      uint64  `json:"bytes"`
+}
+
+// cacheCounters counts the cache lookups and the requests and downloaded bytes of a
+// repository. A nil cacheCounters discards counts.
+type cacheCounters struct {
+	hits     uint64
+	stale    uint64
+	misses   uint64
+	requests uint64
+	bytes    uint64
+}
+
+func (s *cacheCounters) hit() {
+	if nil != s {
+		atomic.AddUint64(&s.hits, 1)
+	}
+}
+
+func (s *cacheCounters) staleHit() {
+	if nil != s {
+		atomic.AddUint64(&s.stale, 1)
+	}
+}
+
+func (s *cacheCounters) miss() {
+	if nil != s {
+		atomic.AddUint64(&s.misses, 1)
+	}
+}
+
+func (s *cacheCounters) request() {
+	if nil != s {
+		atomic.AddUint64(&s.requests, 1)
+	}
+}
+
+func (s *cacheCounters) download(n int) {
+	if nil != s {
+		atomic.AddUint64(&s.bytes, uint64(n))
+	}
+}
+
+// cacheStatsMap keeps the cache counters of the repositories of a client by
+// owner/repo, so that they survive the eviction of the repositories.
+type cacheStatsMap struct {
+	lock sync.Mutex
+	m    map[[2]string]*cacheCounters
+}
+
+// counters returns the cache counters of a repository.
+func (m *cacheStatsMap) counters(owner string, repository string) *cacheCounters {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if nil == m.m {
+		m.m = make(map[[2]string]*cacheCounters)
+	}
+	k := [2]string{owner, repository}
+	s, ok := m.m[k]
+	if !ok {
+		s = &cacheCounters{}
+		m.m[k] = s
+	}
+	return s
+}
+
+// list returns the cache statistics of the repositories sorted by owner/repo.
+func (m *cacheStatsMap) list() []CacheStats {
+	m.lock.Lock()
+	res := make([]CacheStats, 0, len(m.m))
+	for k, s := range m.m {
+		e := CacheStats{
+			Owner:      k[0],
+			Repository: k[1],
+			Hits:       atomic.LoadUint64(&s.hits),
+			Stale:      atomic.LoadUint64(&s.stale),
+			Misses:     atomic.LoadUint64(&s.misses),
+			Requests:   atomic.LoadUint64(&s.requests),
+			Bytes:      atomic.LoadUint64(&s.bytes),
+		}
+		if n := e.Hits + e.Stale + e.Misses; 0 != n {
+			e.HitRatio = float64(e.Hits) / float64(n)
+		}
+		res = append(res, e)
+	}
+	m.lock.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Owner != res[j].Owner {
+			return res[i].Owner < res[j].Owner
+		}
+		return res[i].Repository < res[j].Repository
+	})
+	return res
+}
+
+// GetCacheStats returns the cache statistics of the repositories (and owners) that
+// have been accessed by the client.
+func (c *client) GetCacheStats() []CacheStats {
+	return c.cachestats.list()
+}
@@ -0,0 +1,55 @@
+/*
+ * cachestats_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"testing"
+)
+
+func TestCacheStats(t *testing.T) {
+	var m cacheStatsMap
+	if 0 != len(m.list()) {
+		t.Error()
+	}
+
+	var nilstats *cacheCounters
+	nilstats.hit()
+	nilstats.miss()
+
+	s := m.counters("owner", "repo")
+	if s != m.counters("owner", "repo") {
+		t.Error()
+	}
+	s.hit()
+	s.hit()
+	s.hit()
+	s.staleHit()
+	s.miss()
+	s.request()
+	s.request()
+	s.download(100)
+	s.download(23)
+	m.counters("another", "repo").miss()
+	nilstats.request()
+	nilstats.download(1)
+
+	lst := m.list()
+	if 2 != len(lst) ||
+		"another" != lst[0].Owner || 1 != lst[0].Misses || 0 != lst[0].HitRatio ||
+		"owner" != lst[1].Owner || "repo" != lst[1].Repository ||
+		3 != lst[1].Hits || 1 != lst[1].Stale || 1 != lst[1].Misses || 0.6 != lst[1].HitRatio ||
+		2 != lst[1].Requests || 123 != lst[1].Bytes || 0 != lst[0].Requests {
+		t.Error(lst)
+	}
+}
@@ -14,31 +14,64 @@
 package prov
 
 import (
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/billziss-gh/golib/appdata"
+	libcache "github.com/billziss-gh/golib/cache"
+	"github.com/winfsp/hubfs/git"
 )
 
 type client struct {
-	api      clientApi
-	dir      string
-	keepdir  bool
-	caseins  bool
-	fullrefs bool
-	ttl      time.Duration
-	lock     sync.Mutex
-	cache    *cache
-	owners   *cacheImap
-	filter   *filterType
+	api        clientApi
+	dir        string
+	objdir     string
+	keepdir    bool
+	caseins    bool
+	fullrefs   bool
+	offline    bool
+	compress   bool
+	noverify   bool
+	fetchers   int
+	walkers    int
+	treedepth  int
+	aead       cipher.AEAD
+	ttl        time.Duration
+	metattl    time.Duration
+	negttl     time.Duration
+	refsttl    time.Duration
+	retainage  time.Duration
+	retainsize int64
+	ttls       map[string]time.Duration
+	pins       []string
+	lock       sync.Mutex
+	cache      *cache
+	owners     *cacheImap
+	searches   *libcache.Map
+	filter     *filterType
+	meta       *metadata
+	negative   negativeCache
+	refreshing map[string]bool
+	flights    flightGroup
+	errlog     *errorLog
+	cachestats cacheStatsMap
+	diskStopC  chan struct{}
+	diskStopW  sync.WaitGroup
 }
 
 type owner struct {
 	cacheItem
 	repositories *cacheImap
+	discarded    bool // repositories are fetched again on next access
 	FName        string
 	FKind        string
 }
@@ -51,17 +84,45 @@ type repository struct {
 	FRemote string
 }
 
+type search struct {
+	cacheItem
+	results []SearchResult
+	FQuery  string
+}
+
+type searchResult struct {
+	FOwner      string
+	FRepository string
+	FRef        string
+	FPath       string
+}
+
 type clientApi interface {
 	getIdent() string
 	getGitCredentials() (string, string)
 	getOwner(owner string) (res *owner, err error)
 	getRepositories(owner string, kind string) (res []*repository, err error)
+	searchCode(query string) (res []*searchResult, err error)
+}
+
+// defaultTtl is the time to live of cached items unless configured (config.ttl).
+const defaultTtl = 30 * time.Second
+
+// backgroundLimiter is implemented by a clientApi that limits background requests
+// (e.g. to preserve its rate limit budget for interactive requests).
+type backgroundLimiter interface {
+	allowBackground() bool
 }
 
 func (c *client) init(api clientApi) {
 	c.api = api
 	c.cache = newCache(&c.lock)
 	c.cache.Value = c
+	c.metattl = 24 * time.Hour
+	c.negttl = 10 * time.Second
+	c.fetchers = 4
+	c.walkers = DefaultWalkers
+	c.errlog = &errorLog{provider: api.getIdent()}
 }
 
 func configValue(s string, k string, v *string) bool {
@@ -88,13 +149,123 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 					}
 				}
 			} else {
+				if e := checkCacheLayout(v); nil != e {
+					return nil, e
+				}
 				c.dir = v
 				c.keepdir = true
 			}
+		case configValue(s, "config.objdir=", &v):
+			if "" != v {
+				if e := checkCacheLayout(v); nil != e {
+					return nil, e
+				}
+			}
+			c.objdir = v
+		case configValue(s, "config.compress=", &v):
+			if "1" == v {
+				c.compress = true
+			} else {
+				c.compress = false
+			}
+		case configValue(s, "config.verify=", &v):
+			if "0" == v {
+				c.noverify = true
+			} else {
+				c.noverify = false
+			}
+		case configValue(s, "config.fetchers=", &v):
+			if n, e := strconv.Atoi(v); nil == e && 0 < n {
+				c.fetchers = n
+			}
+		case configValue(s, "config.walkers=", &v):
+			if n, e := strconv.Atoi(v); nil == e && 0 < n {
+				c.walkers = n
+			}
+		case configValue(s, "config.treedepth=", &v):
+			if n, e := strconv.Atoi(v); nil == e && 0 <= n {
+				c.treedepth = n
+			}
 		case configValue(s, "config.ttl=", &v):
 			if ttl, e := time.ParseDuration(v); nil == e && 0 < ttl {
 				c.ttl = ttl
 			}
+		case configValue(s, "config.tick=", &v):
+			if tick, e := time.ParseDuration(v); nil == e && 0 < tick {
+				c.cache.tick = tick
+			}
+		case configValue(s, "config.ttl.jitter=", &v):
+			// fraction of the time to live (e.g. 0.1 or 10%)
+			pct := strings.HasSuffix(v, "%")
+			if f, e := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); nil == e && 0 <= f {
+				if pct {
+					f /= 100
+				}
+				c.cache.jitter = f
+			}
+		case configValue(s, "config.ttl.refs=", &v):
+			if ttl, e := time.ParseDuration(v); nil == e && 0 <= ttl {
+				c.refsttl = ttl
+			}
+		case configValue(s, "config.ttl./", &v):
+			// config.ttl./owner[/repo]=DURATION
+			if i := strings.LastIndexByte(v, '='); -1 != i {
+				if ttl, e := time.ParseDuration(v[i+1:]); nil == e && 0 < ttl {
+					if nil == c.ttls {
+						c.ttls = make(map[string]time.Duration)
+					}
+					c.ttls[strings.ToUpper(strings.Trim(v[:i], "/"))] = ttl
+				}
+			}
+		case configValue(s, "config.negttl=", &v):
+			if ttl, e := time.ParseDuration(v); nil == e && 0 <= ttl {
+				c.negttl = ttl
+			}
+		case configValue(s, "config.maxmem=", &v):
+			size, e := parseSize(v)
+			if nil != e {
+				return nil, errors.New("invalid size: " + s)
+			}
+			c.cache.maxmem = size
+		case configValue(s, "config.maxdisk=", &v):
+			size, e := parseSize(v)
+			if nil != e {
+				return nil, errors.New("invalid size: " + s)
+			}
+			c.cache.maxdisk = size
+		case configValue(s, "config.evict=", &v):
+			switch strings.ToLower(v) {
+			case "lru":
+				c.cache.lfu = false
+			case "lfu":
+				c.cache.lfu = true
+			}
+		case configValue(s, "config.softmem=", &v):
+			size, e := parseSize(v)
+			if nil != e {
+				return nil, errors.New("invalid size: " + s)
+			}
+			c.cache.softmem = size
+		case configValue(s, "config.hardmem=", &v):
+			size, e := parseSize(v)
+			if nil != e {
+				return nil, errors.New("invalid size: " + s)
+			}
+			c.cache.hardmem = size
+		case configValue(s, "config.retain.age=", &v):
+			if age, e := time.ParseDuration(v); nil == e && 0 <= age {
+				c.retainage = age
+			}
+		case configValue(s, "config.retain.size=", &v):
+			size, e := parseSize(v)
+			if nil != e {
+				return nil, errors.New("invalid size: " + s)
+			}
+			c.retainsize = size
+		case configValue(s, "config.metattl=", &v):
+			if ttl, e := time.ParseDuration(v); nil == e && 0 < ttl {
+				c.metattl = ttl
+			}
 		case configValue(s, "config._caseins=", &v):
 			if "1" == v {
 				c.caseins = true
@@ -107,6 +278,25 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 			} else {
 				c.fullrefs = false
 			}
+		case configValue(s, "config._offline=", &v):
+			if "1" == v {
+				c.offline = true
+			} else {
+				c.offline = false
+			}
+		case configValue(s, "config._cachekey=", &v):
+			key, err := hex.DecodeString(v)
+			if nil == err {
+				c.aead, err = newCacheCipher(key)
+			}
+			if nil != err {
+				return nil, err
+			}
+		case configValue(s, "config._pin=", &v):
+			v = strings.Trim(v, "/")
+			if "" != v {
+				c.pins = append(c.pins, strings.ToUpper(v))
+			}
 		case configValue(s, "config._filter=", &v):
 			if nil == c.filter {
 				c.filter = &filterType{}
@@ -120,6 +310,140 @@ func (c *client) SetConfig(config []string) ([]string, error) {
 	return res, nil
 }
 
+// IsRuntimeConfig determines if a setting can be changed while the client is in use
+// (see Reconfigure).
+func IsRuntimeConfig(s string) bool {
+	for _, k := range []string{
+		"config.ttl=", "config.ttl.", "config.negttl=", "config.metattl=", "config._filter=",
+	} {
+		if strings.HasPrefix(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconfigure applies the settings of a configuration that can be changed while the
+// client is in use (see IsRuntimeConfig): the times to live and the filter. Settings
+// that are absent revert to their defaults and the filter rules replace the current
+// ones. Other settings are ignored. Repositories that are already open keep their
+// times to live of refs and nonexistent names.
+func (c *client) Reconfigure(config []string) {
+	settings := []string{}
+	for _, s := range config {
+		if IsRuntimeConfig(s) {
+			settings = append(settings, s)
+		}
+	}
+	n := &client{}
+	n.init(c.api)
+	n.SetConfig(settings)
+
+	c.lock.Lock()
+	c.ttl = n.ttl
+	c.ttls = n.ttls
+	c.refsttl = n.refsttl
+	c.negttl = n.negttl
+	c.metattl = n.metattl
+	c.filter = n.filter
+	c.cache.jitter = n.cache.jitter
+	if 0 != c.cache.ttl {
+		c.cache.ttl = defaultTtl
+		if 0 != c.ttl {
+			c.cache.ttl = c.ttl
+		}
+	}
+	if nil != c.owners {
+		for _, item := range c.owners.Items() {
+			o := item.Value.(*owner)
+			o.ttl = c.ttlOverride(o.FName)
+			if nil != o.repositories {
+				for _, elm := range o.repositories.Items() {
+					r := elm.Value.(*repository)
+					r.ttl = c.ttlOverride(o.FName + "/" + r.FName)
+				}
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	tracef("%v", settings)
+}
+
+// parseSize parses a byte size with an optional K, M, G or T (binary) suffix.
+func parseSize(s string) (int64, error) {
+	mul := int64(1)
+	if 0 < len(s) {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			mul = 1 << 10
+		case 'm', 'M':
+			mul = 1 << 20
+		case 'g', 'G':
+			mul = 1 << 30
+		case 't', 'T':
+			mul = 1 << 40
+		}
+		if 1 != mul {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if nil != err {
+		return 0, err
+	}
+	if 0 > n || math.MaxInt64/mul < n {
+		return 0, strconv.ErrRange
+	}
+	return n * mul, nil
+}
+
+// filterMatch determines if a name (owner or owner/repo) passes the filter. It must
+// be called with the client lock held, because the filter can be replaced by
+// Reconfigure. Repositories are filtered when they are looked up (rather than when
+// they are listed by the provider), so that a new filter takes effect immediately.
+func (c *client) filterMatch(name string) bool {
+	return nil == c.filter || c.filter.match(name)
+}
+
+// ttlOverride returns the time to live configured for an owner or owner/repo
+// (the most specific one wins) or 0 if there is none.
+func (c *client) ttlOverride(name string) time.Duration {
+	name = strings.ToUpper(name)
+	for {
+		if ttl, ok := c.ttls[name]; ok {
+			return ttl
+		}
+		i := strings.LastIndexByte(name, '/')
+		if -1 == i {
+			return 0
+		}
+		name = name[:i]
+	}
+}
+
+// isPinned determines if a repository (owner/repo) or any of its refs is pinned.
+func (c *client) isPinned(name string) bool {
+	name = strings.ToUpper(name)
+	for _, p := range c.pins {
+		if p == name || strings.HasPrefix(p, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isNetworkError determines if an error is the result of the provider being
+// unreachable (e.g. no network connectivity or exhausted rate limit) rather than
+// an error response.
+func isNetworkError(err error) bool {
+	if errRateLimited == err {
+		return true
+	}
+	var e net.Error
+	return errors.As(err, &e)
+}
+
 func (c *client) GetDirectory() string {
 	c.lock.Lock()
 	dir := c.dir
@@ -135,11 +459,11 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 	var res *owner
 	var err error
 
-	if nil != c.filter && !c.filter.match(name) {
+	c.lock.Lock()
+	if !c.filterMatch(name) {
+		c.lock.Unlock()
 		return nil, ErrNotFound
 	}
-
-	c.lock.Lock()
 	if nil != c.owners {
 		item, ok := c.owners.Get(name)
 		if ok {
@@ -149,11 +473,50 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 			return res, nil
 		}
 	}
+	if c.negative.get(name, time.Now()) {
+		c.lock.Unlock()
+		return nil, ErrNotFound
+	}
 	c.lock.Unlock()
 
-	res, err = c.api.getOwner(name)
-	if nil != err {
-		return nil, err
+	res = c.getOwnerMetadata(name, c.offline)
+	if nil == res && !c.offline {
+		// serve stale metadata (if any) and revalidate it in the background
+		if res = c.getOwnerMetadata(name, true); nil != res {
+			c.refreshMetadata("/"+name, func() error {
+				o, err := c.api.getOwner(name)
+				if nil == err {
+					c.setOwnerMetadata(o)
+				}
+				return err
+			})
+		}
+	}
+	if nil == res {
+		if c.offline {
+			return nil, ErrNotFound
+		}
+		var v interface{}
+		start := c.errlog.begin("owner", "/"+name)
+		v, err = c.flights.do("/"+strings.ToUpper(name), func() (interface{}, error) {
+			c.cachestats.counters(name, "").request()
+			return c.api.getOwner(name)
+		})
+		res, _ = v.(*owner)
+		c.errlog.record("owner", "/"+name, start, err)
+		if ErrNotFound == err {
+			c.lock.Lock()
+			c.negative.set(name, time.Now().Add(c.negttl))
+			c.lock.Unlock()
+		}
+		if nil != err {
+			// serve stale metadata if the provider is unreachable
+			if res = c.getOwnerMetadata(name, true); nil == res || !isNetworkError(err) {
+				return nil, err
+			}
+		} else {
+			c.setOwnerMetadata(res)
+		}
 	}
 
 	c.lock.Lock()
@@ -164,6 +527,7 @@ func (c *client) OpenOwner(name string) (Owner, error) {
 	if ok {
 		res = item.Value.(*owner)
 	} else {
+		res.ttl = c.ttlOverride(res.FName)
 		c.owners.Set(name, &res.MapItem, true)
 	}
 	c.cache.touchCacheItem(&res.cacheItem, +1)
@@ -179,45 +543,138 @@ func (c *client) CloseOwner(O Owner) {
 
 func (c *client) ensureRepositories(o *owner, fn func() error) error {
 	c.lock.Lock()
-	if nil != o.repositories {
+	if nil != o.repositories && !o.discarded {
 		err := fn()
 		c.lock.Unlock()
 		return err
 	}
+	discarded := o.discarded && !c.offline
 	c.lock.Unlock()
 
-	repositories, err := c.api.getRepositories(o.FName, o.FKind)
-	if nil != err {
-		return err
+	var repositories []*repository
+	if !discarded {
+		repositories = c.getRepositoriesMetadata(o.FName, c.offline)
+	}
+	if nil == repositories && !c.offline && !discarded {
+		// serve stale metadata (if any) and revalidate it in the background
+		if repositories = c.getRepositoriesMetadata(o.FName, true); nil != repositories {
+			c.refreshMetadata("/"+o.FName+"/", func() error {
+				repositories, err := c.api.getRepositories(o.FName, o.FKind)
+				if nil == err {
+					c.setRepositoriesMetadata(o.FName, repositories)
+				}
+				return err
+			})
+		}
+	}
+	if nil == repositories {
+		if c.offline {
+			return ErrNotFound
+		}
+		start := c.errlog.begin("repositories", "/"+o.FName+"/")
+		v, err := c.flights.do("/"+strings.ToUpper(o.FName)+"/", func() (interface{}, error) {
+			c.cachestats.counters(o.FName, "").request()
+			return c.api.getRepositories(o.FName, o.FKind)
+		})
+		repositories, _ = v.([]*repository)
+		c.errlog.record("repositories", "/"+o.FName+"/", start, err)
+		if nil != err {
+			// serve stale metadata if the provider is unreachable
+			if repositories = c.getRepositoriesMetadata(o.FName, true); nil == repositories ||
+				!isNetworkError(err) {
+				if !discarded {
+					return err
+				}
+				// continue to serve the repositories that we have
+				repositories = nil
+			}
+		} else {
+			c.setRepositoriesMetadata(o.FName, repositories)
+		}
 	}
 
 	c.lock.Lock()
 	if nil == o.repositories {
 		o.repositories = c.cache.newCacheImap()
 		for _, elm := range repositories {
-			if nil != c.filter && !c.filter.match(o.FName+"/"+elm.FName) {
+			elm.ttl = c.ttlOverride(o.FName + "/" + elm.FName)
+			elm.pinned = c.isPinned(o.FName + "/" + elm.FName)
+			o.repositories.Set(elm.FName, &elm.MapItem, true)
+			c.cache.touchCacheItem(&elm.cacheItem, 0)
+		}
+	} else if discarded && nil != repositories {
+		// keep the repositories that are still there (and may be open); forget
+		// the ones that are gone unless they are open
+		names := make(map[string]bool, len(repositories))
+		for _, elm := range repositories {
+			names[strings.ToUpper(elm.FName)] = true
+			if _, ok := o.repositories.Get(elm.FName); ok {
 				continue
 			}
+			elm.ttl = c.ttlOverride(o.FName + "/" + elm.FName)
+			elm.pinned = c.isPinned(o.FName + "/" + elm.FName)
 			o.repositories.Set(elm.FName, &elm.MapItem, true)
 			c.cache.touchCacheItem(&elm.cacheItem, 0)
 		}
+		for k, item := range o.repositories.Items() {
+			if r := item.Value.(*repository); !names[k] && emptyRepository == r.Repository {
+				o.repositories.Delete(k)
+			}
+		}
 	}
-	err = fn()
+	o.discarded = false
+	err := fn()
 	c.lock.Unlock()
 	return err
 }
 
+// refreshMetadata runs fn in the background to revalidate stale metadata, unless
+// a revalidation for the same key is already in progress or the provider does not
+// currently allow background requests.
+func (c *client) refreshMetadata(key string, fn func() error) {
+	key = strings.ToUpper(key)
+
+	if l, ok := c.api.(backgroundLimiter); ok && !l.allowBackground() {
+		tracef("%s [refreshMetadata() deferred]", key)
+		return
+	}
+
+	c.lock.Lock()
+	if c.refreshing[key] {
+		c.lock.Unlock()
+		return
+	}
+	if nil == c.refreshing {
+		c.refreshing = make(map[string]bool)
+	}
+	c.refreshing[key] = true
+	c.lock.Unlock()
+
+	go func() {
+		start := c.errlog.begin("refresh", key)
+		err := fn()
+		c.errlog.record("refresh", key, start, err)
+
+		c.lock.Lock()
+		delete(c.refreshing, key)
+		c.lock.Unlock()
+
+		tracef("%s [refreshMetadata() = %v]", key, err)
+	}()
+}
+
 func (c *client) GetRepositories(O Owner) ([]Repository, error) {
 	var res []Repository
 	var err error
 
 	o := O.(*owner)
 	err = c.ensureRepositories(o, func() error {
-		res = make([]Repository, len(o.repositories.Items()))
-		i := 0
+		res = make([]Repository, 0, len(o.repositories.Items()))
 		for _, elm := range o.repositories.Items() {
-			res[i] = elm.Value.(Repository)
-			i++
+			r := elm.Value.(*repository)
+			if c.filterMatch(o.FName + "/" + r.FName) {
+				res = append(res, r)
+			}
 		}
 		return nil
 	})
@@ -236,9 +693,27 @@ func (c *client) OpenRepository(O Owner, name string) (Repository, error) {
 			return ErrNotFound
 		}
 		res = item.Value.(*repository)
+		if !c.filterMatch(o.FName + "/" + res.FName) {
+			return ErrNotFound
+		}
 		if emptyRepository == res.Repository {
 			u, p := c.api.getGitCredentials()
-			r := newGitRepository(res.FRemote, u, p, c.caseins, c.fullrefs)
+			r := newGitRepository(res.FRemote, u, p, gitConfig{
+				caseins:   c.caseins,
+				fullrefs:  c.fullrefs,
+				offline:   c.offline,
+				compress:  c.compress,
+				noverify:  c.noverify,
+				fetchers:  c.fetchers,
+				walkers:   c.walkers,
+				treedepth: c.treedepth,
+				aead:      c.aead,
+				objdir:    c.objdir,
+				negttl:    c.negttl,
+				refsttl:   c.refsttl,
+				errlog:    c.errlog,
+				stats:     c.cachestats.counters(o.FName, res.FName),
+			})
 			if "" != c.dir {
 				err = r.SetDirectory(filepath.Join(c.dir, o.FName, res.FName))
 				if nil != err {
@@ -263,16 +738,196 @@ func (c *client) CloseRepository(R Repository) {
 	c.lock.Unlock()
 }
 
+func (c *client) SearchCode(query string) ([]SearchResult, error) {
+	var res *search
+
+	c.lock.Lock()
+	if nil != c.searches {
+		item, ok := c.searches.Get(query)
+		if ok {
+			res = item.Value.(*search)
+			c.cache.touchCacheItem(&res.cacheItem, 0)
+			c.lock.Unlock()
+			return res.results, nil
+		}
+	}
+	c.lock.Unlock()
+
+	if c.offline {
+		return nil, ErrNotFound
+	}
+
+	lst, err := c.api.searchCode(query)
+	if nil != err {
+		return nil, err
+	}
+
+	res = &search{
+		results: make([]SearchResult, 0, len(lst)),
+		FQuery:  query,
+	}
+	res.Value = res
+	c.lock.Lock()
+	for _, elm := range lst {
+		if c.filterMatch(elm.FOwner + "/" + elm.FRepository) {
+			res.results = append(res.results, elm)
+		}
+	}
+	c.lock.Unlock()
+
+	c.lock.Lock()
+	if nil == c.searches {
+		c.searches = c.cache.newCacheMap()
+	}
+	item, ok := c.searches.Get(query)
+	if ok {
+		res = item.Value.(*search)
+	} else {
+		c.searches.Set(query, &res.MapItem, true)
+	}
+	c.cache.touchCacheItem(&res.cacheItem, 0)
+	c.lock.Unlock()
+	return res.results, nil
+}
+
+// ImportObjects imports the objects of the local repository at path into the
+// persistent object directory of repository owner/name (or the shared object
+// directory). It returns the number of objects imported.
+func (c *client) ImportObjects(path string, oname string, name string) (int, error) {
+	c.lock.Lock()
+	dir := c.objdir
+	if "" == dir && "" != c.dir && c.keepdir && "" != oname && "" != name {
+		dir = filepath.Join(c.dir, oname, name, "objects")
+	}
+	c.lock.Unlock()
+	if "" == dir {
+		return 0, errors.New("no persistent object directory")
+	}
+
+	count := 0
+	err := git.ReadLocalObjects(path, func(hash string, ot git.ObjectType, content []byte) error {
+		if _, err := statObject(dir, hash, c.aead); nil == err {
+			return nil
+		}
+		writeObject(dir, hash, content, c.compress, c.aead)
+		if _, err := statObject(dir, hash, c.aead); nil != err {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// InvalidateRepository discards the cached refs of a repository (if it is open),
+// so that they are refetched on next access.
+func (c *client) InvalidateRepository(oname string, name string) {
+	var r *repository
+
+	c.lock.Lock()
+	if nil != c.owners {
+		if item, ok := c.owners.Get(oname); ok {
+			o := item.Value.(*owner)
+			if nil != o.repositories {
+				if item, ok := o.repositories.Get(name); ok {
+					r = item.Value.(*repository)
+				}
+			}
+		}
+	}
+	if nil != r {
+		if i, ok := r.Repository.(invalidator); ok {
+			i.invalidate()
+		}
+	}
+	c.lock.Unlock()
+
+	tracef("%s/%s [found=%v]", oname, name, nil != r)
+}
+
+// InvalidatePath discards the cached information of a path (/owner/repo/ref/path)
+// and everything below it, so that the next access fetches it from the provider:
+// the refs of a repository (trees and blobs do not change and are kept); the
+// repositories of an owner and the refs of its open repositories; or for the root
+// path the repositories and refs of all owners. The names that were not found are
+// forgotten as well.
+func (c *client) InvalidatePath(path string) {
+	comp := strings.SplitN(strings.Trim(path, "/"), "/", 3)
+	oname, name := comp[0], ""
+	if 1 < len(comp) {
+		name = comp[1]
+	}
+
+	c.lock.Lock()
+	if "" == oname {
+		c.negative = nil
+	} else {
+		c.negative.remove(oname)
+	}
+	if nil != c.meta {
+		for k, m := range c.meta.Owners {
+			if "" == oname || strings.ToUpper(oname) == k {
+				if "" == name {
+					m.Time = time.Time{}
+				}
+				m.RepositoriesTime = time.Time{}
+			}
+		}
+	}
+	count := 0
+	if nil != c.owners {
+		for _, item := range c.owners.Items() {
+			o := item.Value.(*owner)
+			if "" != oname && !strings.EqualFold(oname, o.FName) {
+				continue
+			}
+			if nil == o.repositories {
+				continue
+			}
+			found := false
+			for _, item := range o.repositories.Items() {
+				r := item.Value.(*repository)
+				if "" != name && !strings.EqualFold(name, r.FName) {
+					continue
+				}
+				found = true
+				if d, ok := r.Repository.(discarder); ok {
+					d.discard()
+					count++
+				}
+			}
+			if "" == name || !found {
+				// also pick up repositories that have been created or deleted
+				o.discarded = true
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	tracef("%s [count=%d]", path, count)
+}
+
 func (c *client) StartExpiration() {
-	ttl := 30 * time.Second
+	ttl := defaultTtl
 	if 0 != c.ttl {
 		ttl = c.ttl
 	}
+	if (0 != c.retainage || 0 != c.retainsize) && ("" != c.objdir || c.keepdir) {
+		count, size, err := c.CollectGarbage()
+		tracef("[CollectGarbage() = %v, %v, %v]", count, size, err)
+	}
+	c.loadMetadata()
 	c.cache.startExpiration(ttl)
+	if 0 < c.cache.maxdisk && ("" != c.objdir || c.keepdir) {
+		c.startDiskBudget()
+	}
 }
 
 func (c *client) StopExpiration() {
+	c.stopDiskBudget()
 	c.cache.stopExpiration()
+	c.saveMetadata()
+	c.errlog.flush()
 
 	c.lock.Lock()
 	if "" == c.dir || c.keepdir {
@@ -321,6 +976,13 @@ func (r *repository) keep() bool {
 	return 0 != len(list)
 }
 
+func (r *repository) cacheSize() (memsize int64, disksize int64) {
+	if s, ok := r.Repository.(sizable); ok {
+		return s.cacheSize()
+	}
+	return 0, 0
+}
+
 func (r *repository) expire(c *cache, currentTime time.Time) bool {
 	return c.expireCacheItem(&r.cacheItem, currentTime, func() {
 		if emptyRepository == r.Repository {
@@ -338,6 +1000,31 @@ func (r *repository) expire(c *cache, currentTime time.Time) bool {
 	})
 }
 
+func (s *search) expire(c *cache, currentTime time.Time) bool {
+	return c.expireCacheItem(&s.cacheItem, currentTime, func() {
+		c := c.Value.(*client)
+		c.searches.Delete(s.FQuery)
+		tracef("%q", s.FQuery)
+	})
+}
+
+func (r *searchResult) Owner() string {
+	return r.FOwner
+}
+
+func (r *searchResult) Repository() string {
+	return r.FRepository
+}
+
+func (r *searchResult) Ref() string {
+	return r.FRef
+}
+
+func (r *searchResult) Path() string {
+	return r.FPath
+}
+
 var _ Client = (*client)(nil)
 var _ Owner = (*owner)(nil)
 var _ Repository = (*repository)(nil)
+var _ SearchResult = (*searchResult)(nil)
@@ -0,0 +1,254 @@
+/*
+ * client_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		size int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1234", 1234, true},
+		{"2k", 2 << 10, true},
+		{"512M", 512 << 20, true},
+		{"3G", 3 << 30, true},
+		{"1t", 1 << 40, true},
+		{"", 0, false},
+		{"M", 0, false},
+		{"-1", 0, false},
+		{"1X", 0, false},
+		{"8388607T", 8388607 << 40, true},
+		{"8388608T", 0, false},
+		{"9999999999T", 0, false},
+		{"9223372036854775808", 0, false},
+	}
+
+	for _, tt := range tests {
+		size, err := parseSize(tt.s)
+		if tt.ok != (nil == err) || size != tt.size {
+			t.Errorf("parseSize(%q) = %v, %v", tt.s, size, err)
+		}
+	}
+}
+
+func TestSetConfigSize(t *testing.T) {
+	c := &client{}
+	c.init(testReconfigureApi{})
+	if _, err := c.SetConfig([]string{"config.maxmem=1G", "config.maxdisk=10G"}); nil != err ||
+		1<<30 != c.cache.maxmem || 10<<30 != c.cache.maxdisk {
+		t.Error(err)
+	}
+	for _, s := range []string{
+		"config.maxmem=1X", "config.maxdisk=", "config.softmem=-1", "config.hardmem=G",
+		"config.retain.size=ten", "config.maxdisk=9999999999T",
+	} {
+		if _, err := c.SetConfig([]string{s}); nil == err {
+			t.Error(s)
+		}
+	}
+}
+
+func TestTtlOverride(t *testing.T) {
+	c := &client{}
+	c.SetConfig([]string{
+		"config.ttl./torvalds=1h",
+		"config.ttl./torvalds/linux=2h",
+		"config.ttl.refs=30s",
+	})
+
+	if time.Hour != c.ttlOverride("Torvalds") {
+		t.Error()
+	}
+	if time.Hour != c.ttlOverride("torvalds/subsurface") {
+		t.Error()
+	}
+	if 2*time.Hour != c.ttlOverride("torvalds/LINUX") {
+		t.Error()
+	}
+	if 0 != c.ttlOverride("winfsp/hubfs") {
+		t.Error()
+	}
+	if 30*time.Second != c.refsttl {
+		t.Error()
+	}
+}
+
+func TestPinned(t *testing.T) {
+	c := &client{}
+	c.SetConfig([]string{
+		"config._pin=winfsp/hubfs",
+		"config._pin=/billziss-gh/golib/master/",
+	})
+
+	if !c.isPinned("WinFsp/HubFS") {
+		t.Error()
+	}
+	if !c.isPinned("billziss-gh/golib") {
+		t.Error()
+	}
+	if c.isPinned("winfsp/winfsp") || c.isPinned("winfsp/hub") || c.isPinned("billziss-gh/go") {
+		t.Error()
+	}
+}
+
+type testReconfigureApi struct {
+	clientApi
+}
+
+func (api testReconfigureApi) getIdent() string {
+	return "test"
+}
+
+func TestReconfigure(t *testing.T) {
+	c := &client{}
+	c.init(testReconfigureApi{})
+	c.SetConfig([]string{
+		"config.ttl=1m",
+		"config.ttl./winfsp=1h",
+		"config._filter=winfsp",
+	})
+	c.cache.ttl = time.Minute
+	c.owners = c.cache.newCacheImap()
+	o := &owner{FName: "winfsp"}
+	o.Value = o
+	o.ttl = c.ttlOverride(o.FName)
+	c.owners.Set(o.FName, &o.MapItem, true)
+
+	if !c.filterMatch("winfsp/hubfs") || c.filterMatch("billziss-gh/golib") || time.Hour != o.ttl {
+		t.Error()
+	}
+
+	c.Reconfigure([]string{
+		"config.ttl./winfsp=2h",
+		"config._filter=billziss-gh",
+		"config.dir=/nonexistent",
+	})
+
+	if 0 != c.ttl || defaultTtl != c.cache.ttl || "" != c.dir {
+		t.Error(c.ttl, c.cache.ttl, c.dir)
+	}
+	if 2*time.Hour != o.ttl || 2*time.Hour != c.ttlOverride("winfsp/hubfs") {
+		t.Error(o.ttl)
+	}
+	if c.filterMatch("winfsp/hubfs") || !c.filterMatch("billziss-gh/golib") {
+		t.Error()
+	}
+	if !IsRuntimeConfig("config.ttl.refs=1s") || IsRuntimeConfig("config.dir=:") {
+		t.Error()
+	}
+}
+
+type testInvalidateApi struct {
+	clientApi
+	repos  []string
+	owners int
+	lists  int
+}
+
+func (api *testInvalidateApi) getIdent() string {
+	return "test"
+}
+
+func (api *testInvalidateApi) getOwner(name string) (*owner, error) {
+	api.owners++
+	if "winfsp" != name {
+		return nil, ErrNotFound
+	}
+	o := &owner{FName: name}
+	o.Value = o
+	return o, nil
+}
+
+func (api *testInvalidateApi) getRepositories(oname string, kind string) ([]*repository, error) {
+	api.lists++
+	res := []*repository{}
+	for _, name := range api.repos {
+		r := &repository{FName: name}
+		r.Value = r
+		r.Repository = emptyRepository
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func TestInvalidatePath(t *testing.T) {
+	api := &testInvalidateApi{repos: []string{"hubfs", "winfsp"}}
+	c := &client{}
+	c.init(api)
+
+	names := func() string {
+		o, err := c.OpenOwner("winfsp")
+		if nil != err {
+			t.Fatal(err)
+		}
+		defer c.CloseOwner(o)
+		repos, err := c.GetRepositories(o)
+		if nil != err {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, r := range repos {
+			names = append(names, r.Name())
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	if "hubfs,winfsp" != names() || 1 != api.lists {
+		t.Error(api.lists)
+	}
+	api.repos = []string{"hubfs", "cgofuse"}
+	if "hubfs,winfsp" != names() || 1 != api.lists {
+		t.Error(api.lists)
+	}
+
+	// a repository that is known does not refetch the repositories
+	c.InvalidatePath("/winfsp/hubfs/main/README.md")
+	if "hubfs,winfsp" != names() || 1 != api.lists {
+		t.Error(api.lists)
+	}
+
+	// a repository that is not known refetches the repositories
+	c.InvalidatePath("/winfsp/cgofuse")
+	if "cgofuse,hubfs" != names() || 2 != api.lists {
+		t.Error(api.lists)
+	}
+
+	api.repos = []string{"hubfs"}
+	c.InvalidatePath("/")
+	if "hubfs" != names() || 3 != api.lists {
+		t.Error(api.lists)
+	}
+
+	// names that were not found are forgotten
+	owners := api.owners
+	c.OpenOwner("nobody")
+	c.OpenOwner("nobody")
+	if owners+1 != api.owners {
+		t.Error(api.owners)
+	}
+	c.InvalidatePath("/NOBODY")
+	c.OpenOwner("nobody")
+	if owners+2 != api.owners {
+		t.Error(api.owners)
+	}
+}
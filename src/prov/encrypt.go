@@ -0,0 +1,76 @@
+/*
+ * encrypt.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// CacheKeySize is the size of the key used to encrypt the persistent cache.
+const CacheKeySize = 32
+
+var errDecrypt = errors.New("cannot decrypt cached data")
+
+func newCacheCipher(key []byte) (cipher.AEAD, error) {
+	if CacheKeySize != len(key) {
+		return nil, errors.New("invalid cache key size")
+	}
+	block, err := aes.NewCipher(key)
+	if nil != err {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealData encrypts and authenticates plaintext. The additional data is
+// authenticated but not encrypted and must be presented again to openData.
+func sealData(aead cipher.AEAD, plaintext []byte, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if nil != err {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func openData(aead cipher.AEAD, data []byte, additional []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if n > len(data) {
+		return nil, errDecrypt
+	}
+	plaintext, err := aead.Open(nil, data[:n], data[n:], additional)
+	if nil != err {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
+
+// encodeCacheData encrypts cached data (e.g. metadata) if a cipher is configured.
+// The name identifies the kind of data and is authenticated.
+func encodeCacheData(aead cipher.AEAD, content []byte, name string) ([]byte, error) {
+	if nil == aead {
+		return content, nil
+	}
+	return sealData(aead, content, []byte(name))
+}
+
+func decodeCacheData(aead cipher.AEAD, data []byte, name string) ([]byte, error) {
+	if nil == aead {
+		return data, nil
+	}
+	return openData(aead, data, []byte(name))
+}
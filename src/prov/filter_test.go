@@ -183,4 +183,14 @@ func TestFilter(t *testing.T) {
 	expect("a/1", true)
 	expect("owner/1", true)
 	expect("owner/repo", false)
+
+	// restrict rules (-owner) followed by filter rules (-filter)
+	config([]string{
+		"acme",
+		"-acme/secret",
+	})
+	expect("acme", true)
+	expect("acme/repo", true)
+	expect("acme/secret", false)
+	expect("other/repo", false)
 }
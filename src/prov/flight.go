@@ -0,0 +1,60 @@
+/*
+ * flight.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls with the same key into a single call whose
+// result is shared by all callers. This avoids duplicate provider requests when
+// multiple file system operations miss on the same owner, repository, tree or blob.
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	val  interface{}
+	err  error
+	dups int // number of additional callers that share the result
+}
+
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.lock.Lock()
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.lock.Unlock()
+		call.wg.Wait()
+		return call.val, call.err
+	}
+	if nil == g.calls {
+		g.calls = make(map[string]*flightCall)
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		call.wg.Done()
+	}()
+
+	call.val, call.err = fn()
+	return call.val, call.err
+}
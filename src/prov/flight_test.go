@@ -0,0 +1,79 @@
+/*
+ * flight_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	start := make(chan struct{})
+	release := make(chan struct{})
+
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(start)
+		<-release
+		return "value", nil
+	}
+
+	wg := sync.WaitGroup{}
+	results := make([]interface{}, 8)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = g.do("key", fn)
+	}()
+	<-start
+	for i := 1; len(results) > i; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do("key", fn)
+		}(i)
+	}
+	for {
+		g.lock.Lock()
+		n := g.calls["key"].dups
+		g.lock.Unlock()
+		if len(results)-1 == n {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if 1 != atomic.LoadInt32(&calls) {
+		t.Error(calls)
+	}
+	for _, r := range results {
+		if "value" != r {
+			t.Error(r)
+		}
+	}
+
+	// calls after completion are not coalesced
+	v, err := g.do("key", func() (interface{}, error) {
+		return "other", nil
+	})
+	if nil != err || "other" != v {
+		t.Error(v, err)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+ * gc.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Garbage collection prunes the persistent cache according to its retention policy
+// (config.retain.age and config.retain.size). The units of collection are repository
+// directories in the client directory and objects in the shared object directory;
+// the least recently used units are collected first. Repository directories that are
+// pinned or that have kept (.keep) writable ref directories are never collected.
+// Temporary files and directories left behind (e.g. by a crash) are always removed.
+//
+// Garbage collection must not run while the cache is in use; it is therefore run by
+// StartExpiration (prior to mounting) and by the "cache gc" command. The exception is
+// the enforcement of the disk budget, which never collects open repositories.
+
+// gcTempName matches the names of directories that are being removed (see
+// gitRepository.RemoveDirectory).
+var gcTempName = regexp.MustCompile(`\.[0-9]{8}T[0-9]{6}\.[0-9]{3}Z$`)
+
+type gcUnit struct {
+	path string
+	time time.Time
+	size int64
+}
+
+// CollectGarbage prunes the persistent cache according to the retention policy.
+// It returns the number of repositories and objects removed and their total size.
+func (c *client) CollectGarbage() (count int, size int64, err error) {
+	c.lock.Lock()
+	maxage, maxsize := c.retainage, c.retainsize
+	c.lock.Unlock()
+	return c.collectGarbage(maxage, maxsize, maxsize, nil)
+}
+
+// collectGarbage removes the units that have not been used for longer than maxage
+// and, if the cache is larger than limit, the least recently used units until it
+// fits within target. Repository directories in open are never removed.
+func (c *client) collectGarbage(maxage time.Duration, limit int64, target int64,
+	open map[string]bool) (count int, size int64, err error) {
+	dir, objdir := c.snapshotDirs()
+	if "" == dir && "" == objdir {
+		return 0, 0, errNoPersistentCache
+	}
+
+	units := []*gcUnit{}
+	total := int64(0)
+	if "" != dir {
+		units, total, err = c.gcRepositoryUnits(dir, open, units, total)
+		if nil != err {
+			return
+		}
+	}
+	if "" != objdir {
+		units, total, err = gcObjectUnits(objdir, units, total)
+		if nil != err {
+			return
+		}
+	}
+
+	maxsize := target
+	if 0 >= limit || total <= limit {
+		maxsize = 0
+	}
+	if 0 >= maxage && 0 >= maxsize {
+		return
+	}
+
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].time.Before(units[j].time)
+	})
+
+	currentTime := time.Now()
+	for _, u := range units {
+		if (0 >= maxage || currentTime.Sub(u.time) <= maxage) &&
+			(0 >= maxsize || total <= maxsize) {
+			break
+		}
+		if nil == gcRemove(u.path) {
+			tracef("%s", u.path)
+			count++
+			size += u.size
+			total -= u.size
+		}
+	}
+
+	return
+}
+
+// The disk budget (config.maxdisk) of a persistent cache is also enforced while the
+// cache is in use: the size of the cache is checked periodically and when it exceeds
+// 90% of the budget, the least recently used repositories that are not open and
+// objects are collected until it is within 80% of the budget. (Open repositories
+// are closed by the eviction of the cache when it exceeds the budget, after which
+// they can be collected.)
+
+// diskCheckInterval is the interval at which the size of a persistent cache is
+// checked against its disk budget.
+var diskCheckInterval = time.Minute
+
+// enforceDiskBudget collects garbage if the persistent cache approaches its budget.
+func (c *client) enforceDiskBudget() (count int, size int64, err error) {
+	c.lock.Lock()
+	maxdisk := c.cache.maxdisk
+	open := map[string]bool{}
+	if nil != c.owners {
+		for _, item := range c.owners.Items() {
+			o := item.Value.(*owner)
+			if nil == o.repositories {
+				continue
+			}
+			for _, elm := range o.repositories.Items() {
+				r := elm.Value.(*repository)
+				if emptyRepository != r.Repository {
+					open[filepath.Join(c.dir, o.FName, r.FName)] = true
+				}
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	if 0 >= maxdisk {
+		return
+	}
+	return c.collectGarbage(0, maxdisk/10*9, maxdisk/10*8, open)
+}
+
+// startDiskBudget periodically enforces the disk budget until stopDiskBudget.
+func (c *client) startDiskBudget() {
+	c.diskStopC = make(chan struct{})
+	c.diskStopW.Add(1)
+	go func() {
+		defer c.diskStopW.Done()
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		for {
+			count, size, err := c.enforceDiskBudget()
+			if 0 != count || nil != err {
+				tracef("[enforceDiskBudget() = %v, %v, %v]", count, size, err)
+			}
+			select {
+			case <-ticker.C:
+			case <-c.diskStopC:
+				return
+			}
+		}
+	}()
+}
+
+func (c *client) stopDiskBudget() {
+	if nil == c.diskStopC {
+		return
+	}
+	close(c.diskStopC)
+	c.diskStopW.Wait()
+	c.diskStopC = nil
+}
+
+// ListCacheRepositories returns the owner/repo names of the repositories in a
+// persistent cache directory (e.g. for shell completion).
+func ListCacheRepositories(dir string) ([]string, error) {
+	owners, err := ioutil.ReadDir(dir)
+	if nil != err {
+		return nil, err
+	}
+	res := []string{}
+	for _, o := range owners {
+		if !o.IsDir() {
+			continue
+		}
+		repos, err := ioutil.ReadDir(filepath.Join(dir, o.Name()))
+		if nil != err {
+			continue
+		}
+		for _, r := range repos {
+			if r.IsDir() && !gcTempName.MatchString(r.Name()) {
+				res = append(res, o.Name()+"/"+r.Name())
+			}
+		}
+	}
+	return res, nil
+}
+
+func (c *client) gcRepositoryUnits(dir string, open map[string]bool, units []*gcUnit, total int64) (
+	[]*gcUnit, int64, error) {
+
+	owners, err := ioutil.ReadDir(dir)
+	if nil != err {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return units, total, err
+	}
+	for _, o := range owners {
+		if !o.IsDir() {
+			continue
+		}
+		opath := filepath.Join(dir, o.Name())
+		repos, err := ioutil.ReadDir(opath)
+		if nil != err {
+			return units, total, err
+		}
+		for _, r := range repos {
+			rpath := filepath.Join(opath, r.Name())
+			if gcTempName.MatchString(r.Name()) {
+				os.RemoveAll(rpath)
+				continue
+			}
+			if !r.IsDir() {
+				continue
+			}
+			s := gcDirSize(rpath)
+			total += s
+			if c.isPinned(o.Name()+"/"+r.Name()) || open[rpath] {
+				continue
+			}
+			if list, _ := filepath.Glob(filepath.Join(rpath, "files/*/.keep")); 0 != len(list) {
+				continue
+			}
+			units = append(units, &gcUnit{path: rpath, time: r.ModTime(), size: s})
+		}
+	}
+	return units, total, nil
+}
+
+func gcObjectUnits(objdir string, units []*gcUnit, total int64) ([]*gcUnit, int64, error) {
+	err := filepath.Walk(objdir, func(p string, info os.FileInfo, err error) error {
+		if nil != err {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || layoutName == info.Name() {
+			return nil
+		}
+		if strings.HasSuffix(p, ".tmp") {
+			os.Remove(p)
+			return nil
+		}
+		total += info.Size()
+		units = append(units, &gcUnit{path: p, time: info.ModTime(), size: info.Size()})
+		return nil
+	})
+	return units, total, err
+}
+
+func gcDirSize(dir string) (size int64) {
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if nil == err && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+func gcRemove(path string) error {
+	info, err := os.Stat(path)
+	if nil != err {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(path)
+	}
+	// rename first, so that a partially removed repository is never used
+	tmpdir := path + time.Now().Format(".20060102T150405.000Z")
+	err = os.Rename(path, tmpdir)
+	if nil == err {
+		err = os.RemoveAll(tmpdir)
+	}
+	return err
+}
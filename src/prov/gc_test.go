@@ -0,0 +1,147 @@
+/*
+ * gc_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	root, err := ioutil.TempDir("", "hubfs-gc-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	c := &client{}
+	c.SetConfig([]string{"config.dir=" + root, "config._pin=owner/pinned"})
+
+	now := time.Now()
+	mkrepo := func(name string, age time.Duration) string {
+		p := filepath.Join(root, "owner", name)
+		os.MkdirAll(filepath.Join(p, "objects"), 0700)
+		ioutil.WriteFile(filepath.Join(p, "objects", "object"), make([]byte, 100), 0600)
+		os.Chtimes(p, now.Add(-age), now.Add(-age))
+		return p
+	}
+	old := mkrepo("old", 48*time.Hour)
+	older := mkrepo("older", 72*time.Hour)
+	recent := mkrepo("recent", time.Hour)
+	pinned := mkrepo("pinned", 96*time.Hour)
+	kept := mkrepo("kept", 96*time.Hour)
+	os.MkdirAll(filepath.Join(kept, "files", "master"), 0700)
+	ioutil.WriteFile(filepath.Join(kept, "files", "master", ".keep"), nil, 0600)
+	os.Chtimes(kept, now.Add(-96*time.Hour), now.Add(-96*time.Hour))
+	temp := filepath.Join(root, "owner", "repo.20220101T000000.000Z")
+	os.MkdirAll(temp, 0700)
+
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return nil == err
+	}
+
+	c.SetConfig([]string{"config.retain.age=60h"})
+	count, size, err := c.CollectGarbage()
+	if nil != err || 1 != count || 100 != size {
+		t.Error(count, size, err)
+	}
+	if exists(older) || !exists(old) || !exists(recent) || !exists(pinned) || !exists(kept) ||
+		exists(temp) {
+		t.Error()
+	}
+
+	c.SetConfig([]string{"config.retain.age=0", "config.retain.size=350"})
+	count, size, err = c.CollectGarbage()
+	if nil != err || 1 != count || 100 != size {
+		t.Error(count, size, err)
+	}
+	if exists(old) || !exists(recent) || !exists(pinned) || !exists(kept) {
+		t.Error()
+	}
+}
+
+func TestEnforceDiskBudget(t *testing.T) {
+	root, err := ioutil.TempDir("", "hubfs-gc-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	c := &client{}
+	c.cache = newCache(&c.lock)
+	c.SetConfig([]string{"config.dir=" + root, "config.maxdisk=320"})
+
+	now := time.Now()
+	mkrepo := func(name string, age time.Duration) string {
+		p := filepath.Join(root, "owner", name)
+		os.MkdirAll(p, 0700)
+		ioutil.WriteFile(filepath.Join(p, "object"), make([]byte, 100), 0600)
+		os.Chtimes(p, now.Add(-age), now.Add(-age))
+		return p
+	}
+	open := mkrepo("open", 72*time.Hour)
+	old := mkrepo("old", 48*time.Hour)
+	recent := mkrepo("recent", time.Hour)
+
+	o := &owner{FName: "owner", repositories: c.cache.newCacheImap()}
+	o.Value = o
+	r := &repository{Repository: &gitRepository{}, FName: "open"}
+	r.Value = r
+	o.repositories.Set("open", &r.MapItem, true)
+	c.owners = c.cache.newCacheImap()
+	c.owners.Set("owner", &o.MapItem, true)
+
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return nil == err
+	}
+
+	// 300 bytes exceed 90% of the budget: collect down to 80% (256 bytes)
+	count, size, err := c.enforceDiskBudget()
+	if nil != err || 1 != count || 100 != size {
+		t.Error(count, size, err)
+	}
+	if !exists(open) || exists(old) || !exists(recent) {
+		t.Error()
+	}
+
+	// 200 bytes are within the budget
+	count, size, err = c.enforceDiskBudget()
+	if nil != err || 0 != count || 0 != size {
+		t.Error(count, size, err)
+	}
+}
+
+func TestListCacheRepositories(t *testing.T) {
+	root, err := ioutil.TempDir("", "hubfs-gc-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "owner", "repo"), 0700)
+	os.MkdirAll(filepath.Join(root, "owner", "repo.20220101T000000.000Z"), 0700)
+	os.MkdirAll(filepath.Join(root, "other", "name"), 0700)
+	ioutil.WriteFile(filepath.Join(root, "owner", "file"), nil, 0600)
+	ioutil.WriteFile(filepath.Join(root, "layout"), []byte("1\n"), 0600)
+
+	names, err := ListCacheRepositories(root)
+	if nil != err || 2 != len(names) || "other/name" != names[0] || "owner/repo" != names[1] {
+		t.Error(names, err)
+	}
+}
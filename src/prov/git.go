@@ -15,7 +15,10 @@ package prov
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
@@ -30,17 +33,43 @@ import (
 	"github.com/winfsp/hubfs/git"
 )
 
+// gitConfig is the client configuration that applies to git repositories.
+type gitConfig struct {
+	caseins   bool
+	fullrefs  bool
+	offline   bool
+	compress  bool
+	aead      cipher.AEAD
+	objdir    string
+	noverify  bool
+	fetchers  int
+	walkers   int
+	treedepth int
+	negttl    time.Duration
+	refsttl   time.Duration
+	errlog    *errorLog
+	stats     *cacheCounters
+}
+
 type gitRepository struct {
-	remote   string
-	username string
-	password string
-	caseins  bool
-	fullrefs bool
-	once     sync.Once
-	repo     *git.Repository
-	lock     sync.RWMutex
-	refs     map[string]*gitRef
-	dir      string
+	gitConfig
+	remote     string
+	username   string
+	password   string
+	once       sync.Once
+	repo       *git.Repository
+	lock       sync.RWMutex
+	refs       map[string]*gitRef
+	refsTime   time.Time
+	stale      bool
+	discarded  bool
+	refreshing bool
+	negative   negativeCache
+	flights    flightGroup
+	verified   map[string]bool
+	dir        string
+	memsize    int64
+	disksize   int64
 }
 
 type gitRef struct {
@@ -62,10 +91,10 @@ type gitTreeEntry struct {
 func NewGitRepository(
 	remote string, username string, password string, caseins bool, fullrefs bool) (Repository, error) {
 	r := &gitRepository{
-		remote:   remote,
-		username: username,
-		password: password,
-		caseins:  caseins,
+		gitConfig: gitConfig{caseins: caseins},
+		remote:    remote,
+		username:  username,
+		password:  password,
 	}
 
 	var err error
@@ -78,18 +107,20 @@ func NewGitRepository(
 }
 
 func newGitRepository(
-	remote string, username string, password string, caseins bool, fullrefs bool) Repository {
+	remote string, username string, password string, config gitConfig) Repository {
 	return &gitRepository{
-		remote:   remote,
-		username: username,
-		password: password,
-		caseins:  caseins,
-		fullrefs: fullrefs,
+		gitConfig: config,
+		remote:    remote,
+		username:  username,
+		password:  password,
 	}
 }
 
 func (r *gitRepository) open() (err error) {
+	start := r.errlog.begin("open", r.remote)
+	r.stats.request()
 	r.repo, err = git.OpenRepository(r.remote, r.username, r.password)
+	r.errlog.record("open", r.remote, start, err)
 	return
 }
 
@@ -112,7 +143,17 @@ func (r *gitRepository) SetDirectory(path string) (err error) {
 	if "" == r.dir {
 		err = os.MkdirAll(path, 0700)
 		if nil == err {
+			// record the last use of the repository (see CollectGarbage)
+			currentTime := time.Now()
+			os.Chtimes(path, currentTime, currentTime)
 			r.dir = path
+			r.disksize = 0
+			filepath.Walk(filepath.Join(path, "objects"), func(p string, info os.FileInfo, e error) error {
+				if nil == e && info.Mode().IsRegular() {
+					r.disksize += info.Size()
+				}
+				return nil
+			})
 		}
 	} else {
 		err = os.ErrExist
@@ -131,6 +172,7 @@ func (r *gitRepository) RemoveDirectory() (err error) {
 	err = os.Rename(r.dir, tmpdir)
 	if nil == err {
 		r.dir = ""
+		r.disksize = 0
 	}
 	r.lock.Unlock()
 	if nil == err {
@@ -139,24 +181,57 @@ func (r *gitRepository) RemoveDirectory() (err error) {
 	return
 }
 
-func objectPath(dir string, hash string) string {
-	if 2 < len(hash) {
-		return filepath.Join(dir, "objects", hash[:2], hash[2:])
+// objectDir returns the directory where objects are stored. This is either the
+// shared object directory (if any), which may be used by multiple repositories
+// and file system instances, or the objects subdirectory of the repository.
+func (r *gitRepository) objectDir() (dir string) {
+	r.lock.RLock()
+	if "" != r.objdir {
+		dir = r.objdir
+	} else if "" != r.dir {
+		dir = filepath.Join(r.dir, "objects")
 	}
-	return ""
+	r.lock.RUnlock()
+	return
 }
 
-func writeObject(dir string, hash string, content []byte) {
-	p := objectPath(dir, hash)
-	if nil == os.MkdirAll(filepath.Dir(p), 0700) {
-		err := ioutil.WriteFile(p+".tmp", content, 0700)
-		if nil == err {
-			err = os.Rename(p+".tmp", p)
-		}
-		if nil != err {
-			os.Remove(p + ".tmp")
-		}
+// refsName is the file in the repository directory where the advertised refs are
+// persisted, so that the repository can be served when the remote is unreachable.
+const refsName = "refs.json"
+
+func readRefs(path string, aead cipher.AEAD) (m map[string]string, err error) {
+	content, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	content, err = decodeCacheData(aead, content, refsName)
+	if nil != err {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &m)
+	if nil != err {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeRefs(path string, m map[string]string, aead cipher.AEAD) error {
+	content, err := json.Marshal(m)
+	if nil != err {
+		return err
+	}
+	content, err = encodeCacheData(aead, content, refsName)
+	if nil != err {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", content, 0600)
+	if nil == err {
+		err = os.Rename(path+".tmp", path)
 	}
+	if nil != err {
+		os.Remove(path + ".tmp")
+	}
+	return err
 }
 
 func containsString(l []string, s string) bool {
@@ -168,6 +243,33 @@ func containsString(l []string, s string) bool {
 	return false
 }
 
+// fetchRemoteObjects fetches objects from the remote. In offline mode or when the
+// remote is unreachable only objects that are already in the object directory
+// are available.
+func (r *gitRepository) fetchRemoteObjects(want []string,
+	fn func(hash string, ot git.ObjectType, content []byte) error) error {
+
+	if r.offline {
+		return ErrNotFound
+	}
+	r.once.Do(func() { r.open() })
+	r.lock.RLock()
+	repo := r.repo
+	r.lock.RUnlock()
+	if nil == repo {
+		return ErrNotFound
+	}
+	start := r.errlog.begin("fetch", r.remote)
+	r.stats.request()
+	err := repo.FetchObjectsConcurrently(want, r.fetchers,
+		func(hash string, ot git.ObjectType, content []byte) error {
+			r.stats.download(len(content))
+			return fn(hash, ot, content)
+		})
+	r.errlog.record("fetch", r.remote, start, err)
+	return err
+}
+
 func (r *gitRepository) prefetchObjects(dir string, want []string,
 	fn func(hash string, size int64) error) error {
 
@@ -178,11 +280,11 @@ func (r *gitRepository) prefetchObjects(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			info, err := os.Stat(objectPath(dir, hash))
+			size, err := statObject(dir, hash, r.aead)
 			if nil != err {
 				w = append(w, hash)
 			} else {
-				err = fn(hash, info.Size())
+				err = fn(hash, size)
 				if nil != err {
 					return err
 				}
@@ -194,19 +296,22 @@ func (r *gitRepository) prefetchObjects(dir string, want []string,
 			return nil
 		}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+			r.addDiskSize(writeObject(dir, hash, content, r.compress, r.aead))
 			if !containsString(want, hash) {
 				return nil
 			}
-			info, err := os.Stat(objectPath(dir, hash))
+			size, err := statObject(dir, hash, r.aead)
 			if nil != err {
 				return err
 			}
-			return fn(hash, info.Size())
+			return fn(hash, size)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		for range want {
+			r.stats.miss()
+		}
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -225,7 +330,11 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			content, err := ioutil.ReadFile(objectPath(dir, hash))
+			content, err := readObject(dir, hash, r.aead)
+			if nil == err && !r.checkObject(hash, bytes.NewReader(content), int64(len(content))) {
+				removeObject(dir, hash)
+				err = errCorruptObject
+			}
 			if nil != err {
 				w = append(w, hash)
 			} else {
@@ -241,15 +350,18 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 			return nil
 		}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+			r.addDiskSize(writeObject(dir, hash, content, r.compress, r.aead))
 			if !containsString(want, hash) {
 				return nil
 			}
 			return fn(hash, content)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		for range want {
+			r.stats.miss()
+		}
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -258,6 +370,35 @@ func (r *gitRepository) fetchObjects(dir string, want []string,
 	}
 }
 
+// checkObject verifies an object read from the object directory against its hash
+// the first time that it is used. Callers remove a corrupt object, so that it is
+// fetched again instead of served.
+func (r *gitRepository) checkObject(hash string, reader io.ReaderAt, size int64) bool {
+	if r.noverify {
+		return true
+	}
+
+	r.lock.RLock()
+	ok := r.verified[hash]
+	r.lock.RUnlock()
+	if ok {
+		return true
+	}
+
+	if !verifyObject(hash, reader, size) {
+		tracef("%s [corrupt object %s]", r.remote, hash)
+		return false
+	}
+
+	r.lock.Lock()
+	if nil == r.verified {
+		r.verified = make(map[string]bool)
+	}
+	r.verified[hash] = true
+	r.lock.Unlock()
+	return true
+}
+
 func (r *gitRepository) refetchObjects(dir string, want []string,
 	fn func(hash string, ot git.ObjectType) error) error {
 
@@ -266,15 +407,18 @@ func (r *gitRepository) refetchObjects(dir string, want []string,
 	}
 
 	if "" != dir {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+			r.addDiskSize(writeObject(dir, hash, content, r.compress, r.aead))
 			if !containsString(want, hash) {
 				return nil
 			}
 			return fn(hash, ot)
 		})
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		for range want {
+			r.stats.miss()
+		}
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -306,10 +450,23 @@ func (r *gitRepository) fetchReaders(dir string, want []string,
 	if "" != dir {
 		w := make([]string, 0, len(want))
 		for _, hash := range want {
-			reader, err := os.Open(objectPath(dir, hash))
+			reader, err := openObject(dir, hash, r.aead)
+			if nil == err {
+				var size int64
+				size, err = statObject(dir, hash, r.aead)
+				if nil != err || !r.checkObject(hash, reader, size) {
+					if c, ok := reader.(io.Closer); ok {
+						c.Close()
+					}
+					removeObject(dir, hash)
+					err = errCorruptObject
+				}
+			}
 			if nil != err {
+				r.stats.miss()
 				w = append(w, hash)
 			} else {
+				r.stats.hit()
 				err = fn(hash, reader)
 				if nil != err {
 					return err
@@ -322,19 +479,33 @@ func (r *gitRepository) fetchReaders(dir string, want []string,
 			return nil
 		}
 
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
-			writeObject(dir, hash, content)
-			if !containsString(want, hash) {
-				return nil
+		// coalesce concurrent fetches of the same objects
+		_, err := r.flights.do(strings.Join(want, ","), func() (interface{}, error) {
+			return nil, r.fetchRemoteObjects(want,
+				func(hash string, ot git.ObjectType, content []byte) error {
+					r.addDiskSize(writeObject(dir, hash, content, r.compress, r.aead))
+					return nil
+				})
+		})
+		if nil != err {
+			return err
+		}
+		for _, hash := range want {
+			reader, err := openObject(dir, hash, r.aead)
+			if nil != err {
+				return err
 			}
-			reader, err := os.Open(objectPath(dir, hash))
+			err = fn(hash, reader)
 			if nil != err {
 				return err
 			}
-			return fn(hash, reader)
-		})
+		}
+		return nil
 	} else {
-		return r.repo.FetchObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
+		for range want {
+			r.stats.miss()
+		}
+		return r.fetchRemoteObjects(want, func(hash string, ot git.ObjectType, content []byte) error {
 			if !containsString(want, hash) {
 				return nil
 			}
@@ -348,25 +519,135 @@ func (r *gitRepository) Name() string {
 	return path.Base(r.remote)
 }
 
-func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return ErrNotFound
+// getRefs gets the advertised refs from the remote and persists them in the
+// repository directory. If the remote is unreachable (or in offline mode) the
+// last persisted refs are used instead.
+func (r *gitRepository) getRefs(refresh bool) (m map[string]string, err error) {
+	dir := r.GetDirectory()
+
+	err = ErrNotFound
+	if !r.offline {
+		r.once.Do(func() { r.open() })
+		r.lock.RLock()
+		repo := r.repo
+		r.lock.RUnlock()
+		if refresh {
+			// the advertised refs are only sent when the session is opened
+			r.stats.request()
+			if newrepo, e := git.OpenRepository(r.remote, r.username, r.password); nil == e {
+				r.lock.Lock()
+				r.repo = newrepo
+				r.lock.Unlock()
+				if nil != repo {
+					repo.Close()
+				}
+				repo = newrepo
+			}
+		}
+		if nil != repo {
+			start := r.errlog.begin("refs", r.remote)
+			m, err = repo.GetRefs()
+			r.errlog.record("refs", r.remote, start, err)
+			if nil == err {
+				if "" != dir {
+					writeRefs(filepath.Join(dir, refsName), m, r.aead)
+				}
+				return m, nil
+			}
+		}
 	}
 
+	if "" == dir {
+		return nil, err
+	}
+	m, e := readRefs(filepath.Join(dir, refsName), r.aead)
+	if nil != e {
+		return nil, err
+	}
+	tracef("repo=%#v [offline]", r.remote)
+	return m, nil
+}
+
+func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error {
 	r.lock.RLock()
 	if nil != r.refs {
-		err := fn(r.refs)
+		if !r.stale && (0 == r.refsttl || time.Now().Before(r.refsTime.Add(r.refsttl))) {
+			r.stats.hit()
+			err := fn(r.refs)
+			r.lock.RUnlock()
+			return err
+		}
+		discarded := r.discarded
 		r.lock.RUnlock()
+
+		if discarded {
+			// the refs have been discarded: revalidate them now
+			r.stats.miss()
+			m, err := r.getRefs(true)
+			if nil != err {
+				return err
+			}
+			refs := r.newRefs(m)
+			r.lock.Lock()
+			r.setRefs(refs)
+			err = fn(r.refs)
+			r.lock.Unlock()
+			return err
+		}
+
+		// serve the stale refs and revalidate them in the background
+		r.stats.staleHit()
+		r.lock.Lock()
+		if !r.refreshing {
+			r.refreshing = true
+			go r.refreshRefs()
+		}
+		err := fn(r.refs)
+		r.lock.Unlock()
 		return err
 	}
 	r.lock.RUnlock()
 
-	m, err := r.repo.GetRefs()
+	r.stats.miss()
+	m, err := r.getRefs(false)
 	if nil != err {
 		return err
 	}
 
+	refs := r.newRefs(m)
+
+	r.lock.Lock()
+	if nil == r.refs {
+		r.setRefs(refs)
+	}
+	err = fn(r.refs)
+	r.lock.Unlock()
+	return err
+}
+
+func (r *gitRepository) refreshRefs() {
+	m, err := r.getRefs(true)
+
+	var refs map[string]*gitRef
+	if nil == err {
+		refs = r.newRefs(m)
+	}
+
+	r.lock.Lock()
+	if nil != refs {
+		r.setRefs(refs)
+	} else {
+		// continue to serve the refs that we have until the next revalidation
+		r.stale = false
+		r.refsTime = time.Now()
+	}
+	r.refreshing = false
+	r.lock.Unlock()
+
+	tracef("repo=%#v [refreshRefs() = %v]", r.remote, err)
+}
+
+func (r *gitRepository) newRefs(m map[string]string) map[string]*gitRef {
 	refs := make(map[string]*gitRef)
 	for n, h := range m {
 		kind := RefOther
@@ -402,14 +683,42 @@ func (r *gitRepository) ensureRefs(fn func(refs map[string]*gitRef) error) error
 			targetHash: h,
 		}
 	}
+	return refs
+}
 
-	r.lock.Lock()
-	if nil == r.refs {
-		r.refs = refs
+// setRefs replaces the cached refs. Refs that have not changed (and temp refs)
+// are kept, so that they keep their trees. Must be called with the lock held.
+func (r *gitRepository) setRefs(refs map[string]*gitRef) {
+	for k, ref := range r.refs {
+		if RefTemp == ref.kind {
+			refs[k] = ref
+		} else if n := refs[k]; nil != n && n.kind == ref.kind && n.targetHash == ref.targetHash {
+			refs[k] = ref
+		}
 	}
-	err = fn(r.refs)
+	r.refs = refs
+	r.stale = false
+	r.discarded = false
+	r.refsTime = time.Now()
+}
+
+// invalidate marks the cached refs as stale, so that they are revalidated on next
+// access. Refs that have not changed keep their trees.
+func (r *gitRepository) invalidate() {
+	r.lock.Lock()
+	r.stale = true
+	r.lock.Unlock()
+}
+
+// discard discards the cached refs and the names that were not found, so that the
+// next access fetches them from the remote rather than serving them stale. Refs that
+// have not changed keep their trees.
+func (r *gitRepository) discard() {
+	r.lock.Lock()
+	r.stale = true
+	r.discarded = true
+	r.negative = nil
 	r.lock.Unlock()
-	return err
 }
 
 func (r *gitRepository) GetRefs() (res []Ref, err error) {
@@ -472,9 +781,14 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 		return
 	}
 
-	r.lock.RLock()
-	dir := r.dir
-	r.lock.RUnlock()
+	r.lock.Lock()
+	neg := r.negative.get(name, time.Now())
+	r.lock.Unlock()
+	if neg {
+		return nil, ErrNotFound
+	}
+
+	dir := r.objectDir()
 
 	err = r.refetchObjects(dir, []string{name}, func(hash string, ot git.ObjectType) error {
 		if git.CommitObject != ot {
@@ -482,6 +796,11 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 		}
 		return nil
 	})
+	if ErrNotFound == err {
+		r.lock.Lock()
+		r.negative.set(name, time.Now().Add(r.negttl))
+		r.lock.Unlock()
+	}
 	if nil != err {
 		return
 	}
@@ -492,7 +811,9 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 		targetHash: name,
 	}
 	r.lock.Lock()
-	r.refs[k] = ref
+	if nil != r.refs {
+		r.refs[k] = ref
+	}
 	r.lock.Unlock()
 
 	return ref, nil
@@ -500,11 +821,6 @@ func (r *gitRepository) GetTempRef(name string) (res Ref, err error) {
 
 func (r *gitRepository) ensureTree(
 	ref0 Ref, entry0 TreeEntry, fn func(tree map[string]*gitTreeEntry) error) error {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return ErrNotFound
-	}
-
 	ref, _ := ref0.(*gitRef)
 	entry, ok := entry0.(*gitTreeEntry)
 	if ok && 0040000 != entry.entry.Mode {
@@ -514,20 +830,85 @@ func (r *gitRepository) ensureTree(
 	r.lock.RLock()
 	if nil == entry {
 		if nil != ref.tree {
+			r.stats.hit()
 			err := fn(ref.tree)
 			r.lock.RUnlock()
 			return err
 		}
 	} else {
 		if nil != entry.tree {
+			r.stats.hit()
 			err := fn(entry.tree)
 			r.lock.RUnlock()
 			return err
 		}
 	}
-	dir := r.dir
 	r.lock.RUnlock()
 
+	r.stats.miss()
+
+	// coalesce concurrent fetches of the same tree
+	key := fmt.Sprintf("%p", ref)
+	if nil != entry {
+		key = fmt.Sprintf("%p", entry)
+	}
+	v, err := r.flights.do(key, func() (interface{}, error) {
+		return r.fetchTree(ref, entry)
+	})
+	if nil != err {
+		return err
+	}
+	fetched := v.(*gitFetchedTree)
+	tree, treeTime := fetched.tree, fetched.treeTime
+
+	prefetch := false
+	r.lock.Lock()
+	if nil == entry {
+		if nil == ref.tree {
+			ref.tree = tree
+			ref.treeTime = treeTime
+			r.memsize += treeSize(tree)
+			prefetch = 0 < r.treedepth
+		}
+		err = fn(ref.tree)
+	} else {
+		if nil == entry.tree {
+			entry.tree = tree
+			r.memsize += treeSize(tree)
+		}
+		err = fn(entry.tree)
+	}
+	r.lock.Unlock()
+
+	if prefetch {
+		go r.prefetchTrees(ref, r.treedepth)
+	}
+
+	return err
+}
+
+// prefetchTrees fetches the subtrees of a ref in the background, up to depth levels
+// below its root, so that exploring a newly opened ref does not pay a round trip for
+// every directory level. Subtrees are fetched as if they had been listed; no files
+// are opened.
+func (r *gitRepository) prefetchTrees(ref *gitRef, depth int) {
+	err := WalkTree(r, ref, nil, depth-1, r.walkers, nil)
+	if nil != err {
+		tracef("repo=%#v ref=%#v [prefetchTrees() = %v]", r.remote, ref.name, err)
+	}
+}
+
+// gitFetchedTree is the result of fetching a tree.
+type gitFetchedTree struct {
+	tree     map[string]*gitTreeEntry
+	treeTime time.Time
+}
+
+// fetchTree fetches the tree of a ref (if entry is nil) or of a tree entry, as well
+// as the sizes of its blobs and the targets of its symlinks.
+func (r *gitRepository) fetchTree(ref *gitRef, entry *gitTreeEntry) (*gitFetchedTree, error) {
+	dir := r.objectDir()
+
 	var treeTime time.Time
 	want := []string{""}
 	if nil == entry {
@@ -556,7 +937,7 @@ func (r *gitRepository) ensureTree(
 			err = r.fetchObjects(dir, []string{h}, f)
 		}
 		if nil != err {
-			return err
+			return nil, err
 		}
 	} else {
 		want[0] = entry.entry.Hash
@@ -579,7 +960,7 @@ func (r *gitRepository) ensureTree(
 		return nil
 	})
 	if nil != err {
-		return err
+		return nil, err
 	}
 
 	want = make([]string, 0, len(tree))
@@ -600,7 +981,7 @@ func (r *gitRepository) ensureTree(
 		return nil
 	})
 	if nil != err {
-		return err
+		return nil, err
 	}
 
 	want = make([]string, 0, len(tree))
@@ -625,24 +1006,37 @@ func (r *gitRepository) ensureTree(
 		return nil
 	})
 	if nil != err {
-		return err
+		return nil, err
 	}
 
-	r.lock.Lock()
-	if nil == entry {
-		if nil == ref.tree {
-			ref.tree = tree
-			ref.treeTime = treeTime
-		}
-		err = fn(ref.tree)
-	} else {
-		if nil == entry.tree {
-			entry.tree = tree
-		}
-		err = fn(entry.tree)
+	return &gitFetchedTree{tree: tree, treeTime: treeTime}, nil
+}
+
+// treeEntryOverhead is the approximate memory overhead of a tree entry
+// (map slot, gitTreeEntry structure and string headers).
+const treeEntryOverhead = 128
+
+func treeSize(tree map[string]*gitTreeEntry) (size int64) {
+	for k, e := range tree {
+		size += int64(len(k)+len(e.entry.Name)+len(e.entry.Hash)+len(e.target)) + treeEntryOverhead
 	}
+	return
+}
+
+func (r *gitRepository) cacheSize() (memsize int64, disksize int64) {
+	r.lock.RLock()
+	memsize = r.memsize
+	if "" == r.objdir {
+		disksize = r.disksize
+	}
+	r.lock.RUnlock()
+	return
+}
+
+func (r *gitRepository) addDiskSize(size int64) {
+	r.lock.Lock()
+	r.disksize += size
 	r.lock.Unlock()
-	return err
 }
 
 func (r *gitRepository) GetTree(ref Ref, entry TreeEntry) (res []TreeEntry, err error) {
@@ -676,14 +1070,7 @@ func (r *gitRepository) GetTreeEntry(ref Ref, entry TreeEntry, name string) (res
 }
 
 func (r *gitRepository) GetBlobReader(entry TreeEntry) (res io.ReaderAt, err error) {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return nil, ErrNotFound
-	}
-
-	r.lock.RLock()
-	dir := r.dir
-	r.lock.RUnlock()
+	dir := r.objectDir()
 
 	want := []string{entry.Hash()}
 	err = r.fetchReaders(dir, want, func(hash string, reader io.ReaderAt) error {
@@ -695,11 +1082,6 @@ func (r *gitRepository) GetBlobReader(entry TreeEntry) (res io.ReaderAt, err err
 
 func (r *gitRepository) ensureModules(
 	ref0 Ref, fn func(modules map[string]string) error) error {
-	r.once.Do(func() { r.open() })
-	if nil == r.repo {
-		return ErrNotFound
-	}
-
 	ref, _ := ref0.(*gitRef)
 
 	r.lock.RLock()
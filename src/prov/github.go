@@ -18,11 +18,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	pathutil "path"
 	"strings"
+	"sync"
+	"time"
 
+	libcache "github.com/billziss-gh/golib/cache"
 	"github.com/cli/oauth"
 	"github.com/winfsp/hubfs/httputil"
 )
@@ -83,8 +87,32 @@ type githubClient struct {
 	gqlApiURI  string
 	token      string
 	login      string
+	vlock      sync.Mutex
+	validators *libcache.Map
+	vsize      int64
+	rate       rateLimits
 }
 
+// githubValidator remembers the validators (ETag, Last-Modified) and content of
+// a REST response, so that the response can be revalidated with a conditional
+// request. GitHub does not count 304 responses against the rate limit.
+//
+// Validators are kept in LRU order; the least recently used ones are discarded
+// when there are more than githubValidatorsMax of them or when their content
+// exceeds githubValidatorsMaxSize bytes.
+type githubValidator struct {
+	libcache.MapItem
+	path         string
+	etag         string
+	lastModified string
+	content      []byte
+}
+
+const (
+	githubValidatorsMax     = 1024
+	githubValidatorsMaxSize = 16 * 1024 * 1024
+)
+
 func NewGithubClient(apiURI string, token string) (Client, error) {
 	uri, err := url.Parse(apiURI)
 	if nil != err {
@@ -97,6 +125,7 @@ func NewGithubClient(apiURI string, token string) (Client, error) {
 		apiURI:     apiURI,
 		gqlApiURI:  apiURI + "/graphql",
 		token:      token,
+		validators: libcache.NewMap(nil),
 	}
 	c.client.init(c)
 
@@ -129,6 +158,14 @@ func (c *githubClient) getIdent() string {
 	return c.ident
 }
 
+func (c *githubClient) getLogin() string {
+	return c.login
+}
+
+func (c *githubClient) getRateLimits() ([]RateLimitStatus, time.Time) {
+	return c.rate.status()
+}
+
 func (c *githubClient) getGitCredentials() (string, string) {
 	return c.token, "x-oauth-basic"
 }
@@ -144,20 +181,117 @@ func (c *githubClient) sendrecv(path string) (*http.Response, error) {
 		req.Header.Set("Authorization", "token "+c.token)
 	}
 
+	v := c.getValidator(path)
+	if nil != v {
+		if "" != v.etag {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if "" != v.lastModified {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+
+	resource := githubRateResource(path)
+	err = c.rate.wait(resource)
+	if nil != err {
+		return nil, err
+	}
+
 	rsp, err := c.httpClient.Do(req)
 	if nil != err {
 		return nil, err
 	}
+	c.rate.update(resource, rsp.Header)
 
-	if 404 == rsp.StatusCode {
-		return nil, ErrNotFound
-	} else if 400 <= rsp.StatusCode {
+	if c.rate.limited(rsp) {
+		rsp.Body.Close()
+		return nil, errRateLimited
+	}
+
+	if 304 == rsp.StatusCode && nil != v {
+		tracef("%s [HTTP 304]", path)
+		rsp.Body.Close()
+		rsp.StatusCode = 200
+		rsp.Body = ioutil.NopCloser(bytes.NewReader(v.content))
+		return rsp, nil
+	}
+
+	if 400 <= rsp.StatusCode {
+		rsp.Body.Close()
+		c.setValidator(path, nil)
+		if 404 == rsp.StatusCode {
+			return nil, ErrNotFound
+		}
 		return nil, errors.New(fmt.Sprintf("HTTP %d", rsp.StatusCode))
 	}
 
+	etag := rsp.Header.Get("ETag")
+	lastModified := rsp.Header.Get("Last-Modified")
+	if "" != etag || "" != lastModified {
+		content, err := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if nil != err {
+			return nil, err
+		}
+		c.setValidator(path, &githubValidator{
+			path:         path,
+			etag:         etag,
+			lastModified: lastModified,
+			content:      content,
+		})
+		rsp.Body = ioutil.NopCloser(bytes.NewReader(content))
+	}
+
 	return rsp, nil
 }
 
+func (c *githubClient) getValidator(path string) *githubValidator {
+	c.vlock.Lock()
+	defer c.vlock.Unlock()
+	if item, ok := c.validators.Get(path); ok {
+		return item.Value.(*githubValidator)
+	}
+	return nil
+}
+
+// setValidator sets (or deletes if nil) the validator of a path and discards the
+// least recently used validators beyond the limits.
+func (c *githubClient) setValidator(path string, v *githubValidator) {
+	c.vlock.Lock()
+	defer c.vlock.Unlock()
+	if item, ok := c.validators.Get(path); ok {
+		c.vsize -= int64(len(item.Value.(*githubValidator).content))
+		c.validators.Delete(path)
+	}
+	if nil == v {
+		return
+	}
+	v.Value = v
+	c.validators.Set(path, &v.MapItem, true)
+	c.vsize += int64(len(v.content))
+	c.validators.Expire(func(list, item *libcache.MapItem) bool {
+		if githubValidatorsMax >= len(c.validators.Items()) && githubValidatorsMaxSize >= c.vsize {
+			return false
+		}
+		e := item.Value.(*githubValidator)
+		c.vsize -= int64(len(e.content))
+		c.validators.Delete(e.path)
+		return true
+	})
+}
+
+// githubRateResource returns the rate limit resource of a REST API path.
+func githubRateResource(path string) string {
+	if strings.HasPrefix(path, "/search/") {
+		return "search"
+	}
+	return "core"
+}
+
+func (c *githubClient) allowBackground() bool {
+	return c.rate.allowBackground("core")
+}
+
 func (c *githubClient) sendrecvGql(query string) (*http.Response, error) {
 	var content = struct {
 		Query string `json:"query"`
@@ -180,10 +314,21 @@ func (c *githubClient) sendrecvGql(query string) (*http.Response, error) {
 		req.Header.Set("Authorization", "token "+c.token)
 	}
 
+	err = c.rate.wait("graphql")
+	if nil != err {
+		return nil, err
+	}
+
 	rsp, err := c.httpClient.Do(req)
 	if nil != err {
 		return nil, err
 	}
+	c.rate.update("graphql", rsp.Header)
+
+	if c.rate.limited(rsp) {
+		rsp.Body.Close()
+		return nil, errRateLimited
+	}
 
 	if 404 == rsp.StatusCode {
 		return nil, ErrNotFound
@@ -220,6 +365,54 @@ func (c *githubClient) getOwner(o string) (res *owner, err error) {
 	return
 }
 
+func (c *githubClient) searchCode(query string) (res []*searchResult, err error) {
+	defer trace(query)(&err)
+
+	rsp, err := c.sendrecv("/search/code?per_page=100&q=" + url.QueryEscape(query))
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	var content struct {
+		Items []struct {
+			FPath      string `json:"path"`
+			FURL       string `json:"url"`
+			Repository struct {
+				FName string `json:"name"`
+				Owner struct {
+					FLogin string `json:"login"`
+				} `json:"owner"`
+			} `json:"repository"`
+		} `json:"items"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&content)
+	if nil != err {
+		return nil, err
+	}
+
+	res = make([]*searchResult, 0, len(content.Items))
+	for _, elm := range content.Items {
+		// the url field has the form .../contents/PATH?ref=COMMIT
+		u, e := url.Parse(elm.FURL)
+		if nil != e {
+			continue
+		}
+		ref := u.Query().Get("ref")
+		if "" == ref {
+			continue
+		}
+		res = append(res, &searchResult{
+			FOwner:      elm.Repository.Owner.FLogin,
+			FRepository: elm.Repository.FName,
+			FRef:        ref,
+			FPath:       elm.FPath,
+		})
+	}
+
+	return res, nil
+}
+
 func (c *githubClient) getRepositoryPageRest(path string) ([]*repository, error) {
 	rsp, err := c.sendrecv(path)
 	if nil != err {
@@ -16,9 +16,11 @@ package prov
 import (
 	"net/url"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
+	libcache "github.com/billziss-gh/golib/cache"
 	"github.com/billziss-gh/golib/keyring"
 )
 
@@ -167,6 +169,39 @@ func TestExpiration(t *testing.T) {
 	testExpiration(t)
 }
 
+func TestGithubValidators(t *testing.T) {
+	c := &githubClient{validators: libcache.NewMap(nil)}
+	for i := 0; githubValidatorsMax+10 > i; i++ {
+		p := "/users/owner" + strconv.Itoa(i)
+		c.setValidator(p, &githubValidator{path: p, etag: "etag", content: []byte("content")})
+		if 0 == i%2 {
+			// keep even validators in use for a while
+			c.getValidator("/users/owner0")
+		}
+	}
+	if githubValidatorsMax != len(c.validators.Items()) || int64(7*githubValidatorsMax) != c.vsize {
+		t.Error(len(c.validators.Items()), c.vsize)
+	}
+	if nil == c.getValidator("/users/owner0") || nil != c.getValidator("/users/owner1") ||
+		nil == c.getValidator("/users/owner"+strconv.Itoa(githubValidatorsMax+9)) {
+		t.Error()
+	}
+
+	c.setValidator("/search/code?q=big", &githubValidator{path: "/search/code?q=big",
+		content: make([]byte, githubValidatorsMaxSize/2)})
+	c.setValidator("/search/code?q=bigger", &githubValidator{path: "/search/code?q=bigger",
+		content: make([]byte, githubValidatorsMaxSize/2)})
+	if 2 != len(c.validators.Items()) || githubValidatorsMaxSize != c.vsize {
+		t.Error(len(c.validators.Items()), c.vsize)
+	}
+
+	c.setValidator("/search/code?q=bigger", nil)
+	if nil != c.getValidator("/search/code?q=bigger") || nil == c.getValidator("/search/code?q=big") ||
+		githubValidatorsMaxSize/2 != c.vsize {
+		t.Error(c.vsize)
+	}
+}
+
 func init() {
 	atinit(func() error {
 		token, err := keyring.Get("hubfs", "github.com")
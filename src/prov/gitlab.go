@@ -163,6 +163,10 @@ func (c *gitlabClient) getIdent() string {
 	return c.ident
 }
 
+func (c *gitlabClient) getLogin() string {
+	return c.login
+}
+
 func (c *gitlabClient) getGitCredentials() (string, string) {
 	return "oauth2", c.token
 }
@@ -313,3 +317,7 @@ func (c *gitlabClient) getRepositories(owner string, kind string) (res []*reposi
 
 	return res, nil
 }
+
+func (c *gitlabClient) searchCode(query string) (res []*searchResult, err error) {
+	return nil, ErrNotFound
+}
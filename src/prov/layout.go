@@ -0,0 +1,152 @@
+/*
+ * layout.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The persistent cache directories carry a marker file with the version of their
+// layout. Caches with an older layout are migrated in place (one version at a time)
+// when the cache is configured; caches with a newer layout are rejected rather than
+// misinterpreted. Caches that predate the marker have layout version 0.
+const layoutName = "layout"
+
+// cacheLayoutVersion is the current layout version of a persistent cache directory.
+// It must equal len(cacheMigrations).
+const cacheLayoutVersion = 1
+
+// cacheMigrations[i] migrates a persistent cache directory from layout version i
+// to layout version i+1.
+var cacheMigrations = []func(dir string) error{
+	// 0 -> 1: the layout is unchanged; only the marker is added.
+	func(dir string) error { return nil },
+}
+
+func readLayoutVersion(dir string) (int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, layoutName))
+	if nil != err {
+		if !os.IsNotExist(err) {
+			return 0, err
+		}
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if nil != err || 0 > version {
+		return 0, fmt.Errorf("invalid cache layout in %s", dir)
+	}
+	return version, nil
+}
+
+func writeLayoutVersion(dir string, version int) error {
+	path := filepath.Join(dir, layoutName)
+	err := ioutil.WriteFile(path+".tmp", []byte(strconv.Itoa(version)+"\n"), 0600)
+	if nil == err {
+		err = os.Rename(path+".tmp", path)
+	}
+	if nil != err {
+		os.Remove(path + ".tmp")
+	}
+	return err
+}
+
+// checkCacheLayout ensures that a persistent cache directory has the current layout.
+// A new (or empty) directory is marked with the current layout version.
+func checkCacheLayout(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if nil != err {
+		return err
+	}
+
+	version, err := readLayoutVersion(dir)
+	if nil != err {
+		return err
+	}
+	if 0 == version {
+		if names, e := ioutil.ReadDir(dir); nil == e && 0 == len(names) {
+			version = cacheLayoutVersion
+		}
+	}
+	if cacheLayoutVersion < version {
+		return fmt.Errorf("cache layout version %d in %s is newer than supported version %d",
+			version, dir, cacheLayoutVersion)
+	}
+
+	for ; cacheLayoutVersion > version; version++ {
+		err = cacheMigrations[version](dir)
+		if nil != err {
+			return fmt.Errorf("cache layout migration in %s: %v", dir, err)
+		}
+		err = writeLayoutVersion(dir, version+1)
+		if nil != err {
+			return err
+		}
+		tracef("%s [layout %d -> %d]", dir, version, version+1)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, layoutName)); nil != err {
+		err = writeLayoutVersion(dir, version)
+	}
+	return err
+}
+
+// CheckCacheDirectory checks that a persistent cache directory is usable without
+// modifying it: the directory (or its nearest existing parent if it does not exist
+// yet) must be writable and its layout must not be newer than the supported one.
+func CheckCacheDirectory(dir string) error {
+	info, err := os.Stat(dir)
+	if nil != err {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := dir
+		for {
+			next := filepath.Dir(parent)
+			if next == parent {
+				return err
+			}
+			parent = next
+			if _, e := os.Stat(parent); nil == e {
+				return checkWritable(parent)
+			}
+		}
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	version, err := readLayoutVersion(dir)
+	if nil != err {
+		return err
+	}
+	if cacheLayoutVersion < version {
+		return fmt.Errorf("cache layout version %d in %s is newer than supported version %d",
+			version, dir, cacheLayoutVersion)
+	}
+	return checkWritable(dir)
+}
+
+func checkWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".check-*")
+	if nil != err {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
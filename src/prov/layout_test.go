@@ -0,0 +1,113 @@
+/*
+ * layout_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheLayout(t *testing.T) {
+	if cacheLayoutVersion != len(cacheMigrations) {
+		t.Fatal()
+	}
+
+	root, err := ioutil.TempDir("", "hubfs-layout-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// new directory
+	dir := filepath.Join(root, "new")
+	err = checkCacheLayout(dir)
+	if version, e := readLayoutVersion(dir); nil != err || nil != e || cacheLayoutVersion != version {
+		t.Error(version, err, e)
+	}
+
+	// unversioned directory
+	dir = filepath.Join(root, "old")
+	os.MkdirAll(dir, 0700)
+	ioutil.WriteFile(filepath.Join(dir, metadataName), []byte("{}"), 0600)
+	saved := cacheMigrations
+	migrated := []string{}
+	cacheMigrations = []func(dir string) error{
+		func(dir string) error {
+			migrated = append(migrated, dir)
+			return nil
+		},
+	}
+	err = checkCacheLayout(dir)
+	cacheMigrations = saved
+	if nil != err || 1 != len(migrated) || dir != migrated[0] {
+		t.Error(migrated, err)
+	}
+	if version, e := readLayoutVersion(dir); nil != e || cacheLayoutVersion != version {
+		t.Error(version, e)
+	}
+
+	// newer directory
+	dir = filepath.Join(root, "newer")
+	os.MkdirAll(dir, 0700)
+	writeLayoutVersion(dir, cacheLayoutVersion+1)
+	err = checkCacheLayout(dir)
+	if nil == err {
+		t.Error()
+	}
+	if version, e := readLayoutVersion(dir); nil != e || cacheLayoutVersion+1 != version {
+		t.Error(version, e)
+	}
+}
+
+func TestCheckCacheDirectory(t *testing.T) {
+	root, err := ioutil.TempDir("", "hubfs-layout-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// nonexistent directory
+	dir := filepath.Join(root, "new", "cache")
+	err = CheckCacheDirectory(dir)
+	if _, e := os.Stat(filepath.Join(root, "new")); nil != err || !os.IsNotExist(e) {
+		t.Error(err, e)
+	}
+
+	// current directory
+	dir = filepath.Join(root, "current")
+	checkCacheLayout(dir)
+	err = CheckCacheDirectory(dir)
+	if names, e := ioutil.ReadDir(dir); nil != err || nil != e || 1 != len(names) {
+		t.Error(names, err, e)
+	}
+
+	// newer directory
+	dir = filepath.Join(root, "newer")
+	os.MkdirAll(dir, 0700)
+	writeLayoutVersion(dir, cacheLayoutVersion+1)
+	err = CheckCacheDirectory(dir)
+	if nil == err {
+		t.Error()
+	}
+
+	// file
+	dir = filepath.Join(root, "file")
+	ioutil.WriteFile(dir, []byte{}, 0600)
+	err = CheckCacheDirectory(dir)
+	if nil == err {
+		t.Error()
+	}
+}
@@ -0,0 +1,45 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * lockfile_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an exclusive lock on a lock file, which is created if it does
+// not exist. The lock is held by the process until the returned function is called.
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if nil != err {
+		return nil, err
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if syscall.EINTR != err {
+			break
+		}
+	}
+	if nil != err {
+		file.Close()
+		return nil, &os.PathError{Op: "flock", Path: path, Err: err}
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
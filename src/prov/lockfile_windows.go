@@ -0,0 +1,52 @@
+//go:build windows
+// +build windows
+
+/*
+ * lockfile_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile acquires an exclusive lock on a lock file, which is created if it does
+// not exist. The lock is held by the process until the returned function is called.
+func lockFile(path string) (func(), error) {
+	const LOCKFILE_EXCLUSIVE_LOCK = 0x00000002
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if nil != err {
+		return nil, err
+	}
+	var overlapped syscall.Overlapped
+	r, _, err := procLockFileEx.Call(file.Fd(), LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if 0 == r {
+		file.Close()
+		return nil, &os.PathError{Op: "LockFileEx", Path: path, Err: err}
+	}
+	return func() {
+		var overlapped syscall.Overlapped
+		procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,270 @@
+/*
+ * metadata.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata is persisted in the client directory so that owners and repositories
+// do not have to be refetched from the provider when the file system is remounted.
+// Git objects are already persisted in the same directory by the git provider.
+// Stale entries are retained, because they are used when the provider is unreachable.
+//
+// Mounts that share a client directory each keep their own copy of the metadata in
+// memory; there is no shared cache daemon. A save merges the on-disk copy while
+// holding the lock file metadata.json.lock.
+const (
+	metadataName       = "metadata.json"
+	metadataLockSuffix = ".lock"
+)
+
+type metadata struct {
+	Owners map[string]*ownerMetadata `json:"owners"`
+}
+
+type ownerMetadata struct {
+	Name             string               `json:"name"`
+	Kind             string               `json:"kind"`
+	Time             time.Time            `json:"time"`
+	Repositories     []repositoryMetadata `json:"repositories,omitempty"`
+	RepositoriesTime time.Time            `json:"repositoriesTime,omitempty"`
+}
+
+type repositoryMetadata struct {
+	Name   string `json:"name"`
+	Remote string `json:"remote"`
+}
+
+func newMetadata() *metadata {
+	return &metadata{
+		Owners: make(map[string]*ownerMetadata),
+	}
+}
+
+func readMetadata(path string, aead cipher.AEAD) (*metadata, error) {
+	content, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	content, err = decodeCacheData(aead, content, metadataName)
+	if nil != err {
+		return nil, err
+	}
+
+	meta := newMetadata()
+	err = json.Unmarshal(content, meta)
+	if nil != err {
+		return nil, err
+	}
+	if nil == meta.Owners {
+		meta.Owners = make(map[string]*ownerMetadata)
+	}
+
+	return meta, nil
+}
+
+func writeMetadata(path string, meta *metadata, aead cipher.AEAD) error {
+	content, err := json.Marshal(meta)
+	if nil != err {
+		return err
+	}
+	content, err = encodeCacheData(aead, content, metadataName)
+	if nil != err {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if nil != err {
+		return err
+	}
+
+	err = ioutil.WriteFile(path+".tmp", content, 0600)
+	if nil == err {
+		err = os.Rename(path+".tmp", path)
+	}
+	if nil != err {
+		os.Remove(path + ".tmp")
+	}
+	return err
+}
+
+// mergeMetadata merges src metadata into dst metadata; the most recent owner and
+// repositories entries win.
+func mergeMetadata(dst *metadata, src *metadata) {
+	for k, o := range src.Owners {
+		m, ok := dst.Owners[k]
+		if !ok {
+			dst.Owners[k] = o
+			continue
+		}
+		if o.Time.After(m.Time) {
+			m.Name, m.Kind, m.Time = o.Name, o.Kind, o.Time
+		}
+		if o.RepositoriesTime.After(m.RepositoriesTime) {
+			m.Repositories, m.RepositoriesTime = o.Repositories, o.RepositoriesTime
+		}
+	}
+}
+
+func (c *client) loadMetadata() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if "" == c.dir || !c.keepdir {
+		return
+	}
+
+	meta, err := readMetadata(filepath.Join(c.dir, metadataName), c.aead)
+	if nil != err {
+		meta = newMetadata()
+	}
+	c.meta = meta
+}
+
+func (c *client) saveMetadata() {
+	if "" == c.dir || !c.keepdir {
+		return
+	}
+
+	// the directory may be shared with other mounts (processes): merge their
+	// metadata while holding the lock file, so that concurrent saves do not lose
+	// each other's entries
+	path := filepath.Join(c.dir, metadataName)
+	err := os.MkdirAll(c.dir, 0700)
+	if nil != err {
+		tracef("%s [writeMetadata() = %v]", c.dir, err)
+		return
+	}
+	unlock, err := lockFile(path + metadataLockSuffix)
+	if nil != err {
+		tracef("%s [writeMetadata() = %v]", c.dir, err)
+		return
+	}
+	defer unlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if nil == c.meta {
+		return
+	}
+
+	if meta, err := readMetadata(path, c.aead); nil == err {
+		mergeMetadata(c.meta, meta)
+	}
+
+	err = writeMetadata(path, c.meta, c.aead)
+	tracef("%s [writeMetadata() = %v]", c.dir, err)
+}
+
+func (c *client) getOwnerMetadata(name string, stale bool) (res *owner) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if nil == c.meta {
+		return
+	}
+
+	o, ok := c.meta.Owners[strings.ToUpper(name)]
+	if !ok || (!stale && !time.Now().Before(o.Time.Add(c.metattl))) {
+		return
+	}
+
+	res = &owner{
+		FName: o.Name,
+		FKind: o.Kind,
+	}
+	res.Value = res
+	return
+}
+
+func (c *client) setOwnerMetadata(o *owner) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if nil == c.meta {
+		return
+	}
+
+	k := strings.ToUpper(o.FName)
+	if m, ok := c.meta.Owners[k]; ok {
+		m.Name = o.FName
+		m.Kind = o.FKind
+		m.Time = time.Now()
+	} else {
+		c.meta.Owners[k] = &ownerMetadata{
+			Name: o.FName,
+			Kind: o.FKind,
+			Time: time.Now(),
+		}
+	}
+}
+
+func (c *client) getRepositoriesMetadata(name string, stale bool) (res []*repository) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if nil == c.meta {
+		return
+	}
+
+	o, ok := c.meta.Owners[strings.ToUpper(name)]
+	if !ok || nil == o.Repositories ||
+		(!stale && !time.Now().Before(o.RepositoriesTime.Add(c.metattl))) {
+		return
+	}
+
+	res = make([]*repository, len(o.Repositories))
+	for i, elm := range o.Repositories {
+		r := &repository{
+			FName:   elm.Name,
+			FRemote: elm.Remote,
+		}
+		r.Value = r
+		r.Repository = emptyRepository
+		r.keepdir = c.keepdir
+		res[i] = r
+	}
+	return
+}
+
+func (c *client) setRepositoriesMetadata(name string, repositories []*repository) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if nil == c.meta {
+		return
+	}
+
+	o, ok := c.meta.Owners[strings.ToUpper(name)]
+	if !ok {
+		return
+	}
+
+	o.Repositories = make([]repositoryMetadata, len(repositories))
+	for i, elm := range repositories {
+		o.Repositories[i] = repositoryMetadata{
+			Name:   elm.FName,
+			Remote: elm.FRemote,
+		}
+	}
+	o.RepositoriesTime = time.Now()
+}
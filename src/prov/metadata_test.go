@@ -0,0 +1,229 @@
+/*
+ * metadata_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-metadata-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	path := filepath.Join(dir, metadataName)
+
+	meta := newMetadata()
+	meta.Owners["FRESH"] = &ownerMetadata{
+		Name: "fresh",
+		Kind: "User",
+		Time: now,
+		Repositories: []repositoryMetadata{
+			{Name: "repo", Remote: "https://example.com/fresh/repo"},
+		},
+		RepositoriesTime: now,
+	}
+	meta.Owners["STALEREPOS"] = &ownerMetadata{
+		Name: "stalerepos",
+		Kind: "Organization",
+		Time: now,
+		Repositories: []repositoryMetadata{
+			{Name: "repo", Remote: "https://example.com/stalerepos/repo"},
+		},
+		RepositoriesTime: now.Add(-2 * time.Hour),
+	}
+	meta.Owners["STALE"] = &ownerMetadata{
+		Name: "stale",
+		Kind: "User",
+		Time: now.Add(-2 * time.Hour),
+	}
+
+	err = writeMetadata(path, meta, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	meta, err = readMetadata(path, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	if o, ok := meta.Owners["FRESH"]; !ok {
+		t.Error()
+	} else if "fresh" != o.Name || "User" != o.Kind ||
+		1 != len(o.Repositories) || "repo" != o.Repositories[0].Name {
+		t.Error()
+	}
+
+	c := &client{}
+	c.metattl = time.Hour
+	c.meta = meta
+
+	if o := c.getOwnerMetadata("Fresh", false); nil == o || "fresh" != o.FName {
+		t.Error()
+	}
+	if r := c.getRepositoriesMetadata("fresh", false); 1 != len(r) ||
+		"https://example.com/fresh/repo" != r[0].FRemote {
+		t.Error()
+	}
+
+	if o := c.getOwnerMetadata("stalerepos", false); nil == o {
+		t.Error()
+	}
+	if r := c.getRepositoriesMetadata("stalerepos", false); nil != r {
+		t.Error()
+	}
+	if r := c.getRepositoriesMetadata("stalerepos", true); 1 != len(r) {
+		t.Error()
+	}
+
+	if o := c.getOwnerMetadata("stale", false); nil != o {
+		t.Error()
+	}
+	if o := c.getOwnerMetadata("stale", true); nil == o || "User" != o.FKind {
+		t.Error()
+	}
+
+	_, err = readMetadata(filepath.Join(dir, "nonexistent"), nil)
+	if nil == err {
+		t.Error()
+	}
+}
+
+func TestMetadataEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-metadata-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	aead, err := newCacheCipher(make([]byte, CacheKeySize))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, metadataName)
+
+	meta := newMetadata()
+	meta.Owners["SECRET"] = &ownerMetadata{Name: "secret", Kind: "User", Time: time.Now()}
+	err = writeMetadata(path, meta, aead)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if nil != err || bytes.Contains(content, []byte("secret")) {
+		t.Error()
+	}
+
+	meta, err = readMetadata(path, aead)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if o, ok := meta.Owners["SECRET"]; !ok || "secret" != o.Name {
+		t.Error()
+	}
+
+	_, err = readMetadata(path, nil)
+	if nil == err {
+		t.Error()
+	}
+
+	other, _ := newCacheCipher(bytes.Repeat([]byte{1}, CacheKeySize))
+	_, err = readMetadata(path, other)
+	if nil == err {
+		t.Error()
+	}
+}
+
+func TestMetadataShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-metadata-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c1 := &client{}
+	c1.SetConfig([]string{"config.dir=" + dir})
+	c1.loadMetadata()
+	c2 := &client{}
+	c2.SetConfig([]string{"config.dir=" + dir})
+	c2.loadMetadata()
+
+	now := time.Now()
+	c1.meta.Owners["ONE"] = &ownerMetadata{Name: "one", Kind: "User", Time: now}
+	c2.meta.Owners["TWO"] = &ownerMetadata{Name: "two", Kind: "User", Time: now}
+	c1.saveMetadata()
+	c2.saveMetadata()
+
+	meta, err := readMetadata(filepath.Join(dir, metadataName), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, ok := meta.Owners["ONE"]; !ok {
+		t.Error()
+	}
+	if _, ok := meta.Owners["TWO"]; !ok {
+		t.Error()
+	}
+}
+
+func TestMetadataSharedConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-metadata-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clients := make([]*client, 8)
+	for i := range clients {
+		clients[i] = &client{}
+		clients[i].SetConfig([]string{"config.dir=" + dir})
+		clients[i].loadMetadata()
+		name := fmt.Sprintf("owner%d", i)
+		clients[i].meta.Owners[strings.ToUpper(name)] =
+			&ownerMetadata{Name: name, Kind: "User", Time: time.Now()}
+	}
+
+	// each save reads, merges and writes the file; without the lock file a save
+	// could overwrite the entries of a concurrent one
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			c.saveMetadata()
+		}(c)
+	}
+	wg.Wait()
+
+	meta, err := readMetadata(filepath.Join(dir, metadataName), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(clients) != len(meta.Owners) {
+		t.Error(len(meta.Owners))
+	}
+}
@@ -0,0 +1,379 @@
+/*
+ * object.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Objects are stored in an object directory as DIR/XX/YYYY..., where XXYYYY... is
+// the object hash. Compressed objects are stored as DIR/XX/YYYY....z and consist
+// of the uncompressed size (8 bytes, big endian) followed by a zlib stream.
+// Encrypted objects are stored as DIR/XX/YYYY....e and consist of the uncompressed
+// size followed by the sealed (AES-GCM) object flags (1 byte) and object content,
+// which is compressed if the flags say so. The hash and size are authenticated.
+// zstd is deferred until the module can depend on klauspost/compress; it would be
+// added under its own suffix.
+const (
+	compressedObjectSuffix = ".z"
+	encryptedObjectSuffix  = ".e"
+	objectFlagCompressed   = 1
+)
+
+// compressMinSize is the size below which objects are not worth compressing.
+const compressMinSize = 512
+
+// compressedMagics are the signatures of common formats that are already
+// compressed and that do not benefit from compression.
+var compressedMagics = [][]byte{
+	{0x1f, 0x8b},                                 // gzip
+	{0x28, 0xb5, 0x2f, 0xfd},                     // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},             // xz
+	{'B', 'Z', 'h'},                              // bzip2
+	{'P', 'K', 0x03, 0x04},                       // zip (jar, docx, etc.)
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c},           // 7z
+	{0x89, 'P', 'N', 'G'},                        // png
+	{0xff, 0xd8, 0xff},                           // jpeg
+	{'G', 'I', 'F', '8'},                         // gif
+	{'R', 'I', 'F', 'F'},                         // webp, etc.
+	{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p'}, // mp4
+	{0x00, 0x00, 0x00, 0x20, 'f', 't', 'y', 'p'}, // mp4
+	{'w', 'O', 'F', 'F'},                         // woff
+	{'w', 'O', 'F', '2'},                         // woff2
+}
+
+var errCorruptObject = errors.New("corrupt object")
+
+func objectPath(dir string, hash string) string {
+	if 2 < len(hash) {
+		return filepath.Join(dir, hash[:2], hash[2:])
+	}
+	return ""
+}
+
+func isCompressible(content []byte) bool {
+	if compressMinSize > len(content) {
+		return false
+	}
+	for _, m := range compressedMagics {
+		if bytes.HasPrefix(content, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func sizeHeader(size int) []byte {
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(size))
+	return hdr[:]
+}
+
+// deflate compresses content and appends it to buf. It returns the compressed data
+// or nil (and leaves buf unchanged) if compression does not save at least 1/8 of
+// the space.
+func deflate(content []byte, buf *bytes.Buffer) []byte {
+	n := buf.Len()
+	w := getZlibWriter(buf)
+	w.Write(content)
+	w.Close()
+	putZlibWriter(w)
+	if buf.Len()-n > len(content)-len(content)/8 {
+		buf.Truncate(n)
+		return nil
+	}
+	return buf.Bytes()[n:]
+}
+
+func inflate(data []byte, size uint64) ([]byte, error) {
+	if size > uint64(len(data))*1032 {
+		// larger than the maximum deflate ratio allows
+		return nil, errCorruptObject
+	}
+	r, err := getZlibReader(bytes.NewReader(data))
+	if nil != err {
+		return nil, err
+	}
+	defer putZlibReader(r)
+	defer r.Close()
+	content := make([]byte, size)
+	_, err = io.ReadFull(r, content)
+	if nil != err {
+		return nil, err
+	}
+	return content, nil
+}
+
+// encodeObject encodes the content of an object for storage. The encoded data is
+// either the content itself or is stored in buf.
+func encodeObject(hash string, content []byte, compress bool, aead cipher.AEAD,
+	buf *bytes.Buffer) (suffix string, data []byte) {
+
+	hdr := sizeHeader(len(content))
+	buf.Reset()
+	buf.Write(hdr)
+
+	var body []byte
+	if compress && isCompressible(content) {
+		body = deflate(content, buf)
+	}
+
+	if nil != aead {
+		flags := byte(0)
+		if nil != body {
+			flags = objectFlagCompressed
+		} else {
+			body = content
+		}
+		plaintext := getBuffer()
+		plaintext.WriteByte(flags)
+		plaintext.Write(body)
+		sealed, err := sealData(aead, plaintext.Bytes(), append([]byte(hash), hdr...))
+		putBuffer(plaintext)
+		if nil != err {
+			return "", nil
+		}
+		buf.Truncate(len(hdr))
+		buf.Write(sealed)
+		return encryptedObjectSuffix, buf.Bytes()
+	} else if nil != body {
+		return compressedObjectSuffix, buf.Bytes()
+	} else {
+		return "", content
+	}
+}
+
+func decodeObject(hash string, suffix string, data []byte, aead cipher.AEAD) ([]byte, error) {
+	if 8 > len(data) {
+		return nil, errCorruptObject
+	}
+	hdr, data := data[:8], data[8:]
+	size := binary.BigEndian.Uint64(hdr)
+
+	compressed := false
+	switch suffix {
+	case compressedObjectSuffix:
+		compressed = true
+	case encryptedObjectSuffix:
+		if nil == aead {
+			return nil, errDecrypt
+		}
+		plaintext, err := openData(aead, data, append([]byte(hash), hdr...))
+		if nil != err || 0 == len(plaintext) {
+			return nil, errDecrypt
+		}
+		compressed = 0 != plaintext[0]&objectFlagCompressed
+		data = plaintext[1:]
+	}
+
+	if compressed {
+		return inflate(data, size)
+	}
+	if uint64(len(data)) != size {
+		return nil, errCorruptObject
+	}
+	return data, nil
+}
+
+func writeObject(dir string, hash string, content []byte, compress bool, aead cipher.AEAD) (
+	size int64) {
+
+	p := objectPath(dir, hash)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	suffix, content := encodeObject(hash, content, compress, aead, buf)
+	if nil == content {
+		return
+	}
+	p += suffix
+	if nil == os.MkdirAll(filepath.Dir(p), 0700) {
+		// use a unique temporary file as the object directory may be shared
+		f, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+		if nil != err {
+			return
+		}
+		_, err = f.Write(content)
+		if e := f.Close(); nil == err {
+			err = e
+		}
+		if nil == err {
+			err = os.Rename(f.Name(), p)
+		}
+		if nil != err {
+			os.Remove(f.Name())
+		} else {
+			size = int64(len(content))
+		}
+	}
+	return
+}
+
+// objectSuffixes are the suffixes of the files that an object may be stored in,
+// in the order in which they are looked up.
+var objectSuffixes = []string{"", compressedObjectSuffix, encryptedObjectSuffix}
+
+// objectLookupSuffixes returns the suffixes of the files that an object is looked up
+// in. When the cache is encrypted only encrypted objects are served; objects that
+// were stored before encryption was enabled are encrypted when they are looked up
+// (see encryptPlainObject).
+func objectLookupSuffixes(dir string, hash string, aead cipher.AEAD) []string {
+	if nil == aead {
+		return objectSuffixes
+	}
+	p := objectPath(dir, hash) + encryptedObjectSuffix
+	if _, err := os.Stat(p); nil != err && os.IsNotExist(err) {
+		encryptPlainObject(dir, hash, aead)
+	}
+	return objectSuffixes[2:]
+}
+
+// encryptPlainObject encrypts an object that is stored in a plain or compressed
+// file and removes that file.
+func encryptPlainObject(dir string, hash string, aead cipher.AEAD) {
+	p := objectPath(dir, hash)
+	for _, suffix := range objectSuffixes[:2] {
+		buf := getBuffer()
+		err := readFileBuffer(p+suffix, buf)
+		if nil != err {
+			putBuffer(buf)
+			continue
+		}
+		content := buf.Bytes()
+		if "" != suffix {
+			content, err = decodeObject(hash, suffix, content, nil)
+		}
+		if nil == err && 0 == writeObject(dir, hash, content, "" != suffix, aead) {
+			err = errCorruptObject
+		}
+		putBuffer(buf)
+		if nil == err || errCorruptObject == err {
+			os.Remove(p + suffix)
+		}
+	}
+}
+
+// statObject returns the (uncompressed) size of an object.
+func statObject(dir string, hash string, aead cipher.AEAD) (int64, error) {
+	p := objectPath(dir, hash)
+	err := error(os.ErrNotExist)
+	for _, suffix := range objectLookupSuffixes(dir, hash, aead) {
+		if "" == suffix {
+			var info os.FileInfo
+			info, err = os.Stat(p)
+			if nil == err {
+				return info.Size(), nil
+			}
+			continue
+		}
+		f, e := os.Open(p + suffix)
+		if nil != e {
+			continue
+		}
+		var hdr [8]byte
+		_, e = io.ReadFull(f, hdr[:])
+		f.Close()
+		if nil != e {
+			return 0, errCorruptObject
+		}
+		return int64(binary.BigEndian.Uint64(hdr[:])), nil
+	}
+	return 0, err
+}
+
+// readObject returns the (uncompressed and decrypted) content of an object.
+func readObject(dir string, hash string, aead cipher.AEAD) ([]byte, error) {
+	p := objectPath(dir, hash)
+	err := error(os.ErrNotExist)
+	for _, suffix := range objectLookupSuffixes(dir, hash, aead) {
+		if "" == suffix {
+			var content []byte
+			content, err = ioutil.ReadFile(p)
+			if nil == err {
+				return content, nil
+			}
+			continue
+		}
+		buf := getBuffer()
+		e := readFileBuffer(p+suffix, buf)
+		if nil != e {
+			putBuffer(buf)
+			continue
+		}
+		// decoded content never aliases the (compressed or encrypted) data
+		content, err := decodeObject(hash, suffix, buf.Bytes(), aead)
+		putBuffer(buf)
+		return content, err
+	}
+	return nil, err
+}
+
+// openObject returns a reader for the (uncompressed and decrypted) content of an
+// object. Plain objects are read directly from their file; compressed or encrypted
+// objects are decoded into memory.
+func openObject(dir string, hash string, aead cipher.AEAD) (io.ReaderAt, error) {
+	if nil == aead {
+		file, err := os.Open(objectPath(dir, hash))
+		if nil == err {
+			return file, nil
+		}
+	}
+	content, err := readObject(dir, hash, aead)
+	if nil != err {
+		return nil, err
+	}
+	return readerAtNopCloser{bytes.NewReader(content)}, nil
+}
+
+// removeObject removes an object (in any of its forms) from an object directory.
+func removeObject(dir string, hash string) {
+	p := objectPath(dir, hash)
+	for _, suffix := range objectSuffixes {
+		os.Remove(p + suffix)
+	}
+}
+
+// objectTypes are the types that an object may have. The type of an object is not
+// stored, so verification tries each type in turn; blobs are the most common.
+var objectTypes = []string{"blob", "tree", "commit", "tag"}
+
+// verifyObject determines if the content of an object matches its (SHA-1) hash.
+// Objects with other kinds of hashes are not verified.
+func verifyObject(hash string, reader io.ReaderAt, size int64) bool {
+	sum, err := hex.DecodeString(hash)
+	if nil != err || sha1.Size != len(sum) {
+		return true
+	}
+	for _, t := range objectTypes {
+		h := sha1.New()
+		fmt.Fprintf(h, "%s %d\x00", t, size)
+		_, err = copyBuffer(h, io.NewSectionReader(reader, 0, size))
+		if nil != err {
+			return false
+		}
+		if bytes.Equal(sum, h.Sum(nil)) {
+			return true
+		}
+	}
+	return false
+}
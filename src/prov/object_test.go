@@ -0,0 +1,238 @@
+/*
+ * object_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func testObject(t *testing.T, dir string, hash string, content []byte, compress bool, compressed bool) {
+	size := writeObject(dir, hash, content, compress, nil)
+	if 0 == size {
+		t.Fatal()
+	}
+
+	_, err := os.Stat(objectPath(dir, hash) + compressedObjectSuffix)
+	if compressed != (nil == err) {
+		t.Error()
+	}
+	if compressed != (size < int64(len(content))) {
+		t.Error()
+	}
+
+	if s, err := statObject(dir, hash, nil); nil != err || int64(len(content)) != s {
+		t.Error(s, err)
+	}
+
+	if c, err := readObject(dir, hash, nil); nil != err || !bytes.Equal(content, c) {
+		t.Error(err)
+	}
+
+	reader, err := openObject(dir, hash, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	c := make([]byte, len(content))
+	if n, err := reader.ReadAt(c, 0); (nil != err && io.EOF != err) || len(content) != n ||
+		!bytes.Equal(content, c) {
+		t.Error(err)
+	}
+	reader.(io.Closer).Close()
+}
+
+func TestObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-object-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	text := bytes.Repeat([]byte("hello world\n"), 1000)
+	testObject(t, dir, "0123456789abcdef0123456789abcdef01234567", text, false, false)
+	testObject(t, dir, "1123456789abcdef0123456789abcdef01234567", text, true, true)
+	testObject(t, dir, "2123456789abcdef0123456789abcdef01234567", []byte("short"), true, false)
+
+	gz := append([]byte{0x1f, 0x8b}, text...)
+	testObject(t, dir, "3123456789abcdef0123456789abcdef01234567", gz, true, false)
+
+	if _, err := statObject(dir, "4123456789abcdef0123456789abcdef01234567", nil); nil == err {
+		t.Error()
+	}
+}
+
+func TestObjectEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-object-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	aead, err := newCacheCipher(make([]byte, CacheKeySize))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	text := bytes.Repeat([]byte("hello world\n"), 1000)
+	for i, compress := range []bool{false, true} {
+		hash := string('0'+rune(i)) + "123456789abcdef0123456789abcdef01234567"
+		size := writeObject(dir, hash, text, compress, aead)
+		if 0 == size || compress != (size < int64(len(text))) {
+			t.Error(size)
+		}
+
+		data, err := ioutil.ReadFile(objectPath(dir, hash) + encryptedObjectSuffix)
+		if nil != err || bytes.Contains(data, []byte("hello")) {
+			t.Error(err)
+		}
+
+		if s, err := statObject(dir, hash, aead); nil != err || int64(len(text)) != s {
+			t.Error(s, err)
+		}
+		if c, err := readObject(dir, hash, aead); nil != err || !bytes.Equal(text, c) {
+			t.Error(err)
+		}
+		if _, err := readObject(dir, hash, nil); nil == err {
+			t.Error()
+		}
+
+		// an object must not be readable under a different hash
+		other := "f" + hash[1:]
+		os.MkdirAll(filepath.Dir(objectPath(dir, other)), 0700)
+		ioutil.WriteFile(objectPath(dir, other)+encryptedObjectSuffix, data, 0600)
+		if _, err := readObject(dir, other, aead); nil == err {
+			t.Error()
+		}
+	}
+
+	// objects stored before encryption was enabled are encrypted when looked up
+	for i, compress := range []bool{false, true} {
+		hash := string('2'+rune(i)) + "123456789abcdef0123456789abcdef01234567"
+		writeObject(dir, hash, text, compress, nil)
+		if 0 == i {
+			if c, err := readObject(dir, hash, aead); nil != err || !bytes.Equal(text, c) {
+				t.Error(err)
+			}
+		} else {
+			if s, err := statObject(dir, hash, aead); nil != err || int64(len(text)) != s {
+				t.Error(s, err)
+			}
+		}
+		for _, suffix := range objectSuffixes[:2] {
+			if _, err := os.Stat(objectPath(dir, hash) + suffix); !os.IsNotExist(err) {
+				t.Error(suffix, err)
+			}
+		}
+		data, err := ioutil.ReadFile(objectPath(dir, hash) + encryptedObjectSuffix)
+		if nil != err || bytes.Contains(data, []byte("hello")) {
+			t.Error(err)
+		}
+		reader, err := openObject(dir, hash, aead)
+		if nil != err {
+			t.Fatal(err)
+		}
+		if _, ok := reader.(*os.File); ok {
+			t.Error()
+		}
+	}
+}
+
+func TestObjectConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-object-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	aead, err := newCacheCipher(make([]byte, CacheKeySize))
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	// objects are written and read concurrently, so that pooled buffers are reused
+	// across objects of different sizes and encodings
+	wg := sync.WaitGroup{}
+	for i := 0; 16 > i; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hash := strconv.FormatInt(int64(0x10+i), 16) + "23456789abcdef0123456789abcdef01234567"
+			text := bytes.Repeat([]byte(hash+"\n"), 1+i*100)
+			var a cipher.AEAD
+			if 0 != i&2 {
+				a = aead
+			}
+			writeObject(dir, hash, text, 0 != i&1, a)
+			for j := 0; 8 > j; j++ {
+				if c, err := readObject(dir, hash, a); nil != err || !bytes.Equal(text, c) {
+					t.Error(hash, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestVerifyObject(t *testing.T) {
+	// git hash-object of "hello\n"
+	hash := "ce013625030ba8dba906f756967f9e9ca394464a"
+	content := []byte("hello\n")
+	if !verifyObject(hash, bytes.NewReader(content), int64(len(content))) {
+		t.Error()
+	}
+	content = []byte("hellO\n")
+	if verifyObject(hash, bytes.NewReader(content), int64(len(content))) {
+		t.Error()
+	}
+
+	// git mktree of an empty tree
+	hash = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	if !verifyObject(hash, bytes.NewReader(nil), 0) {
+		t.Error()
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-object-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &client{}
+	c.SetConfig([]string{"config.objdir=" + dir})
+
+	good := "ce013625030ba8dba906f756967f9e9ca394464a"
+	writeObject(dir, good, []byte("hello\n"), false, nil)
+	bad := "0123456789abcdef0123456789abcdef01234567"
+	writeObject(dir, bad, []byte("hello\n"), false, nil)
+
+	count, corrupt, err := c.VerifyCache()
+	if nil != err || 2 != count || 1 != corrupt {
+		t.Error(count, corrupt, err)
+	}
+	if _, err := statObject(dir, good, nil); nil != err {
+		t.Error(err)
+	}
+	if _, err := statObject(dir, bad, nil); nil == err {
+		t.Error()
+	}
+}
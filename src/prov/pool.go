@@ -0,0 +1,110 @@
+/*
+ * pool.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"sync"
+)
+
+// The buffers and compressors used to transfer objects between the provider, the
+// object directory and the file system are pooled, so that heavy read workloads
+// (e.g. building a mounted repository) do not allocate them for every object.
+
+// poolMaxBufferSize is the capacity above which buffers are not returned to the pool,
+// so that the occasional large object does not pin its memory.
+const poolMaxBufferSize = 4 * 1024 * 1024
+
+// poolCopyBufferSize is the size of the buffers used to copy object content.
+const poolCopyBufferSize = 32 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, poolCopyBufferSize)
+		return &b
+	},
+}
+
+var zlibWriterPool sync.Pool
+var zlibReaderPool sync.Pool
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if poolMaxBufferSize >= buf.Cap() {
+		bufferPool.Put(buf)
+	}
+}
+
+// copyBuffer copies from src to dst using a pooled buffer.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	b := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(b)
+	return io.CopyBuffer(dst, src, *b)
+}
+
+func getZlibWriter(w io.Writer) *zlib.Writer {
+	if z, ok := zlibWriterPool.Get().(*zlib.Writer); ok {
+		z.Reset(w)
+		return z
+	}
+	return zlib.NewWriter(w)
+}
+
+func putZlibWriter(z *zlib.Writer) {
+	zlibWriterPool.Put(z)
+}
+
+func getZlibReader(r io.Reader) (io.ReadCloser, error) {
+	if z, ok := zlibReaderPool.Get().(io.ReadCloser); ok {
+		err := z.(zlib.Resetter).Reset(r, nil)
+		if nil != err {
+			zlibReaderPool.Put(z)
+			return nil, err
+		}
+		return z, nil
+	}
+	return zlib.NewReader(r)
+}
+
+func putZlibReader(z io.ReadCloser) {
+	zlibReaderPool.Put(z)
+}
+
+// readFileBuffer reads the contents of a file into a buffer.
+func readFileBuffer(path string, buf *bytes.Buffer) error {
+	f, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	if info, e := f.Stat(); nil == e {
+		buf.Grow(int(info.Size()) + bytes.MinRead)
+	}
+	_, err = buf.ReadFrom(f)
+	return err
+}
@@ -31,6 +31,7 @@ type Provider interface {
 
 type Client interface {
 	SetConfig(config []string) ([]string, error)
+	Reconfigure(config []string)
 	GetDirectory() string
 	GetOwners() ([]Owner, error)
 	OpenOwner(name string) (Owner, error)
@@ -38,6 +39,16 @@ type Client interface {
 	GetRepositories(owner Owner) ([]Repository, error)
 	OpenRepository(owner Owner, name string) (Repository, error)
 	CloseRepository(repository Repository)
+	SearchCode(query string) ([]SearchResult, error)
+	InvalidateRepository(owner string, name string)
+	InvalidatePath(path string)
+	ImportObjects(path string, owner string, name string) (int, error)
+	ExportCache(w io.Writer) error
+	ImportCache(r io.Reader) (int, error)
+	CollectGarbage() (int, int64, error)
+	VerifyCache() (int, int, error)
+	GetStatus() ClientStatus
+	GetCacheStats() []CacheStats
 	StartExpiration()
 	StopExpiration()
 }
@@ -75,6 +86,13 @@ type TreeEntry interface {
 	Hash() string
 }
 
+type SearchResult interface {
+	Owner() string
+	Repository() string
+	Ref() string
+	Path() string
+}
+
 type RefKind int
 
 const (
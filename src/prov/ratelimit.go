@@ -0,0 +1,151 @@
+/*
+ * ratelimit.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/winfsp/hubfs/httputil"
+)
+
+// rateLimits tracks the remaining request budget of the resources (e.g. "core",
+// "search", "graphql") of a rate limited API, as reported by the X-RateLimit-*
+// response headers. Requests are deferred when a resource is exhausted and its budget
+// resets shortly; otherwise they fail fast with errRateLimited, so that cached (stale)
+// content is served instead. A part of the budget is reserved for interactive requests:
+// background requests (e.g. revalidation of stale metadata) are not made once the
+// budget falls below the reserve. Secondary rate limits (HTTP 403 or 429 with a
+// Retry-After header) apply to all resources: no requests are made until the
+// backoff that they request has elapsed.
+type rateLimits struct {
+	lock    sync.Mutex
+	limits  map[string]*rateLimit
+	backoff time.Time
+}
+
+type rateLimit struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+const (
+	// rateLimitMaxWait is the maximum time that a request is deferred.
+	rateLimitMaxWait = 10 * time.Second
+
+	// rateLimitReserve is the percentage of the budget reserved for interactive requests.
+	rateLimitReserve = 10
+
+	// rateLimitBackoff is the backoff of a secondary rate limit without Retry-After.
+	rateLimitBackoff = time.Minute
+)
+
+// update updates the budget of a resource from the headers of a response. The
+// X-RateLimit-Resource header (if any) overrides the resource.
+func (r *rateLimits) update(resource string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if nil != err {
+		return
+	}
+	limit, _ := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	reset, _ := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if s := header.Get("X-RateLimit-Resource"); "" != s {
+		resource = s
+	}
+
+	r.lock.Lock()
+	if nil == r.limits {
+		r.limits = make(map[string]*rateLimit)
+	}
+	r.limits[resource] = &rateLimit{
+		limit:     limit,
+		remaining: remaining,
+		reset:     time.Unix(reset, 0),
+	}
+	r.lock.Unlock()
+}
+
+// limited determines if a response failed because of a rate limit. A secondary rate
+// limit also starts a backoff.
+func (r *rateLimits) limited(rsp *http.Response) bool {
+	if 403 != rsp.StatusCode && 429 != rsp.StatusCode {
+		return false
+	}
+	if "0" == rsp.Header.Get("X-RateLimit-Remaining") {
+		return true
+	}
+	d := httputil.RetryAfter(rsp.Header)
+	if 0 == d {
+		if 403 == rsp.StatusCode && "" == rsp.Header.Get("Retry-After") {
+			return false
+		}
+		d = rateLimitBackoff
+	}
+
+	r.lock.Lock()
+	backoff := time.Now().Add(d)
+	if backoff.After(r.backoff) {
+		r.backoff = backoff
+	}
+	r.lock.Unlock()
+	tracef("[secondary rate limit; backing off %v]", d)
+	return true
+}
+
+// wait waits until a request for a resource can be made. It returns errRateLimited
+// if the budget of the resource is exhausted (or a backoff is in progress) and does
+// not reset soon.
+func (r *rateLimits) wait(resource string) error {
+	r.lock.Lock()
+	l := r.limits[resource]
+	until := r.backoff
+	r.lock.Unlock()
+
+	if nil != l && 0 >= l.remaining && l.reset.After(until) {
+		until = l.reset
+	}
+	d := time.Until(until)
+	if 0 >= d {
+		return nil
+	}
+	if rateLimitMaxWait < d {
+		return errRateLimited
+	}
+	tracef("%s [rate limited; waiting %v]", resource, d)
+	time.Sleep(d)
+	return nil
+}
+
+// allowBackground determines if the budget of a resource is sufficient for background
+// requests.
+func (r *rateLimits) allowBackground(resource string) bool {
+	r.lock.Lock()
+	l := r.limits[resource]
+	backoff := r.backoff
+	r.lock.Unlock()
+
+	if time.Now().Before(backoff) {
+		return false
+	}
+	if nil == l || 0 >= l.limit || !time.Now().Before(l.reset) {
+		return true
+	}
+	return l.remaining*100 > l.limit*rateLimitReserve
+}
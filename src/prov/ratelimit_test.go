@@ -0,0 +1,119 @@
+/*
+ * ratelimit_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func rateLimitHeader(limit int, remaining int, reset time.Time, resource string) http.Header {
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if "" != resource {
+		header.Set("X-RateLimit-Resource", resource)
+	}
+	return header
+}
+
+func TestRateLimits(t *testing.T) {
+	var r rateLimits
+
+	if nil != r.wait("core") || !r.allowBackground("core") {
+		t.Error()
+	}
+
+	reset := time.Now().Add(time.Hour)
+	r.update("core", http.Header{})
+	if nil != r.wait("core") || !r.allowBackground("core") {
+		t.Error()
+	}
+
+	r.update("core", rateLimitHeader(5000, 1000, reset, ""))
+	if nil != r.wait("core") || !r.allowBackground("core") {
+		t.Error()
+	}
+
+	r.update("core", rateLimitHeader(5000, 400, reset, ""))
+	if nil != r.wait("core") || r.allowBackground("core") {
+		t.Error()
+	}
+
+	r.update("core", rateLimitHeader(5000, 0, reset, ""))
+	if errRateLimited != r.wait("core") || r.allowBackground("core") {
+		t.Error()
+	}
+
+	// other resources have their own budget
+	if nil != r.wait("search") {
+		t.Error()
+	}
+	r.update("core", rateLimitHeader(30, 0, reset, "search"))
+	if errRateLimited != r.wait("search") {
+		t.Error()
+	}
+
+	// expired budget
+	r.update("core", rateLimitHeader(5000, 0, time.Now().Add(-time.Second), ""))
+	if nil != r.wait("core") || !r.allowBackground("core") {
+		t.Error()
+	}
+}
+
+func TestSecondaryRateLimit(t *testing.T) {
+	var r rateLimits
+
+	rsp := &http.Response{StatusCode: 403, Header: http.Header{}}
+	if r.limited(rsp) {
+		t.Error()
+	}
+	rsp.StatusCode = 200
+	rsp.Header.Set("Retry-After", "60")
+	if r.limited(rsp) {
+		t.Error()
+	}
+	if _, backoff := r.status(); !backoff.IsZero() {
+		t.Error()
+	}
+
+	rsp.StatusCode = 403
+	if !r.limited(rsp) {
+		t.Error()
+	}
+	if errRateLimited != r.wait("core") || errRateLimited != r.wait("search") ||
+		r.allowBackground("core") {
+		t.Error()
+	}
+	if _, backoff := r.status(); time.Until(backoff) < 50*time.Second {
+		t.Error(backoff)
+	}
+
+	// short backoff is waited out
+	r = rateLimits{}
+	rsp.Header.Set("Retry-After", "1")
+	if !r.limited(rsp) {
+		t.Error()
+	}
+	start := time.Now()
+	if nil != r.wait("core") || time.Since(start) < 500*time.Millisecond {
+		t.Error()
+	}
+	if !r.allowBackground("core") {
+		t.Error()
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * snapshot.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A cache snapshot is a gzip compressed tar archive of the persistent cache. Files
+// in the client directory are archived under "cache/" and files in the shared object
+// directory under "objdir/". The writable (files) directories of repositories and
+// temporary files are not archived. The first entry of the archive is the layout
+// version of the archived cache (see layout.go); snapshots of a different layout
+// cannot be imported.
+const (
+	snapshotCachePrefix  = "cache/"
+	snapshotObjdirPrefix = "objdir/"
+)
+
+var errNoPersistentCache = errors.New("no persistent cache directory")
+
+func (c *client) snapshotDirs() (dir string, objdir string) {
+	c.lock.Lock()
+	if c.keepdir {
+		dir = c.dir
+	}
+	objdir = c.objdir
+	c.lock.Unlock()
+	return
+}
+
+// ExportCache writes a snapshot of the persistent cache to w.
+func (c *client) ExportCache(w io.Writer) (err error) {
+	dir, objdir := c.snapshotDirs()
+	if "" == dir && "" == objdir {
+		return errNoPersistentCache
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	version := []byte(strconv.Itoa(cacheLayoutVersion) + "\n")
+	err = tw.WriteHeader(&tar.Header{
+		Name:     layoutName,
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		Size:     int64(len(version)),
+	})
+	if nil == err {
+		_, err = tw.Write(version)
+	}
+	if nil == err && "" != dir {
+		err = exportDir(tw, dir, snapshotCachePrefix)
+	}
+	if nil == err && "" != objdir {
+		err = exportDir(tw, objdir, snapshotObjdirPrefix)
+	}
+
+	if e := tw.Close(); nil == err {
+		err = e
+	}
+	if e := gw.Close(); nil == err {
+		err = e
+	}
+	return
+}
+
+func exportDir(tw *tar.Writer, root string, prefix string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if nil != err {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if nil != err {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			// skip writable directories: owner/repo/files
+			if l := strings.Split(rel, "/"); 3 == len(l) && "files" == l[2] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(rel, ".tmp") || layoutName == rel {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if nil != err {
+			return err
+		}
+		hdr.Name = prefix + rel
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		err = tw.WriteHeader(hdr)
+		if nil != err {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if nil != err {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		return err
+	})
+}
+
+// ImportCache reads a snapshot of a persistent cache from r and adds its content to
+// the persistent cache. Existing files are not overwritten; metadata is merged.
+// It returns the number of files imported.
+func (c *client) ImportCache(r io.Reader) (count int, err error) {
+	dir, objdir := c.snapshotDirs()
+	if "" == dir && "" == objdir {
+		return 0, errNoPersistentCache
+	}
+
+	gr, err := gzip.NewReader(r)
+	if nil != err {
+		return 0, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			return count, err
+		}
+		if tar.TypeReg != hdr.Typeflag && tar.TypeRegA != hdr.Typeflag {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || ".." == name || strings.HasPrefix(name, "../") {
+			return count, errors.New("invalid snapshot entry: " + hdr.Name)
+		}
+
+		if layoutName == name {
+			content, err := ioutil.ReadAll(io.LimitReader(tr, 64))
+			if nil != err {
+				return count, err
+			}
+			version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+			if nil != err || cacheLayoutVersion != version {
+				return count, errors.New("incompatible snapshot layout")
+			}
+			continue
+		}
+
+		var p string
+		if strings.HasPrefix(name, snapshotCachePrefix) && "" != dir {
+			name = name[len(snapshotCachePrefix):]
+			if metadataName == name {
+				err = c.importMetadata(filepath.Join(dir, metadataName), tr)
+				if nil != err {
+					return count, err
+				}
+				count++
+				continue
+			}
+			p = filepath.Join(dir, filepath.FromSlash(name))
+		} else if strings.HasPrefix(name, snapshotObjdirPrefix) && "" != objdir {
+			name = name[len(snapshotObjdirPrefix):]
+			p = filepath.Join(objdir, filepath.FromSlash(name))
+		} else {
+			continue
+		}
+
+		if _, err := os.Stat(p); nil == err {
+			continue
+		}
+		err = importFile(p, tr)
+		if nil != err {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+func importFile(p string, r io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if nil != err {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if nil != err {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if e := f.Close(); nil == err {
+		err = e
+	}
+	if nil == err {
+		err = os.Rename(f.Name(), p)
+	}
+	if nil != err {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// importMetadata merges imported metadata with existing metadata.
+func (c *client) importMetadata(p string, r io.Reader) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), metadataName+".*.tmp")
+	if nil != err {
+		if os.IsNotExist(err) {
+			return importFile(p, r)
+		}
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if nil != err {
+		return err
+	}
+
+	imported, err := readMetadata(tmp.Name(), c.aead)
+	if nil != err {
+		return err
+	}
+	meta, err := readMetadata(p, c.aead)
+	if nil != err {
+		meta = newMetadata()
+	}
+
+	mergeMetadata(meta, imported)
+
+	return writeMetadata(p, meta, c.aead)
+}
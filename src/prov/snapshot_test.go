@@ -0,0 +1,89 @@
+/*
+ * snapshot_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	root, err := ioutil.TempDir("", "hubfs-snapshot-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	src := &client{}
+	src.SetConfig([]string{"config.dir=" + filepath.Join(root, "src")})
+	dst := &client{}
+	dst.SetConfig([]string{"config.dir=" + filepath.Join(root, "dst")})
+
+	now := time.Now()
+	meta := newMetadata()
+	meta.Owners["OWNER"] = &ownerMetadata{Name: "owner", Kind: "User", Time: now}
+	writeMetadata(filepath.Join(src.dir, metadataName), meta, nil)
+	meta = newMetadata()
+	meta.Owners["OTHER"] = &ownerMetadata{Name: "other", Kind: "User", Time: now}
+	writeMetadata(filepath.Join(dst.dir, metadataName), meta, nil)
+
+	hash := "0123456789abcdef0123456789abcdef01234567"
+	writeObject(filepath.Join(src.dir, "owner", "repo", "objects"), hash, []byte("content"), false, nil)
+	os.MkdirAll(filepath.Join(src.dir, "owner", "repo", "files", "master"), 0700)
+	ioutil.WriteFile(filepath.Join(src.dir, "owner", "repo", "files", "master", ".keep"), nil, 0600)
+
+	var buf bytes.Buffer
+	err = src.ExportCache(&buf)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	count, err := dst.ImportCache(bytes.NewReader(buf.Bytes()))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if 2 != count {
+		t.Error(count)
+	}
+
+	content, err := readObject(filepath.Join(dst.dir, "owner", "repo", "objects"), hash, nil)
+	if nil != err || "content" != string(content) {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst.dir, "owner", "repo", "files")); nil == err {
+		t.Error()
+	}
+
+	meta, err = readMetadata(filepath.Join(dst.dir, metadataName), nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if _, ok := meta.Owners["OWNER"]; !ok {
+		t.Error()
+	}
+	if _, ok := meta.Owners["OTHER"]; !ok {
+		t.Error()
+	}
+
+	// importing again does not import existing files
+	count, err = dst.ImportCache(bytes.NewReader(buf.Bytes()))
+	if nil != err || 1 != count {
+		t.Error(count, err)
+	}
+}
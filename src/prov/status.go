@@ -0,0 +1,316 @@
+/*
+ * status.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+// ClientStatus is a snapshot of the state of a client as reported by GetStatus.
+type ClientStatus struct {
+	Ident       string            `json:"ident"`
+	Login       string            `json:"login"`
+	Offline     bool              `json:"offline"`
+	Reachable   bool              `json:"reachable"`
+	RateLimits  []RateLimitStatus `json:"ratelimits"`
+	Backoff     *time.Time        `json:"backoff,omitempty"`
+	CacheMemory int64             `json:"cachememory"`
+	CacheDisk   int64             `json:"cachedisk"`
+	CacheItems  int               `json:"cacheitems"`
+	InFlight    []RequestStatus   `json:"inflight"`
+	Errors      []ErrorStatus     `json:"errors"`
+}
+
+// RateLimitStatus is the remaining request budget of a rate limited resource.
+type RateLimitStatus struct {
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// RequestStatus is a request to the provider (or git remote) that is in progress.
+type RequestStatus struct {
+	Start time.Time `json:"start"`
+	Op    string    `json:"op"`
+	Path  string    `json:"path"`
+}
+
+// ErrorStatus is an error of a request to the provider (or git remote).
+type ErrorStatus struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`
+	Path    string    `json:"path"`
+	Message string    `json:"message"`
+}
+
+// loginReporter is implemented by a clientApi that knows the login of the
+// authenticated user.
+type loginReporter interface {
+	getLogin() string
+}
+
+// rateLimitReporter is implemented by a clientApi that tracks its rate limits. It
+// reports the budgets of its resources and the end of the backoff of a secondary
+// rate limit (zero if there is none).
+type rateLimitReporter interface {
+	getRateLimits() ([]RateLimitStatus, time.Time)
+}
+
+// errorLogSize is the number of most recent errors that are kept.
+const errorLogSize = 16
+
+// errorRepeatWindow is the period over which repeats of an error are summarized in
+// a single log record.
+var errorRepeatWindow = time.Minute
+
+// errorRepeat counts the repeats of an error that have not been logged.
+type errorRepeat struct {
+	op     string
+	msg    string
+	count  int
+	path   string
+	window time.Duration
+	timer  *time.Timer
+}
+
+// errorLog keeps the most recent errors of requests to the provider. Every request
+// is also logged as an operation record; failed requests are logged as warnings.
+// Nonexistent names (ErrNotFound) are not errors. A nil errorLog discards errors.
+// The provider is considered unreachable if the most recent request failed with a
+// network error. Requests that have begun but are not yet recorded are in flight.
+//
+// An error that repeats (e.g. a 403 for every request while rate limited) is
+// logged when it first occurs; its repeats (same operation and message) within the
+// following errorRepeatWindow are not logged individually, but summarized in a
+// single record at the end of the window (or when the errorLog is flushed).
+type errorLog struct {
+	provider    string
+	lock        sync.Mutex
+	errors      []ErrorStatus
+	next        int
+	unreachable bool
+	inflight    map[RequestStatus]int
+	repeats     map[string]*errorRepeat
+}
+
+// begin begins a request and returns its start time, which must be passed to record
+// when the request completes.
+func (l *errorLog) begin(op string, path string) time.Time {
+	start := time.Now()
+	if nil == l {
+		return start
+	}
+	l.lock.Lock()
+	if nil == l.inflight {
+		l.inflight = make(map[RequestStatus]int)
+	}
+	l.inflight[RequestStatus{Start: start, Op: op, Path: path}]++
+	l.lock.Unlock()
+	return start
+}
+
+// record records a request that started at the specified time.
+func (l *errorLog) record(op string, path string, start time.Time, err error) {
+	if nil == l {
+		return
+	}
+	var neterr net.Error
+	l.lock.Lock()
+	l.unreachable = errors.As(err, &neterr)
+	k := RequestStatus{Start: start, Op: op, Path: path}
+	if n := l.inflight[k]; 1 < n {
+		l.inflight[k] = n - 1
+	} else {
+		delete(l.inflight, k)
+	}
+	l.lock.Unlock()
+	if nil == err || ErrNotFound == err {
+		util.RecordSpan(nil, "provider."+op, start, nil, "path", path, "provider", l.provider)
+		util.LogOp(util.LogInfo, op, path, l.provider, time.Since(start), err)
+		return
+	}
+	util.RecordSpan(nil, "provider."+op, start, err, "path", path, "provider", l.provider)
+	if !l.repeated(op, path, err.Error()) {
+		util.LogOp(util.LogWarn, op, path, l.provider, time.Since(start), err)
+	}
+	e := ErrorStatus{
+		Time:    time.Now(),
+		Op:      op,
+		Path:    path,
+		Message: err.Error(),
+	}
+	l.lock.Lock()
+	if errorLogSize > len(l.errors) {
+		l.errors = append(l.errors, e)
+	} else {
+		l.errors[l.next] = e
+	}
+	l.next = (l.next + 1) % errorLogSize
+	l.lock.Unlock()
+}
+
+// repeated determines if an error repeats an error that was logged within the
+// current errorRepeatWindow, in which case it is counted rather than logged.
+func (l *errorLog) repeated(op string, path string, msg string) bool {
+	key := op + ": " + msg
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if r, ok := l.repeats[key]; ok {
+		r.count++
+		r.path = path
+		return true
+	}
+	if nil == l.repeats {
+		l.repeats = make(map[string]*errorRepeat)
+	}
+	r := &errorRepeat{op: op, msg: msg, window: errorRepeatWindow}
+	l.repeats[key] = r
+	r.timer = time.AfterFunc(r.window, func() {
+		l.lock.Lock()
+		if l.repeats[key] == r {
+			delete(l.repeats, key)
+		}
+		l.lock.Unlock()
+		l.logRepeat(r)
+	})
+	return false
+}
+
+// flush stops counting the repeats of errors and logs the repeats counted so far.
+func (l *errorLog) flush() {
+	if nil == l {
+		return
+	}
+	l.lock.Lock()
+	repeats := l.repeats
+	l.repeats = nil
+	l.lock.Unlock()
+	for _, r := range repeats {
+		if r.timer.Stop() {
+			l.logRepeat(r)
+		}
+	}
+}
+
+func (l *errorLog) logRepeat(r *errorRepeat) {
+	l.lock.Lock()
+	count, path := r.count, r.path
+	l.lock.Unlock()
+	if 0 < count {
+		util.Logf(util.LogWarn, "%s error repeated %d times in %v [%s, last %s]: %s",
+			r.op, count, r.window, l.provider, path, r.msg)
+	}
+}
+
+// reachable determines if the provider was reachable at the most recent request.
+func (l *errorLog) reachable() bool {
+	if nil == l {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return !l.unreachable
+}
+
+// pending returns the requests in flight, oldest first.
+func (l *errorLog) pending() []RequestStatus {
+	if nil == l {
+		return nil
+	}
+	l.lock.Lock()
+	res := make([]RequestStatus, 0, len(l.inflight))
+	for k, n := range l.inflight {
+		for ; 0 < n; n-- {
+			res = append(res, k)
+		}
+	}
+	l.lock.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Start.Before(res[j].Start)
+	})
+	return res
+}
+
+// list returns the recorded errors, most recent first.
+func (l *errorLog) list() []ErrorStatus {
+	if nil == l {
+		return nil
+	}
+	l.lock.Lock()
+	n := len(l.errors)
+	res := make([]ErrorStatus, n)
+	for i := range res {
+		res[i] = l.errors[(l.next-1-i+n)%n]
+	}
+	l.lock.Unlock()
+	return res
+}
+
+// status returns the budgets of the tracked resources sorted by resource and the end
+// of the current backoff (zero if there is none).
+func (r *rateLimits) status() ([]RateLimitStatus, time.Time) {
+	r.lock.Lock()
+	backoff := r.backoff
+	res := make([]RateLimitStatus, 0, len(r.limits))
+	for resource, l := range r.limits {
+		res = append(res, RateLimitStatus{
+			Resource:  resource,
+			Limit:     l.limit,
+			Remaining: l.remaining,
+			Reset:     l.reset,
+		})
+	}
+	r.lock.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Resource < res[j].Resource
+	})
+	if !time.Now().Before(backoff) {
+		backoff = time.Time{}
+	}
+	return res, backoff
+}
+
+// GetStatus returns the provider identity, rate limits (and secondary rate limit
+// backoff), cache sizes, requests in flight and recent errors of the client.
+func (c *client) GetStatus() ClientStatus {
+	s := ClientStatus{
+		Ident:     c.api.getIdent(),
+		Offline:   c.offline,
+		Reachable: c.errlog.reachable(),
+		InFlight:  c.errlog.pending(),
+		Errors:    c.errlog.list(),
+	}
+	if l, ok := c.api.(loginReporter); ok {
+		s.Login = l.getLogin()
+	}
+	if l, ok := c.api.(rateLimitReporter); ok {
+		var backoff time.Time
+		s.RateLimits, backoff = l.getRateLimits()
+		if !backoff.IsZero() {
+			s.Backoff = &backoff
+		}
+	}
+	c.lock.Lock()
+	s.CacheMemory, s.CacheDisk = c.cache.cacheSize()
+	s.CacheItems = c.cache.cacheItems()
+	c.lock.Unlock()
+	return s
+}
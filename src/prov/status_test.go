@@ -0,0 +1,182 @@
+/*
+ * status_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/winfsp/hubfs/util"
+)
+
+func TestErrorLog(t *testing.T) {
+	var l *errorLog
+	l.record("op", "/path", time.Now(), errors.New("error"))
+	if nil != l.list() {
+		t.Error()
+	}
+
+	l = &errorLog{}
+	l.record("op", "/path", time.Now(), nil)
+	l.record("op", "/path", time.Now(), ErrNotFound)
+	if 0 != len(l.list()) {
+		t.Error()
+	}
+
+	for i := 0; errorLogSize+4 > i; i++ {
+		l.record("op", "/path", time.Now(), fmt.Errorf("error %d", i))
+	}
+	errs := l.list()
+	if errorLogSize != len(errs) {
+		t.Error(len(errs))
+	}
+	if fmt.Sprintf("error %d", errorLogSize+3) != errs[0].Message ||
+		"error 4" != errs[len(errs)-1].Message {
+		t.Error(errs[0].Message, errs[len(errs)-1].Message)
+	}
+}
+
+func TestErrorLogReachable(t *testing.T) {
+	l := &errorLog{}
+	if !l.reachable() {
+		t.Error()
+	}
+	l.record("op", "/path", time.Now(), &net.OpError{Op: "dial", Err: errors.New("refused")})
+	if l.reachable() {
+		t.Error()
+	}
+	l.record("op", "/path", time.Now(), errors.New("HTTP 500"))
+	if !l.reachable() {
+		t.Error()
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that may be written by the log while it is read.
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf.Reset()
+}
+
+func TestErrorLogRepeats(t *testing.T) {
+	var buf lockedBuffer
+	util.SetLogOutput(&buf)
+	defer util.SetLogOutput(os.Stderr)
+	defer func(window time.Duration) { errorRepeatWindow = window }(errorRepeatWindow)
+	errorRepeatWindow = 100 * time.Millisecond
+
+	l := &errorLog{provider: "github.com"}
+	for i := 0; 1000 > i; i++ {
+		l.record("owner", fmt.Sprintf("/owner%d", i), time.Now(), errors.New("HTTP 403"))
+	}
+	l.record("repositories", "/owner/", time.Now(), errors.New("HTTP 403"))
+	if n := strings.Count(buf.String(), "\n"); 2 != n {
+		t.Error(buf.String())
+	}
+	if errorLogSize != len(l.list()) {
+		t.Error(len(l.list()))
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if 3 != len(lines) || !strings.HasSuffix(lines[2],
+		"owner error repeated 999 times in 100ms [github.com, last /owner999]: HTTP 403") {
+		t.Error(buf.String())
+	}
+
+	buf.Reset()
+	l.record("owner", "/owner", time.Now(), errors.New("HTTP 403"))
+	if n := strings.Count(buf.String(), "\n"); 1 != n {
+		t.Error(buf.String())
+	}
+
+	errorRepeatWindow = time.Hour
+	l.record("search", "/search1", time.Now(), errors.New("HTTP 422"))
+	l.record("search", "/search2", time.Now(), errors.New("HTTP 422"))
+	l.flush()
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if 3 != len(lines) || !strings.HasSuffix(lines[2],
+		"search error repeated 1 times in 1h0m0s [github.com, last /search2]: HTTP 422") {
+		t.Error(buf.String())
+	}
+	l.record("search", "/search3", time.Now(), errors.New("HTTP 422"))
+	l.flush()
+	if n := strings.Count(buf.String(), "\n"); 4 != n {
+		t.Error(buf.String())
+	}
+}
+
+func TestErrorLogPending(t *testing.T) {
+	l := &errorLog{}
+	start1 := l.begin("fetch", "/owner/repo")
+	start2 := l.begin("owner", "/owner")
+	if p := l.pending(); 2 != len(p) ||
+		"fetch" != p[0].Op || "/owner/repo" != p[0].Path || start1 != p[0].Start ||
+		"owner" != p[1].Op || "/owner" != p[1].Path || start2 != p[1].Start {
+		t.Error(p)
+	}
+	l.record("fetch", "/owner/repo", start1, nil)
+	if p := l.pending(); 1 != len(p) || "owner" != p[0].Op {
+		t.Error(p)
+	}
+	l.record("owner", "/owner", start2, ErrNotFound)
+	if p := l.pending(); 0 != len(p) {
+		t.Error(p)
+	}
+}
+
+func TestRateLimitsStatus(t *testing.T) {
+	var r rateLimits
+	if s, backoff := r.status(); 0 != len(s) || !backoff.IsZero() {
+		t.Error()
+	}
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "5000")
+	header.Set("X-RateLimit-Remaining", "4999")
+	header.Set("X-RateLimit-Reset", "1600000000")
+	r.update("search", header)
+	r.update("core", header)
+
+	s, _ := r.status()
+	if 2 != len(s) || "core" != s[0].Resource || "search" != s[1].Resource ||
+		5000 != s[0].Limit || 4999 != s[0].Remaining || 1600000000 != s[0].Reset.Unix() {
+		t.Error(s)
+	}
+}
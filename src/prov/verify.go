@@ -0,0 +1,98 @@
+/*
+ * verify.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyCache verifies all objects in the persistent cache against their hashes and
+// removes corrupt objects (which are fetched again when next needed). It returns the
+// number of objects verified and the number of corrupt objects removed.
+func (c *client) VerifyCache() (count int, corrupt int, err error) {
+	dir, objdir := c.snapshotDirs()
+	if "" == dir && "" == objdir {
+		return 0, 0, errNoPersistentCache
+	}
+
+	c.lock.Lock()
+	aead := c.aead
+	c.lock.Unlock()
+
+	dirs := []string{}
+	if "" != objdir {
+		dirs = append(dirs, objdir)
+	}
+	if "" != dir {
+		list, _ := filepath.Glob(filepath.Join(dir, "*", "*", "objects"))
+		dirs = append(dirs, list...)
+	}
+
+	for _, d := range dirs {
+		n, m, e := verifyObjects(d, aead)
+		count += n
+		corrupt += m
+		if nil != e {
+			return count, corrupt, e
+		}
+	}
+
+	return
+}
+
+func verifyObjects(dir string, aead cipher.AEAD) (count int, corrupt int, err error) {
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if nil != err {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if nil != err {
+			return err
+		}
+		l := strings.Split(filepath.ToSlash(rel), "/")
+		if 2 != len(l) || 2 != len(l[0]) {
+			return nil
+		}
+		hash := strings.TrimSuffix(l[0]+l[1], compressedObjectSuffix)
+		if strings.HasSuffix(hash, encryptedObjectSuffix) {
+			if nil == aead {
+				// cannot verify encrypted objects without the cache key
+				return nil
+			}
+			hash = strings.TrimSuffix(hash, encryptedObjectSuffix)
+		}
+
+		content, err := readObject(dir, hash, aead)
+		if nil != err ||
+			!verifyObject(hash, bytes.NewReader(content), int64(len(content))) {
+			removeObject(dir, hash)
+			tracef("%s [corrupt object %s]", dir, hash)
+			corrupt++
+		}
+		count++
+		return nil
+	})
+	return
+}
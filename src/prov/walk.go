@@ -0,0 +1,113 @@
+/*
+ * walk.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"sync"
+)
+
+// DefaultWalkers is the default number of goroutines that walk a tree concurrently.
+const DefaultWalkers = 4
+
+type treeWalker struct {
+	repository Repository
+	ref        Ref
+	fn         func(entry TreeEntry) error
+	sem        chan struct{}
+	wg         sync.WaitGroup
+	lock       sync.Mutex
+	err        error
+}
+
+// WalkTree walks the tree of a ref (if entry is nil) or of a tree entry and calls fn
+// for every entry below it. Subdirectories are walked up to depth levels below the
+// tree (-1 for no limit). Subtrees are walked and fn is called concurrently by up to
+// walkers goroutines; the walk stops at the first error.
+func WalkTree(repository Repository, ref Ref, entry TreeEntry, depth int, walkers int,
+	fn func(entry TreeEntry) error) error {
+
+	if 1 > walkers {
+		walkers = 1
+	}
+	w := &treeWalker{
+		repository: repository,
+		ref:        ref,
+		fn:         fn,
+		sem:        make(chan struct{}, walkers-1),
+	}
+	w.walk(entry, depth)
+	w.wg.Wait()
+	return w.err
+}
+
+// do runs fn in a new goroutine if one is available; otherwise it runs fn inline.
+// It never blocks, so a walker waiting for a goroutine cannot deadlock the walk.
+func (w *treeWalker) do(fn func()) {
+	select {
+	case w.sem <- struct{}{}:
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			defer func() { <-w.sem }()
+			fn()
+		}()
+	default:
+		fn()
+	}
+}
+
+func (w *treeWalker) failed() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return nil != w.err
+}
+
+func (w *treeWalker) fail(err error) {
+	w.lock.Lock()
+	if nil == w.err {
+		w.err = err
+	}
+	w.lock.Unlock()
+}
+
+func (w *treeWalker) walk(entry TreeEntry, depth int) {
+	if w.failed() {
+		return
+	}
+
+	tree, err := w.repository.GetTree(w.ref, entry)
+	if nil != err {
+		w.fail(err)
+		return
+	}
+
+	for _, e := range tree {
+		e := e
+		if nil != w.fn {
+			w.do(func() {
+				if w.failed() {
+					return
+				}
+				if err := w.fn(e); nil != err {
+					w.fail(err)
+				}
+			})
+		}
+		if 0040000 == e.Mode()&0170000 && 0 != depth {
+			w.do(func() {
+				w.walk(e, depth-1)
+			})
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * walk_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/winfsp/hubfs/git"
+)
+
+// testWalkRepository is a repository whose trees have fanout subdirectories and
+// fanout files per level, levels deep.
+type testWalkRepository struct {
+	emptyRepositoryT
+	fanout int
+	levels int
+	fail   string
+	active int32
+	maxact int32
+	listed int32
+}
+
+func (r *testWalkRepository) GetTree(ref Ref, entry TreeEntry) ([]TreeEntry, error) {
+	n := atomic.AddInt32(&r.active, 1)
+	defer atomic.AddInt32(&r.active, -1)
+	for {
+		m := atomic.LoadInt32(&r.maxact)
+		if n <= m || atomic.CompareAndSwapInt32(&r.maxact, m, n) {
+			break
+		}
+	}
+	atomic.AddInt32(&r.listed, 1)
+
+	path := ""
+	if nil != entry {
+		path = entry.Hash()
+	}
+	if "" != r.fail && r.fail == path {
+		return nil, errors.New("fail")
+	}
+	level := strings.Count(path, "/")
+	tree := []TreeEntry{}
+	for i := 0; r.fanout > i; i++ {
+		name := string(rune('a' + i))
+		tree = append(tree, &gitTreeEntry{
+			entry: git.TreeEntry{Name: name, Mode: 0100644, Hash: path + "/" + name + ".f"}})
+		if r.levels > level {
+			tree = append(tree, &gitTreeEntry{
+				entry: git.TreeEntry{Name: name, Mode: 0040000, Hash: path + "/" + name}})
+		}
+	}
+	return tree, nil
+}
+
+func TestWalkTree(t *testing.T) {
+	r := &testWalkRepository{fanout: 3, levels: 3}
+	lock := sync.Mutex{}
+	files := map[string]bool{}
+	err := WalkTree(r, nil, nil, -1, 4, func(e TreeEntry) error {
+		if 0040000 != e.Mode() {
+			lock.Lock()
+			files[e.Hash()] = true
+			lock.Unlock()
+		}
+		return nil
+	})
+	if nil != err {
+		t.Error(err)
+	}
+	// 1 + 3 + 9 + 27 trees with 3 files each
+	if 40 != r.listed || 120 != len(files) {
+		t.Error(r.listed, len(files))
+	}
+	if 4 < r.maxact {
+		t.Error(r.maxact)
+	}
+
+	r = &testWalkRepository{fanout: 3, levels: 3}
+	err = WalkTree(r, nil, nil, 1, 1, nil)
+	if nil != err || 4 != r.listed || 1 != r.maxact {
+		t.Error(err, r.listed, r.maxact)
+	}
+
+	r = &testWalkRepository{fanout: 3, levels: 3, fail: "/b/a"}
+	err = WalkTree(r, nil, nil, -1, 4, nil)
+	if nil == err {
+		t.Error()
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * webhook.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// webhookMaxSize is the maximum size of a webhook payload.
+const webhookMaxSize = 25 << 20
+
+type webhookHandler struct {
+	client Client
+	secret string
+}
+
+// NewWebhookHandler returns an HTTP handler that accepts GitHub and GitLab push
+// webhooks and invalidates the cached refs of the pushed repository. If secret
+// is not empty, GitHub payloads must be signed with it (X-Hub-Signature-256)
+// and GitLab requests must present it (X-Gitlab-Token). An empty secret accepts
+// all requests; it should only be used when the handler is reachable from the
+// local host alone.
+func NewWebhookHandler(client Client, secret string) http.Handler {
+	return &webhookHandler{
+		client: client,
+		secret: secret,
+	}
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if "POST" != req.Method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	content, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, webhookMaxSize))
+	if nil != err {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var fullname string
+	if event := req.Header.Get("X-GitHub-Event"); "" != event {
+		if !h.verifyGithub(req.Header.Get("X-Hub-Signature-256"), content) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch event {
+		case "push", "create", "delete":
+		default:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var payload struct {
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		}
+		err = json.Unmarshal(content, &payload)
+		fullname = payload.Repository.FullName
+	} else if event := req.Header.Get("X-Gitlab-Event"); "" != event {
+		if !h.verifyGitlab(req.Header.Get("X-Gitlab-Token")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch event {
+		case "Push Hook", "Tag Push Hook":
+		default:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var payload struct {
+			Project struct {
+				PathWithNamespace string `json:"path_with_namespace"`
+			} `json:"project"`
+		}
+		err = json.Unmarshal(content, &payload)
+		fullname = payload.Project.PathWithNamespace
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	owner, name, ok := splitFullName(fullname)
+	if nil != err || !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.client.InvalidateRepository(owner, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *webhookHandler) verifyGithub(signature string, content []byte) bool {
+	if "" == h.secret {
+		return true
+	}
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	sig, err := hex.DecodeString(signature[len("sha256="):])
+	if nil != err {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write(content)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+func (h *webhookHandler) verifyGitlab(token string) bool {
+	if "" == h.secret {
+		return true
+	}
+	return 1 == subtle.ConstantTimeCompare([]byte(token), []byte(h.secret))
+}
+
+// splitFullName splits a full repository name (owner/repo or GitLab
+// group/subgroup/repo) into the owner and repository names used by the file system.
+func splitFullName(fullname string) (owner string, name string, ok bool) {
+	i := strings.IndexByte(fullname, '/')
+	if 0 >= i || len(fullname)-1 == i {
+		return "", "", false
+	}
+	owner = fullname[:i]
+	name = strings.ReplaceAll(fullname[i+1:], "/", string(AltPathSeparator))
+	return owner, name, true
+}
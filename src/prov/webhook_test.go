@@ -0,0 +1,86 @@
+/*
+ * webhook_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package prov
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testWebhookClient struct {
+	Client
+	invalidated []string
+}
+
+func (c *testWebhookClient) InvalidateRepository(owner string, name string) {
+	c.invalidated = append(c.invalidated, owner+"/"+name)
+}
+
+func testWebhook(t *testing.T, h http.Handler, header map[string]string, body string, status int) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if status != rec.Code {
+		t.Errorf("status = %d, want %d", rec.Code, status)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	c := &testWebhookClient{}
+	h := NewWebhookHandler(c, "secret")
+
+	body := `{"repository":{"full_name":"winfsp/hubfs"}}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	testWebhook(t, h, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": sig,
+	}, body, http.StatusNoContent)
+	testWebhook(t, h, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=00",
+	}, body, http.StatusUnauthorized)
+	testWebhook(t, h, map[string]string{
+		"X-GitHub-Event":      "issues",
+		"X-Hub-Signature-256": sig,
+	}, body, http.StatusNoContent)
+
+	body = `{"project":{"path_with_namespace":"group/sub/project"}}`
+	testWebhook(t, h, map[string]string{
+		"X-Gitlab-Event": "Push Hook",
+		"X-Gitlab-Token": "secret",
+	}, body, http.StatusNoContent)
+	testWebhook(t, h, map[string]string{
+		"X-Gitlab-Event": "Push Hook",
+		"X-Gitlab-Token": "wrong",
+	}, body, http.StatusUnauthorized)
+
+	testWebhook(t, h, map[string]string{}, body, http.StatusBadRequest)
+
+	if 2 != len(c.invalidated) ||
+		"winfsp/hubfs" != c.invalidated[0] ||
+		"group/sub"+string(AltPathSeparator)+"project" != c.invalidated[1] {
+		t.Error(c.invalidated)
+	}
+}
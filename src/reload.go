@@ -0,0 +1,179 @@
+/*
+ * reload.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+// commandLineFlags returns the names of the options specified on the command line.
+// It must be called before the configuration file is applied.
+func commandLineFlags() map[string]bool {
+	cmdline := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+	return cmdline
+}
+
+// reloader re-reads the configuration file and applies the settings that can be
+// changed without unmounting: the times to live and filter of the clients (see
+// prov.IsRuntimeConfig) and the log level and format. Options specified on the
+// command line take precedence over the configuration file, as they do on startup.
+type reloader struct {
+	path     string
+	profile  string
+	cmdline  map[string]bool
+	config   []string
+	restrict []string
+	filter   []string
+	manager  *mountManager
+}
+
+// newReloader creates a reloader for a configuration file and profile. The mntopt are the -o
+// options and filters maps the names of the filter options (filter, owner, repo) to
+// their values; only those specified on the command line are kept.
+func newReloader(path string, profile string, cmdline map[string]bool, mntopt []string, filters map[string][]string,
+	manager *mountManager) *reloader {
+	config := []string{}
+	if cmdline["o"] {
+		for _, m := range mntopt {
+			for _, s := range strings.Split(m, ",") {
+				if prov.IsRuntimeConfig(s) {
+					config = append(config, s)
+				}
+			}
+		}
+	}
+	restrict, filter := []string{}, []string{}
+	for _, name := range []string{"owner", "repo"} {
+		if cmdline[name] {
+			restrict = append(restrict, filters[name]...)
+		}
+	}
+	if cmdline["filter"] {
+		filter = append(filter, filters["filter"]...)
+	}
+	return &reloader{
+		path:     path,
+		profile:  profile,
+		cmdline:  cmdline,
+		config:   config,
+		restrict: restrict,
+		filter:   filter,
+		manager:  manager,
+	}
+}
+
+// reload re-reads the configuration file and applies it. Settings that have been
+// removed from the file revert to their defaults. Nothing is applied if the file
+// is invalid.
+func (r *reloader) reload() error {
+	var items []util.Confitem
+	if "" != r.path {
+		var err error
+		items, err = util.ReadConfigFile(r.path)
+		if nil != err && !os.IsNotExist(err) {
+			return err
+		}
+		if nil == err {
+			items, err = selectProfile(items, r.profile)
+			if nil != err {
+				return fmt.Errorf("%s: %v", r.path, err)
+			}
+		}
+	}
+
+	config := []string{}
+	restrict, filter := []string{}, []string{}
+	loglevel, logformat := "warn", "text"
+	for _, item := range items {
+		switch {
+		case strings.HasPrefix(item.Key, "config."):
+			config = append(config, item.Key+"="+item.Value)
+		case r.cmdline[item.Key]:
+			// the command line takes precedence
+		case "o" == item.Key:
+			for _, s := range strings.Split(item.Value, ",") {
+				if prov.IsRuntimeConfig(s) {
+					config = append(config, s)
+				}
+			}
+		case "owner" == item.Key || "repo" == item.Key:
+			restrict = append(restrict, item.Value)
+		case "filter" == item.Key:
+			filter = append(filter, item.Value)
+		case "log-level" == item.Key:
+			loglevel = item.Value
+		case "log-format" == item.Key:
+			logformat = item.Value
+		}
+	}
+	config = append(config, filterConfig(append(restrict, r.restrict...), append(filter, r.filter...))...)
+	config = append(config, r.config...)
+
+	level, err := util.ParseLogLevel(loglevel)
+	if nil != err {
+		return fmt.Errorf("%s: %v", r.path, err)
+	}
+	if "text" != logformat && "json" != logformat {
+		return fmt.Errorf("%s: invalid log format: %s", r.path, logformat)
+	}
+
+	if nil != r.manager.debug && util.LogInfo < level {
+		// keep logging the FUSE operations selected by -debug-fuse
+		level = util.LogInfo
+	}
+	if !r.cmdline["log-level"] && !r.cmdline["d"] {
+		util.SetLogLevel(level)
+	}
+	if !r.cmdline["log-format"] {
+		util.SetLogFormat(logformat)
+	}
+	r.manager.reconfigure(config)
+
+	util.Logf(util.LogInfo, "configuration reloaded: %s", r.path)
+	return nil
+}
+
+// watch reloads the configuration on SIGHUP until the returned function is called.
+func (r *reloader) watch() (stop func()) {
+	sigC := make(chan os.Signal, 1)
+	doneC := make(chan struct{})
+	signal.Notify(sigC, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-sigC:
+				if err := r.reload(); nil != err {
+					warn("reload error: %v", err)
+				}
+			case <-doneC:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigC)
+		close(doneC)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * systemd.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// systemdEscapePath escapes a path as a systemd unit name (see systemd-escape --path).
+func systemdEscapePath(path string) string {
+	path = strings.Trim(filepath.Clean("/"+path), "/")
+	if "" == path {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; len(path) > i; i++ {
+		c := path[i]
+		switch {
+		case '/' == c:
+			b.WriteByte('-')
+		case ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			':' == c || '_' == c || ('.' == c && 0 < i):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// systemdUnits returns the name (without suffix) and contents of the systemd mount
+// unit and (if automount) automount unit of a remote and mountpoint. With automount
+// the file system is mounted on first access and unmounted after idle time.
+func systemdUnits(remote string, mntpnt string, options []string, automount bool,
+	idle time.Duration) (name string, mountUnit string, automountUnit string) {
+	name = systemdEscapePath(mntpnt)
+	options = append([]string{"_netdev"}, options...)
+
+	install := "\n[Install]\nWantedBy=multi-user.target\n"
+	mountInstall := install
+	if automount {
+		mountInstall = ""
+	}
+	mountUnit = fmt.Sprintf(
+		"[Unit]\n"+
+			"Description=%s mount of %s\n"+
+			"Wants=network-online.target\n"+
+			"After=network-online.target\n"+
+			"\n"+
+			"[Mount]\n"+
+			"What=%s\n"+
+			"Where=%s\n"+
+			"Type=fuse.%s\n"+
+			"Options=%s\n"+
+			"%s",
+		MyProductName, remote, remote, mntpnt, strings.ToLower(MyProductName),
+		strings.Join(options, ","), mountInstall)
+	if automount {
+		automountUnit = fmt.Sprintf(
+			"[Unit]\n"+
+				"Description=%s automount of %s\n"+
+				"\n"+
+				"[Automount]\n"+
+				"Where=%s\n"+
+				"TimeoutIdleSec=%d\n"+
+				"%s",
+			MyProductName, remote, mntpnt, int64(idle/time.Second), install)
+	}
+	return
+}
+
+// runSystemdCommand generates the systemd units of a mount:
+// "systemd [-automount] [-idle duration] [-dir dir] [remote] mountpoint" writes
+// the units into dir or prints them if dir is not specified. The FUSE mount options
+// (-o) are used as the options of the mount unit.
+func runSystemdCommand(args []string, defremote string, mntopt []string) int {
+	automount := false
+	idle := time.Duration(0)
+	dir := ""
+	fset := flag.NewFlagSet("systemd", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.BoolVar(&automount, "automount", automount, "mount on first access")
+	fset.DurationVar(&idle, "idle", idle, "unmount automount after idle `duration` (default: never)")
+	fset.StringVar(&dir, "dir", dir, "write units into `dir` (e.g. /etc/systemd/system)")
+	if nil != fset.Parse(args) || 1 > fset.NArg() || 2 < fset.NArg() {
+		flag.Usage()
+		return exitUsage
+	}
+	remote, mntpnt := defremote, fset.Arg(0)
+	if 2 == fset.NArg() {
+		remote, mntpnt = fset.Arg(0), fset.Arg(1)
+	}
+	mntpnt = absMountpoint(mntpnt)
+
+	options := []string{}
+	for _, m := range mntopt {
+		options = append(options, strings.Split(m, ",")...)
+	}
+	name, mountUnit, automountUnit := systemdUnits(remote, mntpnt, options, automount, idle)
+
+	units := [][2]string{{name + ".mount", mountUnit}}
+	if automount {
+		units = append(units, [2]string{name + ".automount", automountUnit})
+	}
+	for _, u := range units {
+		if "" == dir {
+			fmt.Printf("# %s\n%s\n", u[0], u[1])
+			continue
+		}
+		err := ioutil.WriteFile(filepath.Join(dir, u[0]), []byte(u[1]), 0644)
+		if nil != err {
+			warn("systemd error: %v", err)
+			return exitFailure
+		}
+		fmt.Printf("%s\n", filepath.Join(dir, u[0]))
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+//go:build darwin || linux
+// +build darwin linux
+
+/*
+ * unmount_unix.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// isMountpoint determines if a file system is mounted on a directory, i.e. if the
+// directory is on a different device than its parent.
+func isMountpoint(mntpnt string) bool {
+	info, err := os.Stat(mntpnt)
+	if nil != err {
+		return false
+	}
+	pinfo, err := os.Stat(filepath.Dir(mntpnt))
+	if nil != err {
+		return false
+	}
+	return info.Sys().(*syscall.Stat_t).Dev != pinfo.Sys().(*syscall.Stat_t).Dev
+}
+
+// isTransportError determines if an error is the result of accessing a FUSE file
+// system whose process is gone ("transport endpoint is not connected" on Linux,
+// "device not configured" on macOS).
+func isTransportError(err error) bool {
+	return errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ENXIO)
+}
+
+// systemUnmount unmounts a FUSE file system with the unmount tool of the OS. The
+// process that serves the file system then destroys it and exits. A forced unmount
+// also removes a mount whose process is gone or that is busy.
+func systemUnmount(mntpnt string, force bool) error {
+	cmds := [][]string{{"umount", mntpnt}}
+	if force {
+		cmds = [][]string{{"umount", "-f", mntpnt}, {"diskutil", "unmount", "force", mntpnt}}
+	}
+	if "linux" == runtime.GOOS {
+		cmds = [][]string{{"fusermount3", "-u", mntpnt}, {"fusermount", "-u", mntpnt}, {"umount", mntpnt}}
+		if force {
+			cmds = [][]string{{"fusermount3", "-uz", mntpnt}, {"fusermount", "-uz", mntpnt},
+				{"umount", "-l", mntpnt}}
+		}
+	}
+	var err error
+	for _, c := range cmds {
+		path, e := exec.LookPath(c[0])
+		if nil != e {
+			continue
+		}
+		out, e := exec.Command(path, c[1:]...).CombinedOutput()
+		if nil == e {
+			return nil
+		}
+		if s := strings.TrimSpace(string(out)); "" != s {
+			e = errors.New(s)
+		}
+		if nil == err {
+			err = e
+		}
+	}
+	if nil == err {
+		err = errors.New("no unmount tool found")
+	}
+	return err
+}
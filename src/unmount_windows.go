@@ -0,0 +1,41 @@
+//go:build windows
+// +build windows
+
+/*
+ * unmount_windows.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// isMountpoint determines if a file system is mounted on a mountpoint (drive or
+// directory).
+func isMountpoint(mntpnt string) bool {
+	_, err := os.Stat(mntpnt)
+	return nil == err
+}
+
+// isTransportError determines if an error is the result of accessing a file system
+// whose process is gone. WinFsp removes such file systems itself.
+func isTransportError(err error) bool {
+	return false
+}
+
+// systemUnmount unmounts a file system. WinFsp file systems can only be unmounted
+// by the process that serves them.
+func systemUnmount(mntpnt string, force bool) error {
+	return errors.New("not mounted by the daemon; stop the process that mounted it")
+}
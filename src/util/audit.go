@@ -0,0 +1,196 @@
+/*
+ * audit.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is a record of an audit log: a modification made through a file
+// system. Records are chained: every record carries the hash of the previous record
+// (Prev) and its own hash (Hash), which covers all of its other fields. The hash is
+// an HMAC-SHA256 with a key that is kept out of the log, so that the chain cannot
+// be recomputed by someone who can only write the log. Modifying, removing or
+// reordering records breaks the chain (see VerifyAuditLog). Removing the last
+// records does not; it is detected by comparing the hash of the last record (the
+// head) with a copy that was exported elsewhere (e.g. to the system log).
+type AuditRecord struct {
+	Time   string `json:"time"`
+	User   string `json:"user"`
+	Remote string `json:"remote"`
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	Repo   string `json:"repo,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Result string `json:"result"`
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+// hash computes the hash of a record (without its Hash field).
+func (r AuditRecord) hash(key []byte) string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	h := hmac.New(sha256.New, key)
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditLog appends hash chained records to an audit log file.
+type AuditLog struct {
+	lock sync.Mutex
+	file *os.File
+	key  []byte
+	prev string
+}
+
+// auditTailSize is the size of the tail of an audit log that is searched for the
+// last record.
+const auditTailSize = 64 * 1024
+
+// OpenAuditLog opens (or creates) an audit log file whose records are hashed with
+// key. New records continue the chain of the last record of the file.
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if nil != err {
+		return nil, err
+	}
+	prev, err := lastAuditHash(file)
+	if nil != err {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &AuditLog{file: file, key: key, prev: prev}, nil
+}
+
+func lastAuditHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if nil != err {
+		return "", err
+	}
+	ofst := info.Size() - auditTailSize
+	if 0 > ofst {
+		ofst = 0
+	}
+	buf := make([]byte, info.Size()-ofst)
+	_, err = file.ReadAt(buf, ofst)
+	if nil != err && io.EOF != err {
+		return "", err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if 0 == len(buf) {
+		return "", nil
+	}
+	if i := bytes.LastIndexByte(buf, '\n'); -1 != i {
+		buf = buf[i+1:]
+	} else if 0 != ofst {
+		return "", fmt.Errorf("audit record too long")
+	}
+	var r AuditRecord
+	err = json.Unmarshal(buf, &r)
+	if nil != err {
+		return "", fmt.Errorf("invalid audit record: %v", err)
+	}
+	return r.Hash, nil
+}
+
+// Record appends a record to the audit log. The Time (if not set), Prev and Hash
+// fields of the record are filled in. The record is synced to disk.
+func (l *AuditLog) Record(r AuditRecord) error {
+	if "" == r.Time {
+		r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if nil == l.file {
+		return os.ErrClosed
+	}
+	r.Prev = l.prev
+	r.Hash = r.hash(l.key)
+	b, err := json.Marshal(r)
+	if nil != err {
+		return err
+	}
+	_, err = l.file.Write(append(b, '\n'))
+	if nil == err {
+		err = l.file.Sync()
+	}
+	if nil != err {
+		return err
+	}
+	l.prev = r.Hash
+	return nil
+}
+
+// Head returns the hash of the last record of the audit log ("" if there are no
+// records).
+func (l *AuditLog) Head() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.prev
+}
+
+// Close closes the audit log.
+func (l *AuditLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if nil == l.file {
+		return os.ErrClosed
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// VerifyAuditLog verifies the chain of the records of an audit log that were hashed
+// with key. It returns the number of verified records, the hash of the last one (the
+// head) and an error that identifies the first record (by line number) that breaks
+// the chain.
+func VerifyAuditLog(reader io.Reader, key []byte) (int, string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 4096), auditTailSize)
+	prev := ""
+	n := 0
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); nil != err {
+			return n, prev, fmt.Errorf("line %d: invalid audit record: %v", n+1, err)
+		}
+		if prev != r.Prev {
+			return n, prev, fmt.Errorf("line %d: audit record does not follow previous record", n+1)
+		}
+		if !hmac.Equal([]byte(r.hash(key)), []byte(r.Hash)) {
+			return n, prev, fmt.Errorf("line %d: audit record has been modified", n+1)
+		}
+		prev = r.Hash
+		n++
+	}
+	return n, prev, scanner.Err()
+}
@@ -0,0 +1,82 @@
+/*
+ * audit_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-audit-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	l, err := OpenAuditLog(path, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	l.Record(AuditRecord{User: "1000", Op: "create", Path: "/o/r/main/a", Result: "ok"})
+	l.Record(AuditRecord{User: "1000", Op: "write", Path: "/o/r/main/a", Size: 10, Result: "ok"})
+	l.Close()
+
+	// reopening continues the chain
+	l, err = OpenAuditLog(path, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	l.Record(AuditRecord{User: "1000", Op: "unlink", Path: "/o/r/main/a", Result: "ok"})
+	head := l.Head()
+	l.Close()
+
+	content, err := ioutil.ReadFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if n, h, err := VerifyAuditLog(bytes.NewReader(content), key); 3 != n || head != h || nil != err {
+		t.Error(n, h, err)
+	}
+
+	// the chain cannot be verified (or recomputed) without the key
+	if n, _, err := VerifyAuditLog(bytes.NewReader(content), []byte("other")); 0 != n || nil == err {
+		t.Error(n, err)
+	}
+
+	// modified record
+	modified := strings.Replace(string(content), `"size":10`, `"size":11`, 1)
+	if n, _, err := VerifyAuditLog(strings.NewReader(modified), key); 1 != n || nil == err {
+		t.Error(n, err)
+	}
+
+	// removed record
+	lines := strings.SplitAfter(string(content), "\n")
+	removed := lines[0] + lines[2]
+	if n, _, err := VerifyAuditLog(strings.NewReader(removed), key); 1 != n || nil == err {
+		t.Error(n, err)
+	}
+
+	// removed last record: the chain is intact, but the head differs
+	truncated := lines[0] + lines[1]
+	if n, h, err := VerifyAuditLog(strings.NewReader(truncated), key); 2 != n || head == h || nil != err {
+		t.Error(n, h, err)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * confile.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Confitem is a setting of a configuration file.
+type Confitem struct {
+	Key   string
+	Value string
+}
+
+// ReadConfigFile reads a configuration file. See ParseConfig.
+func ReadConfigFile(path string) ([]Confitem, error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer file.Close()
+	items, err := ParseConfig(file)
+	if nil != err {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return items, nil
+}
+
+// ParseConfig parses a configuration file in a subset of YAML: a mapping of keys to
+// scalar values, to lists of scalar values or to nested mappings. Lists can be
+// written in block form (indented "- value" lines) or in flow form ([value, value]).
+// Scalars can be plain, single quoted or double quoted. Comments start with #.
+// Environment variable references (${VAR}) are expanded in plain and double quoted
+// scalars (see expandEnv).
+//
+// The settings are returned in file order; a list results in one setting per value
+// and the keys of a nested mapping are joined to the keys that contain them with a
+// dot (e.g. profiles.work.remote).
+func ParseConfig(reader io.Reader) (items []Confitem, err error) {
+	// a parent is a key without a value; it contains either a list or a mapping
+	type parent struct {
+		indent int
+		key    string
+		kind   byte // 0: empty, '-': list, ':': mapping
+	}
+	scanner := bufio.NewScanner(reader)
+	parents := []parent{}
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := stripComment(scanner.Text())
+		text := strings.TrimSpace(line)
+		if "" == text || "---" == text {
+			continue
+		}
+
+		fail := func(msg string) ([]Confitem, error) {
+			return nil, fmt.Errorf("%d: %s", lineno, msg)
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		for 0 < len(parents) && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		var p *parent
+		if 0 < len(parents) {
+			p = &parents[len(parents)-1]
+		}
+
+		if strings.HasPrefix(text, "- ") || "-" == text {
+			if nil == p || ':' == p.kind {
+				return fail("unexpected list item")
+			}
+			v, e := parseScalar(strings.TrimSpace(text[1:]))
+			if nil != e {
+				return fail(e.Error())
+			}
+			p.kind = '-'
+			items = append(items, Confitem{p.key, v})
+			continue
+		}
+
+		key := ""
+		if 0 < indent {
+			if nil == p || '-' == p.kind {
+				return fail("unexpected indentation")
+			}
+			p.kind = ':'
+			key = p.key + "."
+		}
+
+		i := strings.Index(text, ":")
+		if -1 == i || (len(text) > i+1 && ' ' != text[i+1] && '\t' != text[i+1]) {
+			return fail("expected key: value")
+		}
+		k := strings.TrimSpace(text[:i])
+		if "" == k {
+			return fail("empty key")
+		}
+		key += k
+		text = strings.TrimSpace(text[i+1:])
+		if "" == text {
+			parents = append(parents, parent{indent: indent, key: key})
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return fail("unterminated list")
+			}
+			for _, s := range splitFlow(text[1 : len(text)-1]) {
+				v, e := parseScalar(strings.TrimSpace(s))
+				if nil != e {
+					return fail(e.Error())
+				}
+				items = append(items, Confitem{key, v})
+			}
+			continue
+		}
+
+		v, e := parseScalar(text)
+		if nil != e {
+			return fail(e.Error())
+		}
+		items = append(items, Confitem{key, v})
+	}
+	return items, scanner.Err()
+}
+
+// stripComment removes a comment from a line. A comment starts with a # at the
+// beginning of the line or after whitespace and outside of quotes.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; len(line) > i; i++ {
+		c := line[i]
+		switch {
+		case 0 != quote:
+			if '\\' == c && '"' == quote {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case '"' == c || '\'' == c:
+			quote = c
+		case '#' == c && (0 == i || ' ' == line[i-1] || '\t' == line[i-1]):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitFlow splits the items of a flow list at commas outside of quotes.
+func splitFlow(s string) (res []string) {
+	if "" == strings.TrimSpace(s) {
+		return
+	}
+	quote := byte(0)
+	j := 0
+	for i := 0; len(s) > i; i++ {
+		c := s[i]
+		switch {
+		case 0 != quote:
+			if '\\' == c && '"' == quote {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case '"' == c || '\'' == c:
+			quote = c
+		case ',' == c:
+			res = append(res, s[j:i])
+			j = i + 1
+		}
+	}
+	return append(res, s[j:])
+}
+
+func parseScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if nil != err {
+			return "", fmt.Errorf("invalid quoted value %s", s)
+		}
+		return expandEnv(v)
+	case strings.HasPrefix(s, "'"):
+		if 2 > len(s) || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid quoted value %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	default:
+		return expandEnv(s)
+	}
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} with the value of the environment
+// variable VAR (or default if VAR is unset or empty). A $ that is not followed by {
+// is kept as is. It is an error if VAR is unset and there is no default.
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if -1 == i {
+			b.WriteString(s)
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if -1 == j {
+			return "", fmt.Errorf("unterminated variable in %s", s)
+		}
+		name, def, hasdef := s[i+2:i+j], "", false
+		if k := strings.Index(name, ":-"); -1 != k {
+			name, def, hasdef = name[:k], name[k+2:], true
+		}
+		if "" == name {
+			return "", fmt.Errorf("empty variable name in %s", s)
+		}
+		v, ok := os.LookupEnv(name)
+		if "" == v && hasdef {
+			v = def
+		} else if !ok {
+			return "", fmt.Errorf("undefined environment variable %s", name)
+		}
+		b.WriteString(s[:i])
+		b.WriteString(v)
+		s = s[i+j+1:]
+	}
+	return b.String(), nil
+}
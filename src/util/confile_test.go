@@ -0,0 +1,134 @@
+/*
+ * confile_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	text := `# hubfs configuration
+remote: gitlab.com
+auth: token=T   # comment
+filter:
+  - owner/repo
+  - "-owner/other"
+pin: [owner/repo, 'owner/it''s']
+config.ttl: 1m
+config.dir: "/path/with # hash"
+`
+	items, err := ParseConfig(strings.NewReader(text))
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := []Confitem{
+		{"remote", "gitlab.com"},
+		{"auth", "token=T"},
+		{"filter", "owner/repo"},
+		{"filter", "-owner/other"},
+		{"pin", "owner/repo"},
+		{"pin", "owner/it's"},
+		{"config.ttl", "1m"},
+		{"config.dir", "/path/with # hash"},
+	}
+	if !reflect.DeepEqual(expect, items) {
+		t.Error(items)
+	}
+
+	text = `auth: full
+profiles:
+  work:
+    remote: github.example.com/myorg
+    filter:
+      - myorg/a
+      - myorg/b
+    config:
+      dir: /cache/work
+  home:
+    authkey: home
+o: uid=-1
+`
+	items, err = ParseConfig(strings.NewReader(text))
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect = []Confitem{
+		{"auth", "full"},
+		{"profiles.work.remote", "github.example.com/myorg"},
+		{"profiles.work.filter", "myorg/a"},
+		{"profiles.work.filter", "myorg/b"},
+		{"profiles.work.config.dir", "/cache/work"},
+		{"profiles.home.authkey", "home"},
+		{"o", "uid=-1"},
+	}
+	if !reflect.DeepEqual(expect, items) {
+		t.Error(items)
+	}
+
+	for _, s := range []string{
+		"- item\n",
+		"key: value\n  - item\n",
+		"key: value\n  sub: value\n",
+		"key:\n  - item\n  sub: value\n",
+		"key:\n  sub: value\n  - item\n",
+		"key\n",
+		"key: [a, b\n",
+		"key: \"unterminated\n",
+	} {
+		if _, err := ParseConfig(strings.NewReader(s)); nil == err {
+			t.Error(s)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("HUBFS_TEST_TOKEN", "T0K")
+	os.Setenv("HUBFS_TEST_EMPTY", "")
+	defer os.Unsetenv("HUBFS_TEST_TOKEN")
+	defer os.Unsetenv("HUBFS_TEST_EMPTY")
+
+	text := `auth: token=${HUBFS_TEST_TOKEN}
+config.dir: "${HUBFS_TEST_UNSET:-/tmp}/cache"
+config.http.proxy: ${HUBFS_TEST_EMPTY:-http://proxy}
+secret: '${HUBFS_TEST_TOKEN}'
+price: $5
+`
+	items, err := ParseConfig(strings.NewReader(text))
+	if nil != err {
+		t.Fatal(err)
+	}
+	expect := []Confitem{
+		{"auth", "token=T0K"},
+		{"config.dir", "/tmp/cache"},
+		{"config.http.proxy", "http://proxy"},
+		{"secret", "${HUBFS_TEST_TOKEN}"},
+		{"price", "$5"},
+	}
+	if !reflect.DeepEqual(expect, items) {
+		t.Error(items)
+	}
+
+	for _, s := range []string{
+		"key: ${HUBFS_TEST_UNSET}\n",
+		"key: ${HUBFS_TEST_TOKEN\n",
+		"key: ${}\n",
+	} {
+		if _, err := ParseConfig(strings.NewReader(s)); nil == err {
+			t.Error(s)
+		}
+	}
+}
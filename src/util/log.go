@@ -0,0 +1,296 @@
+/*
+ * log.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel is the severity of a log record.
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if 0 <= l && int(l) < len(logLevelNames) {
+		return logLevelNames[l]
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLogLevel parses a log level name (debug, info, warn or error).
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(n, s) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level: %s", s)
+}
+
+var (
+	loglevel int32 = int32(LogWarn)
+	logslow  int64
+	logmux   sync.Mutex
+	logout   io.Writer = os.Stderr
+	logjson  bool
+)
+
+// SetLogLevel sets the minimum level of the records that are logged. It may be
+// called at any time.
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&loglevel, int32(level))
+}
+
+// GetLogLevel gets the minimum level of the records that are logged.
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&loglevel))
+}
+
+// IsLogLevel determines if records of a level are logged.
+func IsLogLevel(level LogLevel) bool {
+	return GetLogLevel() <= level
+}
+
+// SetSlowThreshold sets the latency at or above which operations are slow (0 for
+// none). Slow operations are logged by LogOp at the warn level (or higher).
+func SetSlowThreshold(threshold time.Duration) {
+	atomic.StoreInt64(&logslow, int64(threshold))
+}
+
+// GetSlowThreshold gets the latency at or above which operations are slow.
+func GetSlowThreshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&logslow))
+}
+
+// IsSlow determines if an operation of the specified latency is slow.
+func IsSlow(latency time.Duration) bool {
+	threshold := GetSlowThreshold()
+	return 0 < threshold && threshold <= latency
+}
+
+// SetLogOutput sets the writer that receives the log records (default: stderr).
+func SetLogOutput(w io.Writer) {
+	logmux.Lock()
+	logout = w
+	logmux.Unlock()
+}
+
+// SetLogFormat sets the format of the log records: text (the default) or json.
+// JSON records are written one per line.
+func SetLogFormat(format string) error {
+	switch format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid log format: %s", format)
+	}
+	logmux.Lock()
+	logjson = "json" == format
+	logmux.Unlock()
+	return nil
+}
+
+// logRecord is a log record. Records of operations (see LogOp) carry the operation,
+// its path, the provider and the latency (in seconds) of the operation, whether it
+// was slow and its error (if any).
+type logRecord struct {
+	Time     string  `json:"time"`
+	Level    string  `json:"level"`
+	Msg      string  `json:"msg,omitempty"`
+	Op       string  `json:"op,omitempty"`
+	Path     string  `json:"path,omitempty"`
+	Provider string  `json:"provider,omitempty"`
+	Latency  float64 `json:"latency,omitempty"`
+	Slow     bool    `json:"slow,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	latency  time.Duration
+}
+
+// Logf logs a record of the specified level if the level is enabled.
+func Logf(level LogLevel, format string, a ...interface{}) {
+	if !IsLogLevel(level) {
+		return
+	}
+	writeLogRecord(level, &logRecord{Msg: fmt.Sprintf(format, a...)})
+}
+
+// LogOp logs a record of an operation (e.g. a request to a provider) if the level
+// is enabled. Slow operations (see SetSlowThreshold) are logged at the warn level
+// or higher.
+func LogOp(level LogLevel, op string, path string, provider string, latency time.Duration,
+	err error) {
+	slow := IsSlow(latency)
+	if slow && LogWarn > level {
+		level = LogWarn
+	}
+	if !IsLogLevel(level) {
+		return
+	}
+	r := &logRecord{
+		Op:       op,
+		Path:     path,
+		Provider: provider,
+		Latency:  latency.Seconds(),
+		Slow:     slow,
+		latency:  latency,
+	}
+	if nil != err {
+		r.Error = err.Error()
+	}
+	writeLogRecord(level, r)
+}
+
+func writeLogRecord(level LogLevel, r *logRecord) {
+	r.Time = time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	r.Level = level.String()
+	r.Msg = strings.TrimRight(r.Msg, "\n")
+
+	logmux.Lock()
+	defer logmux.Unlock()
+
+	if logjson {
+		b, _ := json.Marshal(r)
+		logout.Write(append(b, '\n'))
+		return
+	}
+
+	msg := r.Msg
+	if "" != r.Op {
+		slow := ""
+		if r.Slow {
+			slow = " slow"
+		}
+		msg = fmt.Sprintf("%s %s [%s %v%s]",
+			r.Op, r.Path, r.Provider, r.latency.Round(time.Millisecond), slow)
+		if "" != r.Error {
+			msg += ": " + r.Error
+		}
+	}
+	io.WriteString(logout, fmt.Sprintf("%s %-5s %s\n", r.Time, strings.ToUpper(r.Level), msg))
+}
+
+type logWriter LogLevel
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if IsLogLevel(LogLevel(w)) {
+		writeLogRecord(LogLevel(w), &logRecord{Msg: string(p)})
+	}
+	return len(p), nil
+}
+
+// LogWriter returns a writer that logs every write as a record of the specified
+// level. It can be used to redirect the output of other loggers.
+func LogWriter(level LogLevel) io.Writer {
+	return logWriter(level)
+}
+
+// RotatingFile is a log file that is rotated when it reaches a maximum size. The
+// rotated files are named path.1 (most recent) to path.N.
+type RotatingFile struct {
+	lock     sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// OpenRotatingFile opens (or creates) a log file that is rotated when it reaches
+// maxSize bytes; maxFiles rotated files are kept.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if nil != err {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if nil != err {
+		file.Close()
+		return nil, err
+	}
+	return &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		file:     file,
+		size:     info.Size(),
+	}, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if nil == f.file {
+		return 0, os.ErrClosed
+	}
+
+	if 0 < f.maxSize && 0 < f.size && f.maxSize < f.size+int64(len(p)) {
+		err = f.rotate()
+		if nil != err {
+			return 0, err
+		}
+	}
+
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return
+}
+
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	for i := f.maxFiles - 1; 0 < i; i-- {
+		os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	if 0 < f.maxFiles {
+		os.Rename(f.path, f.path+".1")
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if nil != err {
+		return err
+	}
+	f.file = file
+	f.size = 0
+	return nil
+}
+
+// Close closes the log file.
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if nil == f.file {
+		return os.ErrClosed
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
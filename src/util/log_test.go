@@ -0,0 +1,157 @@
+/*
+ * log_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogOutput(&buf)
+	defer SetLogOutput(os.Stderr)
+	defer SetLogLevel(GetLogLevel())
+
+	level, err := ParseLogLevel("INFO")
+	if nil != err || LogInfo != level {
+		t.Error(level, err)
+	}
+	if _, err = ParseLogLevel("verbose"); nil == err {
+		t.Error()
+	}
+
+	SetLogLevel(level)
+	Logf(LogDebug, "debug %d", 1)
+	Logf(LogInfo, "info %d", 2)
+	LogWriter(LogError).Write([]byte("error 3\n"))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if 2 != len(lines) ||
+		!strings.HasSuffix(lines[0], " INFO  info 2") ||
+		!strings.HasSuffix(lines[1], " ERROR error 3") {
+		t.Error(lines)
+	}
+}
+
+func TestLogOp(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogOutput(&buf)
+	defer SetLogOutput(os.Stderr)
+	defer SetLogLevel(GetLogLevel())
+	defer SetLogFormat("text")
+
+	SetLogLevel(LogInfo)
+	LogOp(LogWarn, "owner", "/winfsp", "github.com", 1500*time.Millisecond, errors.New("error"))
+	if !strings.HasSuffix(buf.String(), " WARN  owner /winfsp [github.com 1.5s]: error\n") {
+		t.Error(buf.String())
+	}
+
+	if nil == SetLogFormat("xml") {
+		t.Error()
+	}
+	SetLogFormat("json")
+	buf.Reset()
+	LogOp(LogInfo, "refs", "https://github.com/winfsp/hubfs", "github.com", 250*time.Millisecond, nil)
+	Logf(LogInfo, "message")
+	dec := json.NewDecoder(&buf)
+	var r1, r2 map[string]interface{}
+	if err := dec.Decode(&r1); nil != err {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&r2); nil != err {
+		t.Fatal(err)
+	}
+	if "info" != r1["level"] || "refs" != r1["op"] || "https://github.com/winfsp/hubfs" != r1["path"] ||
+		"github.com" != r1["provider"] || 0.25 != r1["latency"] || nil != r1["error"] || nil != r1["msg"] {
+		t.Error(r1)
+	}
+	if "message" != r2["msg"] || nil != r2["op"] || nil == r2["time"] {
+		t.Error(r2)
+	}
+}
+
+func TestLogSlow(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogOutput(&buf)
+	defer SetLogOutput(os.Stderr)
+	defer SetLogLevel(GetLogLevel())
+	defer SetSlowThreshold(0)
+
+	SetLogLevel(LogWarn)
+	LogOp(LogInfo, "fetch", "https://github.com/winfsp/hubfs", "github.com", 3*time.Second, nil)
+	if 0 != buf.Len() {
+		t.Error(buf.String())
+	}
+
+	SetSlowThreshold(2 * time.Second)
+	LogOp(LogInfo, "fetch", "https://github.com/winfsp/hubfs", "github.com", 1*time.Second, nil)
+	if 0 != buf.Len() {
+		t.Error(buf.String())
+	}
+	LogOp(LogInfo, "fetch", "https://github.com/winfsp/hubfs", "github.com", 3*time.Second, nil)
+	if !strings.HasSuffix(buf.String(),
+		" WARN  fetch https://github.com/winfsp/hubfs [github.com 3s slow]\n") {
+		t.Error(buf.String())
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubfs-log-test")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hubfs.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err = f.Write([]byte(s)); nil != err {
+			t.Error(err)
+		}
+	}
+	f.Close()
+
+	for name, expect := range map[string]string{
+		"hubfs.log":   "dddddd\n",
+		"hubfs.log.1": "cccccc\n",
+		"hubfs.log.2": "bbbbbb\n",
+		"hubfs.log.3": "",
+	} {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if expect != string(content) {
+			t.Error(name, string(content))
+		}
+	}
+
+	// existing file is appended to
+	f, err = OpenRotatingFile(path, 10, 2)
+	if nil != err {
+		t.Fatal(err)
+	}
+	f.Write([]byte("e\n"))
+	f.Close()
+	if content, _ := ioutil.ReadFile(path); "dddddd\ne\n" != string(content) {
+		t.Error(string(content))
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * span.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Spans trace an operation end to end: a file system operation and the cache
+// lookups that it performs. Spans are exported to an OpenTelemetry collector with
+// OTLP/HTTP; the exporter only implements the JSON encoding and drops a batch of
+// spans when its export fails (there are no retries).
+//
+// The code paths of HUBFS do not carry a context; instead the parent of a span is
+// passed explicitly. Requests to the provider are recorded as the roots of their
+// own traces, because a request may be shared by several operations (that wait for
+// the same data) or made by a background refresh.
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusError = 2
+
+	spanBatchSize     = 512
+	spanQueueSize     = 8192
+	spanFlushInterval = 5 * time.Second
+	spanExportTimeout = 10 * time.Second
+)
+
+// Span is a span in progress. A nil Span is valid and records nothing; it is
+// returned by StartSpan when tracing is not enabled.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []string
+	err      string
+}
+
+type spanExporter struct {
+	dropped  uint64 // first for 64-bit alignment
+	endpoint string
+	service  string
+	client   *http.Client
+	spanC    chan *Span
+	stopC    chan struct{}
+	doneC    chan struct{}
+}
+
+var (
+	tracing  int32
+	exporter *spanExporter
+)
+
+// StartTracing exports spans to the OTLP/HTTP endpoint of an OpenTelemetry
+// collector (e.g. http://localhost:4318; /v1/traces is appended if the endpoint
+// has no path). The service is reported as the service.name of the spans.
+func StartTracing(endpoint string, service string) error {
+	uri, err := url.Parse(endpoint)
+	if nil != err || ("http" != uri.Scheme && "https" != uri.Scheme) || "" == uri.Host {
+		return fmt.Errorf("invalid OTLP endpoint: %s", endpoint)
+	}
+	if "" == uri.Path || "/" == uri.Path {
+		uri.Path = "/v1/traces"
+	}
+
+	e := &spanExporter{
+		endpoint: uri.String(),
+		service:  service,
+		client:   &http.Client{Timeout: spanExportTimeout},
+		spanC:    make(chan *Span, spanQueueSize),
+		stopC:    make(chan struct{}),
+		doneC:    make(chan struct{}),
+	}
+	exporter = e
+	go e.run()
+	atomic.StoreInt32(&tracing, 1)
+	return nil
+}
+
+// StopTracing exports the remaining spans and stops tracing.
+func StopTracing() {
+	if 0 == atomic.SwapInt32(&tracing, 0) {
+		return
+	}
+	close(exporter.stopC)
+	<-exporter.doneC
+}
+
+// TracingEnabled determines if spans are exported.
+func TracingEnabled() bool {
+	return 1 == atomic.LoadInt32(&tracing)
+}
+
+// StartSpan starts a span as a child of parent; if parent is nil the span starts a
+// new trace. The attrs are key, value pairs. It returns nil if tracing is not
+// enabled.
+func StartSpan(parent *Span, name string, attrs ...string) *Span {
+	if !TracingEnabled() {
+		return nil
+	}
+	s := &Span{name: name, kind: spanKindInternal, start: time.Now(), attrs: attrs}
+	s.parent(parent)
+	return s
+}
+
+// End ends the span; if err is not nil the span is marked as failed.
+func (s *Span) End(err error) {
+	if nil == s {
+		return
+	}
+	s.finish(err)
+}
+
+// RecordSpan records a completed request to a remote service (e.g. the provider)
+// that started at the specified time as a child of parent; if parent is nil the
+// span starts a new trace.
+func RecordSpan(parent *Span, name string, start time.Time, err error, attrs ...string) {
+	if !TracingEnabled() {
+		return
+	}
+	s := &Span{name: name, kind: spanKindClient, start: start, attrs: attrs}
+	s.parent(parent)
+	s.finish(err)
+}
+
+func (s *Span) parent(p *Span) {
+	if nil != p {
+		s.traceID, s.parentID = p.traceID, p.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+}
+
+func (s *Span) finish(err error) {
+	s.end = time.Now()
+	if nil != err {
+		s.err = err.Error()
+	}
+	e := exporter
+	select {
+	case e.spanC <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *spanExporter) run() {
+	defer close(e.doneC)
+	ticker := time.NewTicker(spanFlushInterval)
+	defer ticker.Stop()
+	batch := []*Span{}
+	for {
+		select {
+		case s := <-e.spanC:
+			batch = append(batch, s)
+			if spanBatchSize > len(batch) {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stopC:
+			for n := len(e.spanC); 0 < n; n-- {
+				batch = append(batch, <-e.spanC)
+			}
+			e.export(batch)
+			return
+		}
+		e.export(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *spanExporter) export(batch []*Span) {
+	if n := atomic.SwapUint64(&e.dropped, 0); 0 != n {
+		Logf(LogWarn, "trace export: %d spans dropped", n)
+	}
+	if 0 == len(batch) {
+		return
+	}
+	body, err := json.Marshal(e.encode(batch))
+	if nil != err {
+		return
+	}
+	rsp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if nil == err {
+		rsp.Body.Close()
+		if 300 <= rsp.StatusCode {
+			err = fmt.Errorf("HTTP %d", rsp.StatusCode)
+		}
+	}
+	if nil != err {
+		Logf(LogWarn, "trace export: %s: %v", e.endpoint, err)
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+func otlpAttrs(kv []string) []otlpAttr {
+	res := []otlpAttr{}
+	for i := 0; len(kv) > i+1; i += 2 {
+		res = append(res, otlpAttr{kv[i], otlpValue{kv[i+1]}})
+	}
+	return res
+}
+
+// encode encodes spans as an OTLP ExportTraceServiceRequest.
+func (e *spanExporter) encode(batch []*Span) interface{} {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttrs(s.attrs),
+		}
+		if [8]byte{} != s.parentID {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if "" != s.err {
+			o.Status = otlpStatus{Code: spanStatusError, Message: s.err}
+		}
+		spans = append(spans, o)
+	}
+
+	type scope struct {
+		Name string `json:"name"`
+	}
+	type scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	type resource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	type resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	return struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}{
+		[]resourceSpans{{
+			Resource:   resource{otlpAttrs([]string{"service.name", e.service})},
+			ScopeSpans: []scopeSpans{{Scope: scope{e.service}, Spans: spans}},
+		}},
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * span_test.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpans(t *testing.T) {
+	type request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	spans := map[string]otlpSpan{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/v1/traces" != r.URL.Path {
+			t.Error(r.URL.Path)
+		}
+		var req request
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	if nil == StartTracing("localhost:4318", "test") {
+		t.Error()
+	}
+	err := StartTracing(server.URL, "test")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	op := StartSpan(nil, "fuse.Open", "path", "/owner/repo")
+	start := time.Now()
+	lookup := StartSpan(op, "cache.OpenRepository")
+	RecordSpan(lookup, "provider.refs", start, errors.New("HTTP 500"))
+	lookup.End(nil)
+	op.End(nil)
+	StartSpan(nil, "fuse.Getattr").End(nil)
+	StopTracing()
+
+	var none *Span
+	if nil != StartSpan(none, "fuse.Read") {
+		t.Error()
+	}
+	none.End(nil)
+
+	if 4 != len(spans) {
+		t.Fatal(spans)
+	}
+	o, l, r, other :=
+		spans["fuse.Open"], spans["cache.OpenRepository"], spans["provider.refs"], spans["fuse.Getattr"]
+	if "" != o.ParentSpanID || 1 != len(o.Attributes) || "/owner/repo" != o.Attributes[0].Value.StringValue {
+		t.Error(o)
+	}
+	if o.TraceID != l.TraceID || o.SpanID != l.ParentSpanID {
+		t.Error(l)
+	}
+	if o.TraceID != r.TraceID || l.SpanID != r.ParentSpanID ||
+		spanKindClient != r.Kind || spanStatusError != r.Status.Code {
+		t.Error(r)
+	}
+	if o.TraceID == other.TraceID || "" != other.ParentSpanID {
+		t.Error(other)
+	}
+}
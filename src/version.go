@@ -0,0 +1,206 @@
+/*
+ * version.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/winfsp/hubfs/httputil"
+	"github.com/winfsp/hubfs/prov"
+	"github.com/winfsp/hubfs/util"
+)
+
+const (
+	// latestReleaseURI is the URI of the latest release of the project.
+	latestReleaseURI = "https://api.github.com/repos/winfsp/hubfs/releases/latest"
+
+	// versionCheckTimeout is the timeout of the update check.
+	versionCheckTimeout = 15 * time.Second
+
+	// noUpdateCheckEnv is the environment variable that disables the update check.
+	noUpdateCheckEnv = "HUBFS_NO_UPDATE_CHECK"
+)
+
+// printVersion prints the version information and the supported providers.
+func printVersion() {
+	name := MyProductName
+	if "" != MyProductTag {
+		name += " " + MyProductTag
+	}
+	fmt.Printf("%s %s (%s) - %s\nCopyright %s\n\n",
+		name, MyProductVersion, MyVersion, MyDescription, MyCopyright)
+	util.InvokeEvent("main.Printver", nil)
+	fmt.Printf("Providers:\n")
+	for _, n := range prov.GetProviderClassNames() {
+		fmt.Printf("  %s\n", n)
+	}
+}
+
+// versionInfo is the version information as printed with -output json.
+type versionInfo struct {
+	Product        string   `json:"product"`
+	Version        string   `json:"version"`
+	ProductVersion string   `json:"productversion"`
+	Providers      []string `json:"providers"`
+	Latest         string   `json:"latest,omitempty"`
+	LatestURL      string   `json:"latesturl,omitempty"`
+	Update         bool     `json:"update"`
+}
+
+// latestRelease is the latest release of the project as reported by the GitHub API.
+type latestRelease struct {
+	Name    string `json:"name"`
+	TagName string `json:"tag_name"`
+	HtmlURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+var releaseVersionRe = regexp.MustCompile(`[0-9]+\.[0-9]+\.[0-9]+`)
+
+// version returns the version of the release, which is the greatest version
+// (MAJOR.MINOR.BUILD) in the names of its assets (e.g. hubfs-win-1.0.22153.msi).
+func (r *latestRelease) version() string {
+	version := ""
+	for _, a := range r.Assets {
+		for _, v := range releaseVersionRe.FindAllString(a.Name, -1) {
+			if "" == version || 0 < compareVersions(v, version) {
+				version = v
+			}
+		}
+	}
+	return version
+}
+
+// compareVersions compares two dotted versions numerically.
+func compareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; len(as) > i || len(bs) > i; i++ {
+		var an, bn int
+		if len(as) > i {
+			an, _ = strconv.Atoi(as[i])
+		}
+		if len(bs) > i {
+			bn, _ = strconv.Atoi(bs[i])
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return +1
+		}
+	}
+	return 0
+}
+
+// getLatestRelease queries the releases of the project for the latest one.
+func getLatestRelease() (*latestRelease, error) {
+	req, err := http.NewRequest("GET", latestReleaseURI, nil)
+	if nil != err {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	client := &http.Client{
+		Transport: httputil.DefaultTransport,
+		Timeout:   versionCheckTimeout,
+	}
+	rsp, err := client.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if 200 != rsp.StatusCode {
+		return nil, fmt.Errorf("HTTP %d", rsp.StatusCode)
+	}
+	var release latestRelease
+	err = json.NewDecoder(rsp.Body).Decode(&release)
+	if nil != err {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// runVersionCommand prints the version information and optionally checks for a
+// newer release: "version [-check]". The check is disabled when the environment
+// variable HUBFS_NO_UPDATE_CHECK is set (e.g. on machines that must not contact
+// GitHub). It exits with status exitUpdate if a newer release is available.
+func runVersionCommand(args []string) int {
+	check := false
+	fset := flag.NewFlagSet("version", flag.ContinueOnError)
+	fset.Usage = flag.Usage
+	fset.BoolVar(&check, "check", check, "check for a newer release")
+	if nil != fset.Parse(args) || 0 != fset.NArg() {
+		flag.Usage()
+		return exitUsage
+	}
+
+	info := versionInfo{
+		Product:        MyProductName,
+		Version:        MyVersion,
+		ProductVersion: MyProductVersion,
+		Providers:      prov.GetProviderClassNames(),
+	}
+	if !jsonOutput {
+		printVersion()
+	}
+	if !check || "" != os.Getenv(noUpdateCheckEnv) {
+		if jsonOutput {
+			printJSON(info)
+		} else if check {
+			fmt.Printf("\nUpdate check disabled (%s is set)\n", noUpdateCheckEnv)
+		}
+		return 0
+	}
+
+	release, err := getLatestRelease()
+	if nil != err {
+		warn("version check error: %v", err)
+		return exitUnreachable
+	}
+	name := release.Name
+	if "" == name {
+		name = release.TagName
+	}
+	latest := release.version()
+	comparable := "" != latest && releaseVersionRe.MatchString(MyVersion)
+	info.Latest, info.LatestURL = latest, release.HtmlURL
+	info.Update = comparable && 0 > compareVersions(MyVersion, latest)
+
+	if jsonOutput {
+		printJSON(info)
+	} else {
+		fmt.Printf("\nLatest release: %s (%s)\n  %s\n", name, latest, release.HtmlURL)
+		switch {
+		case !comparable:
+			fmt.Printf("Cannot compare the running version %s with the latest release\n", MyVersion)
+		case info.Update:
+			fmt.Printf("A newer release is available\n")
+		default:
+			fmt.Printf("Up to date\n")
+		}
+	}
+	if info.Update {
+		return exitUpdate
+	}
+	return 0
+}
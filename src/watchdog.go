@@ -0,0 +1,131 @@
+/*
+ * watchdog.go
+ *
+ * Copyright 2021-2022 Bill Zissimopoulos
+ */
+/*
+ * This file is part of Hubfs.
+ *
+ * You can redistribute it and/or modify it under the terms of the GNU
+ * Affero General Public License version 3 as published by the Free
+ * Software Foundation.
+ */
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// watchdogInterval is the interval at which the mountpoint is checked.
+	watchdogInterval = 5 * time.Second
+
+	// watchdogMinBackoff and watchdogMaxBackoff bound the delay before a remount;
+	// the delay doubles with every failure that follows a short lived mount.
+	watchdogMinBackoff = time.Second
+	watchdogMaxBackoff = time.Minute
+)
+
+// watchdogArgs returns the command line arguments without the -watchdog option.
+func watchdogArgs(args []string) []string {
+	res := []string{}
+	for _, a := range args {
+		n := strings.TrimLeft(a, "-")
+		if a != n && ("watchdog" == n || strings.HasPrefix(n, "watchdog=")) {
+			continue
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// runWatchdog runs the file system in a child process and mounts it again when
+// the child crashes or its FUSE connection fails (i.e. the mountpoint reports
+// "transport endpoint is not connected"). Every incident is logged. The persistent
+// cache and overlay of the file system survive the remount. The watchdog exits when
+// the file system is unmounted (the child exits successfully) or on a signal.
+func runWatchdog(args []string, mntpnt string) int {
+	exe, err := os.Executable()
+	if nil != err {
+		warn("watchdog error: %v", err)
+		return exitFailure
+	}
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigC)
+
+	backoff := watchdogMinBackoff
+	for {
+		cmd := exec.Command(exe, args...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		start := time.Now()
+		err = cmd.Start()
+		if nil != err {
+			warn("watchdog error: %v", err)
+			return exitFailure
+		}
+		doneC := make(chan error, 1)
+		go func() {
+			doneC <- cmd.Wait()
+		}()
+
+		ticker := time.NewTicker(watchdogInterval)
+		incident := ""
+	loop:
+		for {
+			select {
+			case err = <-doneC:
+				if nil == err {
+					ticker.Stop()
+					return 0
+				}
+				if e, ok := err.(*exec.ExitError); ok &&
+					(exitUsage == e.ExitCode() || exitAuth == e.ExitCode()) {
+					// usage or auth error: remounting will not help
+					ticker.Stop()
+					return e.ExitCode()
+				}
+				incident = "file system exited: " + err.Error()
+				break loop
+			case sig := <-sigC:
+				ticker.Stop()
+				cmd.Process.Signal(sig)
+				<-doneC
+				return 0
+			case <-ticker.C:
+				if _, e := os.Stat(mntpnt); nil != e && isTransportError(e) {
+					incident = "file system connection failed: " + e.Error()
+					cmd.Process.Kill()
+					<-doneC
+					break loop
+				}
+			}
+		}
+		ticker.Stop()
+
+		if watchdogMaxBackoff < time.Since(start) {
+			backoff = watchdogMinBackoff
+		}
+		warn("watchdog: %s: %s: %s; remounting in %v",
+			time.Now().Format(time.RFC3339), mntpnt, incident, backoff)
+		systemUnmount(mntpnt, true)
+
+		select {
+		case <-time.After(backoff):
+		case <-sigC:
+			return 0
+		}
+		if backoff *= 2; watchdogMaxBackoff < backoff {
+			backoff = watchdogMaxBackoff
+		}
+	}
+}